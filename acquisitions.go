@@ -0,0 +1,212 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "sort"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// dateLayout is the format used for calendar dates such as order and received dates.
+const dateLayout = "2006-01-02"
+
+// PurchaseOrder struct defines an acquisition: what was bought, from whom, and
+// which budget line paid for it.
+type PurchaseOrder struct {
+    ID           string   `json:"id"`                      // Server-assigned order ID.
+    Vendor       string   `json:"vendor"`                  // Supplier the copies were bought from.
+    CostCents    int64    `json:"cost_cents"`              // Total cost in the smallest currency unit.
+    BudgetLine   string   `json:"budget_line"`             // Budget the cost is charged to, e.g. "adult-fiction".
+    OrderedDate  string   `json:"ordered_date"`            // Date the order was placed (YYYY-MM-DD).
+    ReceivedDate string   `json:"received_date,omitempty"` // Date the order arrived (YYYY-MM-DD); empty while outstanding.
+    CopyIDs      []string `json:"copy_ids"`                // Copies acquired through this order.
+}
+
+// BudgetSpend summarizes purchase orders for one budget line in one fiscal year.
+type BudgetSpend struct {
+    BudgetLine     string `json:"budget_line"`
+    FiscalYear     int    `json:"fiscal_year"`
+    Orders         int    `json:"orders"`
+    SpentCents     int64  `json:"spent_cents"`     // Orders that have been received.
+    CommittedCents int64  `json:"committed_cents"` // Orders placed but not yet received.
+}
+
+var (
+    purchaseOrders    = make(map[string]PurchaseOrder) // Map to store purchase orders with their ID as the key.
+    purchaseOrderSeq  int                              // Last purchase order ID handed out.
+    purchaseOrdersMux sync.RWMutex                     // RWMutex to safeguard purchaseOrders and purchaseOrderSeq.
+
+    // fiscalYearStartMonth is the first month (1-12) of the fiscal year. Fiscal
+    // years are named after the calendar year they end in.
+    fiscalYearStartMonth = envInt("FISCAL_YEAR_START_MONTH", 1)
+)
+
+// fiscalYear returns the fiscal year a date falls in.
+func fiscalYear(t time.Time) int {
+    if fiscalYearStartMonth <= 1 || int(t.Month()) < fiscalYearStartMonth {
+        return t.Year()
+    }
+    return t.Year() + 1
+}
+
+// validatePurchaseOrder checks required fields, dates and copy references.
+func validatePurchaseOrder(po PurchaseOrder) error {
+    if po.Vendor == "" || po.BudgetLine == "" {
+        return errors.New("vendor and budget_line are required")
+    }
+    if po.CostCents < 0 {
+        return errors.New("cost_cents must not be negative")
+    }
+    if _, err := time.Parse(dateLayout, po.OrderedDate); err != nil {
+        return errors.New("ordered_date must be YYYY-MM-DD")
+    }
+    if po.ReceivedDate != "" {
+        if _, err := time.Parse(dateLayout, po.ReceivedDate); err != nil {
+            return errors.New("received_date must be YYYY-MM-DD")
+        }
+    }
+    copiesMux.RLock()
+    defer copiesMux.RUnlock()
+    for _, id := range po.CopyIDs {
+        if _, ok := copies[id]; !ok {
+            return errors.New("unknown copy " + id)
+        }
+    }
+    return nil
+}
+
+// budgetSpend groups purchase orders by budget line and fiscal year. Received
+// orders count as spent in the year they arrived; outstanding orders count as
+// committed in the year they were placed.
+func budgetSpend() []BudgetSpend {
+    type key struct {
+        budget string
+        year   int
+    }
+    totals := make(map[key]*BudgetSpend)
+    purchaseOrdersMux.RLock()
+    for _, po := range purchaseOrders {
+        date, received := po.ReceivedDate, true
+        if date == "" {
+            date, received = po.OrderedDate, false
+        }
+        t, _ := time.Parse(dateLayout, date) // Dates were validated on write.
+        k := key{po.BudgetLine, fiscalYear(t)}
+        s, ok := totals[k]
+        if !ok {
+            s = &BudgetSpend{BudgetLine: k.budget, FiscalYear: k.year}
+            totals[k] = s
+        }
+        s.Orders++
+        if received {
+            s.SpentCents += po.CostCents
+        } else {
+            s.CommittedCents += po.CostCents
+        }
+    }
+    purchaseOrdersMux.RUnlock()
+
+    out := make([]BudgetSpend, 0, len(totals))
+    for _, s := range totals {
+        out = append(out, *s)
+    }
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].FiscalYear != out[j].FiscalYear {
+            return out[i].FiscalYear < out[j].FiscalYear
+        }
+        return out[i].BudgetLine < out[j].BudgetLine
+    })
+    return out
+}
+
+// handlePurchaseOrders handles requests for the /purchase-orders route.
+func handlePurchaseOrders(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case "GET": // Retrieve all purchase orders, optionally for one budget line.
+        budget := r.URL.Query().Get("budget_line")
+        purchaseOrdersMux.RLock()
+        pos := make([]PurchaseOrder, 0, len(purchaseOrders))
+        for _, po := range purchaseOrders {
+            if budget == "" || po.BudgetLine == budget {
+                pos = append(pos, po)
+            }
+        }
+        purchaseOrdersMux.RUnlock()
+        sort.Slice(pos, func(i, j int) bool { return lessID(pos[i].ID, pos[j].ID) })
+        start, end, err := paginate(w, r, len(pos))
+        if err != nil {
+            writePageError(w, err)
+            return
+        }
+        json.NewEncoder(w).Encode(pos[start:end])
+
+    case "POST": // Record a new purchase order.
+        var po PurchaseOrder
+        if err := decodeJSON(r, &po); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        if po.CopyIDs == nil {
+            po.CopyIDs = []string{}
+        }
+        if err := validatePurchaseOrder(po); err != nil {
+            writeError(w, "validation_failed", err.Error())
+            return
+        }
+        purchaseOrdersMux.Lock()
+        purchaseOrderSeq++
+        po.ID = strconv.Itoa(purchaseOrderSeq)
+        purchaseOrders[po.ID] = po
+        purchaseOrdersMux.Unlock()
+        w.WriteHeader(http.StatusCreated)
+        json.NewEncoder(w).Encode(po)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// handlePurchaseOrder handles requests for the /purchase-order/{id} route.
+func handlePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+    id := r.URL.Path[len("/purchase-order/"):] // Extract the order ID from the URL path.
+    switch r.Method {
+    case "GET": // Retrieve a single purchase order.
+        purchaseOrdersMux.RLock()
+        po, ok := purchaseOrders[id]
+        purchaseOrdersMux.RUnlock()
+        if !ok {
+            writeError(w, "purchase_order_not_found", "purchase order "+id+" not found")
+            return
+        }
+        json.NewEncoder(w).Encode(po)
+
+    case "PUT": // Update a purchase order, e.g. to set the received date.
+        var po PurchaseOrder
+        if err := decodeJSON(r, &po); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        po.ID = id // The ID in the path is authoritative.
+        if po.CopyIDs == nil {
+            po.CopyIDs = []string{}
+        }
+        if err := validatePurchaseOrder(po); err != nil {
+            writeError(w, "validation_failed", err.Error())
+            return
+        }
+        purchaseOrdersMux.Lock()
+        defer purchaseOrdersMux.Unlock()
+        if _, ok := purchaseOrders[id]; !ok {
+            writeError(w, "purchase_order_not_found", "purchase order "+id+" not found")
+            return
+        }
+        purchaseOrders[id] = po
+        json.NewEncoder(w).Encode(po)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
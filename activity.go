@@ -0,0 +1,123 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strconv"
+    "time"
+)
+
+// Activity struct defines one entry in a book's activity feed.
+type Activity struct {
+    At      time.Time `json:"at"`
+    Type    string    `json:"type"`              // edit, import, sync, revert, copy, file, weeding or review.
+    Action  string    `json:"action"`            // What happened, e.g. created, status_changed, deaccessioned.
+    Version int       `json:"version,omitempty"` // Book version written, for edits, imports and syncs.
+    Record  string    `json:"record,omitempty"`  // ID of the copy, file, weeding record or review involved.
+    Detail  string    `json:"detail,omitempty"`  // Short human-readable summary.
+}
+
+// bookActivity gathers everything that happened to a book, oldest first: its
+// version history plus copy, e-book file, weeding and review events. It reports false
+// if the book has no history and nothing refers to it.
+func bookActivity(bookID string) ([]Activity, bool) {
+    var acts []Activity
+
+    historyMux.RLock()
+    versions := bookHistory[bookID]
+    for i, v := range versions {
+        if v.At.IsZero() {
+            continue // Placeholder for a version written by a previous run.
+        }
+        typ := v.Via
+        if typ == "" {
+            typ = "edit"
+        }
+        acts = append(acts, Activity{At: v.At, Type: typ, Action: versionAction(versions, i), Version: v.Version})
+    }
+    historyMux.RUnlock()
+
+    weedingMux.RLock()
+    for _, rec := range weeding {
+        if rec.BookID != bookID {
+            continue
+        }
+        acts = append(acts, Activity{At: rec.FlaggedAt, Type: "weeding", Action: WeedingFlagged, Record: rec.ID, Detail: "copy " + rec.CopyID + ": " + rec.ReasonCode})
+        if rec.DecidedAt != nil {
+            action := WeedingApproved
+            if rec.State == WeedingRejected {
+                action = WeedingRejected
+            }
+            acts = append(acts, Activity{At: *rec.DecidedAt, Type: "weeding", Action: action, Record: rec.ID, Detail: "copy " + rec.CopyID})
+        }
+        if rec.DeaccessionedAt != nil {
+            acts = append(acts, Activity{At: *rec.DeaccessionedAt, Type: "weeding", Action: WeedingDeaccessioned, Record: rec.ID, Detail: "copy " + rec.CopyID})
+        }
+    }
+    weedingMux.RUnlock()
+
+    copiesMux.RLock()
+    for _, c := range copies {
+        if c.BookID != bookID {
+            continue
+        }
+        for i, h := range c.History {
+            action := "status_changed"
+            if i == 0 {
+                action = "added"
+            }
+            detail := h.Status + ", " + h.Condition
+            if h.Note != "" {
+                detail += ": " + h.Note
+            }
+            acts = append(acts, Activity{At: h.ChangedAt, Type: "copy", Action: action, Record: c.ID, Detail: detail})
+        }
+    }
+    copiesMux.RUnlock()
+
+    ebookMux.RLock()
+    for _, f := range ebookFiles {
+        if f.BookID == bookID {
+            acts = append(acts, Activity{At: f.UploadedAt, Type: "file", Action: "uploaded", Record: f.ID, Detail: f.MediaType})
+        }
+    }
+    ebookMux.RUnlock()
+
+    reviewsMux.RLock()
+    for _, rv := range reviews {
+        if rv.BookID == bookID {
+            acts = append(acts, Activity{At: rv.CreatedAt, Type: "review", Action: "posted", Record: rv.ID, Detail: strconv.Itoa(rv.Rating) + " stars"})
+        }
+    }
+    reviewsMux.RUnlock()
+
+    if len(versions) == 0 && len(acts) == 0 {
+        return nil, false
+    }
+    sort.SliceStable(acts, func(i, j int) bool { return acts[i].At.Before(acts[j].At) })
+    return acts, true
+}
+
+// handleBookActivity handles GET /book/{id}/activity.
+func handleBookActivity(w http.ResponseWriter, r *http.Request, id string) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    if !canSeeHistory(r, id) { // Like the versions it is built from.
+        writeError(w, "book_not_found", "book "+id+" not found")
+        return
+    }
+    acts, ok := bookActivity(id)
+    if !ok {
+        writeError(w, "book_not_found", "book "+id+" not found")
+        return
+    }
+    start, end, err := paginate(w, r, len(acts))
+    if err != nil {
+        writePageError(w, err)
+        return
+    }
+    json.NewEncoder(w).Encode(acts[start:end])
+}
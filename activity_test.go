@@ -0,0 +1,38 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+    "time"
+)
+
+func TestBookActivityReviews(t *testing.T) {
+    c := useTestSeams(t, time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC))
+    reader := map[string]string{"X-API-Key": "secret-key"}
+    if w := serveBook("PUT", "/book/act-1", `{"title":"Dune"}`, nil); w.Code != http.StatusOK {
+        t.Fatalf("PUT = %d %s", w.Code, w.Body)
+    }
+    advance(c, time.Minute)
+    w := serveBook("POST", "/book/act-1/reviews", `{"rating":4,"body":"Spice"}`, reader)
+    if w.Code != http.StatusCreated {
+        t.Fatalf("POST review = %d %s", w.Code, w.Body)
+    }
+    var rv Review
+    if err := json.Unmarshal(w.Body.Bytes(), &rv); err != nil {
+        t.Fatal(err)
+    }
+
+    w = serveBook("GET", "/book/act-1/activity", "", reader)
+    var acts []Activity
+    if err := json.Unmarshal(w.Body.Bytes(), &acts); err != nil {
+        t.Fatalf("GET activity = %d %s", w.Code, w.Body)
+    }
+    if len(acts) != 2 {
+        t.Fatalf("activity = %+v, want the edit and the review", acts)
+    }
+    want := Activity{At: rv.CreatedAt, Type: "review", Action: "posted", Record: rv.ID, Detail: "4 stars"}
+    if got := acts[1]; !got.At.Equal(want.At) || got.Type != want.Type || got.Action != want.Action || got.Record != want.Record || got.Detail != want.Detail {
+        t.Errorf("last activity = %+v, want %+v", got, want)
+    }
+}
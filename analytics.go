@@ -0,0 +1,99 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "sync"
+    "time"
+)
+
+// AnalyticsEvent is one aggregated usage count sent to the analytics sink.
+// Events never carry keys, client addresses, record IDs or query strings:
+// routes are reported as their registered pattern (e.g. "/book/"), and only
+// as counts per flush window.
+type AnalyticsEvent struct {
+    Type        string    `json:"type"` // Always endpoint_usage for now.
+    Route       string    `json:"route"`
+    Method      string    `json:"method"`
+    Status      int       `json:"status"`
+    Count       int       `json:"count"`
+    WindowStart time.Time `json:"window_start"`
+    WindowEnd   time.Time `json:"window_end"`
+}
+
+// analyticsKey identifies one usage counter within a window.
+type analyticsKey struct {
+    route, method string
+    status        int
+}
+
+var (
+    // analyticsSink is where events go: empty disables analytics, "log" writes
+    // them to the server log, and an http(s) URL receives them as a JSON array.
+    analyticsSink          = envString("ANALYTICS_SINK", "")
+    analyticsFlushInterval = envDuration("ANALYTICS_FLUSH_INTERVAL", time.Minute)
+
+    analyticsCounts = make(map[analyticsKey]int) // Counts for the current window.
+    analyticsStart  = clock.Now().UTC()           // Start of the current window.
+    analyticsMux    sync.Mutex                   // Mutex to safeguard analyticsCounts and analyticsStart.
+)
+
+// recordUsage counts a finished request toward the current analytics window.
+func recordUsage(r *http.Request, status int) {
+    if analyticsSink == "" {
+        return
+    }
+    _, route := http.DefaultServeMux.Handler(r) // The pattern, so IDs in the path never leave the server.
+    analyticsMux.Lock()
+    analyticsCounts[analyticsKey{route, r.Method, status}]++
+    analyticsMux.Unlock()
+}
+
+// flushAnalytics sends the current window's counts to the sink and starts a
+// new window. It runs as a scheduled job every analyticsFlushInterval. Events
+// that cannot be delivered are dropped.
+func flushAnalytics() error {
+    if analyticsSink == "" {
+        return nil
+    }
+    analyticsMux.Lock()
+    counts, start, end := analyticsCounts, analyticsStart, clock.Now().UTC()
+    analyticsCounts, analyticsStart = make(map[analyticsKey]int), end
+    analyticsMux.Unlock()
+    if len(counts) == 0 {
+        return nil
+    }
+
+    events := make([]AnalyticsEvent, 0, len(counts))
+    for k, n := range counts {
+        events = append(events, AnalyticsEvent{Type: "endpoint_usage", Route: k.route, Method: k.method, Status: k.status, Count: n, WindowStart: start, WindowEnd: end})
+    }
+    sort.Slice(events, func(i, j int) bool {
+        if events[i].Route != events[j].Route {
+            return events[i].Route < events[j].Route
+        }
+        if events[i].Method != events[j].Method {
+            return events[i].Method < events[j].Method
+        }
+        return events[i].Status < events[j].Status
+    })
+    data, _ := json.Marshal(events)
+    if analyticsSink == "log" {
+        log.Printf("analytics %s", data)
+        return nil
+    }
+    client := http.Client{Timeout: 10 * time.Second}
+    resp, err := client.Post(analyticsSink, "application/json", bytes.NewReader(data))
+    if err != nil {
+        return fmt.Errorf("dropped %d analytics events: %v", len(events), err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("dropped %d analytics events: sink answered %s", len(events), resp.Status)
+    }
+    return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// ErrorCode describes one error condition. Codes are stable: clients branch on
+// them, so a code must never change meaning once published. Messages may change.
+type ErrorCode struct {
+    Code        string `json:"code"`
+    Status      int    `json:"status"`
+    Description string `json:"description"`
+}
+
+// ErrorBody is the envelope every error response is wrapped in.
+type ErrorBody struct {
+    Error struct {
+        Code    string      `json:"code"`
+        Message string      `json:"message"`
+        Details interface{} `json:"details,omitempty"` // Extra machine-readable context for some codes.
+    } `json:"error"`
+}
+
+// errorCatalog lists every error code the API can return, keyed by code. It is
+// served at GET /errors.
+var errorCatalog = map[string]ErrorCode{}
+
+func init() {
+    for _, e := range []ErrorCode{
+        {"invalid_json", http.StatusBadRequest, "The request body is not valid JSON for this endpoint."},
+        {"unknown_fields", http.StatusBadRequest, "Strict decoding is on and the body contains fields the endpoint does not accept."},
+        {"validation_failed", http.StatusBadRequest, "The request body is well-formed but a field is missing or invalid."},
+        {"invalid_query", http.StatusBadRequest, "A query parameter is malformed or out of range."},
+        {"result_too_large", http.StatusBadRequest, "The list is too long to return unpaginated; details.first_page has the paginated request."},
+        {"invalid_header", http.StatusBadRequest, "A request header is malformed or out of range."},
+        {"invalid_method_override", http.StatusBadRequest, "X-HTTP-Method-Override was used on a non-POST request or with an unsupported method."},
+        {"unauthorized", http.StatusUnauthorized, "The X-API-Key header is missing or not valid for this instance."},
+        {"admin_required", http.StatusForbidden, "The action needs the admin API key."},
+        {"forbidden", http.StatusForbidden, "The authorization policy does not allow this key to make this request."},
+        {"quota_exceeded", http.StatusForbidden, "The write would take the API key past its record or storage quota."},
+        {"sandbox_required", http.StatusForbidden, "The action only runs on a sandbox instance."},
+        {"invalid_signature", http.StatusForbidden, "The download link is not valid or has expired."},
+        {"not_found", http.StatusNotFound, "No route matches the request path."},
+        {"book_not_found", http.StatusNotFound, "No book exists with the given ID."},
+        {"copy_not_found", http.StatusNotFound, "No copy exists with the given ID."},
+        {"location_not_found", http.StatusNotFound, "No location exists with the given ID."},
+        {"purchase_order_not_found", http.StatusNotFound, "No purchase order exists with the given ID."},
+        {"weeding_record_not_found", http.StatusNotFound, "No weeding record exists with the given ID."},
+        {"ill_request_not_found", http.StatusNotFound, "No inter-library loan request exists with the given ID."},
+        {"file_not_found", http.StatusNotFound, "No e-book file exists with the given ID on this book."},
+        {"cover_not_found", http.StatusNotFound, "The book has no cover image."},
+        {"template_not_found", http.StatusNotFound, "No notification template exists with the given name."},
+        {"job_not_found", http.StatusNotFound, "No import or export job with the given ID belongs to this API key."},
+        {"author_not_found", http.StatusNotFound, "No author with the given ID exists."},
+        {"genre_not_found", http.StatusNotFound, "No genre with the given ID exists."},
+        {"publisher_not_found", http.StatusNotFound, "No publisher with the given ID exists."},
+        {"isbn_not_found", http.StatusNotFound, "OpenLibrary has no record of the ISBN."},
+        {"version_not_found", http.StatusNotFound, "The book has no recorded version with the given number."},
+        {"search_not_found", http.StatusNotFound, "No saved search with the given ID belongs to this API key."},
+        {"method_not_allowed", http.StatusMethodNotAllowed, "The route does not support this method; see the Allow header."},
+        {"invalid_transition", http.StatusConflict, "The record is not in a state that allows this action."},
+        {"duplicate", http.StatusConflict, "An equivalent record or action already exists."},
+        {"import_conflict", http.StatusConflict, "The import ran with on_conflict=fail and records have IDs already in the catalog; details.conflicts lists them."},
+        {"title_in_catalog", http.StatusConflict, "The requested title is already in the catalog."},
+        {"copy_withdrawn", http.StatusConflict, "The copy has been withdrawn from the collection."},
+        {"location_in_use", http.StatusConflict, "The location still holds copies."},
+        {"author_in_use", http.StatusConflict, "Books still link to the author."},
+        {"genre_in_use", http.StatusConflict, "Books or subgenres are still filed under the genre."},
+        {"publisher_in_use", http.StatusConflict, "Books still link to the publisher."},
+        {"export_expired", http.StatusGone, "The export's file is no longer kept; run the export again."},
+        {"cursor_expired", http.StatusGone, "The change cursor is older than the retained history; reload and start again."},
+        {"version_conflict", http.StatusConflict, "The version in the body is not the book's current version; details.current_version has it."},
+        {"precondition_failed", http.StatusPreconditionFailed, "If-Match did not match the current version of the resource."},
+        {"precondition_required", http.StatusPreconditionRequired, "The request must carry an If-Match header."},
+        {"patch_test_failed", http.StatusConflict, "A test operation in the JSON Patch did not hold, so none of it was applied."},
+        {"invalid_patch", http.StatusUnprocessableEntity, "The patch is well-formed but can't be applied to the resource."},
+        {"payload_too_large", http.StatusRequestEntityTooLarge, "The uploaded file is larger than the server accepts."},
+        {"unsupported_media_type", http.StatusUnsupportedMediaType, "The Content-Type of the upload is not one the endpoint accepts."},
+        {"internal_error", http.StatusInternalServerError, "Something went wrong on the server."},
+        {"lookup_failed", http.StatusBadGateway, "The ISBN lookup service could not be reached or gave a bad answer."},
+        {"request_quota_exceeded", http.StatusTooManyRequests, "The API key has used up its daily request quota; see Retry-After."},
+        {"rate_limited", http.StatusTooManyRequests, "The client address has made too many public requests this minute; see Retry-After."},
+        {"read_only", http.StatusServiceUnavailable, "The server is in read-only mode; retry after the Retry-After delay."},
+        {"request_timeout", http.StatusGatewayTimeout, "The request did not complete within X-Request-Timeout."},
+    } {
+        errorCatalog[e.Code] = e
+    }
+}
+
+// writeError sends an error response in the standard envelope. The status code
+// comes from the catalog, so a code always maps to the same status.
+func writeError(w http.ResponseWriter, code, message string) {
+    writeErrorDetails(w, code, message, nil)
+}
+
+// writeErrorDetails is writeError with extra machine-readable details.
+func writeErrorDetails(w http.ResponseWriter, code, message string, details interface{}) {
+    e, ok := errorCatalog[code]
+    if !ok {
+        log.Printf("error code %q is missing from the catalog", code)
+        e = errorCatalog["internal_error"]
+    }
+    var body ErrorBody
+    body.Error.Code, body.Error.Message, body.Error.Details = e.Code, message, details
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+    w.WriteHeader(e.Status)
+    json.NewEncoder(w).Encode(body)
+}
+
+// writeVersionConflict reports a write based on a stale book version.
+func writeVersionConflict(w http.ResponseWriter, current int) {
+    writeErrorDetails(w, "version_conflict", "book was changed; current version is "+strconv.Itoa(current), map[string]int{"current_version": current})
+}
+
+// writeDecodeError reports a request body that decodeJSON rejected.
+func writeDecodeError(w http.ResponseWriter, err error) {
+    if _, ok := err.(unknownFieldsError); ok || strings.HasPrefix(err.Error(), "json: unknown field") {
+        writeError(w, "unknown_fields", err.Error())
+        return
+    }
+    if errs, ok := err.(schemaError); ok {
+        writeErrorDetails(w, "validation_failed", err.Error(), map[string][]string{"errors": errs})
+        return
+    }
+    writeError(w, "invalid_json", err.Error())
+}
+
+// handleNotFound answers requests that match no route.
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+    writeError(w, "not_found", "no route for "+r.URL.Path)
+}
+
+// handleErrors handles requests for the /errors route, listing the error catalog.
+func handleErrors(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    codes := make([]ErrorCode, 0, len(errorCatalog))
+    for _, e := range errorCatalog {
+        codes = append(codes, e)
+    }
+    sort.Slice(codes, func(i, j int) bool {
+        if codes[i].Status != codes[j].Status {
+            return codes[i].Status < codes[j].Status
+        }
+        return codes[i].Code < codes[j].Code
+    })
+    json.NewEncoder(w).Encode(codes)
+}
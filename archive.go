@@ -0,0 +1,275 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "sort"
+    "time"
+)
+
+// ArchivedBook is the archive index entry of a book moved to cold storage.
+// The book itself is kept in the archive store under archiveKey(ID).
+type ArchivedBook struct {
+    ID           string    `json:"id"`
+    Title        string    `json:"title"`
+    LastModified time.Time `json:"last_modified"` // When the book was last written before it was archived.
+    ArchivedAt   time.Time `json:"archived_at"`
+}
+
+// ArchiveRun is the response for POST /admin/archive.
+type ArchiveRun struct {
+    Archived []string `json:"archived"` // IDs of the books moved to the archive.
+}
+
+var (
+    // archiveDriver names the blob driver for the cold tier, such as "dir";
+    // archiving is off when it is empty.
+    archiveDriver     = envString("ARCHIVE_STORE", "")
+    archiveDSN        = envString("ARCHIVE_DSN", "")            // Driver-specific location of the archive.
+    archiveAfterYears = envInt("ARCHIVE_AFTER_YEARS", 5)        // Books untouched this long are archived.
+    archiveSchedule   = envString("ARCHIVE_SCHEDULE", "@daily") // How often the archive job looks for them.
+
+    // archive is the cold tier, opened by openArchive at startup; nil when
+    // archiving is off.
+    archive BlobStore
+
+    archived   = make(map[string]ArchivedBook) // Index of archived books by ID. Guarded by mux.
+    rehydrated = make(map[string]time.Time)    // When books were brought back from the archive, so a read counts as a touch. Guarded by mux.
+)
+
+// archiveIndexKey is where the archive index is kept in the archive store,
+// next to the books.
+const archiveIndexKey = "archive-index.json"
+
+// archiveKey is the archive store key of an archived book.
+func archiveKey(bookID string) string {
+    return "book-" + bookID
+}
+
+// openArchive opens the archive store and loads its index.
+func openArchive() error {
+    if archiveDriver == "" {
+        return nil
+    }
+    open, ok := blobDrivers[archiveDriver]
+    if !ok {
+        return errors.New("unknown blob store driver " + archiveDriver)
+    }
+    bs, err := open(archiveDSN)
+    if err != nil {
+        return err
+    }
+    rc, err := bs.Open(archiveIndexKey)
+    if errors.Is(err, errBlobNotFound) {
+        archive = bs // A new archive.
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    defer rc.Close()
+    var index []ArchivedBook
+    if err := json.NewDecoder(rc).Decode(&index); err != nil {
+        return err
+    }
+    for _, a := range index {
+        archived[a.ID] = a
+    }
+    archive = bs
+    return nil
+}
+
+// saveArchiveIndex writes the archive index to the archive store. Callers
+// hold mux.
+func saveArchiveIndex() error {
+    index := make([]ArchivedBook, 0, len(archived))
+    for _, a := range archived {
+        index = append(index, a)
+    }
+    sort.Slice(index, func(i, j int) bool { return lessID(index[i].ID, index[j].ID) })
+    data, err := json.Marshal(index)
+    if err != nil {
+        return err
+    }
+    _, err = archive.Put(archiveIndexKey, bytes.NewReader(data))
+    return err
+}
+
+// resetArchive empties the archive, for a sandbox reset, so books archived
+// before it can't be rehydrated into the fresh data. Callers hold mux.
+func resetArchive() {
+    if archive == nil {
+        return
+    }
+    for id := range archived {
+        if err := archive.Delete(archiveKey(id)); err != nil {
+            log.Printf("archive: deleting book %s: %v", id, err)
+        }
+    }
+    archived, rehydrated = make(map[string]ArchivedBook), make(map[string]time.Time)
+    if err := saveArchiveIndex(); err != nil {
+        log.Printf("archive: saving index: %v", err)
+    }
+}
+
+// catalogued reports whether a book exists, in the store, the archive or the
+// trash.
+// Callers hold mux.
+func catalogued(bookID string) bool {
+    if _, ok := getBook(bookID); ok {
+        return true
+    }
+    _, inArchive := archived[bookID]
+    _, inTrash := trash[bookID]
+    return inArchive || inTrash
+}
+
+// archiveStaleBooks moves books that haven't been written, or read back from
+// the archive, in archiveAfterYears to the archive store. Books are copied
+// and indexed before they leave the store, so a failure part-way keeps them
+// where they were. Books with neither a timestamp nor recorded history are
+// left alone, as there is no telling how old they are.
+func archiveStaleBooks() (ArchiveRun, error) {
+    run := ArchiveRun{Archived: []string{}}
+    now := clock.Now().UTC()
+    cutoff := now.AddDate(-archiveAfterYears, 0, 0)
+    mux.Lock()
+    defer mux.Unlock()
+    bks, err := store.List()
+    if err != nil {
+        return run, err
+    }
+    for _, book := range bks {
+        touched := book.UpdatedAt
+        if touched.IsZero() {
+            touched = lastModified(book.ID) // Written before books carried timestamps.
+        }
+        if touched.IsZero() || touched.After(cutoff) || rehydrated[book.ID].After(cutoff) {
+            continue
+        }
+        data, err := json.Marshal(book)
+        if err != nil {
+            return run, err
+        }
+        if _, err := archive.Put(archiveKey(book.ID), bytes.NewReader(data)); err != nil {
+            return run, err
+        }
+        archived[book.ID] = ArchivedBook{ID: book.ID, Title: book.Title, LastModified: touched, ArchivedAt: now}
+        run.Archived = append(run.Archived, book.ID)
+    }
+    if len(run.Archived) == 0 {
+        return run, nil
+    }
+    if err := saveArchiveIndex(); err != nil {
+        for _, id := range run.Archived {
+            delete(archived, id) // Still in the store; the copies are just overwritten next time.
+        }
+        return ArchiveRun{Archived: []string{}}, err
+    }
+    for _, id := range run.Archived {
+        if err := store.Delete(id); err != nil {
+            log.Printf("archive: removing book %s from the store: %v", id, err) // Found in the store, so rehydrating just drops the copy.
+        }
+        delete(rehydrated, id)
+    }
+    touchCatalog()
+    return run, nil
+}
+
+// scheduledArchive is the scheduled job that archives stale books.
+func scheduledArchive() error {
+    run, err := archiveStaleBooks()
+    if err != nil {
+        return err
+    }
+    if len(run.Archived) > 0 {
+        log.Printf("archived %d books", len(run.Archived))
+    }
+    return nil
+}
+
+// rehydrate brings an archived book back into the store, so requests for it
+// work as if it had never left. A book that is already back in the store,
+// because it was created again under the same ID, keeps that version.
+func rehydrate(bookID string) error {
+    if archive == nil {
+        return nil
+    }
+    mux.RLock()
+    _, ok := archived[bookID]
+    mux.RUnlock()
+    if !ok {
+        return nil
+    }
+
+    mux.Lock()
+    defer mux.Unlock()
+    if _, ok := archived[bookID]; !ok {
+        return nil // Another request brought it back first.
+    }
+    if _, ok := getBook(bookID); !ok {
+        rc, err := archive.Open(archiveKey(bookID))
+        if err != nil {
+            return err
+        }
+        var book Book
+        err = json.NewDecoder(rc).Decode(&book)
+        rc.Close()
+        if err != nil {
+            return err
+        }
+        if err := store.Create(book); err != nil {
+            return err
+        }
+        rehydrated[bookID] = clock.Now().UTC()
+        touchCatalog()
+    }
+    delete(archived, bookID)
+    if err := saveArchiveIndex(); err != nil {
+        log.Printf("archive: saving index: %v", err) // The stale entry is dropped by the next rehydrate or archive run.
+    }
+    if err := archive.Delete(archiveKey(bookID)); err != nil {
+        log.Printf("archive: deleting book %s: %v", bookID, err)
+    }
+    return nil
+}
+
+// handleArchive handles requests for the /admin/archive route: GET lists the
+// archived books a page at a time, POST archives stale books now. Needs the
+// admin key.
+func handleArchive(w http.ResponseWriter, r *http.Request) {
+    if !isAdmin(r) {
+        writeError(w, "admin_required", "the archive requires the admin key")
+        return
+    }
+    switch r.Method {
+    case "GET": // List archived books.
+        mux.RLock()
+        list := make([]ArchivedBook, 0, len(archived))
+        for _, a := range archived {
+            list = append(list, a)
+        }
+        mux.RUnlock()
+        sort.Slice(list, func(i, j int) bool { return lessID(list[i].ID, list[j].ID) })
+        start, end, err := paginateByDefault(w, r, len(list))
+        if err != nil {
+            writePageError(w, err)
+            return
+        }
+        json.NewEncoder(w).Encode(list[start:end])
+
+    case "POST": // Archive now, outside the schedule.
+        run, err := archiveStaleBooks()
+        if err != nil {
+            writeError(w, "internal_error", err.Error())
+            return
+        }
+        json.NewEncoder(w).Encode(run)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// Author struct defines a person or body credited with writing books.
+type Author struct {
+    ID        string `json:"id"`
+    Name      string `json:"name"`                // Name as it should be displayed, e.g. "Ursula K. Le Guin".
+    SortName  string `json:"sort_name,omitempty"` // Name for alphabetical lists, e.g. "Le Guin, Ursula K.".
+    BirthYear int    `json:"birth_year,omitempty"`
+    DeathYear int    `json:"death_year,omitempty"`
+    Bio       string `json:"bio,omitempty"`
+}
+
+var (
+    authors    = make(map[string]Author) // Map to store authors with their ID as the key.
+    authorSeq  int                       // Last author ID handed out.
+    authorsMux sync.RWMutex              // RWMutex to safeguard authors and authorSeq. Taken after mux.
+)
+
+// checkAuthor reports the first problem with an author record, if any.
+func checkAuthor(a Author) (string, bool) {
+    if strings.TrimSpace(a.Name) == "" {
+        return "name is required", false
+    }
+    if a.DeathYear != 0 && a.BirthYear > a.DeathYear {
+        return "death_year must not be before birth_year", false
+    }
+    return "", true
+}
+
+// linkAuthor checks that a book's author_id names an existing author and
+// fills in the book's author from it if the client left that out.
+func linkAuthor(book *Book) error {
+    if book.AuthorID == "" {
+        return nil
+    }
+    authorsMux.RLock()
+    a, ok := authors[book.AuthorID]
+    authorsMux.RUnlock()
+    if !ok {
+        return errors.New("unknown author_id " + book.AuthorID)
+    }
+    if book.Author == "" {
+        book.Author = a.Name
+    }
+    return nil
+}
+
+// booksByAuthor returns the books linked to an author. Callers hold mux.
+func booksByAuthor(id string) ([]Book, error) {
+    source, err := store.List()
+    if err != nil {
+        return nil, err
+    }
+    bks := make([]Book, 0)
+    for _, book := range source {
+        if book.AuthorID == id {
+            bks = append(bks, book)
+        }
+    }
+    return bks, nil
+}
+
+// handleAuthors handles requests for the /authors route.
+func handleAuthors(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case "GET": // Retrieve all authors, optionally those whose name contains ?q=.
+        q := strings.ToLower(r.URL.Query().Get("q"))
+        authorsMux.RLock()
+        list := make([]Author, 0, len(authors))
+        for _, a := range authors {
+            if q == "" || strings.Contains(strings.ToLower(a.Name), q) {
+                list = append(list, a)
+            }
+        }
+        authorsMux.RUnlock()
+        sort.Slice(list, func(i, j int) bool { return lessID(list[i].ID, list[j].ID) })
+        start, end, err := paginate(w, r, len(list))
+        if err != nil {
+            writePageError(w, err)
+            return
+        }
+        json.NewEncoder(w).Encode(list[start:end])
+
+    case "POST": // Add a new author.
+        var a Author
+        if err := decodeJSON(r, &a); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        if msg, ok := checkAuthor(a); !ok {
+            writeError(w, "validation_failed", msg)
+            return
+        }
+        authorsMux.Lock()
+        authorSeq++
+        a.ID = strconv.Itoa(authorSeq)
+        authors[a.ID] = a
+        authorsMux.Unlock()
+        w.Header().Set("Location", "/author/"+a.ID)
+        w.WriteHeader(http.StatusCreated)
+        json.NewEncoder(w).Encode(a)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// handleAuthor handles requests for the /author/{id} and /author/{id}/books routes.
+func handleAuthor(w http.ResponseWriter, r *http.Request) {
+    id, sub, _ := strings.Cut(r.URL.Path[len("/author/"):], "/")
+    if sub != "" && sub != "books" {
+        writeError(w, "not_found", "no route for "+r.URL.Path)
+        return
+    }
+    authorsMux.RLock()
+    a, ok := authors[id]
+    authorsMux.RUnlock()
+    if !ok {
+        writeError(w, "author_not_found", "author "+id+" not found")
+        return
+    }
+    if sub == "books" {
+        handleAuthorBooks(w, r, id)
+        return
+    }
+
+    switch r.Method {
+    case "GET": // Retrieve a single author.
+        json.NewEncoder(w).Encode(a)
+
+    case "PUT": // Update an author. Linked books keep the author name they were saved with.
+        var a Author
+        if err := decodeJSON(r, &a); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        a.ID = id // The ID in the path is authoritative.
+        if msg, ok := checkAuthor(a); !ok {
+            writeError(w, "validation_failed", msg)
+            return
+        }
+        authorsMux.Lock()
+        defer authorsMux.Unlock()
+        if _, ok := authors[id]; !ok {
+            writeError(w, "author_not_found", "author "+id+" not found")
+            return
+        }
+        authors[id] = a
+        json.NewEncoder(w).Encode(a)
+
+    case "DELETE": // Remove an author that no book links to.
+        mux.RLock() // Held until the author is gone, so no book can link to it meanwhile.
+        defer mux.RUnlock()
+        bks, err := booksByAuthor(id)
+        if err != nil {
+            writeError(w, "internal_error", err.Error())
+            return
+        }
+        if len(bks) > 0 {
+            writeError(w, "author_in_use", strconv.Itoa(len(bks))+" book(s) still link to author "+id+"; relink or delete them first")
+            return
+        }
+        authorsMux.Lock()
+        delete(authors, id)
+        authorsMux.Unlock()
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// handleAuthorBooks handles /author/{id}/books: GET lists the author's books
+// and POST creates a book linked to the author, as POST /books would.
+func handleAuthorBooks(w http.ResponseWriter, r *http.Request, id string) {
+    switch r.Method {
+    case "GET":
+        mux.RLock()
+        bks, err := booksByAuthor(id)
+        mux.RUnlock()
+        if err != nil {
+            writeError(w, "internal_error", err.Error())
+            return
+        }
+        if !isAdmin(r) {
+            bks = publishedOnly(bks)
+        }
+        sort.Slice(bks, func(i, j int) bool { return lessID(bks[i].ID, bks[j].ID) })
+        start, end, err := paginate(w, r, len(bks))
+        if err != nil {
+            writePageError(w, err)
+            return
+        }
+        w.Header().Add("Vary", "Accept-Language")
+        json.NewEncoder(w).Encode(renderBooks(bks[start:end], nil, acceptedLanguages(r)))
+
+    case "POST":
+        var book Book
+        if err := decodeJSON(r, &book); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        if book.AuthorID != "" && book.AuthorID != id {
+            writeError(w, "validation_failed", "author_id must be "+id+" or left out")
+            return
+        }
+        book.AuthorID = id
+        createBook(w, r, book)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "sort"
+    "strconv"
+    "time"
+)
+
+// backupFormat is the version of the backup layout written by this server.
+const backupFormat = 1
+
+// Backup is a full dump of the dataset, as written by POST /admin/backup and
+// read by POST /admin/restore. E-book files are listed but their contents
+// stay in the blob store.
+type Backup struct {
+    Format         int                    `json:"format"`
+    TakenAt        time.Time              `json:"taken_at"`
+    Books          []Book                 `json:"books"`
+    Authors        []Author               `json:"authors"`
+    Genres         []Genre                `json:"genres"`
+    Publishers     []Publisher            `json:"publishers"`
+    Reviews        []Review               `json:"reviews"`
+    Copies         []Copy                 `json:"copies"`
+    Locations      []Location             `json:"locations"`
+    PurchaseOrders []PurchaseOrder        `json:"purchase_orders"`
+    Weeding        []WeedingRecord        `json:"weeding"`
+    ILLRequests    []ILLRequest           `json:"ill_requests"`
+    Files          []EbookFile            `json:"files"`
+    Covers         []Cover                `json:"covers"` // Cover records only; the images stay in the blob store, like e-book files.
+    Templates      []NotificationTemplate `json:"templates"`
+    Sequences      map[string]int         `json:"sequences"` // Last ID handed out per record type, so new IDs don't collide.
+}
+
+// RestoreResult is the response for POST /admin/restore.
+type RestoreResult struct {
+    Books          int `json:"books"`
+    Authors        int `json:"authors"`
+    Genres         int `json:"genres"`
+    Publishers     int `json:"publishers"`
+    Reviews        int `json:"reviews"`
+    Copies         int `json:"copies"`
+    Locations      int `json:"locations"`
+    PurchaseOrders int `json:"purchase_orders"`
+    Weeding        int `json:"weeding"`
+    ILLRequests    int `json:"ill_requests"`
+    Files          int `json:"files"`
+    Covers         int `json:"covers"`
+    Templates      int `json:"templates"`
+}
+
+// lockAll takes every record lock in the order the handlers nest them and
+// returns a function that releases them.
+func lockAll() func() {
+    illRequestsMux.Lock()
+    weedingMux.Lock()
+    purchaseOrdersMux.Lock()
+    mux.Lock()
+    copiesMux.Lock()
+    locationsMux.Lock()
+    ebookMux.Lock()
+    templatesMux.Lock()
+    authorsMux.Lock()
+    genresMux.Lock()
+    publishersMux.Lock()
+    reviewsMux.Lock()
+    coversMux.Lock()
+    return func() {
+        coversMux.Unlock()
+        reviewsMux.Unlock()
+        publishersMux.Unlock()
+        genresMux.Unlock()
+        authorsMux.Unlock()
+        templatesMux.Unlock()
+        ebookMux.Unlock()
+        locationsMux.Unlock()
+        copiesMux.Unlock()
+        mux.Unlock()
+        purchaseOrdersMux.Unlock()
+        weedingMux.Unlock()
+        illRequestsMux.Unlock()
+    }
+}
+
+// takeBackup dumps every record at one instant.
+func takeBackup() (Backup, error) {
+    unlock := lockAll()
+    defer unlock()
+    bks, err := store.List()
+    if err != nil {
+        return Backup{}, err
+    }
+    b := Backup{
+        Format:         backupFormat,
+        TakenAt:        clock.Now().UTC(),
+        Books:          bks,
+        Authors:        []Author{},
+        Genres:         []Genre{},
+        Publishers:     []Publisher{},
+        Reviews:        []Review{},
+        Copies:         []Copy{},
+        Locations:      []Location{},
+        PurchaseOrders: []PurchaseOrder{},
+        Weeding:        []WeedingRecord{},
+        ILLRequests:    []ILLRequest{},
+        Files:          []EbookFile{},
+        Covers:         []Cover{},
+        Templates:      []NotificationTemplate{},
+        Sequences: map[string]int{
+            "authors":         authorSeq,
+            "publishers":      publisherSeq,
+            "reviews":         reviewSeq,
+            "copies":          copySeq,
+            "purchase_orders": purchaseOrderSeq,
+            "weeding":         weedingSeq,
+            "ill_requests":    illSeq,
+            "files":           ebookSeq,
+        },
+    }
+    for _, a := range authors {
+        b.Authors = append(b.Authors, a)
+    }
+    for _, g := range genres {
+        b.Genres = append(b.Genres, g)
+    }
+    for _, p := range publishers {
+        b.Publishers = append(b.Publishers, p)
+    }
+    for _, rv := range reviews {
+        b.Reviews = append(b.Reviews, rv)
+    }
+    for _, c := range copies {
+        b.Copies = append(b.Copies, c)
+    }
+    for _, l := range locations {
+        b.Locations = append(b.Locations, l)
+    }
+    for _, po := range purchaseOrders {
+        b.PurchaseOrders = append(b.PurchaseOrders, po)
+    }
+    for _, rec := range weeding {
+        b.Weeding = append(b.Weeding, rec)
+    }
+    for _, req := range illRequests {
+        b.ILLRequests = append(b.ILLRequests, req)
+    }
+    for _, f := range ebookFiles {
+        b.Files = append(b.Files, f)
+    }
+    for _, c := range covers {
+        b.Covers = append(b.Covers, c)
+    }
+    for _, t := range templates {
+        b.Templates = append(b.Templates, t)
+    }
+    sort.Slice(b.Authors, func(i, j int) bool { return lessID(b.Authors[i].ID, b.Authors[j].ID) })
+    sort.Slice(b.Genres, func(i, j int) bool { return b.Genres[i].ID < b.Genres[j].ID })
+    sort.Slice(b.Publishers, func(i, j int) bool { return lessID(b.Publishers[i].ID, b.Publishers[j].ID) })
+    sort.Slice(b.Reviews, func(i, j int) bool { return lessID(b.Reviews[i].ID, b.Reviews[j].ID) })
+    sort.Slice(b.Copies, func(i, j int) bool { return lessID(b.Copies[i].ID, b.Copies[j].ID) })
+    sort.Slice(b.Locations, func(i, j int) bool { return b.Locations[i].ID < b.Locations[j].ID })
+    sort.Slice(b.PurchaseOrders, func(i, j int) bool { return lessID(b.PurchaseOrders[i].ID, b.PurchaseOrders[j].ID) })
+    sort.Slice(b.Weeding, func(i, j int) bool { return lessID(b.Weeding[i].ID, b.Weeding[j].ID) })
+    sort.Slice(b.ILLRequests, func(i, j int) bool { return lessID(b.ILLRequests[i].ID, b.ILLRequests[j].ID) })
+    sort.Slice(b.Files, func(i, j int) bool { return lessID(b.Files[i].ID, b.Files[j].ID) })
+    sort.Slice(b.Covers, func(i, j int) bool { return lessID(b.Covers[i].BookID, b.Covers[j].BookID) })
+    sort.Slice(b.Templates, func(i, j int) bool { return b.Templates[i].Name < b.Templates[j].Name })
+    return b, nil
+}
+
+// checkBackup rejects a dump that can't be restored cleanly: an unknown
+// format or a record without an ID or with a duplicate one.
+func checkBackup(b Backup) error {
+    if b.Format != backupFormat {
+        return errors.New("unsupported backup format " + strconv.Itoa(b.Format) + "; expected " + strconv.Itoa(backupFormat))
+    }
+    for _, kind := range []struct {
+        name string
+        ids  []string
+    }{
+        {"books", idsOf(len(b.Books), func(i int) string { return b.Books[i].ID })},
+        {"authors", idsOf(len(b.Authors), func(i int) string { return b.Authors[i].ID })},
+        {"genres", idsOf(len(b.Genres), func(i int) string { return b.Genres[i].ID })},
+        {"publishers", idsOf(len(b.Publishers), func(i int) string { return b.Publishers[i].ID })},
+        {"reviews", idsOf(len(b.Reviews), func(i int) string { return b.Reviews[i].ID })},
+        {"copies", idsOf(len(b.Copies), func(i int) string { return b.Copies[i].ID })},
+        {"locations", idsOf(len(b.Locations), func(i int) string { return b.Locations[i].ID })},
+        {"purchase_orders", idsOf(len(b.PurchaseOrders), func(i int) string { return b.PurchaseOrders[i].ID })},
+        {"weeding", idsOf(len(b.Weeding), func(i int) string { return b.Weeding[i].ID })},
+        {"ill_requests", idsOf(len(b.ILLRequests), func(i int) string { return b.ILLRequests[i].ID })},
+        {"files", idsOf(len(b.Files), func(i int) string { return b.Files[i].ID })},
+        {"covers", idsOf(len(b.Covers), func(i int) string { return b.Covers[i].BookID })},
+        {"templates", idsOf(len(b.Templates), func(i int) string { return b.Templates[i].Name })},
+    } {
+        seen := make(map[string]bool)
+        for i, id := range kind.ids {
+            if id == "" || seen[id] {
+                return errors.New(kind.name + "[" + strconv.Itoa(i) + "]: every record needs a unique id")
+            }
+            seen[id] = true
+        }
+    }
+    return nil
+}
+
+// idsOf collects IDs from a slice of records.
+func idsOf(n int, id func(int) string) []string {
+    ids := make([]string, n)
+    for i := range ids {
+        ids[i] = id(i)
+    }
+    return ids
+}
+
+// restoreBackup replaces every record with the contents of a dump. All locks
+// are held throughout, so no request sees a half-restored dataset. The
+// change log and version history restart, like after a sandbox reset, with
+// each book keeping its version number. Book ownership for quotas is not
+// part of a backup.
+func restoreBackup(b Backup) error {
+    unlock := lockAll()
+    defer unlock()
+    current, err := store.List()
+    if err != nil {
+        return err
+    }
+    for _, book := range current {
+        if err := store.Delete(book.ID); err != nil {
+            return err
+        }
+    }
+    trash = make(map[string]DeletedBook) // Deleted books weren't part of the backup.
+    historyMux.Lock()
+    bookHistory = make(map[string][]BookVersion)
+    historyMux.Unlock()
+    now := clock.Now().UTC()
+    for _, book := range b.Books {
+        if err := store.Create(book); err != nil {
+            return err
+        }
+        restoreHistory(book, now)
+    }
+    resetChangeLog() // Cursors handed out before the restore mean nothing now.
+    bookOwners = make(map[string]string)
+
+    copies, locations, purchaseOrders = make(map[string]Copy), make(map[string]Location), make(map[string]PurchaseOrder)
+    weeding, illRequests, ebookFiles = make(map[string]WeedingRecord), make(map[string]ILLRequest), make(map[string]EbookFile)
+    for _, c := range b.Copies {
+        copies[c.ID] = c
+    }
+    for _, l := range b.Locations {
+        locations[l.ID] = l
+    }
+    for _, po := range b.PurchaseOrders {
+        purchaseOrders[po.ID] = po
+    }
+    for _, rec := range b.Weeding {
+        weeding[rec.ID] = rec
+    }
+    for _, req := range b.ILLRequests {
+        illRequests[req.ID] = req
+    }
+    for _, f := range b.Files {
+        ebookFiles[f.ID] = f
+    }
+    templates = make(map[string]NotificationTemplate)
+    for _, t := range b.Templates {
+        templates[t.Name] = t
+    }
+    authors, authorSeq = make(map[string]Author), b.Sequences["authors"]
+    for _, a := range b.Authors {
+        authors[a.ID] = a
+    }
+    genres = make(map[string]Genre)
+    for _, g := range b.Genres {
+        genres[g.ID] = g
+    }
+    publishers, publisherSeq = make(map[string]Publisher), b.Sequences["publishers"]
+    for _, p := range b.Publishers {
+        publishers[p.ID] = p
+    }
+    reviews, reviewSeq = make(map[string]Review), b.Sequences["reviews"]
+    for _, rv := range b.Reviews {
+        reviews[rv.ID] = rv
+    }
+    rebuildRatings()
+    covers = make(map[string]Cover)
+    for _, c := range b.Covers {
+        covers[c.BookID] = c
+    }
+    copySeq, purchaseOrderSeq = b.Sequences["copies"], b.Sequences["purchase_orders"]
+    weedingSeq, illSeq, ebookSeq = b.Sequences["weeding"], b.Sequences["ill_requests"], b.Sequences["files"]
+    return nil
+}
+
+// handleBackup handles POST /admin/backup, streaming a full dump of the
+// dataset. Needs the admin key.
+func handleBackup(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "POST" {
+        methodNotAllowed(w, r)
+        return
+    }
+    if !isAdmin(r) {
+        writeError(w, "admin_required", "backups require the admin key")
+        return
+    }
+    b, err := takeBackup()
+    if err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Content-Disposition", `attachment; filename="backup-`+b.TakenAt.Format("20060102T150405Z")+`.json"`)
+    json.NewEncoder(w).Encode(b)
+}
+
+// handleRestore handles POST /admin/restore, replacing the whole dataset
+// with a dump from POST /admin/backup. The dump is checked before anything
+// is touched. Needs the admin key.
+func handleRestore(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "POST" {
+        methodNotAllowed(w, r)
+        return
+    }
+    if !isAdmin(r) {
+        writeError(w, "admin_required", "restores require the admin key")
+        return
+    }
+    var b Backup
+    if err := decodeJSON(r, &b); err != nil {
+        writeDecodeError(w, err)
+        return
+    }
+    if err := checkBackup(b); err != nil {
+        writeError(w, "validation_failed", err.Error())
+        return
+    }
+    if err := restoreBackup(b); err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    json.NewEncoder(w).Encode(RestoreResult{
+        Books:          len(b.Books),
+        Authors:        len(b.Authors),
+        Genres:         len(b.Genres),
+        Publishers:     len(b.Publishers),
+        Reviews:        len(b.Reviews),
+        Copies:         len(b.Copies),
+        Locations:      len(b.Locations),
+        PurchaseOrders: len(b.PurchaseOrders),
+        Weeding:        len(b.Weeding),
+        ILLRequests:    len(b.ILLRequests),
+        Files:          len(b.Files),
+        Covers:         len(b.Covers),
+        Templates:      len(b.Templates),
+    })
+}
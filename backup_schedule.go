@@ -0,0 +1,215 @@
+package main
+
+import (
+    "compress/gzip"
+    "encoding/json"
+    "errors"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// backupPrefix and backupSuffix frame the names of stored backups. The time in
+// between sorts lexically, so the oldest backups come first in a listing.
+const (
+    backupPrefix = "backup-"
+    backupSuffix = ".json.gz"
+)
+
+var (
+    // backupSchedule is when to back up the dataset to backupTarget, as a cron
+    // expression or "@every <duration>". Empty turns scheduled backups off;
+    // POST /admin/backups still works.
+    backupSchedule = envString("BACKUP_SCHEDULE", "")
+    backupTarget   = envString("BACKUP_TARGET", "dir") // Name of the registered target to upload backups to.
+    backupDSN      = envString("BACKUP_DSN", "")       // Target-specific location, e.g. a directory or bucket URL.
+    backupKeep     = envInt("BACKUP_KEEP", 7)          // How many backups to keep; older ones are deleted after each run.
+
+    backups      BackupTarget // The configured target, opened at startup.
+    backupRunMux sync.Mutex   // Serializes runs so scheduled and manual backups don't prune each other's uploads.
+)
+
+// BackupTarget stores compressed backups by name, e.g. in a directory or an
+// S3-compatible bucket.
+type BackupTarget interface {
+    Put(name string, r io.Reader) (int64, error) // Store everything r yields; returns the size.
+    List() ([]string, error)                     // Names of the stored backups, in any order.
+    Delete(name string) error                    // Deleting a missing backup is not an error.
+}
+
+// StoredBackup is one entry in GET /admin/backups.
+type StoredBackup struct {
+    Name string `json:"name"`
+}
+
+// BackupRun is the response for POST /admin/backups.
+type BackupRun struct {
+    Name    string    `json:"name"`
+    Size    int64     `json:"size"` // Compressed size in bytes.
+    TakenAt time.Time `json:"taken_at"`
+    Books   int       `json:"books"`
+    Pruned  []string  `json:"pruned"` // Older backups deleted to stay within BACKUP_KEEP.
+}
+
+// backupTargets holds the available backup targets by name.
+var backupTargets = make(map[string]func(dsn string) (BackupTarget, error))
+
+func init() {
+    registerBackupTarget("dir", openDirBackups)
+}
+
+// registerBackupTarget makes a backup target available under a name.
+func registerBackupTarget(name string, open func(dsn string) (BackupTarget, error)) {
+    backupTargets[name] = open
+}
+
+// openBackups opens the configured backup target.
+func openBackups() (BackupTarget, error) {
+    open, ok := backupTargets[backupTarget]
+    if !ok {
+        return nil, errors.New("unknown backup target " + backupTarget)
+    }
+    return open(backupDSN)
+}
+
+// runBackup takes a backup, uploads it gzip-compressed and deletes all but
+// the newest backupKeep backups.
+func runBackup() (BackupRun, error) {
+    backupRunMux.Lock()
+    defer backupRunMux.Unlock()
+    b, err := takeBackup()
+    if err != nil {
+        return BackupRun{}, err
+    }
+    run := BackupRun{
+        Name:    backupPrefix + b.TakenAt.Format("20060102T150405.000Z") + backupSuffix,
+        TakenAt: b.TakenAt,
+        Books:   len(b.Books),
+        Pruned:  []string{},
+    }
+    pr, pw := io.Pipe()
+    go func() {
+        zw := gzip.NewWriter(pw)
+        err := json.NewEncoder(zw).Encode(b)
+        if cerr := zw.Close(); err == nil {
+            err = cerr
+        }
+        pw.CloseWithError(err)
+    }()
+    run.Size, err = backups.Put(run.Name, pr)
+    pr.Close() // Unblocks the encoder if the upload gave up early.
+    if err != nil {
+        return BackupRun{}, err
+    }
+
+    names, err := backups.List()
+    if err != nil {
+        return run, err
+    }
+    var stored []string
+    for _, name := range names {
+        if strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+            stored = append(stored, name) // Leave anything else in the target alone.
+        }
+    }
+    sort.Strings(stored)
+    for len(stored) > max(backupKeep, 1) {
+        if err := backups.Delete(stored[0]); err != nil {
+            return run, err
+        }
+        run.Pruned = append(run.Pruned, stored[0])
+        stored = stored[1:]
+    }
+    return run, nil
+}
+
+// scheduledBackup is the scheduled job that runs a backup every backupSchedule.
+func scheduledBackup() error {
+    run, err := runBackup()
+    if err != nil {
+        return err
+    }
+    log.Printf("backup %s uploaded (%d bytes); pruned %d", run.Name, run.Size, len(run.Pruned))
+    return nil
+}
+
+// handleBackups handles requests for the /admin/backups route: GET lists the
+// stored backups, POST takes one now. Needs the admin key.
+func handleBackups(w http.ResponseWriter, r *http.Request) {
+    if !isAdmin(r) {
+        writeError(w, "admin_required", "backups require the admin key")
+        return
+    }
+    switch r.Method {
+    case "GET": // List stored backups, oldest first.
+        names, err := backups.List()
+        if err != nil {
+            writeError(w, "internal_error", err.Error())
+            return
+        }
+        sort.Strings(names)
+        list := make([]StoredBackup, 0, len(names))
+        for _, name := range names {
+            if strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+                list = append(list, StoredBackup{Name: name})
+            }
+        }
+        json.NewEncoder(w).Encode(list)
+
+    case "POST": // Back up now, outside the schedule.
+        run, err := runBackup()
+        if err != nil {
+            writeError(w, "internal_error", err.Error())
+            return
+        }
+        w.WriteHeader(http.StatusCreated)
+        json.NewEncoder(w).Encode(run)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// dirBackups keeps backups as files in a directory, e.g. a mounted volume.
+type dirBackups struct {
+    dirBlobs
+}
+
+// openDirBackups uses the directory at dir, "backups" if empty. It is
+// created on the first backup, so an instance that never backs up leaves
+// nothing behind.
+func openDirBackups(dir string) (BackupTarget, error) {
+    if dir == "" {
+        dir = "backups"
+    }
+    return &dirBackups{dirBlobs{dir: dir}}, nil
+}
+
+func (b *dirBackups) Put(name string, r io.Reader) (int64, error) {
+    if err := os.MkdirAll(b.dir, 0o700); err != nil {
+        return 0, err
+    }
+    return b.dirBlobs.Put(name, r)
+}
+
+func (b *dirBackups) List() ([]string, error) {
+    entries, err := os.ReadDir(b.dir)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, nil // Nothing backed up yet.
+    }
+    if err != nil {
+        return nil, err
+    }
+    var names []string
+    for _, e := range entries {
+        if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+            names = append(names, e.Name())
+        }
+    }
+    return names, nil
+}
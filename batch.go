@@ -0,0 +1,132 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+)
+
+// maxBatchSize caps the number of sub-requests in one POST /batch call.
+const maxBatchSize = 50
+
+// apiHandler is the server's root handler; batch sub-requests are dispatched
+// through it so they see exactly the same routes and middleware as direct calls.
+var apiHandler http.Handler = http.DefaultServeMux
+
+// BatchRequest is one sub-request of a POST /batch call.
+type BatchRequest struct {
+    Method string          `json:"method"`
+    Path   string          `json:"path"`           // Path and optional query, e.g. "/book/1".
+    Body   json.RawMessage `json:"body,omitempty"` // JSON request body, if any.
+}
+
+// BatchResponse is the outcome of one sub-request.
+type BatchResponse struct {
+    Status  int               `json:"status"`
+    Headers map[string]string `json:"headers,omitempty"`
+    Body    json.RawMessage   `json:"body,omitempty"` // JSON bodies are embedded as-is; anything else as a JSON string.
+}
+
+// batchRecorder is a minimal http.ResponseWriter that captures a sub-response.
+type batchRecorder struct {
+    header http.Header
+    status int
+    body   bytes.Buffer
+}
+
+func (rec *batchRecorder) Header() http.Header { return rec.header }
+
+func (rec *batchRecorder) Write(b []byte) (int, error) {
+    if rec.status == 0 {
+        rec.status = http.StatusOK
+    }
+    return rec.body.Write(b)
+}
+
+func (rec *batchRecorder) WriteHeader(status int) {
+    if rec.status == 0 {
+        rec.status = status
+    }
+}
+
+// handleBatch handles POST /batch. The sub-requests run one after another, in
+// order, with the caller's API key, and their responses are returned as an
+// array in the same order. A failing sub-request does not stop the rest.
+func handleBatch(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "POST" {
+        methodNotAllowed(w, r)
+        return
+    }
+    var reqs []BatchRequest
+    if err := decodeJSON(r, &reqs); err != nil {
+        writeDecodeError(w, err)
+        return
+    }
+    if len(reqs) > maxBatchSize {
+        writeError(w, "validation_failed", fmt.Sprintf("a batch may contain at most %d requests", maxBatchSize))
+        return
+    }
+    for i, sub := range reqs { // Reject the whole batch up front if any entry is malformed.
+        if sub.Method == "" || !strings.HasPrefix(sub.Path, "/") {
+            writeError(w, "validation_failed", fmt.Sprintf("request %d: method and an absolute path are required", i))
+            return
+        }
+        if strings.HasPrefix(sub.Path, "/batch") {
+            writeError(w, "validation_failed", fmt.Sprintf("request %d: batches cannot be nested", i))
+            return
+        }
+    }
+
+    resps := make([]BatchResponse, 0, len(reqs))
+    for _, sub := range reqs {
+        resps = append(resps, runBatchRequest(r, sub))
+    }
+    enc := json.NewEncoder(w)
+    enc.SetEscapeHTML(false) // Keep Link headers readable.
+    enc.Encode(resps)
+}
+
+// runBatchRequest executes one sub-request against apiHandler on behalf of the outer request.
+func runBatchRequest(outer *http.Request, sub BatchRequest) BatchResponse {
+    req, err := http.NewRequestWithContext(outer.Context(), strings.ToUpper(sub.Method), sub.Path, bytes.NewReader(sub.Body))
+    if err != nil {
+        msg, _ := json.Marshal(err.Error())
+        return BatchResponse{Status: http.StatusBadRequest, Body: msg}
+    }
+    req.RemoteAddr = outer.RemoteAddr
+    req.Header.Set("X-API-Key", outer.Header.Get("X-API-Key")) // Sub-requests share the batch's credentials.
+    if v := outer.Header.Get("X-Strict-Decoding"); v != "" {
+        req.Header.Set("X-Strict-Decoding", v) // ...and its decoding mode.
+    }
+    if v := outer.Header.Get("X-API-Schema"); v != "" {
+        req.Header.Set("X-API-Schema", v) // ...and its pinned schema version.
+    }
+    if len(sub.Body) > 0 {
+        req.Header.Set("Content-Type", "application/json")
+    }
+
+    rec := &batchRecorder{header: make(http.Header)}
+    apiHandler.ServeHTTP(rec, req)
+    if rec.status == 0 {
+        rec.status = http.StatusOK // Handler wrote nothing at all.
+    }
+
+    resp := BatchResponse{Status: rec.status}
+    for k := range rec.header {
+        if resp.Headers == nil {
+            resp.Headers = make(map[string]string)
+        }
+        resp.Headers[k] = rec.header.Get(k)
+    }
+    body := bytes.TrimSpace(rec.body.Bytes())
+    switch {
+    case len(body) == 0:
+    case json.Valid(body):
+        resp.Body = body
+    default:
+        resp.Body, _ = json.Marshal(string(body)) // e.g. plain-text error messages.
+    }
+    return resp
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "os"
+    "path/filepath"
+    "sync"
+)
+
+// errBlobNotFound is returned by BlobStore.Open for a missing key.
+var errBlobNotFound = errors.New("blob not found")
+
+// BlobStore keeps file contents, such as e-book files, by key. Metadata about
+// the files lives with the API's other records; only the bytes go here.
+type BlobStore interface {
+    Put(key string, r io.Reader) (int64, error) // Store everything r yields; returns the size.
+    Open(key string) (io.ReadCloser, error)     // errBlobNotFound if the key is missing.
+    Delete(key string) error                    // Deleting a missing key is not an error.
+}
+
+var (
+    // blobs is the configured blob store, replaced by openBlobs at startup.
+    blobs BlobStore = newMemoryBlobs()
+
+    blobDriver = envString("BLOB_STORE", "memory") // Name of the registered driver to keep files in.
+    blobDSN    = envString("BLOB_DSN", "")         // Driver-specific location, e.g. a directory.
+)
+
+// blobDrivers holds the available blob stores by name.
+var blobDrivers = make(map[string]func(dsn string) (BlobStore, error))
+
+func init() {
+    registerBlobDriver("memory", func(string) (BlobStore, error) { return newMemoryBlobs(), nil })
+    registerBlobDriver("dir", openDirBlobs)
+}
+
+// registerBlobDriver makes a blob store available under a name.
+func registerBlobDriver(name string, open func(dsn string) (BlobStore, error)) {
+    blobDrivers[name] = open
+}
+
+// openBlobs opens the configured blob store.
+func openBlobs() (BlobStore, error) {
+    open, ok := blobDrivers[blobDriver]
+    if !ok {
+        return nil, errors.New("unknown blob store driver " + blobDriver)
+    }
+    return open(blobDSN)
+}
+
+// memoryBlobs keeps files in memory. Everything is lost on restart.
+type memoryBlobs struct {
+    mu    sync.RWMutex
+    files map[string][]byte
+}
+
+func newMemoryBlobs() *memoryBlobs {
+    return &memoryBlobs{files: make(map[string][]byte)}
+}
+
+func (b *memoryBlobs) Put(key string, r io.Reader) (int64, error) {
+    data, err := io.ReadAll(r)
+    if err != nil {
+        return 0, err
+    }
+    b.mu.Lock()
+    b.files[key] = data
+    b.mu.Unlock()
+    return int64(len(data)), nil
+}
+
+func (b *memoryBlobs) Open(key string) (io.ReadCloser, error) {
+    b.mu.RLock()
+    data, ok := b.files[key]
+    b.mu.RUnlock()
+    if !ok {
+        return nil, errBlobNotFound
+    }
+    return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (b *memoryBlobs) Delete(key string) error {
+    b.mu.Lock()
+    delete(b.files, key)
+    b.mu.Unlock()
+    return nil
+}
+
+// dirBlobs keeps each file in a directory on disk.
+type dirBlobs struct {
+    dir string
+}
+
+// openDirBlobs uses the directory at dir, "files" if empty, creating it if
+// needed.
+func openDirBlobs(dir string) (BlobStore, error) {
+    if dir == "" {
+        dir = "files"
+    }
+    if err := os.MkdirAll(dir, 0o700); err != nil {
+        return nil, err
+    }
+    return &dirBlobs{dir: dir}, nil
+}
+
+// path maps a key to a file name. Keys are generated by the server, but keep
+// them inside the directory regardless.
+func (b *dirBlobs) path(key string) string {
+    return filepath.Join(b.dir, filepath.Base(key))
+}
+
+// Put writes to a temporary file and renames it into place, so a failed
+// upload never leaves a partial file under the key.
+func (b *dirBlobs) Put(key string, r io.Reader) (int64, error) {
+    f, err := os.CreateTemp(b.dir, ".upload-*")
+    if err != nil {
+        return 0, err
+    }
+    n, err := io.Copy(f, r)
+    if cerr := f.Close(); err == nil {
+        err = cerr
+    }
+    if err == nil {
+        err = os.Rename(f.Name(), b.path(key))
+    }
+    if err != nil {
+        os.Remove(f.Name())
+        return 0, err
+    }
+    return n, nil
+}
+
+func (b *dirBlobs) Open(key string) (io.ReadCloser, error) {
+    f, err := os.Open(b.path(key))
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, errBlobNotFound
+    }
+    return f, err
+}
+
+func (b *dirBlobs) Delete(key string) error {
+    if err := os.Remove(b.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+        return err
+    }
+    return nil
+}
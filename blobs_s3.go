@@ -0,0 +1,68 @@
+//go:build s3
+
+package main
+
+import (
+    "context"
+    "io"
+
+    "github.com/minio/minio-go/v7"
+)
+
+func init() {
+    registerBlobDriver("s3", openS3Blobs)
+}
+
+// s3Blobs keeps files as objects in an S3-compatible bucket, sharing the
+// connection handling of the S3 backup target.
+type s3Blobs struct {
+    *s3Backups
+}
+
+// openS3Blobs connects to the bucket in dsn, in the same form as BACKUP_DSN
+// for the s3 backup target.
+func openS3Blobs(dsn string) (BlobStore, error) {
+    t, err := openS3Backups(dsn)
+    if err != nil {
+        return nil, err
+    }
+    return s3Blobs{t.(*s3Backups)}, nil
+}
+
+func (b s3Blobs) Put(key string, r io.Reader) (int64, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+    defer cancel()
+    info, err := b.client.PutObject(ctx, b.bucket, b.prefix+key, r, -1, minio.PutObjectOptions{ContentType: "application/octet-stream"})
+    if err != nil {
+        return 0, err
+    }
+    return info.Size, nil
+}
+
+func (b s3Blobs) Open(key string) (io.ReadCloser, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+    obj, err := b.client.GetObject(ctx, b.bucket, b.prefix+key, minio.GetObjectOptions{})
+    if err == nil {
+        _, err = obj.Stat() // GetObject is lazy; this is where a missing key shows.
+    }
+    if err != nil {
+        cancel()
+        if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+            return nil, errBlobNotFound
+        }
+        return nil, err
+    }
+    return s3Object{obj, cancel}, nil
+}
+
+// s3Object is an object being read, releasing its request's context once
+// closed.
+type s3Object struct {
+    *minio.Object
+    cancel context.CancelFunc
+}
+
+func (o s3Object) Close() error {
+    defer o.cancel()
+    return o.Object.Close()
+}
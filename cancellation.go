@@ -0,0 +1,61 @@
+package main
+
+import (
+    "context"
+    "io"
+    "net/http"
+)
+
+// cancelledRequests counts requests whose client went away before the
+// response was complete, by route.
+var cancelledRequests = newCounterVec("cancelled_requests_total", "Requests abandoned because the client disconnected.", "route")
+
+// abandoned reports whether there is no point carrying on with a request:
+// its client has disconnected or its X-Request-Timeout has passed.
+func abandoned(r *http.Request) bool {
+    return r.Context().Err() != nil
+}
+
+// countCancelled counts a request whose client disconnected and reports
+// whether it was one, for the access log. It runs once the handler returns,
+// so it sees disconnects at any point of the request.
+func countCancelled(r *http.Request) bool {
+    if r.Context().Err() != context.Canceled {
+        return false
+    }
+    _, route := http.DefaultServeMux.Handler(r)
+    cancelledRequests.Inc(route)
+    return true
+}
+
+// writeStoreError reports a failed store read. A read given up because the
+// request was abandoned gets no response at all: a disconnected client can't
+// read one, and after a timeout withRequestTimeout has already answered 504.
+// The access log and cancelled_requests_total record it instead.
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+    if abandoned(r) {
+        return
+    }
+    writeError(w, "internal_error", err.Error())
+}
+
+// abandonTransfer records an export or import given up because its request
+// was abandoned as a failed job, without writing a response.
+func abandonTransfer(r *http.Request, job TransferJob, message string) {
+    job.Error = message + ": " + r.Context().Err().Error()
+    finishTransfer(job)
+}
+
+// contextWriter is a Writer that fails once ctx is done, so encoders writing
+// a long response stop at their next write after the client goes away.
+type contextWriter struct {
+    ctx context.Context
+    w   io.Writer
+}
+
+func (cw contextWriter) Write(p []byte) (int, error) {
+    if err := cw.ctx.Err(); err != nil {
+        return 0, err
+    }
+    return cw.w.Write(p)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+)
+
+// Capabilities is the response for GET /capabilities. It describes how this
+// deployment is configured so client SDKs can adapt instead of hard-coding it.
+type Capabilities struct {
+    Features map[string]bool   `json:"features"` // Optional features and whether they are enabled here.
+    Formats  CapabilityFormats `json:"formats"`
+    Auth     CapabilityAuth    `json:"auth"`
+    Limits   CapabilityLimits  `json:"limits"`
+    IDs      string            `json:"id_strategy"`     // How new book IDs are chosen: user, sequential, uuidv7 or ulid.
+    Custom   []CustomField     `json:"custom_fields"`   // Deployment-specific book fields, kept under each book's "custom".
+    Schemas  []SchemaVersion   `json:"schema_versions"` // Versions X-API-Schema can pin, oldest first; the last is current.
+}
+
+// CapabilityFormats lists the media types the API reads and writes.
+type CapabilityFormats struct {
+    Request  []string `json:"request"`
+    Response []string `json:"response"`
+    Reports  []string `json:"reports"` // Formats for downloadable reports such as the deaccession report.
+    Import   []string `json:"import"`  // Formats accepted by POST /books/import.
+    Export   []string `json:"export"`  // Formats offered by GET /books/export.
+}
+
+// CapabilityAuth describes how clients authenticate.
+type CapabilityAuth struct {
+    Modes           []string `json:"modes"`             // Supported authentication schemes.
+    Header          string   `json:"header"`            // Header carrying the API key.
+    Sandbox         bool     `json:"sandbox"`           // Whether this is a sandbox instance.
+    IfMatchRequired bool     `json:"if_match_required"` // Whether PUT, PATCH and DELETE of an existing book must carry If-Match.
+}
+
+// CapabilityLimits lists server-enforced limits.
+type CapabilityLimits struct {
+    DefaultPerPage    int `json:"default_per_page"`
+    MaxPerPage        int `json:"max_per_page"`
+    MaxUnpaginated    int `json:"max_unpaginated_results"` // 0 if unpaginated lists are unlimited.
+    MaxBatchSize      int `json:"max_batch_size"`
+    MaxChangesWait    int `json:"max_changes_wait_seconds"`
+    MaxRequestTimeout int `json:"max_request_timeout_seconds"`
+    ChangeLogSize     int `json:"change_log_size"`
+    MaxMetadataKeys   int `json:"max_metadata_keys"`
+}
+
+// capabilities describes the running configuration.
+func capabilities() Capabilities {
+    return Capabilities{
+        Features: map[string]bool{
+            "pagination":      true,
+            "batch":           true,
+            "authors":         true,
+            "genres":          true,
+            "tags":            true,
+            "soft_delete":     true,
+            "publishers":      true,
+            "changes_feed":    true,
+            "computed_fields": true,
+            "delta_sync":      true,
+            "dry_run":         true,
+            "ebook_files":     true,
+            "etags":           true,
+            "method_override": true,
+            "read_only":       readOnlyState().ReadOnly, // Whether writes are currently refused.
+            "request_timeout": true,
+            "reviews":         true,
+            "covers":          true,
+            "isbn_lookup":     true,
+            "public_tier":     publicAPI,
+            "archive":         archive != nil,
+            "saved_searches":  true,
+            "strict_decoding": strictDecoding, // Default mode; clients can still opt in per request.
+            "search":          false,
+            "webhooks":        false,
+        },
+        Formats: CapabilityFormats{
+            Request:  []string{"application/json"},
+            Response: []string{"application/json"},
+            Reports:  []string{"application/json", "text/csv"},
+            Import:   importFormats(),
+            Export:   exportFormats(),
+        },
+        Auth: CapabilityAuth{
+            Modes:           []string{"api_key"},
+            Header:          "X-API-Key",
+            Sandbox:         sandboxMode,
+            IfMatchRequired: requireIfMatch,
+        },
+        IDs:     idStrategy,
+        Custom:  append([]CustomField{}, customFields...),
+        Schemas: schemaVersions(),
+        Limits: CapabilityLimits{
+            DefaultPerPage:    defaultPerPage,
+            MaxPerPage:        maxPerPage,
+            MaxUnpaginated:    maxUnpaginated,
+            MaxBatchSize:      maxBatchSize,
+            MaxChangesWait:    int(maxChangesWait.Seconds()),
+            MaxRequestTimeout: int(maxRequestTimeout.Seconds()),
+            ChangeLogSize:     changeLogSize,
+            MaxMetadataKeys:   maxMetadataKeys,
+        },
+    }
+}
+
+// handleCapabilities handles requests for the /capabilities route.
+func handleCapabilities(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    json.NewEncoder(w).Encode(capabilities())
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Change operations recorded in the change log.
+const (
+    ChangeCreate = "create"
+    ChangeUpdate = "update"
+    ChangeDelete = "delete"
+)
+
+// maxChangesWait caps how long GET /books/changes may block.
+const maxChangesWait = 60 * time.Second
+
+// Change struct defines one entry in the book change log.
+type Change struct {
+    Seq    int64                `json:"seq"`            // Position in the log; cursors refer to this.
+    Op     string               `json:"op"`             // create, update or delete.
+    BookID string               `json:"book_id"`        // ID of the affected book.
+    Book   *Book                `json:"book,omitempty"` // The book after the change; omitted for deletes.
+    Diff   map[string]FieldDiff `json:"diff,omitempty"` // Updates only: the fields that changed, with old and new values.
+    At     time.Time            `json:"at"`             // When the change happened.
+}
+
+// ChangesResponse is the response for GET /books/changes.
+type ChangesResponse struct {
+    Changes []Change `json:"changes"`
+    Cursor  string   `json:"cursor"` // Pass back as ?since= to get the next batch.
+}
+
+var (
+    changeLog     []Change                           // Most recent changes, oldest first.
+    changeSeq     int64                              // Seq of the last recorded change.
+    changeFloor   int64                              // Seq of the newest change no longer retained.
+    listedAt      time.Time                          // When the books as listed last changed, including changes the log doesn't record.
+    changeNotify  = make(chan struct{})              // Closed and replaced whenever a change is recorded.
+    changeLogSize = envInt("CHANGE_LOG_SIZE", 10000) // How many changes to retain.
+    changesMux    sync.Mutex                         // Mutex to safeguard changeLog, changeSeq, listedAt and changeNotify.
+
+    // changeEpoch identifies this run of the server in the cursors it hands
+    // out, as seqs start again from 1 on every run. It comes from the system
+    // clock rather than clock or rng, which repeat from run to run when pinned.
+    changeEpoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+)
+
+// Errors from parseCursor.
+var (
+    errCursorInvalid = errors.New("since must be a cursor returned by this endpoint")
+    errCursorExpired = errors.New("cursor is from a previous run of the server")
+)
+
+// formatCursor returns the cursor handed to clients for a change log seq:
+// "<epoch>:<seq>".
+func formatCursor(seq int64) string {
+    return changeEpoch + ":" + strconv.FormatInt(seq, 10)
+}
+
+// parseCursor returns the seq in a cursor made by formatCursor. A cursor from
+// another run of the server gives errCursorExpired, however its seq compares.
+func parseCursor(v string) (int64, error) {
+    epoch, seq, ok := strings.Cut(v, ":")
+    n, err := strconv.ParseInt(seq, 10, 64)
+    if !ok || epoch == "" || err != nil || n < 0 {
+        return 0, errCursorInvalid
+    }
+    if epoch != changeEpoch {
+        return 0, errCursorExpired
+    }
+    return n, nil
+}
+
+// recordChange appends a change to the log and wakes up long-polling clients.
+// Callers hold mux so changes are logged in the same order they are applied.
+func recordChange(op, bookID string, book *Book) {
+    recordChangeVia("", op, bookID, book)
+}
+
+// recordChangeVia is recordChange for writes made through a path such as
+// import, sync or revert, noting it in the book's history.
+func recordChangeVia(via, op, bookID string, book *Book) {
+    changesMux.Lock()
+    defer changesMux.Unlock()
+    changeSeq++
+    now := clock.Now().UTC()
+    listedAt = now
+    c := Change{Seq: changeSeq, Op: op, BookID: bookID, Book: book, At: now}
+    if prev := bookAtVersion(bookID, currentVersion(bookID)); op == ChangeUpdate && prev != nil && book != nil {
+        c.Diff = fieldDiff(*prev, *book) // Consumers needn't keep the previous version to see what changed.
+    }
+    changeLog = append(changeLog, c)
+    recordVersion(bookID, book, now, via) // Keep the full history for ?as_of= reads.
+    if len(changeLog) > changeLogSize {
+        changeLog = append([]Change(nil), changeLog[len(changeLog)-changeLogSize:]...) // Drop the oldest entries.
+        changeFloor = changeLog[0].Seq - 1
+    }
+    close(changeNotify)
+    changeNotify = make(chan struct{})
+}
+
+// lastChangeAt returns when the books as listed last changed, or the zero
+// time if that isn't known, as right after startup.
+func lastChangeAt() time.Time {
+    changesMux.Lock()
+    defer changesMux.Unlock()
+    return listedAt
+}
+
+// touchCatalog notes a change to the books as listed that isn't logged as a
+// change to a book, such as archiving, rehydration or a trash purge, so
+// GET /books doesn't answer 304 across it.
+func touchCatalog() {
+    changesMux.Lock()
+    listedAt = clock.Now().UTC()
+    changesMux.Unlock()
+}
+
+// resetChangeLog discards the whole log, e.g. after the data set was replaced
+// wholesale. Clients holding an older cursor get 410 and must reload.
+// Callers hold mux.
+func resetChangeLog() {
+    changesMux.Lock()
+    defer changesMux.Unlock()
+    changeSeq++                             // Advance past every cursor handed out so far...
+    changeLog, changeFloor = nil, changeSeq // ...and make them all too old.
+    listedAt = clock.Now().UTC()
+    close(changeNotify)                     // Wake long-polling clients so they learn about it now.
+    changeNotify = make(chan struct{})
+}
+
+// currentChangeSeq returns the seq of the last recorded change.
+func currentChangeSeq() int64 {
+    changesMux.Lock()
+    defer changesMux.Unlock()
+    return changeSeq
+}
+
+// changesSince returns the retained changes after the given seq, the current
+// seq, and whether the cursor is still good: the log must reach back that far,
+// and the seq must have been handed out by this log.
+func changesSince(since int64) ([]Change, int64, chan struct{}, bool) {
+    changesMux.Lock()
+    defer changesMux.Unlock()
+    if since < changeFloor {
+        return nil, changeSeq, changeNotify, false // Entries the client needs have been dropped.
+    }
+    if since > changeSeq {
+        return nil, changeSeq, changeNotify, false // Never handed out; the seq it waits for may never come.
+    }
+    out := make([]Change, 0)
+    for _, c := range changeLog {
+        if c.Seq > since {
+            out = append(out, c)
+        }
+    }
+    return out, changeSeq, changeNotify, true
+}
+
+// handleBookChanges handles GET /books/changes?since=<cursor>&wait=30s. If there
+// are no changes after the cursor it blocks for up to wait until one arrives,
+// so clients that cannot keep a streaming connection open can still follow
+// the catalog with a cheap request loop.
+func handleBookChanges(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    q := r.URL.Query()
+    since := currentChangeSeq() // Without a cursor, follow changes from now on.
+    if v := q.Get("since"); v != "" {
+        n, err := parseCursor(v)
+        if errors.Is(err, errCursorExpired) {
+            writeError(w, "cursor_expired", "cursor is from before a restart; reload the full list and start again")
+            return
+        } else if err != nil {
+            writeError(w, "invalid_query", err.Error())
+            return
+        }
+        since = n
+    }
+    var wait time.Duration
+    if v := q.Get("wait"); v != "" {
+        d, err := time.ParseDuration(v)
+        if err != nil || d < 0 {
+            writeError(w, "invalid_query", "wait must be a duration such as 30s")
+            return
+        }
+        wait = min(d, maxChangesWait)
+    }
+
+    timer := time.NewTimer(wait)
+    defer timer.Stop()
+    for {
+        chs, cursor, notify, ok := changesSince(since)
+        if !ok {
+            writeError(w, "cursor_expired", "cursor is too old or from before a restart; reload the full list and start again")
+            return
+        }
+        for i, c := range chs {
+            chs[i] = visibleChange(r, c)
+        }
+        if len(chs) > 0 || wait == 0 {
+            json.NewEncoder(w).Encode(ChangesResponse{Changes: chs, Cursor: formatCursor(cursor)})
+            return
+        }
+        select {
+        case <-notify: // Something changed; loop to collect it.
+        case <-timer.C:
+            wait = 0 // Timed out; answer with an empty batch.
+        case <-r.Context().Done():
+            return // Client went away.
+        }
+    }
+}
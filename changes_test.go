@@ -0,0 +1,51 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestChangeCursors(t *testing.T) {
+    useTestSeams(t, time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC))
+    since := formatCursor(currentChangeSeq())
+    if w := serveBook("PUT", "/book/cursor-1", `{"title":"Dune"}`, nil); w.Code != http.StatusOK {
+        t.Fatalf("PUT = %d %s", w.Code, w.Body)
+    }
+    get := func(handler http.HandlerFunc, path string) *httptest.ResponseRecorder {
+        w := httptest.NewRecorder()
+        handler(w, httptest.NewRequest("GET", path, nil))
+        return w
+    }
+
+    w := get(handleBookChanges, "/books/changes?since="+since)
+    var resp ChangesResponse
+    if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+        t.Fatalf("changes = %d %s", w.Code, w.Body)
+    }
+    if len(resp.Changes) != 1 || resp.Cursor != formatCursor(currentChangeSeq()) {
+        t.Errorf("changes = %+v, want the PUT and the current cursor", resp)
+    }
+
+    // A seq the log has handed out, but from an earlier run of the server.
+    previous := "0:" + since[len(changeEpoch)+1:]
+    for _, tt := range []struct {
+        name    string
+        handler http.HandlerFunc
+        path    string
+    }{
+        {"changes", handleBookChanges, "/books/changes?since="},
+        {"sync", handleSync, "/sync?since="},
+    } {
+        if w := get(tt.handler, tt.path+previous); w.Code != http.StatusGone {
+            t.Errorf("%s with a cursor from before a restart = %d, want %d", tt.name, w.Code, http.StatusGone)
+        }
+        for _, bad := range []string{"5", changeEpoch + ":", changeEpoch + ":-1"} {
+            if w := get(tt.handler, tt.path+bad); w.Code != http.StatusBadRequest {
+                t.Errorf("%s with cursor %q = %d, want %d", tt.name, bad, w.Code, http.StatusBadRequest)
+            }
+        }
+    }
+}
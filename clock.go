@@ -0,0 +1,124 @@
+package main
+
+import (
+    crand "crypto/rand"
+    "log"
+    "math/big"
+    "math/rand"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// Clock is where the server reads the time for everything it stores or
+// returns: timestamps, time-ordered IDs, link expiry and job schedules.
+// Durations that only measure work, such as request latency in the access
+// log, and deadlines on real I/O stay on the system clock.
+type Clock interface {
+    Now() time.Time
+    Sleep(d time.Duration) // Waits until Now has moved on by d.
+}
+
+// Random is the source of randomness for IDs, signing keys and job jitter.
+type Random interface {
+    Read(b []byte) (int, error)
+    Int63n(n int64) int64 // A number in [0, n).
+}
+
+var (
+    // clock is the system clock, or a fixed one starting at CLOCK_START
+    // (RFC 3339) so timestamps come out the same on every run.
+    clock Clock = openClock(envString("CLOCK_START", ""))
+
+    // rng is crypto/rand, or a math/rand source seeded with RANDOM_SEED so
+    // generated IDs and keys come out the same on every run.
+    rng Random = openRandom(envString("RANDOM_SEED", ""))
+)
+
+// openClock returns the clock for CLOCK_START. Unlike other settings a bad
+// value stops the server, since falling back would quietly give up the
+// determinism that was asked for.
+func openClock(start string) Clock {
+    if start == "" {
+        return systemClock{}
+    }
+    t, err := time.Parse(time.RFC3339Nano, start)
+    if err != nil {
+        log.Fatalf("CLOCK_START must be an RFC 3339 time: %v", err)
+    }
+    return &fixedClock{now: t.UTC()}
+}
+
+// openRandom returns the source of randomness for RANDOM_SEED.
+func openRandom(seed string) Random {
+    if seed == "" {
+        return systemRandom{}
+    }
+    n, err := strconv.ParseInt(seed, 10, 64)
+    if err != nil {
+        log.Fatalf("RANDOM_SEED must be an integer: %v", err)
+    }
+    log.Printf("RANDOM_SEED is set: IDs and signing keys are predictable; use it for tests and replays only")
+    return &seededRandom{r: rand.New(rand.NewSource(n))}
+}
+
+// systemClock is the real wall clock.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time        { return time.Now() }
+func (systemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// fixedClock only moves when something sleeps on it, by exactly the time
+// slept, so the times the server records depend only on what it was asked
+// to do. Sleeps still take real time, so scheduled jobs don't spin.
+type fixedClock struct {
+    mu  sync.Mutex
+    now time.Time
+}
+
+func (c *fixedClock) Now() time.Time {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.now
+}
+
+func (c *fixedClock) Sleep(d time.Duration) {
+    if d <= 0 {
+        return
+    }
+    time.Sleep(d)
+    c.mu.Lock()
+    c.now = c.now.Add(d)
+    c.mu.Unlock()
+}
+
+// systemRandom is crypto/rand.
+type systemRandom struct{}
+
+func (systemRandom) Read(b []byte) (int, error) { return crand.Read(b) }
+
+func (systemRandom) Int63n(n int64) int64 {
+    v, err := crand.Int(crand.Reader, big.NewInt(n))
+    if err != nil {
+        panic(err) // crypto/rand only fails when the OS has no entropy source at all.
+    }
+    return v.Int64()
+}
+
+// seededRandom is a seeded math/rand source, safe for concurrent use.
+type seededRandom struct {
+    mu sync.Mutex
+    r  *rand.Rand
+}
+
+func (s *seededRandom) Read(b []byte) (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.r.Read(b)
+}
+
+func (s *seededRandom) Int63n(n int64) int64 {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.r.Int63n(n)
+}
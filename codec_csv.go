@@ -0,0 +1,87 @@
+package main
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "io"
+)
+
+// csvCodec imports and exports the catalog as CSV with a header row naming
+// Book's JSON fields. String fields are written as-is and any other field as
+// JSON, so the layout follows Book without listing its fields here.
+type csvCodec struct{}
+
+func init() { registerCodec(csvCodec{}) }
+
+func (csvCodec) Name() string      { return "csv" }
+func (csvCodec) MediaType() string { return "text/csv" }
+
+func (csvCodec) EncodeBooks(w io.Writer, books []Book) error {
+    cols := bookColumns()
+    cw := csv.NewWriter(w)
+    header := make([]string, len(cols))
+    for i, col := range cols {
+        header[i] = col.Name
+    }
+    cw.Write(header)
+    for _, book := range books {
+        fields := bookFields(book)
+        row := make([]string, len(cols))
+        for i, col := range cols {
+            raw := fields[col.Name]
+            switch {
+            case col.Text:
+                json.Unmarshal(raw, &row[i])
+            case raw != nil && string(raw) != "null":
+                row[i] = string(raw) // Not a string: keep the JSON.
+            }
+        }
+        cw.Write(row)
+    }
+    cw.Flush()
+    return cw.Error()
+}
+
+func (csvCodec) DecodeBooks(r io.Reader) ([]Book, error) {
+    rows, err := csv.NewReader(r).ReadAll()
+    if err != nil {
+        return nil, err
+    }
+    if len(rows) == 0 {
+        return nil, fmt.Errorf("missing header row")
+    }
+    columns := make(map[string]bookColumn)
+    for _, col := range bookColumns() {
+        columns[col.Name] = col
+    }
+    header := rows[0]
+    for _, name := range header {
+        if _, ok := columns[name]; !ok {
+            return nil, fmt.Errorf("unknown column %q", name)
+        }
+    }
+
+    books := make([]Book, 0, len(rows)-1)
+    for n, row := range rows[1:] {
+        obj := make(map[string]json.RawMessage, len(header))
+        for i, name := range header {
+            switch v := row[i]; {
+            case columns[name].Text:
+                obj[name], _ = json.Marshal(v)
+            case v != "":
+                obj[name] = json.RawMessage(v) // Numbers, lists and objects were written as JSON.
+            }
+        }
+        data, err := json.Marshal(obj)
+        var book Book
+        if err == nil {
+            err = json.Unmarshal(data, &book)
+        }
+        if err != nil {
+            return nil, fmt.Errorf("row %d: %v", n+2, err) // +2: rows count from 1 and the header is row 1.
+        }
+        books = append(books, book)
+    }
+    return books, nil
+}
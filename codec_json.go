@@ -0,0 +1,25 @@
+package main
+
+import (
+    "encoding/json"
+    "io"
+)
+
+// jsonCodec imports and exports the catalog as a JSON array of books, the same
+// shape GET /books returns.
+type jsonCodec struct{}
+
+func init() { registerCodec(jsonCodec{}) }
+
+func (jsonCodec) Name() string      { return "json" }
+func (jsonCodec) MediaType() string { return "application/json" }
+
+func (jsonCodec) EncodeBooks(w io.Writer, books []Book) error {
+    return json.NewEncoder(w).Encode(books)
+}
+
+func (jsonCodec) DecodeBooks(r io.Reader) ([]Book, error) {
+    var books []Book
+    err := json.NewDecoder(r).Decode(&books)
+    return books, err
+}
@@ -0,0 +1,52 @@
+//go:build yaml
+
+package main
+
+import (
+    "encoding/json"
+    "io"
+
+    "gopkg.in/yaml.v3"
+)
+
+// yamlCodec imports and exports the catalog as a YAML list of books, with
+// the same field names as JSON. Build with -tags yaml to include it.
+type yamlCodec struct{}
+
+func init() { registerCodec(yamlCodec{}) }
+
+func (yamlCodec) Name() string      { return "yaml" }
+func (yamlCodec) MediaType() string { return "application/yaml" }
+
+// EncodeBooks goes through JSON so fields keep their JSON names and omitempty rules.
+func (yamlCodec) EncodeBooks(w io.Writer, books []Book) error {
+    data, err := json.Marshal(books)
+    if err != nil {
+        return err
+    }
+    var v []map[string]interface{}
+    if err := json.Unmarshal(data, &v); err != nil {
+        return err
+    }
+    enc := yaml.NewEncoder(w)
+    if err := enc.Encode(v); err != nil {
+        return err
+    }
+    return enc.Close()
+}
+
+// DecodeBooks reads generic YAML and converts it through JSON, so Book's JSON
+// tags apply and YAML doesn't need tags of its own.
+func (yamlCodec) DecodeBooks(r io.Reader) ([]Book, error) {
+    var v []interface{}
+    if err := yaml.NewDecoder(r).Decode(&v); err != nil {
+        return nil, err
+    }
+    data, err := json.Marshal(v)
+    if err != nil {
+        return nil, err
+    }
+    var books []Book
+    err = json.Unmarshal(data, &books)
+    return books, err
+}
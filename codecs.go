@@ -0,0 +1,299 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "io"
+    "net/http"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// BookCodec is an import/export format for the catalog. Formats live in their
+// own codec_*.go file and register themselves from init, so adding one (MARC,
+// ONIX, ...) doesn't touch the handlers.
+type BookCodec interface {
+    Name() string      // Value of ?format=, e.g. "csv".
+    MediaType() string // Content-Type of exported files.
+}
+
+// BookEncoder is a codec that can export books.
+type BookEncoder interface {
+    BookCodec
+    EncodeBooks(w io.Writer, books []Book) error
+}
+
+// BookDecoder is a codec that can import books.
+type BookDecoder interface {
+    BookCodec
+    DecodeBooks(r io.Reader) ([]Book, error)
+}
+
+// codecs holds every registered format by name. It is only written from init.
+var codecs = make(map[string]BookCodec)
+
+// registerCodec makes a format available for import, export or both,
+// depending on which of BookEncoder and BookDecoder it implements.
+func registerCodec(c BookCodec) {
+    if _, ok := codecs[c.Name()]; ok {
+        panic("codec " + c.Name() + " registered twice")
+    }
+    codecs[c.Name()] = c
+}
+
+// exportFormats lists the names of the formats books can be exported in.
+func exportFormats() []string {
+    var names []string
+    for name, c := range codecs {
+        if _, ok := c.(BookEncoder); ok {
+            names = append(names, name)
+        }
+    }
+    sort.Strings(names)
+    return names
+}
+
+// importFormats lists the names of the formats books can be imported from.
+func importFormats() []string {
+    var names []string
+    for name, c := range codecs {
+        if _, ok := c.(BookDecoder); ok {
+            names = append(names, name)
+        }
+    }
+    sort.Strings(names)
+    return names
+}
+
+// ImportResult is the response for POST /books/import.
+type ImportResult struct {
+    JobID      string           `json:"job_id"` // See GET /job/{id}.
+    OnConflict string           `json:"on_conflict"`
+    Created    int              `json:"created"`
+    Updated    int              `json:"updated"`
+    Skipped    int              `json:"skipped"`
+    Conflicts  []ImportConflict `json:"conflicts"`         // Records whose ID was already in the catalog, in file order.
+    DryRun     bool             `json:"dry_run,omitempty"` // The counts are what the import would do; nothing was written.
+}
+
+// handleBooksExport handles GET /books/export?format=csv, downloading the whole
+// catalog in any registered export format (JSON by default). The file is kept
+// for EXPORT_RETENTION so it can be downloaded again from the job record.
+func handleBooksExport(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    format := r.URL.Query().Get("format")
+    if format == "" {
+        format = "json"
+    }
+    enc, ok := codecs[format].(BookEncoder)
+    if !ok {
+        writeError(w, "invalid_query", "format must be one of: "+strings.Join(exportFormats(), ", "))
+        return
+    }
+    job := newTransferJob(r, TransferExport, format)
+    mux.RLock()
+    bks, err := listWithContext(r.Context(), store)
+    mux.RUnlock()
+    if !isAdmin(r) {
+        bks = publishedOnly(bks) // Only staff export drafts and archived books.
+    }
+    var buf bytes.Buffer
+    if err == nil {
+        err = enc.EncodeBooks(contextWriter{r.Context(), &buf}, bks) // Stops at the next write once the client has gone.
+    }
+    if abandoned(r) {
+        abandonTransfer(r, job, "export cancelled")
+        return
+    } else if err != nil {
+        writeTransferError(w, job, "internal_error", err.Error())
+        return
+    }
+    job.Rows, job.Size, job.mediaType = len(bks), int64(buf.Len()), enc.MediaType()
+    keepExport(&job, buf.Bytes())
+    finishTransfer(job)
+
+    w.Header().Set("Content-Type", enc.MediaType())
+    w.Header().Set("Content-Disposition", `attachment; filename="books.`+format+`"`)
+    w.Header().Set("Content-Location", "/job/"+job.ID) // The job record, with a link to download this file again.
+    w.Write(buf.Bytes())
+}
+
+// handleBooksImport handles POST /books/import?format=csv. The whole file is
+// decoded and checked before anything is written, so a bad row leaves the
+// catalog untouched. Records with an existing ID are handled as ?on_conflict=
+// says, overwriting the book by default, and listed in the conflict report.
+func handleBooksImport(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "POST" {
+        methodNotAllowed(w, r)
+        return
+    }
+    format := r.URL.Query().Get("format")
+    if format == "" {
+        format = "json"
+    }
+    dec, ok := codecs[format].(BookDecoder)
+    if !ok {
+        writeError(w, "invalid_query", "format must be one of: "+strings.Join(importFormats(), ", "))
+        return
+    }
+    strategy := r.URL.Query().Get("on_conflict")
+    if strategy == "" {
+        strategy = ConflictOverwrite
+    }
+    if !contains(importConflictStrategies, strategy) {
+        writeError(w, "invalid_query", "on_conflict must be one of: "+strings.Join(importConflictStrategies, ", "))
+        return
+    }
+    job := newTransferJob(r, TransferImport, format)
+    bks, err := dec.DecodeBooks(r.Body)
+    if abandoned(r) {
+        abandonTransfer(r, job, "import cancelled before anything was written")
+        return
+    } else if err != nil {
+        writeTransferError(w, job, "validation_failed", err.Error())
+        return
+    }
+    job.Rows = len(bks)
+    seen := make(map[string]bool)
+    raw := make([]map[string]json.RawMessage, len(bks)) // Records as decoded, for merge-nonempty.
+    for i, book := range bks {
+        if seen[book.ID] && book.ID != "" {
+            writeTransferError(w, job, "validation_failed", "record "+strconv.Itoa(i+1)+": every book needs a unique id")
+            return
+        }
+        seen[book.ID] = true
+        if strategy == ConflictMergeNonEmpty {
+            raw[i] = bookFields(book)
+        }
+        if err := checkBook(&bks[i]); err != nil {
+            writeTransferError(w, job, "validation_failed", "record "+strconv.Itoa(i+1)+": "+err.Error())
+            return
+        }
+    }
+
+    result := ImportResult{JobID: job.ID, OnConflict: strategy, Conflicts: []ImportConflict{}}
+    key := r.Header.Get("X-API-Key")
+    if abandoned(r) { // Last chance to give up cleanly; once writing starts the import runs to the end.
+        abandonTransfer(r, job, "import cancelled before anything was written")
+        return
+    }
+    mux.Lock()
+    writes := make([]Book, 0, len(bks)) // Records left to write once conflicts are resolved.
+    for i := range bks { // New books get their IDs and conflicts are resolved first, so a bad record stops the import before anything is written.
+        prev, exists, err := store.Get(bks[i].ID)
+        conflict := ImportConflict{}
+        switch {
+        case err == nil && !exists:
+            err = assignID(&bks[i])
+        case err == nil:
+            conflict, err = resolveImportConflict(strategy, i+1, prev, &bks[i], raw[i])
+            result.Conflicts = append(result.Conflicts, conflict)
+        }
+        if err != nil {
+            mux.Unlock()
+            writeTransferError(w, job, "validation_failed", "record "+strconv.Itoa(i+1)+": "+err.Error())
+            return
+        }
+        if conflict.Action == ImportSkipped {
+            result.Skipped++
+            continue
+        }
+        writes = append(writes, bks[i])
+    }
+    if strategy == ConflictFail && len(result.Conflicts) > 0 {
+        mux.Unlock()
+        job.Error = strconv.Itoa(len(result.Conflicts)) + " records have IDs already in the catalog"
+        finishTransfer(job)
+        writeErrorDetails(w, "import_conflict", job.Error, map[string][]ImportConflict{"conflicts": result.Conflicts})
+        return
+    }
+    if err := checkWriteQuota(key, writes); err != nil {
+        mux.Unlock()
+        writeTransferError(w, job, "quota_exceeded", err.Error())
+        return
+    }
+    if isDryRun(r) {
+        for _, book := range writes {
+            _, exists, err := store.Get(book.ID)
+            if err != nil {
+                mux.Unlock()
+                writeTransferError(w, job, "internal_error", err.Error())
+                return
+            }
+            if exists {
+                result.Updated++
+            } else {
+                result.Created++
+            }
+        }
+        mux.Unlock()
+        result.DryRun, job.DryRun = true, true
+        job.Created, job.Updated, job.Skipped = result.Created, result.Updated, result.Skipped
+        finishTransfer(job)
+        w.Header().Set("X-Dry-Run", "true")
+        json.NewEncoder(w).Encode(result) // Report the import without applying it.
+        return
+    }
+    for i, book := range writes {
+        op := ChangeCreate
+        prev, exists, err := store.Get(book.ID)
+        if err != nil {
+            mux.Unlock()
+            job.Created, job.Updated = result.Created, result.Updated
+            writeTransferError(w, job, "internal_error", err.Error()) // Records before this one have been written.
+            return
+        }
+        if exists {
+            op = ChangeUpdate
+        }
+        stampBook(&writes[i], prev, exists) // Versions and timestamps in the file are ignored.
+        if err := saveBook(op, writes[i]); err != nil {
+            mux.Unlock()
+            job.Created, job.Updated = result.Created, result.Updated
+            writeTransferError(w, job, "internal_error", err.Error())
+            return
+        }
+        if op == ChangeCreate {
+            result.Created++
+        } else {
+            result.Updated++
+        }
+        if op == ChangeCreate {
+            claimBook(key, book.ID)
+        }
+        recordChangeVia("import", op, book.ID, &writes[i])
+    }
+    mux.Unlock()
+    job.Created, job.Updated, job.Skipped = result.Created, result.Updated, result.Skipped
+    finishTransfer(job)
+    json.NewEncoder(w).Encode(result)
+}
+
+// bookColumn is one of Book's fields, for formats with a fixed column layout.
+type bookColumn struct {
+    Name string // JSON name of the field.
+    Text bool   // Whether the field is a plain string rather than a number, list, etc.
+}
+
+// bookColumns returns Book's fields in declaration order.
+func bookColumns() []bookColumn {
+    t := reflect.TypeOf(Book{})
+    var cols []bookColumn
+    for i := 0; i < t.NumField(); i++ {
+        f := t.Field(i)
+        name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+        if name == "-" {
+            continue
+        }
+        if name == "" {
+            name = f.Name
+        }
+        cols = append(cols, bookColumn{Name: name, Text: f.Type.Kind() == reflect.String})
+    }
+    return cols
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+// importBooks runs a JSON import through handleBooksImport.
+func importBooks(t *testing.T, query, body string) (*httptest.ResponseRecorder, ImportResult) {
+    t.Helper()
+    w := httptest.NewRecorder()
+    handleBooksImport(w, httptest.NewRequest("POST", "/books/import?format=json&"+query, strings.NewReader(body)))
+    var result ImportResult
+    if w.Code == http.StatusOK {
+        if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return w, result
+}
+
+func TestImportDryRun(t *testing.T) {
+    useTestSeams(t, time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC))
+    if w := serveBook("PUT", "/book/dry-1", `{"title":"Dune"}`, nil); w.Code != http.StatusOK {
+        t.Fatalf("PUT = %d %s", w.Code, w.Body)
+    }
+    seq := currentChangeSeq()
+
+    file := `[{"id":"dry-1","title":"Dune Messiah"},{"id":"dry-2","title":"Children of Dune"}]`
+    w, result := importBooks(t, "dry_run=true", file)
+    if w.Code != http.StatusOK || w.Header().Get("X-Dry-Run") != "true" {
+        t.Fatalf("dry run = %d %s", w.Code, w.Body)
+    }
+    if !result.DryRun || result.Created != 1 || result.Updated != 1 || len(result.Conflicts) != 1 {
+        t.Errorf("dry run = %+v, want 1 created, 1 updated and 1 conflict", result)
+    }
+    if book, _, _ := store.Get("dry-1"); book.Title != "Dune" {
+        t.Errorf("dry run overwrote dry-1 with %q", book.Title)
+    }
+    if _, exists, _ := store.Get("dry-2"); exists {
+        t.Error("dry run created dry-2")
+    }
+    if got := currentChangeSeq(); got != seq {
+        t.Errorf("dry run logged %d changes", got-seq)
+    }
+
+    if _, result := importBooks(t, "dry_run=true&on_conflict=skip", file); result.Created != 1 || result.Updated != 0 || result.Skipped != 1 {
+        t.Errorf("dry run skipping conflicts = %+v, want 1 created and 1 skipped", result)
+    }
+    if w, _ := importBooks(t, "dry_run=true&on_conflict=fail", file); w.Code != http.StatusConflict {
+        t.Errorf("dry run failing on conflicts = %d, want %d", w.Code, http.StatusConflict)
+    }
+}
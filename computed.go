@@ -0,0 +1,110 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "sort"
+    "strings"
+)
+
+// ComputedLoader resolves a derived field for a whole page of books in one go,
+// returning the value per book ID. Loading in batches keeps a listing to one
+// pass over the source data instead of one lookup per book.
+type ComputedLoader func(bookIDs []string) map[string]interface{}
+
+// computedFields are the derived fields clients can ask for with ?include=,
+// keyed by field name. Only written from init.
+var computedFields = make(map[string]ComputedLoader)
+
+// registerComputedField makes a derived book field available to ?include=.
+func registerComputedField(name string, load ComputedLoader) {
+    if _, ok := computedFields[name]; ok {
+        panic("computed field " + name + " registered twice")
+    }
+    computedFields[name] = load
+}
+
+func init() {
+    registerComputedField("copy_count", func(ids []string) map[string]interface{} {
+        return countCopies(ids, func(Copy) bool { return true })
+    })
+    registerComputedField("available_copies", func(ids []string) map[string]interface{} {
+        return countCopies(ids, Copy.isAvailable)
+    })
+}
+
+// countCopies counts the copies of each book that satisfy keep.
+func countCopies(ids []string, keep func(Copy) bool) map[string]interface{} {
+    counts := make(map[string]interface{}, len(ids))
+    for _, id := range ids {
+        counts[id] = 0
+    }
+    copiesMux.RLock()
+    defer copiesMux.RUnlock()
+    for _, c := range copies {
+        if n, ok := counts[c.BookID]; ok && keep(c) {
+            counts[c.BookID] = n.(int) + 1
+        }
+    }
+    return counts
+}
+
+// parseInclude reads ?include=a,b and checks every name is a computed field.
+func parseInclude(r *http.Request) ([]string, error) {
+    v := r.URL.Query().Get("include")
+    if v == "" {
+        return nil, nil
+    }
+    var names []string
+    for _, name := range strings.Split(v, ",") {
+        name = strings.TrimSpace(name)
+        if _, ok := computedFields[name]; !ok {
+            known := make([]string, 0, len(computedFields))
+            for k := range computedFields {
+                known = append(known, k)
+            }
+            sort.Strings(known)
+            return nil, errors.New("include must list fields from: " + strings.Join(known, ", "))
+        }
+        names = append(names, name)
+    }
+    return names, nil
+}
+
+// renderBooks returns books ready to encode, with the requested computed fields
+// added and, if the client sent Accept-Language, a "localized" title and
+// description. Without either the books are returned unchanged.
+func renderBooks(bks []Book, include, langs []string) interface{} {
+    if len(include) == 0 && len(langs) == 0 {
+        return bks
+    }
+    ids := make([]string, len(bks))
+    for i, book := range bks {
+        ids[i] = book.ID
+    }
+    out := make([]map[string]interface{}, len(bks))
+    for i, book := range bks {
+        out[i] = make(map[string]interface{})
+        for k, v := range bookFields(book) {
+            out[i][k] = v
+        }
+        if len(langs) > 0 {
+            out[i]["localized"] = localize(book, langs)
+        }
+    }
+    for _, name := range include {
+        values := computedFields[name](ids) // One batch per field for the whole page.
+        for i, book := range bks {
+            out[i][name] = values[book.ID]
+        }
+    }
+    return out
+}
+
+// renderBook is renderBooks for a single book.
+func renderBook(book Book, include, langs []string) interface{} {
+    if len(include) == 0 && len(langs) == 0 {
+        return book
+    }
+    return renderBooks([]Book{book}, include, langs).([]map[string]interface{})[0]
+}
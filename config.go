@@ -0,0 +1,76 @@
+package main
+
+import (
+    "log"
+    "os"
+    "strconv"
+    "time"
+)
+
+// envString returns the value of the environment variable key, or def when it is unset.
+func envString(key, def string) string {
+    if v, ok := os.LookupEnv(key); ok {
+        return v
+    }
+    return def
+}
+
+// envInt returns the environment variable key parsed as an int, or def when it
+// is unset. An unparsable value is logged and ignored rather than crashing startup.
+func envInt(key string, def int) int {
+    v, ok := os.LookupEnv(key)
+    if !ok {
+        return def
+    }
+    n, err := strconv.Atoi(v)
+    if err != nil {
+        log.Printf("ignoring %s=%q: %v", key, v, err)
+        return def
+    }
+    return n
+}
+
+// envFloat returns the environment variable key parsed as a float64, or def
+// when it is unset or unparsable.
+func envFloat(key string, def float64) float64 {
+    v, ok := os.LookupEnv(key)
+    if !ok {
+        return def
+    }
+    f, err := strconv.ParseFloat(v, 64)
+    if err != nil {
+        log.Printf("ignoring %s=%q: %v", key, v, err)
+        return def
+    }
+    return f
+}
+
+// envBool returns the environment variable key parsed as a bool ("true", "1",
+// "false", ...), or def when it is unset or unparsable.
+func envBool(key string, def bool) bool {
+    v, ok := os.LookupEnv(key)
+    if !ok {
+        return def
+    }
+    b, err := strconv.ParseBool(v)
+    if err != nil {
+        log.Printf("ignoring %s=%q: %v", key, v, err)
+        return def
+    }
+    return b
+}
+
+// envDuration returns the environment variable key parsed as a duration such as
+// "90s" or "1h", or def when it is unset or unparsable.
+func envDuration(key string, def time.Duration) time.Duration {
+    v, ok := os.LookupEnv(key)
+    if !ok {
+        return def
+    }
+    d, err := time.ParseDuration(v)
+    if err != nil {
+        log.Printf("ignoring %s=%q: %v", key, v, err)
+        return def
+    }
+    return d
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// Condition grades for a physical copy, from best to worst.
+const (
+    ConditionNew  = "new"
+    ConditionGood = "good"
+    ConditionFair = "fair"
+    ConditionPoor = "poor"
+)
+
+// Copy statuses. Only available copies can be lent out or count towards availability.
+const (
+    CopyAvailable = "available"
+    CopyInRepair  = "in_repair"
+    CopyWithdrawn = "withdrawn"
+)
+
+// CopyStatusChange records one change of condition or status on a copy.
+type CopyStatusChange struct {
+    Condition string    `json:"condition"`      // Condition grade after the change.
+    Status    string    `json:"status"`         // Status after the change.
+    Note      string    `json:"note,omitempty"` // Free-text reason, e.g. "spine repaired".
+    ChangedAt time.Time `json:"changed_at"`     // When the change was recorded.
+}
+
+// Copy struct defines a single physical copy of a book.
+type Copy struct {
+    ID         string             `json:"id"`                    // Server-assigned copy ID.
+    BookID     string             `json:"book_id"`               // ID of the book this is a copy of.
+    Condition  string             `json:"condition"`             // Current condition grade.
+    Status     string             `json:"status"`                // Current status (available, in_repair, withdrawn).
+    LocationID string             `json:"location_id,omitempty"` // Shelf location the copy is kept at.
+    History    []CopyStatusChange `json:"history"`               // Every condition/status change, oldest first.
+}
+
+// CopySummary is the response for GET /book/{id}/copies.
+type CopySummary struct {
+    BookID    string `json:"book_id"`
+    Total     int    `json:"total"`     // All copies, including ones in repair or withdrawn.
+    Available int    `json:"available"` // Copies that can currently be lent out.
+    Copies    []Copy `json:"copies"`
+}
+
+// copyUpdate is the request body for creating or updating a copy.
+type copyUpdate struct {
+    Condition  string `json:"condition"`
+    Status     string `json:"status"`
+    Note       string `json:"note"`
+    LocationID string `json:"location_id"`
+}
+
+var (
+    copies    = make(map[string]Copy) // Map to store copies with their ID as the key.
+    copySeq   int                     // Last copy ID handed out.
+    copiesMux sync.RWMutex            // RWMutex to safeguard copies and copySeq.
+)
+
+// isAvailable reports whether the copy can be lent out. Copies in repair or
+// withdrawn are excluded from checkout and availability counts.
+func (c Copy) isAvailable() bool {
+    return c.Status == CopyAvailable
+}
+
+func validCondition(condition string) bool {
+    switch condition {
+    case ConditionNew, ConditionGood, ConditionFair, ConditionPoor:
+        return true
+    }
+    return false
+}
+
+func validCopyStatus(status string) bool {
+    switch status {
+    case CopyAvailable, CopyInRepair, CopyWithdrawn:
+        return true
+    }
+    return false
+}
+
+// copiesOfBook returns the copies of a book ordered by ID. Callers must hold copiesMux.
+func copiesOfBook(bookID string) []Copy {
+    cps := make([]Copy, 0)
+    for _, c := range copies {
+        if c.BookID == bookID {
+            cps = append(cps, c)
+        }
+    }
+    sort.Slice(cps, func(i, j int) bool { return lessID(cps[i].ID, cps[j].ID) })
+    return cps
+}
+
+// handleBookCopies handles requests for the /book/{id}/copies route.
+func handleBookCopies(w http.ResponseWriter, r *http.Request, bookID string) {
+    mux.RLock()
+    _, ok, err := store.Get(bookID) // Copies can only be attached to books that exist.
+    mux.RUnlock()
+    if err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    if !ok {
+        writeError(w, "book_not_found", "book "+bookID+" not found")
+        return
+    }
+
+    switch r.Method {
+    case "GET": // List the copies of the book along with availability counts.
+        copiesMux.RLock()
+        cps := copiesOfBook(bookID)
+        copiesMux.RUnlock()
+        summary := CopySummary{BookID: bookID, Total: len(cps), Copies: cps}
+        for _, c := range cps {
+            if c.isAvailable() {
+                summary.Available++
+            }
+        }
+        json.NewEncoder(w).Encode(summary)
+
+    case "POST": // Register a new physical copy of the book.
+        var req copyUpdate
+        if err := decodeJSON(r, &req); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        if req.Condition == "" {
+            req.Condition = ConditionNew // New acquisitions default to mint condition.
+        }
+        if req.Status == "" {
+            req.Status = CopyAvailable
+        }
+        if !validCondition(req.Condition) || !validCopyStatus(req.Status) {
+            writeError(w, "validation_failed", "invalid condition or status")
+            return
+        }
+        copiesMux.Lock()
+        if req.LocationID != "" && !locationExists(req.LocationID) {
+            copiesMux.Unlock()
+            writeError(w, "validation_failed", "unknown location")
+            return
+        }
+        copySeq++
+        c := Copy{
+            ID:         strconv.Itoa(copySeq),
+            BookID:     bookID,
+            Condition:  req.Condition,
+            Status:     req.Status,
+            LocationID: req.LocationID,
+            History: []CopyStatusChange{{
+                Condition: req.Condition,
+                Status:    req.Status,
+                Note:      req.Note,
+                ChangedAt: clock.Now().UTC(),
+            }},
+        }
+        copies[c.ID] = c
+        copiesMux.Unlock()
+        w.WriteHeader(http.StatusCreated)
+        json.NewEncoder(w).Encode(c)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// handleCopy handles requests for the /copy/{id} route.
+func handleCopy(w http.ResponseWriter, r *http.Request) {
+    id := r.URL.Path[len("/copy/"):] // Extract the copy ID from the URL path.
+    switch r.Method {
+    case "GET": // Retrieve a single copy including its status history.
+        copiesMux.RLock()
+        c, ok := copies[id]
+        copiesMux.RUnlock()
+        if !ok {
+            writeError(w, "copy_not_found", "copy "+id+" not found")
+            return
+        }
+        json.NewEncoder(w).Encode(c)
+
+    case "PUT": // Change the condition and/or status of a copy, recording the change.
+        var req copyUpdate
+        if err := decodeJSON(r, &req); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        copiesMux.Lock()
+        defer copiesMux.Unlock()
+        if req.LocationID != "" && !locationExists(req.LocationID) {
+            writeError(w, "validation_failed", "unknown location")
+            return
+        }
+        c, ok := copies[id]
+        if !ok {
+            writeError(w, "copy_not_found", "copy "+id+" not found")
+            return
+        }
+        if req.Condition == "" {
+            req.Condition = c.Condition // Omitted fields keep their current value.
+        }
+        if req.Status == "" {
+            req.Status = c.Status
+        }
+        if !validCondition(req.Condition) || !validCopyStatus(req.Status) {
+            writeError(w, "validation_failed", "invalid condition or status")
+            return
+        }
+        if req.Condition != c.Condition || req.Status != c.Status || req.Note != "" {
+            c.Condition, c.Status = req.Condition, req.Status
+            c.History = append(c.History, CopyStatusChange{
+                Condition: c.Condition,
+                Status:    c.Status,
+                Note:      req.Note,
+                ChangedAt: clock.Now().UTC(),
+            })
+        }
+        if req.LocationID != "" {
+            c.LocationID = req.LocationID // Moving a copy is not a condition/status change, so no history entry.
+        }
+        copies[id] = c
+        json.NewEncoder(w).Encode(c)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
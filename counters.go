@@ -0,0 +1,249 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "hash/fnv"
+    "log"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Counter names.
+const (
+    CounterViews     = "views"     // Reads of GET /book/{id}, per book.
+    CounterDownloads = "downloads" // Completed e-book downloads, per file.
+    CounterSearches  = "searches"  // Catalog searches, with no record ID.
+)
+
+// counterKey identifies one counter: a name and the record it counts, if any.
+type counterKey struct {
+    name, id string
+}
+
+// counterShard holds the increments made since the last flush for the keys
+// that hash to it. The lock only guards the map: increments take it shared
+// and bump the counter atomically, so they never wait on each other, only on
+// the brief exclusive hold of a flush.
+type counterShard struct {
+    mu      sync.RWMutex
+    pending map[counterKey]*atomic.Int64
+}
+
+// counterShardCount is how many shards increments are spread over.
+const counterShardCount = 16
+
+// countersKey is where the flushed totals are kept in the blob store, so
+// they survive a restart whichever book store is in use.
+const countersKey = "counters.json"
+
+// SavedCounter is one flushed total as kept under countersKey.
+type SavedCounter struct {
+    Name  string `json:"name"`
+    ID    string `json:"id,omitempty"`
+    Count int64  `json:"count"`
+}
+
+var (
+    counterShards [counterShardCount]counterShard
+
+    counterFlushInterval = envDuration("COUNTERS_FLUSH_INTERVAL", 10*time.Second) // How often counts are folded into their totals.
+
+    counterTotals    = make(map[counterKey]int64) // Flushed views and searches; downloads are kept on the files, request usage in usageTotals.
+    counterTotalsMux sync.RWMutex                 // RWMutex to safeguard counterTotals.
+    counterSaveMux   sync.Mutex                   // Serializes saveCounters, so an older copy never overwrites a newer one.
+)
+
+// shard returns the shard a key's increments go to.
+func (k counterKey) shard() *counterShard {
+    h := fnv.New32a()
+    h.Write([]byte(k.name))
+    h.Write([]byte{0})
+    h.Write([]byte(k.id))
+    return &counterShards[h.Sum32()%counterShardCount]
+}
+
+// incrementCounter counts one event. It is cheap enough to call on every
+// read: it takes no lock another request could be holding for long.
+func incrementCounter(name, id string) {
+    key := counterKey{name, id}
+    s := key.shard()
+    s.mu.RLock()
+    if c, ok := s.pending[key]; ok {
+        c.Add(1) // Under the shared lock, so a flush can't swap the map out from under it.
+        s.mu.RUnlock()
+        return
+    }
+    s.mu.RUnlock()
+    s.mu.Lock()
+    if s.pending == nil {
+        s.pending = make(map[counterKey]*atomic.Int64)
+    }
+    c, ok := s.pending[key]
+    if !ok {
+        c = new(atomic.Int64)
+        s.pending[key] = c
+    }
+    c.Add(1)
+    s.mu.Unlock()
+}
+
+// drainCounters takes the increments made since the last call, starting
+// every shard afresh.
+func drainCounters() map[counterKey]int64 {
+    drained := make(map[counterKey]int64)
+    for i := range counterShards {
+        s := &counterShards[i]
+        s.mu.Lock()
+        pending := s.pending
+        s.pending = nil
+        s.mu.Unlock()
+        for key, c := range pending {
+            drained[key] += c.Load()
+        }
+    }
+    return drained
+}
+
+// flushCounters folds the pending increments into their totals: downloads
+// into each file's record, request usage into usageTotals and the rest into
+// counterTotals, which is then saved to the blob store. Each is locked once
+// per flush instead of once per event. It runs as a scheduled job every
+// counterFlushInterval, and once more on shutdown.
+func flushCounters() error {
+    drained := drainCounters()
+    if len(drained) == 0 {
+        return nil
+    }
+    downloads, usage := make(map[counterKey]int64), make(map[counterKey]int64)
+    totalsChanged := false
+    counterTotalsMux.Lock()
+    for key, n := range drained {
+        switch key.name {
+        case CounterDownloads:
+            downloads[key] = n
+        case CounterRequests, CounterErrors, CounterServerErrors:
+            usage[key] = n
+        default:
+            counterTotals[key] += n
+            totalsChanged = true
+        }
+    }
+    counterTotalsMux.Unlock()
+    ebookMux.Lock()
+    for key, n := range downloads {
+        if f, ok := ebookFiles[key.id]; ok { // A file deleted since is no longer counted.
+            f.Downloads += int(n)
+            ebookFiles[key.id] = f
+        }
+    }
+    ebookMux.Unlock()
+    flushUsage(usage)
+    if !totalsChanged {
+        return nil
+    }
+    return saveCounters()
+}
+
+// saveCounters writes counterTotals to the blob store.
+func saveCounters() error {
+    counterSaveMux.Lock()
+    defer counterSaveMux.Unlock()
+    counterTotalsMux.RLock()
+    saved := make([]SavedCounter, 0, len(counterTotals))
+    for key, n := range counterTotals {
+        saved = append(saved, SavedCounter{Name: key.name, ID: key.id, Count: n})
+    }
+    counterTotalsMux.RUnlock()
+    data, err := json.Marshal(saved)
+    if err != nil {
+        return err
+    }
+    _, err = blobs.Put(countersKey, bytes.NewReader(data))
+    return err
+}
+
+// loadCounters reads the totals saved by the last run from the blob store, at
+// startup. A store without them starts every count at zero.
+func loadCounters() error {
+    rc, err := blobs.Open(countersKey)
+    if errors.Is(err, errBlobNotFound) {
+        return nil
+    } else if err != nil {
+        return err
+    }
+    defer rc.Close()
+    var saved []SavedCounter
+    if err := json.NewDecoder(rc).Decode(&saved); err != nil {
+        return err
+    }
+    counterTotalsMux.Lock()
+    defer counterTotalsMux.Unlock()
+    for _, c := range saved {
+        counterTotals[counterKey{c.Name, c.ID}] += c.Count
+    }
+    return nil
+}
+
+// dropCounters forgets a book's view count, when the book itself is deleted.
+// Pending views are flushed first so they aren't added back afterwards.
+func dropCounters(bookID string) {
+    flushCounters()
+    counterTotalsMux.Lock()
+    delete(counterTotals, counterKey{CounterViews, bookID})
+    counterTotalsMux.Unlock()
+    if err := saveCounters(); err != nil {
+        log.Printf("counters: saving totals: %v", err)
+    }
+}
+
+// resetCounters forgets every view, download and search count, pending or
+// flushed, for a sandbox reset. Pending request usage is about the keys
+// rather than the data and is still folded in. It leaves ebookMux alone: the
+// reset holds it while it deletes the files, download counts and all.
+func resetCounters() {
+    usage := make(map[counterKey]int64)
+    for key, n := range drainCounters() {
+        switch key.name {
+        case CounterRequests, CounterErrors, CounterServerErrors:
+            usage[key] = n
+        }
+    }
+    flushUsage(usage)
+    counterTotalsMux.Lock()
+    counterTotals = make(map[counterKey]int64)
+    counterTotalsMux.Unlock()
+    if err := saveCounters(); err != nil {
+        log.Printf("counters: saving totals: %v", err)
+    }
+}
+
+// counterSums adds up the flushed totals of every counter by name.
+func counterSums() map[string]int64 {
+    sums := map[string]int64{CounterViews: 0, CounterDownloads: 0, CounterSearches: 0}
+    counterTotalsMux.RLock()
+    for key, n := range counterTotals {
+        sums[key.name] += n
+    }
+    counterTotalsMux.RUnlock()
+    ebookMux.RLock()
+    for _, f := range ebookFiles {
+        sums[CounterDownloads] += int64(f.Downloads)
+    }
+    ebookMux.RUnlock()
+    return sums
+}
+
+func init() {
+    registerComputedField("views", func(ids []string) map[string]interface{} {
+        out := make(map[string]interface{}, len(ids))
+        counterTotalsMux.RLock()
+        defer counterTotalsMux.RUnlock()
+        for _, id := range ids {
+            out[id] = counterTotals[counterKey{CounterViews, id}]
+        }
+        return out
+    })
+}
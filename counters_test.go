@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestCountersSurviveRestart(t *testing.T) {
+    prevBlobs, prevTotals := blobs, counterTotals
+    t.Cleanup(func() { blobs, counterTotals = prevBlobs, prevTotals })
+    blobs, counterTotals = newMemoryBlobs(), make(map[counterKey]int64)
+
+    for i := 0; i < 3; i++ {
+        incrementCounter(CounterViews, "count-1")
+    }
+    incrementCounter(CounterSearches, "")
+    if err := flushCounters(); err != nil {
+        t.Fatal(err)
+    }
+
+    counterTotals = make(map[counterKey]int64) // As after a restart.
+    if err := loadCounters(); err != nil {
+        t.Fatal(err)
+    }
+    if got := counterTotals[counterKey{CounterViews, "count-1"}]; got != 3 {
+        t.Errorf("views after reloading = %d, want 3", got)
+    }
+    if got := counterTotals[counterKey{CounterSearches, ""}]; got != 1 {
+        t.Errorf("searches after reloading = %d, want 1", got)
+    }
+
+    dropCounters("count-1")
+    counterTotals = make(map[counterKey]int64)
+    if err := loadCounters(); err != nil {
+        t.Fatal(err)
+    }
+    if got := counterTotals[counterKey{CounterViews, "count-1"}]; got != 0 {
+        t.Errorf("views of a dropped book after reloading = %d, want 0", got)
+    }
+}
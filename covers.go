@@ -0,0 +1,226 @@
+package main
+
+import (
+    "bufio"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "io"
+    "mime"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// coverTypes are the image types accepted as book covers.
+var coverTypes = map[string]bool{
+    "image/jpeg": true,
+    "image/png":  true,
+    "image/webp": true,
+}
+
+// Cover struct defines a book's cover image. The image itself is kept in the
+// blob store under coverKey(BookID).
+type Cover struct {
+    BookID     string               `json:"book_id"`
+    MediaType  string               `json:"media_type"` // image/jpeg, image/png or image/webp.
+    Size       int64                `json:"size"`       // In bytes.
+    SHA256     string               `json:"sha256"`     // Hex-encoded checksum of the image.
+    UploadedAt time.Time            `json:"uploaded_at"`
+    Thumbnails map[string]Thumbnail `json:"thumbnails,omitempty"` // By size name, once the background worker has made them.
+}
+
+var (
+    covers    = make(map[string]Cover) // Map to store covers with their book's ID as the key.
+    coversMux sync.RWMutex             // RWMutex to safeguard covers. Taken after reviewsMux.
+
+    maxCoverBytes = int64(envInt("COVER_MAX_BYTES", 5<<20))          // Largest image that can be uploaded.
+    coverMaxAge   = envDuration("COVER_CACHE_MAX_AGE", 24*time.Hour) // How long clients may cache a cover.
+)
+
+// errCoverType is returned by readCover when the upload isn't a supported image.
+var errCoverType = errors.New("covers must be JPEG, PNG or WebP images")
+
+// coverKey is the blob store key of a book's cover.
+func coverKey(bookID string) string {
+    return "cover-" + bookID
+}
+
+// dropCover deletes a book's cover, when the book itself is deleted.
+func dropCover(bookID string) {
+    coversMux.Lock()
+    _, ok := covers[bookID]
+    delete(covers, bookID)
+    coversMux.Unlock()
+    if ok {
+        deleteCoverBlobs(bookID)
+    }
+}
+
+// handleBookCover handles requests for the /book/{id}/cover route.
+func handleBookCover(w http.ResponseWriter, r *http.Request, bookID string) {
+    mux.RLock()
+    book, ok, err := store.Get(bookID)
+    mux.RUnlock()
+    if err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    if !ok || !canSeeBook(r, book) {
+        writeError(w, "book_not_found", "book "+bookID+" not found")
+        return
+    }
+
+    switch r.Method {
+    case "GET": // Serve the image, or a thumbnail of it with ?size=.
+        size := r.URL.Query().Get("size")
+        if _, ok := thumbnailSizes[size]; !ok && size != "" && size != "original" {
+            writeError(w, "invalid_query", "size must be small, medium or original")
+            return
+        }
+        coversMux.RLock()
+        c, ok := covers[bookID]
+        coversMux.RUnlock()
+        if !ok {
+            writeError(w, "cover_not_found", "book "+bookID+" has no cover")
+            return
+        }
+        serveCover(w, r, c, size, isPublished(book))
+
+    case "PUT": // Upload or replace the cover.
+        uploadCover(w, r, bookID)
+
+    case "DELETE": // Remove the cover.
+        coversMux.RLock()
+        _, ok := covers[bookID]
+        coversMux.RUnlock()
+        if !ok {
+            writeError(w, "cover_not_found", "book "+bookID+" has no cover")
+            return
+        }
+        dropCover(bookID)
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// uploadCover stores an image as a book's cover, replacing any earlier one.
+func uploadCover(w http.ResponseWriter, r *http.Request, bookID string) {
+    r.Body = http.MaxBytesReader(w, r.Body, maxCoverBytes)
+    mediaType, image, err := readCover(r)
+    if err != nil {
+        writeCoverError(w, err)
+        return
+    }
+
+    hash := sha256.New()
+    size, err := blobs.Put(coverKey(bookID), io.TeeReader(image, hash))
+    if err != nil {
+        writeCoverError(w, err)
+        return
+    }
+    c := Cover{BookID: bookID, MediaType: mediaType, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil)), UploadedAt: clock.Now().UTC()}
+
+    coversMux.Lock()
+    _, replaced := covers[bookID]
+    covers[bookID] = c
+    coversMux.Unlock()
+    queueThumbnails(c)
+    if !replaced {
+        w.WriteHeader(http.StatusCreated)
+    }
+    json.NewEncoder(w).Encode(c)
+}
+
+// readCover finds the image in an upload: either the whole body, with
+// Content-Type giving its type, or the "cover" field of a multipart form.
+// The declared type must match what the first bytes of the image say it is.
+func readCover(r *http.Request) (string, io.Reader, error) {
+    mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+    if err != nil {
+        return "", nil, errCoverType
+    }
+    var image io.Reader = r.Body
+    if mediaType == "multipart/form-data" {
+        mr, err := r.MultipartReader()
+        if err != nil {
+            return "", nil, err
+        }
+        for {
+            part, err := mr.NextPart()
+            if err == io.EOF {
+                return "", nil, errors.New("the form has no cover field")
+            }
+            if err != nil {
+                return "", nil, err
+            }
+            if part.FormName() == "cover" {
+                mediaType, _, _ = mime.ParseMediaType(part.Header.Get("Content-Type"))
+                image = part
+                break
+            }
+        }
+    }
+
+    br := bufio.NewReaderSize(image, 512)
+    head, err := br.Peek(512)
+    if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+        return "", nil, err
+    }
+    sniffed := http.DetectContentType(head)
+    if mediaType == "" || mediaType == "application/octet-stream" {
+        mediaType = sniffed // Form parts often come without a useful type.
+    }
+    if !coverTypes[mediaType] || sniffed != mediaType {
+        return "", nil, errCoverType
+    }
+    return mediaType, br, nil
+}
+
+// writeCoverError reports a failed cover upload.
+func writeCoverError(w http.ResponseWriter, err error) {
+    var tooLarge *http.MaxBytesError
+    switch {
+    case errors.As(err, &tooLarge):
+        writeError(w, "payload_too_large", "covers may be at most "+strconv.FormatInt(maxCoverBytes, 10)+" bytes")
+    case errors.Is(err, errCoverType):
+        writeError(w, "unsupported_media_type", err.Error())
+    default:
+        writeError(w, "validation_failed", "reading the upload: "+err.Error())
+    }
+}
+
+// serveCover streams a cover, or one of its thumbnails, with headers that let
+// browsers and proxies cache it. Covers of unpublished books are only cached
+// privately. Until a thumbnail has been made the original is served instead.
+func serveCover(w http.ResponseWriter, r *http.Request, c Cover, size string, public bool) {
+    key, mediaType, length, sum := coverKey(c.BookID), c.MediaType, c.Size, c.SHA256
+    if t, ok := c.Thumbnails[size]; ok {
+        key, mediaType, length, sum = thumbnailKey(c.BookID, size), t.MediaType, t.Size, t.SHA256
+    }
+    etag := `"` + sum + `"`
+    scope := "private"
+    if public {
+        scope = "public"
+    }
+    w.Header().Set("Cache-Control", scope+", max-age="+strconv.Itoa(int(coverMaxAge.Seconds())))
+    w.Header().Set("ETag", etag)
+    w.Header().Set("Last-Modified", c.UploadedAt.Format(http.TimeFormat))
+    if r.Header.Get("If-None-Match") == etag {
+        w.WriteHeader(http.StatusNotModified)
+        return
+    }
+    rc, err := blobs.Open(key)
+    if err != nil {
+        writeError(w, "internal_error", "reading cover of book "+c.BookID+": "+err.Error())
+        return
+    }
+    defer rc.Close()
+    w.Header().Set("Content-Type", mediaType)
+    w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+    io.Copy(w, rc)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "os"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Custom field types, as set in CUSTOM_FIELDS_FILE.
+const (
+    CustomString = "string"
+    CustomNumber = "number"
+    CustomDate   = "date" // YYYY-MM-DD.
+    CustomEnum   = "enum" // One of Values.
+)
+
+// CustomField declares one deployment-specific book field, kept under the
+// book's "custom" object.
+type CustomField struct {
+    Name     string   `json:"name"`
+    Type     string   `json:"type"`             // string, number, date or enum.
+    Values   []string `json:"values,omitempty"` // Allowed values of an enum.
+    Required bool     `json:"required,omitempty"`
+}
+
+// customFieldName is the shape of a custom field name.
+var customFieldName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// customFields is loaded once at startup; without CUSTOM_FIELDS_FILE books
+// take no custom fields.
+var customFields = loadCustomFields(envString("CUSTOM_FIELDS_FILE", ""))
+
+// loadCustomFields reads the JSON list of custom fields. Like the policy file,
+// a bad declaration stops the server rather than letting unchecked data in.
+func loadCustomFields(file string) []CustomField {
+    if file == "" {
+        return nil
+    }
+    data, err := os.ReadFile(file)
+    if err != nil {
+        log.Fatalf("reading CUSTOM_FIELDS_FILE: %v", err)
+    }
+    var fields []CustomField
+    if err := json.Unmarshal(data, &fields); err != nil {
+        log.Fatalf("parsing CUSTOM_FIELDS_FILE: %v", err)
+    }
+    seen := make(map[string]bool)
+    for i, f := range fields {
+        switch {
+        case !customFieldName.MatchString(f.Name):
+            log.Fatalf("CUSTOM_FIELDS_FILE: field %d: name must be lower-case letters, digits and underscores", i)
+        case seen[f.Name]:
+            log.Fatalf("CUSTOM_FIELDS_FILE: field %s is declared twice", f.Name)
+        case f.Type == CustomEnum && len(f.Values) == 0:
+            log.Fatalf("CUSTOM_FIELDS_FILE: enum field %s needs values", f.Name)
+        case f.Type != CustomString && f.Type != CustomNumber && f.Type != CustomDate && f.Type != CustomEnum:
+            log.Fatalf("CUSTOM_FIELDS_FILE: field %s: type must be string, number, date or enum", f.Name)
+        }
+        seen[f.Name] = true
+    }
+    return fields
+}
+
+// customField returns the declaration of a custom field.
+func customField(name string) (CustomField, bool) {
+    for _, f := range customFields {
+        if f.Name == name {
+            return f, true
+        }
+    }
+    return CustomField{}, false
+}
+
+// customFieldsSchema describes the "custom" object for GET /schemas.
+func customFieldsSchema() *Schema {
+    props := make(map[string]*Schema, len(customFields))
+    var required []string
+    for _, f := range customFields {
+        switch f.Type {
+        case CustomString:
+            props[f.Name] = str()
+        case CustomNumber:
+            props[f.Name] = &Schema{Type: "number"}
+        case CustomDate:
+            props[f.Name] = date()
+        case CustomEnum:
+            props[f.Name] = oneOf(f.Values...)
+        }
+        if f.Required {
+            required = append(required, f.Name)
+        }
+    }
+    return object(required, props)
+}
+
+// checkCustomFields checks a book's custom fields against their declarations.
+func checkCustomFields(book *Book) error {
+    for name, v := range book.Custom {
+        f, ok := customField(name)
+        if !ok {
+            return errors.New("unknown custom field " + name)
+        }
+        if v == nil {
+            delete(book.Custom, name) // null clears a field.
+            continue
+        }
+        if msg, ok := checkCustomValue(f, v); !ok {
+            return errors.New("custom." + name + " " + msg)
+        }
+    }
+    for _, f := range customFields {
+        if _, ok := book.Custom[f.Name]; f.Required && !ok {
+            return errors.New("custom." + f.Name + " is required")
+        }
+    }
+    if len(book.Custom) == 0 {
+        book.Custom = nil
+    }
+    return nil
+}
+
+// checkCustomValue reports what is wrong with one custom field value, if anything.
+func checkCustomValue(f CustomField, v interface{}) (string, bool) {
+    if f.Type == CustomNumber {
+        if _, ok := v.(float64); !ok {
+            return "must be a number", false
+        }
+        return "", true
+    }
+    s, ok := v.(string)
+    if !ok {
+        return "must be a string", false
+    }
+    switch f.Type {
+    case CustomDate:
+        if _, err := time.Parse(dateLayout, s); err != nil {
+            return "must be a date (YYYY-MM-DD)", false
+        }
+    case CustomEnum:
+        if !contains(f.Values, s) {
+            return "must be one of " + strings.Join(f.Values, ", "), false
+        }
+    }
+    return "", true
+}
+
+// parseCustomFilters reads ?custom.{name}= filters for GET /books.
+func parseCustomFilters(r *http.Request) (map[string]string, error) {
+    var filters map[string]string
+    for key, values := range r.URL.Query() {
+        name, ok := strings.CutPrefix(key, "custom.")
+        if !ok {
+            continue
+        }
+        f, ok := customField(name)
+        if !ok {
+            return nil, errors.New("unknown custom field " + name)
+        }
+        if _, err := strconv.ParseFloat(values[0], 64); f.Type == CustomNumber && err != nil {
+            return nil, errors.New(key + " must be a number")
+        }
+        if filters == nil {
+            filters = make(map[string]string)
+        }
+        filters[name] = values[0]
+    }
+    return filters, nil
+}
+
+// matchesCustom reports whether a book has every filtered custom field set
+// to the given value. Strings compare case-insensitively, numbers by value.
+func matchesCustom(book Book, filters map[string]string) bool {
+    for name, want := range filters {
+        switch v := book.Custom[name].(type) {
+        case float64:
+            if n, _ := strconv.ParseFloat(want, 64); n != v {
+                return false
+            }
+        case string:
+            if !strings.EqualFold(v, want) {
+                return false
+            }
+        default:
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "io"
+    "net/http"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// strictDecoding rejects request bodies containing fields the endpoint does not
+// know about. Clients can also opt in per request with "X-Strict-Decoding: true".
+var strictDecoding = envBool("STRICT_DECODING", false)
+
+// isStrict reports whether the request body should be decoded strictly.
+func isStrict(r *http.Request) bool {
+    if v, err := strconv.ParseBool(r.Header.Get("X-Strict-Decoding")); err == nil {
+        return v // An explicit header wins over the global setting either way.
+    }
+    return strictDecoding
+}
+
+// unknownFieldsError lists the unknown keys found in a strictly decoded body.
+type unknownFieldsError []string
+
+func (e unknownFieldsError) Error() string {
+    return "unknown fields: " + strings.Join(e, ", ")
+}
+
+// decodeJSON decodes the request body into v. If the route has a schema the
+// body is validated against it first. In strict mode unknown fields are an
+// error naming every unknown key, so a typo like "titel" is reported instead
+// of being silently dropped.
+func decodeJSON(r *http.Request, v interface{}) error {
+    schema := schemaFor(r)
+    if !isStrict(r) && schema == nil {
+        return json.NewDecoder(r.Body).Decode(v)
+    }
+    data, err := io.ReadAll(r.Body)
+    if err != nil {
+        return err
+    }
+    var doc interface{}
+    if schema != nil && json.Unmarshal(data, &doc) == nil { // Invalid JSON is left for the decoder to report.
+        if errs := schema.validate(doc, ""); len(errs) > 0 {
+            return schemaError(errs)
+        }
+    }
+    if !isStrict(r) {
+        return json.Unmarshal(data, v)
+    }
+    if unknown := unknownFields(data, reflect.TypeOf(v)); len(unknown) > 0 {
+        return unknownFieldsError(unknown)
+    }
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.DisallowUnknownFields() // Still catches unknown keys in nested objects.
+    return dec.Decode(v)
+}
+
+// unknownFields lists the top-level keys of a JSON object (or of each object in
+// a JSON array) that do not map to a field of the target type.
+func unknownFields(data []byte, t reflect.Type) []string {
+    for t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+    var objects []map[string]json.RawMessage
+    switch t.Kind() {
+    case reflect.Struct:
+        var obj map[string]json.RawMessage
+        if json.Unmarshal(data, &obj) != nil {
+            return nil // Not an object; let the real decode report the error.
+        }
+        objects = append(objects, obj)
+    case reflect.Slice:
+        if json.Unmarshal(data, &objects) != nil {
+            return nil
+        }
+        t = t.Elem()
+        if t.Kind() != reflect.Struct {
+            return nil
+        }
+    default:
+        return nil
+    }
+
+    known := jsonFieldNames(t)
+    seen := make(map[string]bool)
+    var unknown []string
+    for _, obj := range objects {
+        for key := range obj {
+            if !known[strings.ToLower(key)] && !seen[key] {
+                seen[key] = true
+                unknown = append(unknown, key)
+            }
+        }
+    }
+    sort.Strings(unknown)
+    return unknown
+}
+
+// jsonFieldNames returns the lower-cased JSON names of a struct's fields. They are
+// lower-cased because encoding/json matches keys case-insensitively.
+func jsonFieldNames(t reflect.Type) map[string]bool {
+    names := make(map[string]bool)
+    for i := 0; i < t.NumField(); i++ {
+        f := t.Field(i)
+        name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+        switch {
+        case name == "-":
+            continue
+        case name == "":
+            name = f.Name
+        }
+        names[strings.ToLower(name)] = true
+    }
+    return names
+}
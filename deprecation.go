@@ -0,0 +1,43 @@
+package main
+
+import (
+    "net/http"
+    "strconv"
+    "time"
+)
+
+// Deprecation describes a route that is scheduled for removal.
+type Deprecation struct {
+    Since       time.Time // When the route was deprecated; sent as the Deprecation header.
+    Sunset      time.Time // When the route will stop working; sent as the Sunset header. Optional.
+    Replacement string    // URL of the route that replaces it; sent as a successor-version Link. Optional.
+}
+
+// deprecations maps registered route patterns (as passed to http.HandleFunc) to
+// their deprecation metadata, e.g.
+//
+//	"/old-books": {Since: ..., Sunset: ..., Replacement: "/books"},
+var deprecations = map[string]Deprecation{}
+
+// deprecatedCalls counts requests served by deprecated routes, so we can tell
+// when nobody uses them any more and they are safe to remove.
+var deprecatedCalls = newCounterVec("deprecated_route_calls_total", "Requests served by deprecated routes.", "route")
+
+// withDeprecations is a middleware that adds Deprecation, Sunset and Link headers
+// to responses from deprecated routes and counts the calls.
+func withDeprecations(next *http.ServeMux) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        _, pattern := next.Handler(r) // Look up which registered route will serve the request.
+        if d, ok := deprecations[pattern]; ok {
+            w.Header().Set("Deprecation", "@"+strconv.FormatInt(d.Since.Unix(), 10)) // RFC 9745 date format.
+            if !d.Sunset.IsZero() {
+                w.Header().Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat)) // RFC 8594 HTTP-date.
+            }
+            if d.Replacement != "" {
+                w.Header().Add("Link", "<"+d.Replacement+">; rel=\"successor-version\"")
+            }
+            deprecatedCalls.Inc(pattern)
+        }
+        next.ServeHTTP(w, r)
+    })
+}
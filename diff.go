@@ -0,0 +1,135 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "sort"
+    "time"
+)
+
+// CatalogDiff is the response for GET /admin/diff.
+type CatalogDiff struct {
+    From    string       `json:"from"`
+    To      string       `json:"to"`
+    Added   []Book       `json:"added"`   // In to but not from.
+    Removed []Book       `json:"removed"` // In from but not to.
+    Changed []BookChange `json:"changed"` // In both with different contents.
+}
+
+// BookChange is a book as it was on each side of a diff.
+type BookChange struct {
+    ID     string               `json:"id"`
+    Before Book                 `json:"before"`
+    After  Book                 `json:"after"`
+    Fields map[string]FieldDiff `json:"fields"` // Just the fields that differ.
+}
+
+// FieldDiff is one field's value before and after a change, as JSON. Old is
+// left out for a field that was added and New for one that was removed.
+type FieldDiff struct {
+    Old json.RawMessage `json:"old,omitempty"`
+    New json.RawMessage `json:"new,omitempty"`
+}
+
+// fieldDiff compares two versions of a book field by field. The version
+// number and timestamps are left out, since they change on every write.
+func fieldDiff(before, after Book) map[string]FieldDiff {
+    old, cur := bookFields(before), bookFields(after)
+    diff := make(map[string]FieldDiff)
+    for name, v := range cur {
+        if !contains(serverManagedFields, name) && !bytes.Equal(old[name], v) {
+            diff[name] = FieldDiff{Old: old[name], New: v}
+        }
+    }
+    for name, v := range old {
+        if _, ok := cur[name]; !ok {
+            diff[name] = FieldDiff{Old: v}
+        }
+    }
+    return diff
+}
+
+// catalogAt returns the catalog at a diff endpoint: "now" for the live
+// catalog, "snapshot" for the last one written to SNAPSHOT_FILE, or an RFC
+// 3339 timestamp to rebuild it from the version history.
+func catalogAt(ref string) ([]Book, error) {
+    switch {
+    case ref == "now":
+        mux.RLock()
+        defer mux.RUnlock()
+        return store.List()
+    case ref == "snapshot" && snapshotFile != "":
+        snap, err := readSnapshot()
+        return snap.Books, err
+    }
+    t, err := time.Parse(time.RFC3339, ref)
+    if err != nil {
+        return nil, errors.New(`expected "now", "snapshot" or an RFC 3339 timestamp such as 2024-01-01T00:00:00Z, got ` + ref)
+    }
+    return booksAsOf(t), nil
+}
+
+// diffCatalogs lists what was added, removed and changed between two
+// catalogs. Versions are ignored, so a write that changed nothing doesn't show.
+func diffCatalogs(from, to []Book) CatalogDiff {
+    diff := CatalogDiff{Added: []Book{}, Removed: []Book{}, Changed: []BookChange{}}
+    before := make(map[string]Book, len(from))
+    for _, book := range from {
+        before[book.ID] = book
+    }
+    for _, book := range to {
+        prev, ok := before[book.ID]
+        switch {
+        case !ok:
+            diff.Added = append(diff.Added, book)
+        case !sameContent(&prev, &book):
+            diff.Changed = append(diff.Changed, BookChange{ID: book.ID, Before: prev, After: book, Fields: fieldDiff(prev, book)})
+        }
+        delete(before, book.ID)
+    }
+    for _, book := range before {
+        diff.Removed = append(diff.Removed, book)
+    }
+    sort.Slice(diff.Added, func(i, j int) bool { return lessID(diff.Added[i].ID, diff.Added[j].ID) })
+    sort.Slice(diff.Removed, func(i, j int) bool { return lessID(diff.Removed[i].ID, diff.Removed[j].ID) })
+    sort.Slice(diff.Changed, func(i, j int) bool { return lessID(diff.Changed[i].ID, diff.Changed[j].ID) })
+    return diff
+}
+
+// handleDiff handles GET /admin/diff?from=<time>&to=<time|now>, comparing the
+// catalog at two points so a catalog update can be reviewed before it is
+// published. to defaults to now. Needs the admin key.
+func handleDiff(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    if !isAdmin(r) {
+        writeError(w, "admin_required", "catalog diffs require the admin key")
+        return
+    }
+    q := r.URL.Query()
+    fromRef, toRef := q.Get("from"), q.Get("to")
+    if fromRef == "" {
+        writeError(w, "invalid_query", "from is required")
+        return
+    }
+    if toRef == "" {
+        toRef = "now"
+    }
+    from, err := catalogAt(fromRef)
+    if err != nil {
+        writeError(w, "invalid_query", "from: "+err.Error())
+        return
+    }
+    to, err := catalogAt(toRef)
+    if err != nil {
+        writeError(w, "invalid_query", "to: "+err.Error())
+        return
+    }
+    diff := diffCatalogs(from, to)
+    diff.From, diff.To = fromRef, toRef
+    json.NewEncoder(w).Encode(diff)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "strconv"
+)
+
+// DryRunResult describes what a mutating request would have done.
+type DryRunResult struct {
+    DryRun   bool   `json:"dry_run"`            // Always true; nothing was persisted.
+    Op       string `json:"op"`                 // create, update, delete or none.
+    Book     *Book  `json:"book,omitempty"`     // The book as it would be stored; omitted for deletes.
+    Previous *Book  `json:"previous,omitempty"` // The book as it is stored now, if it exists.
+}
+
+// isDryRun reports whether the request asked for ?dry_run=true.
+func isDryRun(r *http.Request) bool {
+    dry, err := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+    return err == nil && dry
+}
+
+// writeDryRun reports the outcome of a validated but unapplied mutation.
+func writeDryRun(w http.ResponseWriter, op string, book *Book, previous *Book) {
+    w.Header().Set("X-Dry-Run", "true")
+    json.NewEncoder(w).Encode(DryRunResult{DryRun: true, Op: op, Book: book, Previous: previous})
+}
+
+// previousBook returns a pointer to a copy of the current book, or nil if it does not exist.
+func previousBook(book Book, exists bool) *Book {
+    if !exists {
+        return nil
+    }
+    return &book
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "io"
+    "log"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ebookTypes are the file types that can be attached to a book, with the
+// extension used for downloads.
+var ebookTypes = map[string]string{
+    "application/epub+zip": "epub",
+    "application/pdf":      "pdf",
+}
+
+// EbookFile struct defines an e-book file attached to a book. The contents
+// are kept in the blob store under the file's ID.
+type EbookFile struct {
+    ID         string    `json:"id"`
+    BookID     string    `json:"book_id"`
+    MediaType  string    `json:"media_type"` // application/epub+zip or application/pdf.
+    Size       int64     `json:"size"`       // In bytes.
+    SHA256     string    `json:"sha256"`     // Hex-encoded checksum of the contents.
+    Downloads  int       `json:"downloads"`  // Completed downloads, by any route.
+    UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// DownloadLink is the response for POST /book/{id}/files/{fileID}/link.
+type DownloadLink struct {
+    URL       string     `json:"url"`
+    ExpiresAt *time.Time `json:"expires_at,omitempty"` // Absent for links that don't expire.
+}
+
+var (
+    ebookFiles = make(map[string]EbookFile) // Map to store e-book files with their ID as the key.
+    ebookSeq   int                          // Last e-book file ID handed out.
+    ebookMux   sync.RWMutex                 // RWMutex to safeguard ebookFiles and ebookSeq.
+
+    maxEbookBytes   = int64(envInt("EBOOK_MAX_BYTES", 50<<20))               // Largest file that can be uploaded.
+    downloadLinkTTL = envDuration("DOWNLOAD_LINK_TTL", 24*time.Hour)         // Default lifetime of download links.
+    downloadKey     = []byte(envString("DOWNLOAD_SIGNING_KEY", randomKey())) // Signs download links.
+)
+
+// randomKey returns a random signing key, used when none is configured.
+// Links signed with it stop working when the server restarts.
+func randomKey() string {
+    b := make([]byte, 32)
+    rng.Read(b)
+    return hex.EncodeToString(b)
+}
+
+// signDownload returns the signature of a download link for a file. An
+// expiry of 0 means the link never expires.
+func signDownload(fileID string, expires int64) string {
+    mac := hmac.New(sha256.New, downloadKey)
+    mac.Write([]byte(fileID + "\n" + strconv.FormatInt(expires, 10)))
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+// downloadURL returns a signed link to a file.
+func downloadURL(fileID string, expires int64) string {
+    return "/downloads/" + fileID + "?expires=" + strconv.FormatInt(expires, 10) + "&signature=" + signDownload(fileID, expires)
+}
+
+// bookFiles returns the files attached to a book, oldest first.
+func bookFiles(bookID string) []EbookFile {
+    ebookMux.RLock()
+    defer ebookMux.RUnlock()
+    files := []EbookFile{}
+    for _, f := range ebookFiles {
+        if f.BookID == bookID {
+            files = append(files, f)
+        }
+    }
+    sort.Slice(files, func(i, j int) bool { return lessID(files[i].ID, files[j].ID) })
+    return files
+}
+
+// handleBookFiles handles requests for the /book/{id}/files routes: listing
+// and uploading files, and for one file ({rest} = fileID) downloading and
+// deleting it or ({rest} = fileID/link) creating a download link.
+func handleBookFiles(w http.ResponseWriter, r *http.Request, bookID, rest string) {
+    mux.RLock()
+    _, ok, err := store.Get(bookID) // Files can only be attached to books that exist.
+    mux.RUnlock()
+    if err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    if !ok {
+        writeError(w, "book_not_found", "book "+bookID+" not found")
+        return
+    }
+    if rest == "" {
+        switch r.Method {
+        case "GET":
+            json.NewEncoder(w).Encode(bookFiles(bookID))
+        case "POST":
+            uploadEbook(w, r, bookID)
+        default:
+            methodNotAllowed(w, r)
+        }
+        return
+    }
+
+    fileID, sub, _ := strings.Cut(rest, "/")
+    ebookMux.RLock()
+    f, ok := ebookFiles[fileID]
+    ebookMux.RUnlock()
+    if !ok || f.BookID != bookID {
+        writeError(w, "file_not_found", "file "+fileID+" not found on book "+bookID)
+        return
+    }
+    switch {
+    case sub == "link":
+        if r.Method != "POST" {
+            methodNotAllowed(w, r)
+            return
+        }
+        createDownloadLink(w, r, f)
+    case sub != "":
+        writeError(w, "not_found", "no route for "+r.URL.Path)
+    case r.Method == "GET":
+        serveEbook(w, f)
+    case r.Method == "DELETE":
+        ebookMux.Lock()
+        delete(ebookFiles, f.ID)
+        ebookMux.Unlock()
+        if err := blobs.Delete(f.ID); err != nil {
+            log.Printf("deleting file %s: %v", f.ID, err) // The record is gone; the bytes are just orphaned.
+        }
+        w.WriteHeader(http.StatusNoContent)
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// uploadEbook stores the request body as a new file on a book. The body is
+// the raw file, with Content-Type saying which kind. If X-Checksum-SHA256 is
+// sent the upload is rejected unless it matches.
+func uploadEbook(w http.ResponseWriter, r *http.Request, bookID string) {
+    mediaType := strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0])
+    if _, ok := ebookTypes[mediaType]; !ok {
+        writeError(w, "unsupported_media_type", "Content-Type must be application/epub+zip or application/pdf")
+        return
+    }
+    if r.ContentLength > maxEbookBytes {
+        writeError(w, "payload_too_large", "files may be at most "+strconv.FormatInt(maxEbookBytes, 10)+" bytes")
+        return
+    }
+
+    ebookMux.Lock()
+    ebookSeq++
+    f := EbookFile{ID: strconv.Itoa(ebookSeq), BookID: bookID, MediaType: mediaType}
+    ebookMux.Unlock()
+
+    hash := sha256.New()
+    size, err := blobs.Put(f.ID, io.TeeReader(http.MaxBytesReader(w, r.Body, maxEbookBytes), hash))
+    var tooLarge *http.MaxBytesError
+    if errors.As(err, &tooLarge) {
+        writeError(w, "payload_too_large", "files may be at most "+strconv.FormatInt(maxEbookBytes, 10)+" bytes")
+        return
+    } else if err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    f.Size, f.SHA256 = size, hex.EncodeToString(hash.Sum(nil))
+    if want := r.Header.Get("X-Checksum-SHA256"); want != "" && !strings.EqualFold(want, f.SHA256) {
+        blobs.Delete(f.ID)
+        writeError(w, "validation_failed", "the file's SHA-256 checksum is "+f.SHA256+", not "+want)
+        return
+    }
+    f.UploadedAt = clock.Now().UTC()
+
+    ebookMux.Lock()
+    ebookFiles[f.ID] = f
+    ebookMux.Unlock()
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(f)
+}
+
+// createDownloadLink handles POST /book/{id}/files/{fileID}/link. The link
+// works without an API key, so it can be handed to a reader app or another
+// person; ?expires_in= sets its lifetime in seconds, 0 for a link that
+// doesn't expire.
+func createDownloadLink(w http.ResponseWriter, r *http.Request, f EbookFile) {
+    ttl := downloadLinkTTL
+    if v := r.URL.Query().Get("expires_in"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 0 {
+            writeError(w, "invalid_query", "expires_in must be a number of seconds, or 0 for no expiry")
+            return
+        }
+        ttl = time.Duration(n) * time.Second
+    }
+    var link DownloadLink
+    var expires int64
+    if ttl > 0 {
+        t := clock.Now().UTC().Add(ttl).Truncate(time.Second)
+        expires, link.ExpiresAt = t.Unix(), &t
+    }
+    link.URL = downloadURL(f.ID, expires)
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(link)
+}
+
+// handleDownload handles GET /downloads/{fileID}?expires=&signature=, serving
+// a file through a signed link without an API key.
+func handleDownload(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    fileID := r.URL.Path[len("/downloads/"):]
+    q := r.URL.Query()
+    expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+    if err != nil || !hmac.Equal([]byte(q.Get("signature")), []byte(signDownload(fileID, expires))) {
+        writeError(w, "invalid_signature", "the download link is not valid")
+        return
+    }
+    if expires != 0 && clock.Now().Unix() > expires {
+        writeError(w, "invalid_signature", "the download link has expired")
+        return
+    }
+    ebookMux.RLock()
+    f, ok := ebookFiles[fileID]
+    ebookMux.RUnlock()
+    if !ok {
+        writeError(w, "file_not_found", "file "+fileID+" not found")
+        return
+    }
+    serveEbook(w, f)
+}
+
+// handleOPDSDownload handles GET /opds/download/{fileID}, the acquisition
+// link in OPDS feeds, for readers signed in with basic auth.
+func handleOPDSDownload(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    fileID := r.URL.Path[len("/opds/download/"):]
+    ebookMux.RLock()
+    f, ok := ebookFiles[fileID]
+    ebookMux.RUnlock()
+    if !ok {
+        writeError(w, "file_not_found", "file "+fileID+" not found")
+        return
+    }
+    serveEbook(w, f)
+}
+
+// serveEbook streams a file and counts the download once it has been sent
+// in full.
+func serveEbook(w http.ResponseWriter, f EbookFile) {
+    rc, err := blobs.Open(f.ID)
+    if err != nil {
+        writeError(w, "internal_error", "reading file "+f.ID+": "+err.Error())
+        return
+    }
+    defer rc.Close()
+    w.Header().Set("Content-Type", f.MediaType)
+    w.Header().Set("Content-Length", strconv.FormatInt(f.Size, 10))
+    w.Header().Set("Content-Disposition", `attachment; filename="book-`+f.BookID+`.`+ebookTypes[f.MediaType]+`"`)
+    w.Header().Set("ETag", `"`+f.SHA256+`"`)
+    if n, err := io.Copy(w, rc); err != nil || n != f.Size {
+        return // The client went away; not a download.
+    }
+    incrementCounter(CounterDownloads, f.ID) // Shows in Downloads after the next flush.
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// requireIfMatch makes If-Match mandatory on PUT, PATCH and DELETE of an
+// existing book, so no client can overwrite a change it hasn't seen. Without
+// it the header is still honored when a client sends it.
+var requireIfMatch = envBool("REQUIRE_IF_MATCH", false)
+
+// bookETag returns a strong ETag derived from the book's content, so it changes
+// whenever any field of the book changes.
+func bookETag(book Book) string {
+    data, _ := json.Marshal(book)
+    sum := sha256.Sum256(data)
+    return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// contentETag returns a strong ETag for a rendered response body, for
+// responses such as lists that have no single version of their own.
+func contentETag(body []byte) string {
+    sum := sha256.Sum256(body)
+    return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// renderedBookETag returns the ETag of one rendering of a book, as GET
+// /book/{id} sends it: the book's ETag joined with that of the body. Computed
+// fields, ratings and the language asked for all change the body without
+// changing the book, so each rendering gets an ETag of its own, while the
+// book's part still identifies the version for If-Match.
+func renderedBookETag(book Book, body []byte) string {
+    return strings.TrimSuffix(bookETag(book), `"`) + "-" + strings.TrimPrefix(contentETag(body), `"`)
+}
+
+// etagMatches reports whether an If-Match header value matches the current
+// ETag. "*" matches any existing resource, and the ETag of any rendering of
+// the current version, from renderedBookETag, matches too.
+func etagMatches(header, etag string) bool {
+    for _, candidate := range strings.Split(header, ",") {
+        candidate = strings.TrimSpace(candidate)
+        if candidate == "*" || candidate == etag || strings.HasPrefix(candidate, strings.TrimSuffix(etag, `"`)+"-") {
+            return true
+        }
+    }
+    return false
+}
+
+// checkIfMatch enforces If-Match for a mutation of a book that may or may not
+// exist. It writes 428 or 412 and returns false when the request must not proceed.
+// A book that doesn't exist yet needs no If-Match, as creating it overwrites
+// nothing; one sent anyway fails, as there is no version for it to match.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, book Book, exists bool) bool {
+    header := r.Header.Get("If-Match")
+    if header == "" {
+        if requireIfMatch && exists {
+            writeError(w, "precondition_required", "If-Match header is required; GET the book first to obtain its ETag")
+            return false
+        }
+        return true
+    }
+    if !exists || !etagMatches(header, bookETag(book)) {
+        writeError(w, "precondition_failed", "book has changed since it was fetched")
+        return false
+    }
+    return true
+}
+
+// notModified answers a conditional GET. When If-None-Match lists the current
+// ETag, or there is no If-None-Match and nothing changed since
+// If-Modified-Since, it writes 304 with no body and returns true; headers the
+// handler has already set, such as ETag and Vary, go out with it. A zero
+// modified time means unknown and never counts as unchanged.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+    if header := r.Header.Get("If-None-Match"); header != "" {
+        match := false
+        for _, candidate := range strings.Split(header, ",") {
+            candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/") // If-None-Match compares weakly.
+            if candidate == "*" || candidate == etag {
+                match = true
+            }
+        }
+        if !match {
+            return false
+        }
+    } else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err != nil || modified.IsZero() || modified.Truncate(time.Second).After(since) {
+        return false // HTTP dates have whole seconds, so compare at that precision.
+    }
+    w.WriteHeader(http.StatusNotModified)
+    return true
+}
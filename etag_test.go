@@ -0,0 +1,96 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestNotModified(t *testing.T) {
+    modified := time.Date(2026, 10, 15, 9, 30, 15, 500, time.UTC)
+    at := modified.Format(http.TimeFormat) // Whole seconds, as clients send it back.
+    tests := []struct {
+        name        string
+        ifNoneMatch string
+        ifModSince  string
+        modified    time.Time
+        want        bool
+    }{
+        {"no conditions", "", "", modified, false},
+        {"etag matches", `"abc"`, "", modified, true},
+        {"etag in list", `"x", "abc"`, "", modified, true},
+        {"weak etag matches", `W/"abc"`, "", modified, true},
+        {"star", "*", "", modified, true},
+        {"etag differs", `"xyz"`, "", modified, false},
+        {"unchanged since", "", at, modified, true},
+        {"changed since", "", modified.Add(-time.Second).Format(http.TimeFormat), modified, false},
+        {"later date", "", modified.Add(time.Hour).Format(http.TimeFormat), modified, true},
+        {"unknown modified time", "", at, time.Time{}, false},
+        {"bad date", "", "yesterday", modified, false},
+        {"etag wins over date", `"xyz"`, at, modified, false},
+        {"etag wins the other way", `"abc"`, modified.Add(-time.Hour).Format(http.TimeFormat), modified, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r := httptest.NewRequest("GET", "/book/1", nil)
+            if tt.ifNoneMatch != "" {
+                r.Header.Set("If-None-Match", tt.ifNoneMatch)
+            }
+            if tt.ifModSince != "" {
+                r.Header.Set("If-Modified-Since", tt.ifModSince)
+            }
+            w := httptest.NewRecorder()
+            if got := notModified(w, r, `"abc"`, tt.modified); got != tt.want {
+                t.Fatalf("notModified = %v, want %v", got, tt.want)
+            }
+            if want := map[bool]int{true: http.StatusNotModified, false: http.StatusOK}[tt.want]; w.Code != want {
+                t.Errorf("status = %d, want %d", w.Code, want)
+            }
+        })
+    }
+}
+
+func TestCheckIfMatch(t *testing.T) {
+    book := Book{ID: "1", Title: "1984", Version: 2}
+    current := bookETag(book)
+    rendered := renderedBookETag(book, []byte(`{"id":"1"}`))
+    stale := bookETag(Book{ID: "1", Title: "1984", Version: 1})
+    tests := []struct {
+        name     string
+        ifMatch  string
+        exists   bool
+        required bool
+        want     int // 0 when the write may go ahead.
+    }{
+        {"no header", "", true, false, 0},
+        {"no header, required", "", true, true, http.StatusPreconditionRequired},
+        {"no header, required, new book", "", false, true, 0},
+        {"current etag", current, true, true, 0},
+        {"rendered etag", rendered, true, true, 0},
+        {"one of several", `"x", ` + current, true, false, 0},
+        {"star", "*", true, false, 0},
+        {"stale etag", stale, true, false, http.StatusPreconditionFailed},
+        {"stale rendered etag", renderedBookETag(Book{ID: "1", Version: 1}, nil), true, false, http.StatusPreconditionFailed},
+        {"etag for a missing book", current, false, false, http.StatusPreconditionFailed},
+        {"star for a missing book", "*", false, false, http.StatusPreconditionFailed},
+    }
+    defer func(v bool) { requireIfMatch = v }(requireIfMatch)
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            requireIfMatch = tt.required
+            r := httptest.NewRequest("PUT", "/book/1", nil)
+            if tt.ifMatch != "" {
+                r.Header.Set("If-Match", tt.ifMatch)
+            }
+            w := httptest.NewRecorder()
+            ok := checkIfMatch(w, r, book, tt.exists)
+            if ok != (tt.want == 0) {
+                t.Fatalf("checkIfMatch = %v, want %v", ok, tt.want == 0)
+            }
+            if !ok && w.Code != tt.want {
+                t.Errorf("status = %d, want %d", w.Code, tt.want)
+            }
+        })
+    }
+}
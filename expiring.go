@@ -0,0 +1,141 @@
+package main
+
+import (
+    "sync"
+    "time"
+)
+
+// expiringEntry is one value in an expiringStore.
+type expiringEntry struct {
+    value   interface{}
+    expires time.Time
+}
+
+// expiringStore is a map whose entries expire. Each kind of short-lived
+// per-client state, such as rate-limit buckets and request quotas, gets a
+// store of its own instead of a bare map, and the reaper drops expired
+// entries from all of them so none grows without bound. Expired entries read
+// as missing even before they are reaped.
+type expiringStore struct {
+    name    string
+    mu      sync.Mutex
+    entries map[string]expiringEntry
+}
+
+var (
+    expiringStores    []*expiringStore // Every store, in registration order.
+    expiringStoresMux sync.Mutex       // Mutex to safeguard expiringStores.
+
+    expiringReapInterval = envDuration("EXPIRING_KEYS_REAP_INTERVAL", time.Minute) // How often expired entries are dropped.
+
+    expiringReaped = newCounterVec("expiring_keys_reaped_total", "Expired keys dropped by the reaper.", "store")
+)
+
+func init() {
+    registerGauge("expiring_keys", "Keys held in each expiring-keys store, including expired ones not yet reaped.", "store", func() map[string]float64 {
+        sizes := make(map[string]float64)
+        expiringStoresMux.Lock()
+        defer expiringStoresMux.Unlock()
+        for _, s := range expiringStores {
+            s.mu.Lock()
+            sizes[s.name] = float64(len(s.entries))
+            s.mu.Unlock()
+        }
+        return sizes
+    })
+}
+
+// newExpiringStore creates a store and registers it with the reaper. The name
+// labels its metrics.
+func newExpiringStore(name string) *expiringStore {
+    s := &expiringStore{name: name, entries: make(map[string]expiringEntry)}
+    expiringStoresMux.Lock()
+    expiringStores = append(expiringStores, s)
+    expiringStoresMux.Unlock()
+    return s
+}
+
+// live returns the entry for key if it hasn't expired. Callers hold s.mu.
+func (s *expiringStore) live(key string, now time.Time) (expiringEntry, bool) {
+    e, ok := s.entries[key]
+    if !ok || !now.Before(e.expires) {
+        return expiringEntry{}, false
+    }
+    return e, true
+}
+
+// Get returns the value stored under key, unless it has expired.
+func (s *expiringStore) Get(key string) (interface{}, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    e, ok := s.live(key, clock.Now())
+    return e.value, ok
+}
+
+// Set stores a value under key until expires.
+func (s *expiringStore) Set(key string, value interface{}, expires time.Time) {
+    s.mu.Lock()
+    s.entries[key] = expiringEntry{value: value, expires: expires}
+    s.mu.Unlock()
+}
+
+// Delete removes key.
+func (s *expiringStore) Delete(key string) {
+    s.mu.Lock()
+    delete(s.entries, key)
+    s.mu.Unlock()
+}
+
+// Count returns the count kept under key by Take, or 0.
+func (s *expiringStore) Count(key string) int {
+    n, _ := s.Get(key)
+    count, _ := n.(int)
+    return count
+}
+
+// Take counts one use against key's limit, for rate limits and quotas. A key
+// without a live count starts a new one that lasts until expires. It reports
+// the count after this use and whether the use was within the limit; a use
+// past the limit isn't counted. A limit of 0 or less means unlimited.
+func (s *expiringStore) Take(key string, limit int, expires time.Time) (count int, ok bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    e, live := s.live(key, clock.Now())
+    if !live {
+        e = expiringEntry{value: 0, expires: expires}
+    }
+    count = e.value.(int)
+    if limit > 0 && count >= limit {
+        return count, false
+    }
+    e.value = count + 1
+    s.entries[key] = e
+    return count + 1, true
+}
+
+// reap drops the expired entries and returns how many there were.
+func (s *expiringStore) reap(now time.Time) int {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    reaped := 0
+    for key, e := range s.entries {
+        if !now.Before(e.expires) {
+            delete(s.entries, key)
+            reaped++
+        }
+    }
+    return reaped
+}
+
+// reapExpiringKeys is the scheduled job that drops expired entries from
+// every store, every expiringReapInterval.
+func reapExpiringKeys() error {
+    now := clock.Now()
+    expiringStoresMux.Lock()
+    stores := append([]*expiringStore(nil), expiringStores...)
+    expiringStoresMux.Unlock()
+    for _, s := range stores {
+        expiringReaped.Add(s.name, uint64(s.reap(now)))
+    }
+    return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+    "strings"
+    "unicode"
+)
+
+// fuzzyThreshold is the least relevance, from 0 to 1, a title needs to match
+// a ?fuzzy=true search.
+var fuzzyThreshold = envFloat("FUZZY_THRESHOLD", 0.7)
+
+// fuzzyWords splits text into lower-case words, dropping punctuation, so
+// "Moby-Dick; or, The Whale" is moby, dick, or, the, whale.
+func fuzzyWords(s string) []string {
+    return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+    })
+}
+
+// levenshtein is the number of single-rune insertions, deletions and
+// substitutions that turn a into b.
+func levenshtein(a, b string) int {
+    ra, rb := []rune(a), []rune(b)
+    prev, cur := make([]int, len(rb)+1), make([]int, len(rb)+1)
+    for j := range prev {
+        prev[j] = j
+    }
+    for i := 1; i <= len(ra); i++ {
+        cur[0] = i
+        for j := 1; j <= len(rb); j++ {
+            cost := 1
+            if ra[i-1] == rb[j-1] {
+                cost = 0
+            }
+            cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+        }
+        prev, cur = cur, prev
+    }
+    return prev[len(rb)]
+}
+
+// wordSimilarity is 1 for equal words, falling to 0 as the edit distance
+// reaches the length of the longer word.
+func wordSimilarity(a, b string) float64 {
+    longest := max(len([]rune(a)), len([]rune(b)))
+    if longest == 0 {
+        return 1
+    }
+    return 1 - float64(levenshtein(a, b))/float64(longest)
+}
+
+// trigrams returns the set of three-rune sequences in the words, each padded
+// with spaces so word starts and ends count.
+func trigrams(words []string) map[string]bool {
+    set := make(map[string]bool)
+    for _, w := range words {
+        r := []rune("  " + w + " ")
+        for i := 0; i+3 <= len(r); i++ {
+            set[string(r[i:i+3])] = true
+        }
+    }
+    return set
+}
+
+// fuzzyScore rates how well a text matches a query, from 0 to 1. It takes
+// the better of two measures: how closely each query word matches some word
+// of the text, which forgives typos ("mobi dick"), and how many of the
+// query's trigrams appear in the text, which forgives split and joined words
+// ("mobydick"). Words of the text the query doesn't mention don't count
+// against it, so a short query can match a long title.
+func fuzzyScore(query, text string) float64 {
+    qw, tw := fuzzyWords(query), fuzzyWords(text)
+    if len(qw) == 0 || len(tw) == 0 {
+        return 0
+    }
+    var words float64
+    for _, q := range qw {
+        best := 0.0
+        for _, t := range tw {
+            best = max(best, wordSimilarity(q, t))
+        }
+        words += best
+    }
+    words /= float64(len(qw))
+
+    qt, tt := trigrams(qw), trigrams(tw)
+    for g := range trigrams([]string{strings.Join(tw, "")}) { // The text's words run together too, so "mobydick" finds Moby Dick.
+        tt[g] = true
+    }
+    shared := 0
+    for g := range qt {
+        if tt[g] {
+            shared++
+        }
+    }
+    return max(words, float64(shared)/float64(len(qt)))
+}
+
+// searchScore is the relevance of a book to a ?q= search and whether it
+// matches at all. Books containing the query in their title, description or
+// author, as matchesQuery finds them, score 1. With fuzzy, other books score
+// the best fuzzyScore of their titles in any language, and match when that
+// reaches fuzzyThreshold.
+func searchScore(book Book, q string, fuzzy bool) (float64, bool) {
+    if matchesQuery(book, q) {
+        return 1, true
+    }
+    if !fuzzy {
+        return 0, false
+    }
+    best := fuzzyScore(q, book.Title)
+    for _, t := range book.Titles {
+        best = max(best, fuzzyScore(q, t))
+    }
+    return best, best >= fuzzyThreshold
+}
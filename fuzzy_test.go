@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestLevenshtein(t *testing.T) {
+    tests := []struct {
+        a, b string
+        want int
+    }{
+        {"", "", 0},
+        {"abc", "", 3},
+        {"", "abc", 3},
+        {"moby", "moby", 0},
+        {"moby", "mobi", 1},
+        {"kitten", "sitting", 3},
+        {"flaw", "lawn", 2},
+        {"dick", "dikc", 2}, // A transposition is two edits.
+        {"café", "cafe", 1}, // Counted in runes, not bytes.
+    }
+    for _, tt := range tests {
+        if got := levenshtein(tt.a, tt.b); got != tt.want {
+            t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+        }
+        if got := levenshtein(tt.b, tt.a); got != tt.want {
+            t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+        }
+    }
+}
+
+func TestFuzzyScore(t *testing.T) {
+    tests := []struct {
+        query, title string
+        match        bool // Whether the score reaches the default threshold of 0.7.
+    }{
+        {"moby dick", "Moby-Dick; or, The Whale", true},
+        {"mobi dick", "Moby Dick", true},
+        {"mobydick", "Moby Dick", true},
+        {"brave nu wrld", "Brave New World", true},
+        {"MOBY", "moby dick", true},
+        {"war and peace", "Moby Dick", false},
+        {"zzzz", "Brave New World", false},
+        {"", "Moby Dick", false},
+        {"moby", "", false},
+        {"!!!", "Moby Dick", false},
+    }
+    for _, tt := range tests {
+        score := fuzzyScore(tt.query, tt.title)
+        if score < 0 || score > 1 {
+            t.Errorf("fuzzyScore(%q, %q) = %v, want a score from 0 to 1", tt.query, tt.title, score)
+        }
+        if got := score >= 0.7; got != tt.match {
+            t.Errorf("fuzzyScore(%q, %q) = %v, want a match: %v", tt.query, tt.title, score, tt.match)
+        }
+    }
+    if got := fuzzyScore("moby dick", "Moby Dick"); got != 1 {
+        t.Errorf("fuzzyScore of an exact match = %v, want 1", got)
+    }
+    if close, far := fuzzyScore("mobi dick", "Moby Dick"), fuzzyScore("mobi dik", "Moby Dick"); close <= far {
+        t.Errorf("fewer typos scored %v, not above more typos at %v", close, far)
+    }
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// Genre struct defines one entry of the managed genre list. Genres form a
+// tree through Parent, e.g. "space-opera" under "scifi" under "fiction".
+type Genre struct {
+    ID     string `json:"id"`               // Slug chosen by the client, e.g. "scifi".
+    Name   string `json:"name"`             // Display name, e.g. "Science fiction".
+    Parent string `json:"parent,omitempty"` // ID of the broader genre, if any.
+}
+
+var (
+    genres    = make(map[string]Genre) // Map to store genres with their ID as the key.
+    genresMux sync.RWMutex             // RWMutex to safeguard genres. Taken after authorsMux.
+)
+
+// genreSlug is the shape of a genre ID.
+var genreSlug = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// checkGenre reports the first problem with a genre, if any. Callers hold genresMux.
+func checkGenre(g Genre) (string, bool) {
+    if !genreSlug.MatchString(g.ID) {
+        return "id must be lower-case letters and digits separated by hyphens", false
+    }
+    if strings.TrimSpace(g.Name) == "" {
+        return "name is required", false
+    }
+    if g.Parent == "" {
+        return "", true
+    }
+    for p := g.Parent; p != ""; p = genres[p].Parent { // Walk up to make sure the tree stays a tree.
+        if p == g.ID {
+            return "parent would make genre " + g.ID + " its own ancestor", false
+        }
+        if _, ok := genres[p]; !ok {
+            return "unknown parent " + p, false
+        }
+    }
+    return "", true
+}
+
+// checkBookGenres checks that every genre a book lists exists, dropping
+// duplicates.
+func checkBookGenres(book *Book) error {
+    if len(book.Genres) == 0 {
+        return nil
+    }
+    genresMux.RLock()
+    defer genresMux.RUnlock()
+    seen := make(map[string]bool)
+    kept := book.Genres[:0]
+    for _, id := range book.Genres {
+        if _, ok := genres[id]; !ok {
+            return errors.New("unknown genre " + id)
+        }
+        if !seen[id] {
+            seen[id] = true
+            kept = append(kept, id)
+        }
+    }
+    book.Genres = kept
+    return nil
+}
+
+// genreAndSubgenres returns the IDs of a genre and every genre below it, so
+// ?genre=fiction also finds books filed under "scifi".
+func genreAndSubgenres(id string) map[string]bool {
+    genresMux.RLock()
+    defer genresMux.RUnlock()
+    ids := map[string]bool{id: true}
+    for grew := true; grew; {
+        grew = false
+        for _, g := range genres {
+            if ids[g.Parent] && !ids[g.ID] {
+                ids[g.ID] = true
+                grew = true
+            }
+        }
+    }
+    return ids
+}
+
+// hasGenre reports whether a book is filed under any of the given genres.
+func hasGenre(book Book, ids map[string]bool) bool {
+    for _, id := range book.Genres {
+        if ids[id] {
+            return true
+        }
+    }
+    return false
+}
+
+// handleGenres handles requests for the /genres route.
+func handleGenres(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case "GET": // Retrieve all genres, optionally only the direct children of ?parent=.
+        parent, byParent := r.URL.Query()["parent"]
+        genresMux.RLock()
+        list := make([]Genre, 0, len(genres))
+        for _, g := range genres {
+            if !byParent || g.Parent == parent[0] {
+                list = append(list, g)
+            }
+        }
+        genresMux.RUnlock()
+        sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+        start, end, err := paginate(w, r, len(list))
+        if err != nil {
+            writePageError(w, err)
+            return
+        }
+        json.NewEncoder(w).Encode(list[start:end])
+
+    case "POST": // Add a genre.
+        var g Genre
+        if err := decodeJSON(r, &g); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        genresMux.Lock()
+        defer genresMux.Unlock()
+        if msg, ok := checkGenre(g); !ok {
+            writeError(w, "validation_failed", msg)
+            return
+        }
+        if _, exists := genres[g.ID]; exists {
+            writeError(w, "duplicate", "genre "+g.ID+" already exists")
+            return
+        }
+        genres[g.ID] = g
+        w.Header().Set("Location", "/genre/"+g.ID)
+        w.WriteHeader(http.StatusCreated)
+        json.NewEncoder(w).Encode(g)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// handleGenre handles requests for the /genre/{id} route.
+func handleGenre(w http.ResponseWriter, r *http.Request) {
+    id := r.URL.Path[len("/genre/"):] // Extract the genre ID from the URL path.
+    switch r.Method {
+    case "GET": // Retrieve a single genre.
+        genresMux.RLock()
+        g, ok := genres[id]
+        genresMux.RUnlock()
+        if !ok {
+            writeError(w, "genre_not_found", "genre "+id+" not found")
+            return
+        }
+        json.NewEncoder(w).Encode(g)
+
+    case "PUT": // Rename a genre or move it under another parent.
+        var g Genre
+        if err := decodeJSON(r, &g); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        g.ID = id // The ID in the path is authoritative; books refer to it.
+        genresMux.Lock()
+        defer genresMux.Unlock()
+        if _, ok := genres[id]; !ok {
+            writeError(w, "genre_not_found", "genre "+id+" not found")
+            return
+        }
+        if msg, ok := checkGenre(g); !ok {
+            writeError(w, "validation_failed", msg)
+            return
+        }
+        genres[id] = g
+        json.NewEncoder(w).Encode(g)
+
+    case "DELETE": // Remove a genre that no book or subgenre refers to.
+        mux.RLock() // Held until the genre is gone, so no book can be filed under it meanwhile.
+        defer mux.RUnlock()
+        bks, err := store.List()
+        if err != nil {
+            writeError(w, "internal_error", err.Error())
+            return
+        }
+        inUse := 0
+        for _, book := range bks {
+            if hasGenre(book, map[string]bool{id: true}) {
+                inUse++
+            }
+        }
+        if inUse > 0 {
+            writeError(w, "genre_in_use", strconv.Itoa(inUse)+" book(s) are still filed under genre "+id+"; refile them first")
+            return
+        }
+        genresMux.Lock()
+        defer genresMux.Unlock()
+        if _, ok := genres[id]; !ok {
+            writeError(w, "genre_not_found", "genre "+id+" not found")
+            return
+        }
+        for _, g := range genres {
+            if g.Parent == id {
+                writeError(w, "genre_in_use", "genre "+g.ID+" is filed under genre "+id+"; move or delete it first")
+                return
+            }
+        }
+        delete(genres, id)
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
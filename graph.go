@@ -0,0 +1,195 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+// maxGraphDepth caps ?depth= for GET /book/{id}/graph.
+const maxGraphDepth = 3
+
+// GraphNode is one resource in a graph response. IDs are "type/id".
+type GraphNode struct {
+    ID   string      `json:"id"`
+    Type string      `json:"type"`
+    Data interface{} `json:"data"`
+}
+
+// GraphEdge connects two nodes.
+type GraphEdge struct {
+    From string `json:"from"`
+    To   string `json:"to"`
+    Type string `json:"type"` // Relationship, e.g. copy_of.
+}
+
+// Graph is the response for GET /book/{id}/graph.
+type Graph struct {
+    Nodes []GraphNode `json:"nodes"`
+    Edges []GraphEdge `json:"edges"`
+}
+
+// graphNeighbors lists the resources directly connected to a node of the
+// given type, keyed by node type. Each returns the neighbors' nodes and the
+// edges to them; a new resource type plugs into the graph by adding an entry.
+var graphNeighbors = map[string]func(id string) ([]GraphNode, []GraphEdge){
+    "book":           bookNeighbors,
+    "copy":           copyNeighbors,
+    "genre":          genreNeighbors,
+    "author":         graphLeaf, // Other books by the author are not about this one.
+    "publisher":      graphLeaf, // Nor are the publisher's other books.
+    "location":       graphLeaf, // Listing every copy on a shelf would swamp the graph.
+    "purchase_order": graphLeaf, // Its other copies may belong to unrelated books.
+}
+
+// graphLeaf is the neighbor function for nodes the graph doesn't expand.
+func graphLeaf(string) ([]GraphNode, []GraphEdge) { return nil, nil }
+
+// bookNeighbors links a book to its author, its publisher, its genres and its
+// copies.
+func bookNeighbors(id string) ([]GraphNode, []GraphEdge) {
+    var nodes []GraphNode
+    var edges []GraphEdge
+    mux.RLock()
+    book, _ := getBook(id)
+    mux.RUnlock()
+    if book.AuthorID != "" {
+        authorsMux.RLock()
+        a, ok := authors[book.AuthorID]
+        authorsMux.RUnlock()
+        if ok {
+            nodes = append(nodes, GraphNode{ID: "author/" + a.ID, Type: "author", Data: a})
+            edges = append(edges, GraphEdge{From: "book/" + id, To: "author/" + a.ID, Type: "written_by"})
+        }
+    }
+    if book.PublisherID != "" {
+        publishersMux.RLock()
+        p, ok := publishers[book.PublisherID]
+        publishersMux.RUnlock()
+        if ok {
+            nodes = append(nodes, GraphNode{ID: "publisher/" + p.ID, Type: "publisher", Data: p})
+            edges = append(edges, GraphEdge{From: "book/" + id, To: "publisher/" + p.ID, Type: "published_by"})
+        }
+    }
+    genresMux.RLock()
+    for _, gid := range book.Genres {
+        if g, ok := genres[gid]; ok {
+            nodes = append(nodes, GraphNode{ID: "genre/" + g.ID, Type: "genre", Data: g})
+            edges = append(edges, GraphEdge{From: "book/" + id, To: "genre/" + g.ID, Type: "in_genre"})
+        }
+    }
+    genresMux.RUnlock()
+    copiesMux.RLock()
+    defer copiesMux.RUnlock()
+    for _, c := range copiesOfBook(id) {
+        nodes = append(nodes, GraphNode{ID: "copy/" + c.ID, Type: "copy", Data: c})
+        edges = append(edges, GraphEdge{From: "copy/" + c.ID, To: "book/" + id, Type: "copy_of"})
+    }
+    return nodes, edges
+}
+
+// genreNeighbors links a genre to its parent, so a deep enough graph shows
+// the path up the taxonomy. Its other books and subgenres are left out.
+func genreNeighbors(id string) ([]GraphNode, []GraphEdge) {
+    genresMux.RLock()
+    defer genresMux.RUnlock()
+    g, ok := genres[id]
+    if !ok || g.Parent == "" {
+        return nil, nil
+    }
+    parent, ok := genres[g.Parent]
+    if !ok {
+        return nil, nil
+    }
+    return []GraphNode{{ID: "genre/" + parent.ID, Type: "genre", Data: parent}},
+        []GraphEdge{{From: "genre/" + id, To: "genre/" + parent.ID, Type: "subgenre_of"}}
+}
+
+// copyNeighbors links a copy to its shelf location and the purchase orders
+// that acquired it.
+func copyNeighbors(id string) ([]GraphNode, []GraphEdge) {
+    var nodes []GraphNode
+    var edges []GraphEdge
+    copiesMux.RLock()
+    c, ok := copies[id]
+    copiesMux.RUnlock()
+    if !ok {
+        return nil, nil
+    }
+    if c.LocationID != "" {
+        locationsMux.RLock()
+        loc, ok := locations[c.LocationID]
+        locationsMux.RUnlock()
+        if ok {
+            nodes = append(nodes, GraphNode{ID: "location/" + loc.ID, Type: "location", Data: loc})
+            edges = append(edges, GraphEdge{From: "copy/" + id, To: "location/" + loc.ID, Type: "shelved_at"})
+        }
+    }
+    purchaseOrdersMux.RLock()
+    defer purchaseOrdersMux.RUnlock()
+    for _, po := range purchaseOrders {
+        for _, copyID := range po.CopyIDs {
+            if copyID == id {
+                nodes = append(nodes, GraphNode{ID: "purchase_order/" + po.ID, Type: "purchase_order", Data: po})
+                edges = append(edges, GraphEdge{From: "copy/" + id, To: "purchase_order/" + po.ID, Type: "acquired_by"})
+            }
+        }
+    }
+    return nodes, edges
+}
+
+// handleBookGraph handles GET /book/{id}/graph?depth=2, returning the book and
+// the resources connected to it within depth hops as nodes and edges.
+func handleBookGraph(w http.ResponseWriter, r *http.Request, id string) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    depth := 2
+    if v := r.URL.Query().Get("depth"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 0 || n > maxGraphDepth {
+            writeError(w, "invalid_query", "depth must be between 0 and "+strconv.Itoa(maxGraphDepth))
+            return
+        }
+        depth = n
+    }
+    mux.RLock()
+    book, ok, err := store.Get(id)
+    mux.RUnlock()
+    if err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    if !ok || !canSeeBook(r, book) { // Unpublished books look missing to everyone but staff.
+        writeError(w, "book_not_found", "book "+id+" not found")
+        return
+    }
+
+    graph := Graph{Nodes: []GraphNode{{ID: "book/" + id, Type: "book", Data: book}}, Edges: []GraphEdge{}}
+    seen := map[string]bool{"book/" + id: true}
+    seenEdges := make(map[GraphEdge]bool)
+    frontier := graph.Nodes
+    for hop := 0; hop < depth && len(frontier) > 0; hop++ {
+        var next []GraphNode
+        for _, node := range frontier {
+            nodes, edges := graphNeighbors[node.Type](strings.TrimPrefix(node.ID, node.Type+"/"))
+            for _, n := range nodes {
+                if !seen[n.ID] {
+                    seen[n.ID] = true
+                    graph.Nodes = append(graph.Nodes, n)
+                    next = append(next, n)
+                }
+            }
+            for _, e := range edges {
+                if !seenEdges[e] {
+                    seenEdges[e] = true
+                    graph.Edges = append(graph.Edges, e)
+                }
+            }
+        }
+        frontier = next
+    }
+    json.NewEncoder(w).Encode(graph)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+    "time"
+)
+
+func TestBookGraphGenres(t *testing.T) {
+    useTestSeams(t, time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC))
+    genresMux.Lock()
+    prevGenres := genres
+    genres = map[string]Genre{
+        "fiction": {ID: "fiction", Name: "Fiction"},
+        "sf":      {ID: "sf", Name: "Science fiction", Parent: "fiction"},
+    }
+    genresMux.Unlock()
+    t.Cleanup(func() {
+        genresMux.Lock()
+        genres = prevGenres
+        genresMux.Unlock()
+    })
+    if w := serveBook("PUT", "/book/graph-1", `{"title":"Dune","genres":["sf"]}`, nil); w.Code != http.StatusOK {
+        t.Fatalf("PUT = %d %s", w.Code, w.Body)
+    }
+
+    graphAt := func(depth string) Graph {
+        t.Helper()
+        w := serveBook("GET", "/book/graph-1/graph?depth="+depth, "", nil)
+        var g Graph
+        if err := json.Unmarshal(w.Body.Bytes(), &g); err != nil {
+            t.Fatalf("GET graph = %d %s", w.Code, w.Body)
+        }
+        return g
+    }
+    hasEdge := func(g Graph, want GraphEdge) bool {
+        for _, e := range g.Edges {
+            if e == want {
+                return true
+            }
+        }
+        return false
+    }
+    inGenre := GraphEdge{From: "book/graph-1", To: "genre/sf", Type: "in_genre"}
+    subgenre := GraphEdge{From: "genre/sf", To: "genre/fiction", Type: "subgenre_of"}
+
+    if g := graphAt("1"); !hasEdge(g, inGenre) || hasEdge(g, subgenre) {
+        t.Errorf("depth 1 edges = %+v, want the in_genre edge only", g.Edges)
+    }
+    if g := graphAt("2"); !hasEdge(g, inGenre) || !hasEdge(g, subgenre) {
+        t.Errorf("depth 2 edges = %+v, want the in_genre and subgenre_of edges", g.Edges)
+    }
+}
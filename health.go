@@ -0,0 +1,188 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "sort"
+    "sync"
+    "time"
+)
+
+// healthCheckTimeout limits a dependency check that doesn't set its own timeout.
+var healthCheckTimeout = envDuration("HEALTH_CHECK_TIMEOUT", 2*time.Second)
+
+// WarmupStep reports the progress of one initialization task, such as loading
+// seed data or replaying a log, that must finish before the instance is ready.
+type WarmupStep struct {
+    Name      string     `json:"name"`
+    Completed int        `json:"completed"`         // Units of work done so far.
+    Total     int        `json:"total"`             // Units of work expected; 0 if unknown.
+    Done      bool       `json:"done"`              // Whether the step has finished.
+    StartedAt time.Time  `json:"started_at"`        // When the step began.
+    DoneAt    *time.Time `json:"done_at,omitempty"` // When the step finished.
+}
+
+// HealthCheck struct defines a check of something the instance depends on,
+// such as its database or a webhook receiver, run on every GET /readyz.
+type HealthCheck struct {
+    Name     string
+    Timeout  time.Duration                   // 0 means healthCheckTimeout.
+    Critical bool                            // A failing critical check makes the instance not ready.
+    Check    func(ctx context.Context) error // Returns nil if the dependency is usable.
+}
+
+// CheckResult is the outcome of one health check in a readiness report.
+type CheckResult struct {
+    Name       string `json:"name"`
+    Critical   bool   `json:"critical"`
+    OK         bool   `json:"ok"`
+    Error      string `json:"error,omitempty"`
+    DurationMS int64  `json:"duration_ms"`
+}
+
+// Pinger is implemented by stores, blob stores and backup targets that talk
+// to a server, so their connection can be health-checked.
+type Pinger interface {
+    Ping(ctx context.Context) error
+}
+
+// ReadinessReport is the response for GET /readyz.
+type ReadinessReport struct {
+    Ready    bool          `json:"ready"`
+    Degraded bool          `json:"degraded,omitempty"` // A non-critical check is failing.
+    Steps    []*WarmupStep `json:"steps"`
+    Checks   []CheckResult `json:"checks"`
+}
+
+var (
+    warmupSteps []*WarmupStep // Every warm-up step, in the order they were started.
+    warmupMux   sync.Mutex    // Mutex to safeguard warmupSteps and the steps themselves.
+
+    healthChecks    = make(map[string]HealthCheck) // Registered dependency checks by name.
+    healthChecksMux sync.RWMutex                   // RWMutex to safeguard healthChecks.
+)
+
+// registerHealthCheck adds a dependency check to /readyz, replacing any
+// check with the same name.
+func registerHealthCheck(c HealthCheck) {
+    if c.Timeout <= 0 {
+        c.Timeout = healthCheckTimeout
+    }
+    healthChecksMux.Lock()
+    healthChecks[c.Name] = c
+    healthChecksMux.Unlock()
+}
+
+// registerPinger adds a check for v if it is a Pinger, and does nothing otherwise.
+func registerPinger(name string, v interface{}, critical bool) {
+    if p, ok := v.(Pinger); ok {
+        registerHealthCheck(HealthCheck{Name: name, Critical: critical, Check: p.Ping})
+    }
+}
+
+// runHealthChecks runs every registered check at once, each under its own
+// timeout, and returns the results by name.
+func runHealthChecks() []CheckResult {
+    healthChecksMux.RLock()
+    checks := make([]HealthCheck, 0, len(healthChecks))
+    for _, c := range healthChecks {
+        checks = append(checks, c)
+    }
+    healthChecksMux.RUnlock()
+
+    results := make([]CheckResult, len(checks))
+    var wg sync.WaitGroup
+    for i, c := range checks {
+        wg.Add(1)
+        go func(i int, c HealthCheck) {
+            defer wg.Done()
+            ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+            defer cancel()
+            start := time.Now()
+            errc := make(chan error, 1)
+            go func() { errc <- c.Check(ctx) }()
+            var err error
+            select {
+            case err = <-errc:
+            case <-ctx.Done(): // Don't wait on a check that ignores its context.
+                err = ctx.Err()
+            }
+            results[i] = CheckResult{Name: c.Name, Critical: c.Critical, OK: err == nil, DurationMS: time.Since(start).Milliseconds()}
+            if err != nil {
+                results[i].Error = err.Error()
+            }
+        }(i, c)
+    }
+    wg.Wait()
+    sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+    return results
+}
+
+// startWarmup registers a warm-up step. /readyz fails until every registered
+// step has been marked done, so register steps before the server starts listening.
+func startWarmup(name string) *WarmupStep {
+    warmupMux.Lock()
+    defer warmupMux.Unlock()
+    step := &WarmupStep{Name: name, StartedAt: clock.Now().UTC()}
+    warmupSteps = append(warmupSteps, step)
+    return step
+}
+
+// progress records how far the step has got.
+func (s *WarmupStep) progress(completed, total int) {
+    warmupMux.Lock()
+    s.Completed, s.Total = completed, total
+    warmupMux.Unlock()
+}
+
+// finish marks the step as done.
+func (s *WarmupStep) finish() {
+    warmupMux.Lock()
+    now := clock.Now().UTC()
+    s.Done, s.DoneAt = true, &now
+    if s.Total > 0 {
+        s.Completed = s.Total
+    }
+    warmupMux.Unlock()
+}
+
+// readiness returns a snapshot of the warm-up state and the results of the
+// dependency checks.
+func readiness() ReadinessReport {
+    warmupMux.Lock()
+    report := ReadinessReport{Ready: true, Steps: make([]*WarmupStep, 0, len(warmupSteps))}
+    for _, s := range warmupSteps {
+        step := *s // Copy so the report isn't mutated while it is encoded.
+        report.Steps = append(report.Steps, &step)
+        report.Ready = report.Ready && s.Done
+    }
+    warmupMux.Unlock()
+    report.Checks = runHealthChecks()
+    for _, c := range report.Checks {
+        if !c.OK && c.Critical {
+            report.Ready = false
+        } else if !c.OK {
+            report.Degraded = true
+        }
+    }
+    return report
+}
+
+// handleHealthz handles requests for the /healthz route: the process is up and serving HTTP.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+    w.Write([]byte("ok\n"))
+}
+
+// handleReadyz handles requests for the /readyz route. It answers 503 with the
+// warm-up progress and dependency checks until initialization is complete
+// and while a critical dependency is failing, so load balancers keep traffic
+// away from an instance that can't serve it.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+    report := readiness()
+    if !report.Ready {
+        w.Header().Set("Retry-After", "1") // Warm-up takes seconds; poll again shortly.
+        w.WriteHeader(http.StatusServiceUnavailable)
+    }
+    json.NewEncoder(w).Encode(report)
+}
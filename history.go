@@ -0,0 +1,116 @@
+package main
+
+import (
+    "sync"
+    "time"
+)
+
+// BookVersion struct defines one stored version of a book.
+type BookVersion struct {
+    Version int       `json:"version"`        // 1 for the first version of a book, then counting up.
+    Book    *Book     `json:"book,omitempty"` // The book as of this version; nil if it was deleted.
+    At      time.Time `json:"at"`             // When this version was written.
+    Via     string    `json:"via,omitempty"`  // How it was written, if not a plain API call: import, sync or revert.
+}
+
+var (
+    bookHistory = make(map[string][]BookVersion) // Every version of every book, oldest first, keyed by book ID.
+    historyMux  sync.RWMutex                     // RWMutex to safeguard bookHistory.
+)
+
+// recordVersion appends a new version of a book (nil for a delete) to its
+// history. Callers hold mux so versions are stored in the order they happen.
+func recordVersion(bookID string, book *Book, at time.Time, via string) {
+    historyMux.Lock()
+    defer historyMux.Unlock()
+    versions := bookHistory[bookID]
+    bookHistory[bookID] = append(versions, BookVersion{Version: len(versions) + 1, Book: book, At: at, Via: via})
+}
+
+// booksAsOf reconstructs the catalog as it was at the given instant from the
+// version history.
+func booksAsOf(t time.Time) []Book {
+    historyMux.RLock()
+    defer historyMux.RUnlock()
+    bks := make([]Book, 0, len(bookHistory))
+    for _, versions := range bookHistory {
+        var current *Book
+        for _, v := range versions {
+            if v.At.After(t) {
+                break // Versions are in time order, so the rest are later too.
+            }
+            current = v.Book
+        }
+        if current != nil {
+            bks = append(bks, *current)
+        }
+    }
+    return bks
+}
+
+// currentVersion returns the latest version number of a book, or 0 if it has
+// never existed.
+func currentVersion(bookID string) int {
+    historyMux.RLock()
+    defer historyMux.RUnlock()
+    return len(bookHistory[bookID])
+}
+
+// nextVersion returns the version number the next write to a book gets. Callers
+// hold mux so no other write can take it first.
+func nextVersion(bookID string) int {
+    return currentVersion(bookID) + 1
+}
+
+// serverManagedFields are the JSON names of the fields stampBook sets. They
+// aren't part of a book's content, so merges and diffs leave them out.
+var serverManagedFields = []string{"version", "created_at", "updated_at", "deleted_at"}
+
+// stampBook sets the fields the server manages on a book about to be
+// written: the next version, and the creation and modification times. Values
+// sent by the client are ignored; an update keeps prev's creation time.
+func stampBook(book *Book, prev Book, exists bool) {
+    now := clock.Now().UTC()
+    book.Version = nextVersion(book.ID)
+    book.CreatedAt, book.UpdatedAt, book.DeletedAt = now, now, nil
+    if exists && !prev.CreatedAt.IsZero() {
+        book.CreatedAt = prev.CreatedAt
+    }
+}
+
+// bookAtVersion returns the book as it was at the given version, or nil if
+// that version is unknown or was a delete.
+func bookAtVersion(bookID string, version int) *Book {
+    historyMux.RLock()
+    defer historyMux.RUnlock()
+    versions := bookHistory[bookID]
+    if version < 1 || version > len(versions) {
+        return nil
+    }
+    return versions[version-1].Book
+}
+
+// restoreHistory starts the history of a book loaded from a persistent store
+// at its stored version. Earlier versions were written by a previous run and
+// are unknown, so they read as nil.
+func restoreHistory(book Book, at time.Time) {
+    historyMux.Lock()
+    defer historyMux.Unlock()
+    versions := make([]BookVersion, 0, book.Version)
+    for v := 1; v < book.Version; v++ {
+        versions = append(versions, BookVersion{Version: v}) // No book and no time: as_of reads treat it as missing before now.
+    }
+    bookHistory[book.ID] = append(versions, BookVersion{Version: len(versions) + 1, Book: &book, At: at})
+}
+
+// lastModified returns when a book was last written, or the zero time if it
+// has no history.
+func lastModified(bookID string) time.Time {
+    historyMux.RLock()
+    defer historyMux.RUnlock()
+    versions := bookHistory[bookID]
+    if len(versions) == 0 {
+        return time.Time{}
+    }
+    return versions[len(versions)-1].At
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+    "encoding/binary"
+    "encoding/hex"
+    "errors"
+    "strconv"
+    "strings"
+)
+
+// ID strategies, as set in ID_STRATEGY.
+const (
+    IDUser       = "user"       // Clients choose every ID; any string without "/" will do.
+    IDSequential = "sequential" // 1, 2, 3, ... one more than the highest numeric ID in the catalog.
+    IDUUIDv7     = "uuidv7"     // Time-ordered UUIDs (RFC 9562), e.g. 01928c8e-7c5a-7b3e-9f1d-3c0a9b8e2d41.
+    IDULID       = "ulid"       // Time-ordered ULIDs, e.g. 01J4Z3N8Q6K9V2X7T5R0M1P8WD.
+)
+
+// IDStrategy decides what IDs new books get. A strategy that generates IDs
+// also checks client-supplied ones, so every book created from now on has an
+// ID of the same shape. Existing books keep whatever ID they have.
+type IDStrategy interface {
+    Generate() (string, error) // An ID for a new book that came without one. Callers hold mux.
+    Check(id string) error     // Whether a client may create a book with this ID.
+}
+
+var (
+    // idStrategy names the strategy for new book IDs.
+    idStrategy = envString("ID_STRATEGY", IDUser)
+
+    // ids is the configured strategy, set by openIDStrategy at startup.
+    ids IDStrategy = userIDs{}
+)
+
+// errIDRequired is returned by Generate when the client has to choose the ID.
+var errIDRequired = errors.New("id is required")
+
+// openIDStrategy returns the strategy named by ID_STRATEGY.
+func openIDStrategy() (IDStrategy, error) {
+    switch idStrategy {
+    case IDUser:
+        return userIDs{}, nil
+    case IDSequential:
+        return &sequentialIDs{}, nil
+    case IDUUIDv7:
+        return uuidv7IDs{}, nil
+    case IDULID:
+        return ulidIDs{}, nil
+    }
+    return nil, errors.New("ID_STRATEGY must be user, sequential, uuidv7 or ulid, not " + idStrategy)
+}
+
+// assignID gives a new book an ID if it has none, or checks the one it came
+// with. Callers hold mux.
+func assignID(book *Book) error {
+    if book.ID == "" {
+        id, err := ids.Generate()
+        if err != nil {
+            return err
+        }
+        book.ID = id
+        return nil
+    }
+    return ids.Check(book.ID)
+}
+
+// userIDs leaves IDs to the client.
+type userIDs struct{}
+
+func (userIDs) Generate() (string, error) { return "", errIDRequired }
+
+func (userIDs) Check(id string) error {
+    if id == "" || strings.Contains(id, "/") {
+        return errors.New("id must be non-empty and must not contain /")
+    }
+    return nil
+}
+
+// sequentialIDs counts up from the highest numeric ID in the catalog.
+type sequentialIDs struct {
+    last int64 // Highest number handed out or seen; 0 until first loaded from the store.
+}
+
+func (s *sequentialIDs) Generate() (string, error) {
+    if s.last == 0 {
+        bks, err := store.List() // Start above whatever is already there.
+        if err != nil {
+            return "", err
+        }
+        for _, book := range bks {
+            if n, err := strconv.ParseInt(book.ID, 10, 64); err == nil && n > s.last {
+                s.last = n
+            }
+        }
+    }
+    for {
+        s.last++
+        id := strconv.FormatInt(s.last, 10)
+        _, exists, err := store.Get(id) // A client may have taken the next number itself.
+        if err != nil {
+            return "", err
+        }
+        _, inArchive := archived[id]
+        if _, inTrash := trash[id]; !exists && !inArchive && !inTrash { // Archived and deleted books keep their numbers too.
+            return id, nil
+        }
+    }
+}
+
+func (*sequentialIDs) Check(id string) error {
+    if n, err := strconv.ParseInt(id, 10, 64); err != nil || n <= 0 || strconv.FormatInt(n, 10) != id {
+        return errors.New("id must be a positive integer without leading zeros")
+    }
+    return nil
+}
+
+// uuidv7IDs generates version 7 UUIDs: a millisecond timestamp followed by
+// random bits, so they sort in creation order.
+type uuidv7IDs struct{}
+
+func (uuidv7IDs) Generate() (string, error) {
+    var b [16]byte
+    if _, err := rng.Read(b[6:]); err != nil {
+        return "", err
+    }
+    ms := uint64(clock.Now().UnixMilli())
+    var ts [8]byte
+    binary.BigEndian.PutUint64(ts[:], ms)
+    copy(b[:6], ts[2:])
+    b[6] = b[6]&0x0f | 0x70 // Version 7.
+    b[8] = b[8]&0x3f | 0x80 // RFC 9562 variant.
+    h := hex.EncodeToString(b[:])
+    return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
+
+func (uuidv7IDs) Check(id string) error {
+    invalid := errors.New("id must be a lower-case version 7 UUID")
+    if len(id) != 36 || id[8] != '-' || id[13] != '-' || id[18] != '-' || id[23] != '-' {
+        return invalid
+    }
+    b, err := hex.DecodeString(strings.ReplaceAll(id, "-", ""))
+    if err != nil || id != strings.ToLower(id) || b[6]>>4 != 7 || b[8]>>6 != 2 {
+        return invalid
+    }
+    return nil
+}
+
+// crockford is the base32 alphabet ULIDs are written in.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidIDs generates ULIDs: 48 bits of millisecond timestamp and 80 random
+// bits, as 26 characters of Crockford base32.
+type ulidIDs struct{}
+
+func (ulidIDs) Generate() (string, error) {
+    var b [16]byte
+    if _, err := rng.Read(b[6:]); err != nil {
+        return "", err
+    }
+    var ts [8]byte
+    binary.BigEndian.PutUint64(ts[:], uint64(clock.Now().UnixMilli()))
+    copy(b[:6], ts[2:])
+    hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+    out := make([]byte, 26)
+    for i := 25; i >= 0; i-- { // 128 bits, five at a time from the low end; the first character holds the top 3.
+        out[i] = crockford[lo&0x1f]
+        lo = lo>>5 | hi<<59
+        hi >>= 5
+    }
+    return string(out), nil
+}
+
+func (ulidIDs) Check(id string) error {
+    if len(id) != 26 || id[0] > '7' {
+        return errors.New("id must be a 26-character ULID")
+    }
+    for i := 0; i < len(id); i++ {
+        if !strings.ContainsRune(crockford, rune(id[i])) {
+            return errors.New("id must be a 26-character ULID in upper-case Crockford base32")
+        }
+    }
+    return nil
+}
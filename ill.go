@@ -0,0 +1,244 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Inter-library loan request statuses.
+const (
+    ILLRequested = "requested"          // Member asked for the title; staff are looking for a lender.
+    ILLBorrowed  = "borrowed"           // A lending library sent us the item.
+    ILLReturned  = "returned_to_lender" // The item went back to the lending library.
+    ILLCancelled = "cancelled"          // The request was withdrawn or could not be filled.
+)
+
+// ILLRequest struct defines a member's request for a title the library does not hold.
+type ILLRequest struct {
+    ID             string    `json:"id"`
+    Title          string    `json:"title"`
+    Author         string    `json:"author,omitempty"`
+    ISBN           string    `json:"isbn,omitempty"`
+    RequestedBy    string    `json:"requested_by"`              // Member who asked for the title.
+    LendingLibrary string    `json:"lending_library,omitempty"` // Library that lent the item, once known.
+    Status         string    `json:"status"`
+    BookID         string    `json:"book_id,omitempty"` // Temporary catalog record while the item is here.
+    Note           string    `json:"note,omitempty"`
+    RequestedAt    time.Time `json:"requested_at"`
+    UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// illTransitions lists the statuses each status may move to.
+var illTransitions = map[string][]string{
+    ILLRequested: {ILLBorrowed, ILLCancelled},
+    ILLBorrowed:  {ILLReturned},
+}
+
+var (
+    illRequests    = make(map[string]ILLRequest) // Map to store ILL requests with their ID as the key.
+    illSeq         int                           // Last ILL request ID handed out.
+    illRequestsMux sync.RWMutex                  // RWMutex to safeguard illRequests and illSeq.
+)
+
+// canTransition reports whether an ILL request may move from one status to another.
+func canTransition(from, to string) bool {
+    for _, s := range illTransitions[from] {
+        if s == to {
+            return true
+        }
+    }
+    return false
+}
+
+// catalogHasTitle reports whether a book with the given title is already in the catalog.
+func catalogHasTitle(title string) bool {
+    mux.RLock()
+    defer mux.RUnlock()
+    for _, book := range listBooks() {
+        if strings.EqualFold(book.Title, title) {
+            return true
+        }
+    }
+    return false
+}
+
+// handleILLRequests handles requests for the /ill-requests route.
+func handleILLRequests(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case "GET": // List ILL requests, optionally in a single status.
+        status := r.URL.Query().Get("status")
+        illRequestsMux.RLock()
+        reqs := make([]ILLRequest, 0, len(illRequests))
+        for _, req := range illRequests {
+            if status == "" || req.Status == status {
+                reqs = append(reqs, req)
+            }
+        }
+        illRequestsMux.RUnlock()
+        sort.Slice(reqs, func(i, j int) bool { return lessID(reqs[i].ID, reqs[j].ID) })
+        start, end, err := paginate(w, r, len(reqs))
+        if err != nil {
+            writePageError(w, err)
+            return
+        }
+        json.NewEncoder(w).Encode(reqs[start:end])
+
+    case "POST": // A member requests a title that is not in the catalog.
+        var req ILLRequest
+        if err := decodeJSON(r, &req); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        if req.Title == "" || req.RequestedBy == "" {
+            writeError(w, "validation_failed", "title and requested_by are required")
+            return
+        }
+        if catalogHasTitle(req.Title) {
+            writeError(w, "title_in_catalog", "title is already in the catalog")
+            return
+        }
+        now := clock.Now().UTC()
+        req.Status, req.LendingLibrary, req.BookID = ILLRequested, "", ""
+        req.RequestedAt, req.UpdatedAt = now, now
+        illRequestsMux.Lock()
+        illSeq++
+        req.ID = strconv.Itoa(illSeq)
+        illRequests[req.ID] = req
+        illRequestsMux.Unlock()
+        w.WriteHeader(http.StatusCreated)
+        json.NewEncoder(w).Encode(req)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// handleILLRequest handles requests for the /ill-request/{id} and
+// /ill-request/{id}/catalog routes.
+func handleILLRequest(w http.ResponseWriter, r *http.Request) {
+    id, sub, _ := strings.Cut(r.URL.Path[len("/ill-request/"):], "/") // Extract the request ID from the URL path.
+    if sub == "catalog" {
+        handleILLCatalog(w, r, id)
+        return
+    } else if sub != "" {
+        writeError(w, "not_found", "no route for "+r.URL.Path)
+        return
+    }
+
+    switch r.Method {
+    case "GET": // Retrieve a single ILL request.
+        illRequestsMux.RLock()
+        req, ok := illRequests[id]
+        illRequestsMux.RUnlock()
+        if !ok {
+            writeError(w, "ill_request_not_found", "ILL request "+id+" not found")
+            return
+        }
+        json.NewEncoder(w).Encode(req)
+
+    case "PUT": // Staff move the request along: borrowed, returned to lender or cancelled.
+        var upd struct {
+            Status         string `json:"status"`
+            LendingLibrary string `json:"lending_library"`
+            Note           string `json:"note"`
+        }
+        if err := decodeJSON(r, &upd); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        illRequestsMux.Lock()
+        defer illRequestsMux.Unlock()
+        req, ok := illRequests[id]
+        if !ok {
+            writeError(w, "ill_request_not_found", "ILL request "+id+" not found")
+            return
+        }
+        if upd.Status != "" && upd.Status != req.Status {
+            if !canTransition(req.Status, upd.Status) {
+                writeError(w, "invalid_transition", "cannot move from "+req.Status+" to "+upd.Status)
+                return
+            }
+            if upd.Status == ILLBorrowed && upd.LendingLibrary == "" && req.LendingLibrary == "" {
+                writeError(w, "validation_failed", "lending_library is required once the item is borrowed")
+                return
+            }
+            if upd.Status == ILLReturned && req.BookID != "" {
+                mux.Lock()
+                if err := store.Delete(req.BookID); err != nil { // The temporary record goes away with the item.
+                    mux.Unlock()
+                    writeError(w, "internal_error", err.Error())
+                    return
+                }
+                releaseBook(req.BookID)
+                dropReviews(req.BookID)
+                dropCover(req.BookID)
+                dropCounters(req.BookID)
+                recordChange(ChangeDelete, req.BookID, nil)
+                mux.Unlock()
+                req.BookID = ""
+            }
+            req.Status = upd.Status
+        }
+        if upd.LendingLibrary != "" {
+            req.LendingLibrary = upd.LendingLibrary
+        }
+        if upd.Note != "" {
+            req.Note = upd.Note
+        }
+        req.UpdatedAt = clock.Now().UTC()
+        illRequests[id] = req
+        json.NewEncoder(w).Encode(req)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// handleILLCatalog handles POST /ill-request/{id}/catalog, which adds a temporary
+// catalog record for a borrowed item so it can be found and lent like any other
+// book. The record is removed when the item is returned to the lender.
+func handleILLCatalog(w http.ResponseWriter, r *http.Request, id string) {
+    if r.Method != "POST" {
+        methodNotAllowed(w, r)
+        return
+    }
+    illRequestsMux.Lock()
+    defer illRequestsMux.Unlock()
+    req, ok := illRequests[id]
+    if !ok {
+        writeError(w, "ill_request_not_found", "ILL request "+id+" not found")
+        return
+    }
+    if req.Status != ILLBorrowed {
+        writeError(w, "invalid_transition", "only borrowed items can be cataloged")
+        return
+    }
+    if req.BookID != "" {
+        writeError(w, "duplicate", "already cataloged as book "+req.BookID)
+        return
+    }
+
+    book := Book{ID: "ill-" + req.ID, Title: req.Title, Author: req.Author}
+    if isbn := normalizeISBN(req.ISBN); validISBN13(isbn) {
+        book.ISBN = isbn // Requests aren't validated, so an ISBN that wouldn't pass on a book is left off.
+    }
+    mux.Lock()
+    stampBook(&book, Book{}, false)
+    if err := saveBook(ChangeCreate, book); err != nil {
+        mux.Unlock()
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    recordChange(ChangeCreate, book.ID, &book)
+    mux.Unlock()
+    req.BookID = book.ID
+    req.UpdatedAt = clock.Now().UTC()
+    illRequests[id] = req
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(book)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestILLCatalogCopiesRequest(t *testing.T) {
+    useTestSeams(t, time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC))
+    illRequestsMux.Lock()
+    prev := illRequests
+    illRequests = map[string]ILLRequest{
+        "1": {ID: "1", Title: "Dune", Author: "Frank Herbert", ISBN: "978-0-441-17271-9", Status: ILLBorrowed},
+        "2": {ID: "2", Title: "Dune Messiah", ISBN: "not an isbn", Status: ILLBorrowed},
+    }
+    illRequestsMux.Unlock()
+    t.Cleanup(func() {
+        illRequestsMux.Lock()
+        illRequests = prev
+        illRequestsMux.Unlock()
+    })
+
+    tests := []struct {
+        id, title, author, isbn string
+    }{
+        {"1", "Dune", "Frank Herbert", "9780441172719"},
+        {"2", "Dune Messiah", "", ""},
+    }
+    for _, tt := range tests {
+        w := httptest.NewRecorder()
+        handleILLCatalog(w, httptest.NewRequest("POST", "/ill-request/"+tt.id+"/catalog", nil), tt.id)
+        if w.Code != http.StatusCreated {
+            t.Fatalf("catalog %s = %d %s", tt.id, w.Code, w.Body)
+        }
+        var book Book
+        if err := json.Unmarshal(w.Body.Bytes(), &book); err != nil {
+            t.Fatal(err)
+        }
+        stored, _, _ := store.Get("ill-" + tt.id)
+        for _, b := range []Book{book, stored} {
+            if b.Title != tt.title || b.Author != tt.author || b.ISBN != tt.isbn {
+                t.Errorf("ill-%s = %q by %q, ISBN %q; want %q by %q, ISBN %q", tt.id, b.Title, b.Author, b.ISBN, tt.title, tt.author, tt.isbn)
+            }
+        }
+    }
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "sort"
+)
+
+// Import conflict strategies, chosen per run with ?on_conflict=. A conflict
+// is a record whose ID is already in the catalog.
+const (
+    ConflictOverwrite     = "overwrite"      // Replace the book with the record. The default.
+    ConflictSkip          = "skip"           // Keep the book and ignore the record.
+    ConflictMergeNonEmpty = "merge-nonempty" // Update the book with the record's non-empty fields only.
+    ConflictFail          = "fail"           // Import nothing if any record conflicts.
+)
+
+// importConflictStrategies lists the strategies in the order error messages
+// give them.
+var importConflictStrategies = []string{ConflictOverwrite, ConflictSkip, ConflictMergeNonEmpty, ConflictFail}
+
+// What became of a conflicting record, as given in ImportConflict.Action.
+const (
+    ImportOverwritten = "overwritten"
+    ImportSkipped     = "skipped"
+    ImportMerged      = "merged"
+    ImportRejected    = "rejected" // The run used on_conflict=fail, so nothing was written.
+)
+
+// ImportConflict is one conflicting record in an import's conflict report.
+type ImportConflict struct {
+    Record int      `json:"record"` // Position of the record in the file, from 1.
+    ID     string   `json:"id"`
+    Action string   `json:"action"`
+    Fields []string `json:"fields"` // Fields the record would change, or changed, on the book.
+}
+
+// resolveImportConflict applies a strategy to a record whose ID is taken by
+// prev. For merge-nonempty the record is replaced by the merged book, which
+// is checked like any other; raw holds the record's fields as decoded, before
+// checkBook filled in defaults, so those don't count as values to merge.
+// Callers hold mux.
+func resolveImportConflict(strategy string, record int, prev Book, row *Book, raw map[string]json.RawMessage) (ImportConflict, error) {
+    c := ImportConflict{Record: record, ID: prev.ID}
+    switch strategy {
+    case ConflictSkip:
+        c.Action = ImportSkipped
+    case ConflictFail:
+        c.Action = ImportRejected
+    case ConflictMergeNonEmpty:
+        merged := mergeNonEmpty(prev, raw)
+        if err := checkBook(&merged); err != nil {
+            return c, err
+        }
+        *row = merged
+        c.Action = ImportMerged
+    default:
+        c.Action = ImportOverwritten
+    }
+    c.Fields = make([]string, 0)
+    for name := range fieldDiff(prev, *row) {
+        c.Fields = append(c.Fields, name)
+    }
+    sort.Strings(c.Fields)
+    return c, nil
+}
+
+// mergeNonEmpty returns prev with the non-empty fields of a record laid over
+// it. Empty strings, zeroes, false, empty lists and null leave the book's
+// value alone, so a sparse file only fills in what it has.
+func mergeNonEmpty(prev Book, raw map[string]json.RawMessage) Book {
+    fields := bookFields(prev)
+    for name, v := range raw {
+        if !contains(serverManagedFields, name) && !emptyJSON(v) {
+            fields[name] = v
+        }
+    }
+    data, _ := json.Marshal(fields)
+    var out Book
+    json.Unmarshal(data, &out)
+    return out
+}
+
+// emptyJSON reports whether a JSON value is null or its type's zero value.
+func emptyJSON(v json.RawMessage) bool {
+    switch string(bytes.TrimSpace(v)) {
+    case "", "null", `""`, "0", "false", "[]", "{}":
+        return true
+    }
+    return false
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+    "net/http"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestImportOnConflict(t *testing.T) {
+    tests := []struct {
+        strategy         string
+        status           int
+        created, updated int
+        skipped          int
+        action           string   // What happened to the conflicting record.
+        fields           []string // Fields the record changes on the book.
+        title, author    string   // The existing book after the import.
+    }{
+        {ConflictOverwrite, http.StatusOK, 1, 1, 0, ImportOverwritten, []string{"author", "title"}, "Dune (1965)", ""},
+        {ConflictSkip, http.StatusOK, 1, 0, 1, ImportSkipped, []string{"author", "title"}, "Dune", "Frank Herbert"},
+        {ConflictMergeNonEmpty, http.StatusOK, 1, 1, 0, ImportMerged, []string{"title"}, "Dune (1965)", "Frank Herbert"},
+        {ConflictFail, http.StatusConflict, 0, 0, 0, "", nil, "Dune", "Frank Herbert"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.strategy, func(t *testing.T) {
+            useTestSeams(t, time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC))
+            old, fresh := "conflict-"+tt.strategy, "new-"+tt.strategy
+            if w := serveBook("PUT", "/book/"+old, `{"title":"Dune","author":"Frank Herbert"}`, nil); w.Code != http.StatusOK {
+                t.Fatalf("PUT = %d %s", w.Code, w.Body)
+            }
+
+            w, result := importBooks(t, "on_conflict="+tt.strategy,
+                `[{"id":"`+old+`","title":"Dune (1965)","author":""},{"id":"`+fresh+`","title":"Dune Messiah"}]`)
+            if w.Code != tt.status {
+                t.Fatalf("import = %d %s, want %d", w.Code, w.Body, tt.status)
+            }
+            if result.Created != tt.created || result.Updated != tt.updated || result.Skipped != tt.skipped {
+                t.Errorf("import counted %d created, %d updated, %d skipped; want %d, %d, %d",
+                    result.Created, result.Updated, result.Skipped, tt.created, tt.updated, tt.skipped)
+            }
+            if tt.status == http.StatusOK {
+                if len(result.Conflicts) != 1 {
+                    t.Fatalf("conflicts = %+v, want one", result.Conflicts)
+                }
+                c := result.Conflicts[0]
+                if c.Record != 1 || c.ID != old || c.Action != tt.action || !reflect.DeepEqual(c.Fields, tt.fields) {
+                    t.Errorf("conflict = %+v, want record 1, %s, %s changing %v", c, old, tt.action, tt.fields)
+                }
+            }
+
+            book, _, _ := store.Get(old)
+            if book.Title != tt.title || book.Author != tt.author {
+                t.Errorf("existing book = %q by %q, want %q by %q", book.Title, book.Author, tt.title, tt.author)
+            }
+            if _, exists, _ := store.Get(fresh); exists != (tt.created == 1) {
+                t.Errorf("new book imported: %v, want %v", exists, tt.created == 1)
+            }
+        })
+    }
+}
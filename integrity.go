@@ -0,0 +1,211 @@
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "sort"
+)
+
+// IntegrityIssue is one inconsistency found between records.
+type IntegrityIssue struct {
+    Kind   string `json:"kind"`   // What is wrong, e.g. copy_missing_book.
+    Record string `json:"record"` // The record with the bad reference, e.g. "copy/12".
+    Detail string `json:"detail"`
+    Fixed  bool   `json:"fixed"` // Whether this run repaired it.
+}
+
+// IntegrityReport is the response for /admin/integrity.
+type IntegrityReport struct {
+    Issues []IntegrityIssue `json:"issues"`
+    Fixed  int              `json:"fixed"`
+}
+
+// handleIntegrity handles requests for the /admin/integrity route. GET checks
+// references between books, authors, publishers, genres, copies, locations,
+// purchase orders, weeding records and ILL requests, and that every book's
+// version matches its history; POST runs the same checks and repairs what
+// can be repaired safely. Both need the admin key.
+func handleIntegrity(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" && r.Method != "POST" {
+        methodNotAllowed(w, r)
+        return
+    }
+    if !isAdmin(r) {
+        writeError(w, "admin_required", "integrity checks require the admin key")
+        return
+    }
+    json.NewEncoder(w).Encode(checkIntegrity(r.Method == "POST"))
+}
+
+// checkIntegrity looks for dangling references and, with fix, repairs them:
+// missing locations, authors, publishers and ILL catalog records are cleared,
+// missing genres are dropped from books, reviews and covers of deleted books
+// are removed, missing copies are dropped from purchase orders, orphaned
+// copies are withdrawn, stale book versions are brought in line with the
+// history and files of deleted books are removed. Weeding records that lost
+// their copy are only reported, since they are an audit trail. Locks are
+// taken in the same order the handlers nest them.
+func checkIntegrity(fix bool) IntegrityReport {
+    illRequestsMux.Lock()
+    defer illRequestsMux.Unlock()
+    weedingMux.Lock()
+    defer weedingMux.Unlock()
+    purchaseOrdersMux.Lock()
+    defer purchaseOrdersMux.Unlock()
+    mux.Lock()
+    defer mux.Unlock()
+    copiesMux.Lock()
+    defer copiesMux.Unlock()
+    locationsMux.Lock()
+    defer locationsMux.Unlock()
+    authorsMux.Lock()
+    defer authorsMux.Unlock()
+    genresMux.Lock()
+    defer genresMux.Unlock()
+    publishersMux.Lock()
+    defer publishersMux.Unlock()
+    reviewsMux.Lock()
+    defer reviewsMux.Unlock()
+    coversMux.Lock()
+    defer coversMux.Unlock()
+
+    report := IntegrityReport{Issues: []IntegrityIssue{}}
+    add := func(kind, record, detail string) {
+        report.Issues = append(report.Issues, IntegrityIssue{Kind: kind, Record: record, Detail: detail, Fixed: fix})
+        if fix {
+            report.Fixed++
+        }
+    }
+    now := clock.Now().UTC()
+
+    for id, c := range copies {
+        if !catalogued(c.BookID) && c.Status != CopyWithdrawn {
+            add("copy_missing_book", "copy/"+id, "book "+c.BookID+" does not exist")
+            if fix {
+                c.Status = CopyWithdrawn
+                c.History = append(c.History, CopyStatusChange{Condition: c.Condition, Status: c.Status, Note: "withdrawn by integrity repair: book record missing", ChangedAt: now})
+            }
+        }
+        if _, ok := locations[c.LocationID]; c.LocationID != "" && !ok {
+            add("copy_missing_location", "copy/"+id, "location "+c.LocationID+" does not exist")
+            if fix {
+                c.LocationID = ""
+            }
+        }
+        copies[id] = c
+    }
+    for id, po := range purchaseOrders {
+        kept := make([]string, 0, len(po.CopyIDs))
+        for _, copyID := range po.CopyIDs {
+            if _, ok := copies[copyID]; ok {
+                kept = append(kept, copyID)
+            } else {
+                add("purchase_order_missing_copy", "purchase-order/"+id, "copy "+copyID+" does not exist")
+            }
+        }
+        if fix {
+            po.CopyIDs = kept
+            purchaseOrders[id] = po
+        }
+    }
+    for id, rec := range weeding {
+        if _, ok := copies[rec.CopyID]; !ok {
+            report.Issues = append(report.Issues, IntegrityIssue{Kind: "weeding_missing_copy", Record: "weeding/" + id, Detail: "copy " + rec.CopyID + " does not exist"})
+        }
+    }
+    for id, req := range illRequests {
+        if req.BookID != "" && !catalogued(req.BookID) {
+            add("ill_missing_book", "ill-request/"+id, "catalog record "+req.BookID+" does not exist")
+            if fix {
+                req.BookID = ""
+                illRequests[id] = req
+            }
+        }
+    }
+    for _, book := range listBooks() {
+        id := book.ID
+        if _, ok := authors[book.AuthorID]; book.AuthorID != "" && !ok {
+            add("book_missing_author", "book/"+id, "author "+book.AuthorID+" does not exist")
+            if fix {
+                book.AuthorID = "" // The author's name stays in the book's author field.
+                if err := store.Update(book); err != nil {
+                    log.Printf("integrity: fixing book %s: %v", id, err)
+                }
+            }
+        }
+        if _, ok := publishers[book.PublisherID]; book.PublisherID != "" && !ok {
+            add("book_missing_publisher", "book/"+id, "publisher "+book.PublisherID+" does not exist")
+            if fix {
+                book.PublisherID = ""
+                if err := store.Update(book); err != nil {
+                    log.Printf("integrity: fixing book %s: %v", id, err)
+                }
+            }
+        }
+        kept := make([]string, 0, len(book.Genres))
+        for _, g := range book.Genres {
+            if _, ok := genres[g]; ok {
+                kept = append(kept, g)
+            } else {
+                add("book_missing_genre", "book/"+id, "genre "+g+" does not exist")
+            }
+        }
+        if fix && len(kept) < len(book.Genres) {
+            book.Genres = kept
+            if err := store.Update(book); err != nil {
+                log.Printf("integrity: fixing book %s: %v", id, err)
+            }
+        }
+        if v := currentVersion(id); book.Version != v {
+            add("book_version_mismatch", "book/"+id, "version does not match the history")
+            if fix {
+                book.Version = v
+                if err := store.Update(book); err != nil {
+                    log.Printf("integrity: fixing book %s: %v", id, err)
+                }
+            }
+        }
+    }
+    for id, rv := range reviews {
+        if !catalogued(rv.BookID) {
+            add("review_missing_book", "review/"+id, "book "+rv.BookID+" does not exist")
+            if fix {
+                delete(reviews, id)
+            }
+        }
+    }
+    if fix {
+        rebuildRatings()
+    }
+    for id := range covers {
+        if !catalogued(id) {
+            add("cover_missing_book", "cover/"+id, "book "+id+" does not exist")
+            if fix {
+                delete(covers, id)
+                deleteCoverBlobs(id)
+            }
+        }
+    }
+    ebookMux.Lock()
+    for id, f := range ebookFiles {
+        if !catalogued(f.BookID) {
+            add("file_missing_book", "file/"+id, "book "+f.BookID+" does not exist")
+            if fix {
+                delete(ebookFiles, id)
+                if err := blobs.Delete(id); err != nil {
+                    log.Printf("integrity: deleting file %s: %v", id, err)
+                }
+            }
+        }
+    }
+    ebookMux.Unlock()
+
+    sort.Slice(report.Issues, func(i, j int) bool {
+        if report.Issues[i].Kind != report.Issues[j].Kind {
+            return report.Issues[i].Kind < report.Issues[j].Kind
+        }
+        return report.Issues[i].Record < report.Issues[j].Record
+    })
+    return report
+}
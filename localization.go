@@ -0,0 +1,111 @@
+package main
+
+import (
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Localized is a book's title and description in the language that best
+// matches the request's Accept-Language.
+type Localized struct {
+    Language    string `json:"language,omitempty"` // Empty if the book's original language isn't known.
+    Title       string `json:"title"`
+    Description string `json:"description,omitempty"`
+}
+
+// acceptedLanguages returns the language tags in an Accept-Language header,
+// most preferred first. Tags with q=0 are dropped.
+func acceptedLanguages(r *http.Request) []string {
+    type pref struct {
+        tag string
+        q   float64
+    }
+    var prefs []pref
+    for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+        tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+        tag = strings.TrimSpace(tag)
+        if tag == "" {
+            continue
+        }
+        q := 1.0
+        if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+            if f, err := strconv.ParseFloat(params[len("q="):], 64); err == nil {
+                q = f
+            }
+        }
+        if q > 0 {
+            prefs = append(prefs, pref{tag, q})
+        }
+    }
+    sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+    tags := make([]string, len(prefs))
+    for i, p := range prefs {
+        tags[i] = p.tag
+    }
+    return tags
+}
+
+// bookLanguages lists the languages a book has a title or description in,
+// starting with its original language.
+func bookLanguages(book Book) []string {
+    var langs []string
+    if book.Language != "" {
+        langs = append(langs, book.Language)
+    }
+    for _, m := range []map[string]string{book.Titles, book.Descriptions} {
+        for lang := range m {
+            if !contains(langs, lang) {
+                langs = append(langs, lang)
+            }
+        }
+    }
+    start := 0
+    if book.Language != "" {
+        start = 1 // Keep the original first.
+    }
+    sort.Strings(langs[start:]) // Map order is random; matching should not be.
+    return langs
+}
+
+// matchLanguage picks the language from available that best fits the
+// preferences: an exact match first, then one where either tag is a more
+// specific form of the other (en-GB for en, or en for en-GB). The wildcard
+// matches the first available language. Returns "" if nothing fits.
+func matchLanguage(prefs, available []string) string {
+    for _, want := range prefs {
+        if want == "*" && len(available) > 0 {
+            return available[0]
+        }
+        for _, have := range available {
+            if strings.EqualFold(want, have) {
+                return have
+            }
+        }
+        for _, have := range available {
+            w, h := strings.ToLower(want), strings.ToLower(have)
+            if strings.HasPrefix(w, h+"-") || strings.HasPrefix(h, w+"-") {
+                return have
+            }
+        }
+    }
+    return ""
+}
+
+// localize returns the book's title and description in the best language for
+// prefs, falling back to the original where there is no translation.
+func localize(book Book, prefs []string) Localized {
+    lang := matchLanguage(prefs, bookLanguages(book))
+    if lang == "" {
+        lang = book.Language // Nothing matched: the original.
+    }
+    l := Localized{Language: lang, Title: book.Title, Description: book.Description}
+    if t, ok := book.Titles[lang]; ok {
+        l.Title = t
+    }
+    if d, ok := book.Descriptions[lang]; ok {
+        l.Description = d
+    }
+    return l
+}
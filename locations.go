@@ -0,0 +1,212 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "sync"
+)
+
+// Location struct defines a shelf location where copies are kept.
+type Location struct {
+    ID     string `json:"id"`     // Unique identifier, e.g. "branch-2-fic-a".
+    Branch string `json:"branch"` // Library branch, e.g. "branch-2".
+    Room   string `json:"room"`   // Room or floor within the branch.
+    Shelf  string `json:"shelf"`  // Shelf or range label.
+}
+
+// relocateRequest is the request body for POST /copies/relocate. Either CopyIDs
+// or FromLocationID selects the copies to move.
+type relocateRequest struct {
+    CopyIDs        []string `json:"copy_ids"`         // Specific copies to move.
+    FromLocationID string   `json:"from_location_id"` // Move every copy currently at this location.
+    ToLocationID   string   `json:"to_location_id"`   // Destination location.
+}
+
+var (
+    locations    = make(map[string]Location) // Map to store locations with their ID as the key.
+    locationsMux sync.RWMutex                // RWMutex to safeguard the locations map.
+)
+
+// locationExists reports whether a location with the given ID is registered.
+// Copy writers call it holding copiesMux, so a location can't be deleted
+// between the check and the copy being shelved there.
+func locationExists(id string) bool {
+    locationsMux.RLock()
+    defer locationsMux.RUnlock()
+    _, ok := locations[id]
+    return ok
+}
+
+// bookIDsAtLocation returns the IDs of books with at least one copy at the given
+// location. The filter matches either a location ID or a whole branch.
+func bookIDsAtLocation(filter string) map[string]bool {
+    locationsMux.RLock()
+    matching := make(map[string]bool)
+    for _, loc := range locations {
+        if loc.ID == filter || loc.Branch == filter {
+            matching[loc.ID] = true
+        }
+    }
+    locationsMux.RUnlock()
+
+    ids := make(map[string]bool)
+    copiesMux.RLock()
+    for _, c := range copies {
+        if matching[c.LocationID] {
+            ids[c.BookID] = true
+        }
+    }
+    copiesMux.RUnlock()
+    return ids
+}
+
+// handleLocations handles requests for the /locations route.
+func handleLocations(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case "GET": // Retrieve all locations, optionally limited to one branch.
+        branch := r.URL.Query().Get("branch")
+        locationsMux.RLock()
+        locs := make([]Location, 0, len(locations))
+        for _, loc := range locations {
+            if branch == "" || loc.Branch == branch {
+                locs = append(locs, loc)
+            }
+        }
+        locationsMux.RUnlock()
+        sort.Slice(locs, func(i, j int) bool { return locs[i].ID < locs[j].ID })
+        start, end, err := paginate(w, r, len(locs))
+        if err != nil {
+            writePageError(w, err)
+            return
+        }
+        json.NewEncoder(w).Encode(locs[start:end])
+
+    case "POST": // Register a new location.
+        var loc Location
+        if err := decodeJSON(r, &loc); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        if loc.ID == "" || loc.Branch == "" {
+            writeError(w, "validation_failed", "id and branch are required")
+            return
+        }
+        locationsMux.Lock()
+        defer locationsMux.Unlock()
+        if _, exists := locations[loc.ID]; exists {
+            writeError(w, "duplicate", "location "+loc.ID+" already exists; use PUT to change it")
+            return
+        }
+        locations[loc.ID] = loc
+        w.WriteHeader(http.StatusCreated)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// handleLocation handles requests for the /location/{id} route.
+func handleLocation(w http.ResponseWriter, r *http.Request) {
+    id := r.URL.Path[len("/location/"):] // Extract the location ID from the URL path.
+    switch r.Method {
+    case "GET": // Retrieve a single location.
+        locationsMux.RLock()
+        loc, ok := locations[id]
+        locationsMux.RUnlock()
+        if !ok {
+            writeError(w, "location_not_found", "location "+id+" not found")
+            return
+        }
+        json.NewEncoder(w).Encode(loc)
+
+    case "PUT": // Update an existing location.
+        var loc Location
+        if err := decodeJSON(r, &loc); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        loc.ID = id // The ID in the path is authoritative.
+        if loc.Branch == "" {
+            writeError(w, "validation_failed", "branch is required")
+            return
+        }
+        locationsMux.Lock()
+        defer locationsMux.Unlock()
+        if _, ok := locations[id]; !ok {
+            writeError(w, "location_not_found", "location "+id+" not found")
+            return
+        }
+        locations[id] = loc
+        json.NewEncoder(w).Encode(loc)
+
+    case "DELETE": // Remove a location that no longer holds any copies.
+        copiesMux.RLock() // Held until the delete, so no copy can be shelved here in between.
+        defer copiesMux.RUnlock()
+        locationsMux.Lock()
+        defer locationsMux.Unlock()
+        for _, c := range copies {
+            if c.LocationID == id {
+                writeError(w, "location_in_use", "location still holds copies; relocate them first")
+                return
+            }
+        }
+        delete(locations, id)
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// handleRelocateCopies handles POST /copies/relocate, moving copies to a new
+// location in one step. Either all selected copies move or none do.
+func handleRelocateCopies(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "POST" {
+        methodNotAllowed(w, r)
+        return
+    }
+    var req relocateRequest
+    if err := decodeJSON(r, &req); err != nil {
+        writeDecodeError(w, err)
+        return
+    }
+    if (len(req.CopyIDs) == 0) == (req.FromLocationID == "") {
+        writeError(w, "validation_failed", "specify exactly one of copy_ids or from_location_id")
+        return
+    }
+    copiesMux.Lock()
+    defer copiesMux.Unlock()
+    if !locationExists(req.ToLocationID) {
+        writeError(w, "validation_failed", "unknown to_location_id")
+        return
+    }
+    ids := []string{}
+    seen := make(map[string]bool)
+    for _, id := range req.CopyIDs {
+        if !seen[id] { // A copy listed twice still moves once.
+            seen[id] = true
+            ids = append(ids, id)
+        }
+    }
+    if req.FromLocationID != "" {
+        for _, c := range copies {
+            if c.LocationID == req.FromLocationID {
+                ids = append(ids, c.ID)
+            }
+        }
+    }
+    for _, id := range ids { // Validate everything before moving anything.
+        if _, ok := copies[id]; !ok {
+            writeError(w, "validation_failed", "unknown copy "+id)
+            return
+        }
+    }
+    for _, id := range ids {
+        c := copies[id]
+        c.LocationID = req.ToLocationID
+        copies[id] = c
+    }
+    sort.Slice(ids, func(i, j int) bool { return lessID(ids[i], ids[j]) })
+    json.NewEncoder(w).Encode(map[string]interface{}{"moved": len(ids), "copy_ids": ids})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestDeleteLocationInUse(t *testing.T) {
+    useTestSeams(t, time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC))
+    copiesMux.Lock()
+    locationsMux.Lock()
+    prevCopies, prevSeq, prevLocations := copies, copySeq, locations
+    copies, locations = make(map[string]Copy), make(map[string]Location)
+    locationsMux.Unlock()
+    copiesMux.Unlock()
+    t.Cleanup(func() {
+        copiesMux.Lock()
+        locationsMux.Lock()
+        copies, copySeq, locations = prevCopies, prevSeq, prevLocations
+        locationsMux.Unlock()
+        copiesMux.Unlock()
+    })
+    if w := serveBook("PUT", "/book/loc-1", `{"title":"Dune"}`, nil); w.Code != http.StatusOK {
+        t.Fatalf("PUT = %d %s", w.Code, w.Body)
+    }
+    serve := func(handler http.HandlerFunc, method, path, body string) *httptest.ResponseRecorder {
+        w := httptest.NewRecorder()
+        handler(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+        return w
+    }
+
+    serve(handleLocations, "POST", "/locations", `{"id":"shelf","branch":"Main"}`)
+    serveBook("POST", "/book/loc-1/copies", `{"location_id":"shelf"}`, nil)
+    if w := serve(handleLocation, "DELETE", "/location/shelf", ""); w.Code != http.StatusConflict {
+        t.Errorf("DELETE of a location holding a copy = %d, want %d", w.Code, http.StatusConflict)
+    }
+    copiesMux.Lock()
+    copies = make(map[string]Copy)
+    copiesMux.Unlock()
+    if w := serve(handleLocation, "DELETE", "/location/shelf", ""); w.Code != http.StatusNoContent {
+        t.Errorf("DELETE of an empty location = %d, want %d", w.Code, http.StatusNoContent)
+    }
+
+    // Shelving a copy and deleting its location race; whichever wins, no
+    // copy may be left at a location that no longer exists.
+    for i := 0; i < 50; i++ {
+        if w := serve(handleLocations, "POST", "/locations", `{"id":"shelf","branch":"Main"}`); w.Code != http.StatusCreated {
+            t.Fatalf("POST location = %d %s", w.Code, w.Body)
+        }
+        var wg sync.WaitGroup
+        wg.Add(2)
+        go func() {
+            defer wg.Done()
+            serveBook("POST", "/book/loc-1/copies", `{"location_id":"shelf"}`, nil)
+        }()
+        go func() {
+            defer wg.Done()
+            serve(handleLocation, "DELETE", "/location/shelf", "")
+        }()
+        wg.Wait()
+
+        copiesMux.Lock()
+        locationsMux.Lock()
+        for _, c := range copies {
+            if _, ok := locations[c.LocationID]; !ok {
+                t.Fatalf("copy %s left at deleted location %q", c.ID, c.LocationID)
+            }
+        }
+        if len(copies) > 0 && len(locations) == 0 {
+            t.Fatal("location deleted while holding a copy")
+        }
+        copies, locations = make(map[string]Copy), make(map[string]Location)
+        locationsMux.Unlock()
+        copiesMux.Unlock()
+    }
+}
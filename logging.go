@@ -0,0 +1,172 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "io"
+    "log"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+)
+
+// redacted replaces sensitive values in logs.
+const redacted = "[REDACTED]"
+
+// maxLoggedBody caps how much of a request body debug logging prints.
+const maxLoggedBody = 64 << 10
+
+var (
+    // debugLogging adds request headers and bodies to the access log.
+    debugLogging = envBool("LOG_DEBUG", false)
+
+    // sensitiveHeaders are never written to logs in clear text.
+    sensitiveHeaders = map[string]bool{
+        "X-Api-Key":           true,
+        "Authorization":       true,
+        "Proxy-Authorization": true,
+        "Cookie":              true,
+        "Set-Cookie":          true,
+    }
+
+    // sensitiveParams are query parameters whose values are redacted from logged URLs.
+    sensitiveParams = map[string]bool{"api_key": true, "key": true, "token": true, "signature": true}
+
+    // sensitiveFields are JSON body fields redacted from logs, at any depth. Extend
+    // with REDACT_FIELDS, a comma-separated list of field names.
+    sensitiveFields = fieldSet("password,secret,token,api_key," + envString("REDACT_FIELDS", ""))
+)
+
+// fieldSet turns a comma-separated list into a lower-cased lookup set.
+func fieldSet(list string) map[string]bool {
+    set := make(map[string]bool)
+    for _, f := range strings.Split(list, ",") {
+        if f = strings.TrimSpace(f); f != "" {
+            set[strings.ToLower(f)] = true
+        }
+    }
+    return set
+}
+
+// redactHeaders returns the headers as a flat map with credentials masked.
+func redactHeaders(h http.Header) map[string]string {
+    out := make(map[string]string, len(h))
+    for k, v := range h {
+        if sensitiveHeaders[http.CanonicalHeaderKey(k)] {
+            out[k] = redacted
+        } else {
+            out[k] = strings.Join(v, ", ")
+        }
+    }
+    return out
+}
+
+// redactURL returns the request URI with sensitive query parameters masked.
+func redactURL(u *url.URL) string {
+    q := u.Query()
+    changed := false
+    for k := range q {
+        if sensitiveParams[strings.ToLower(k)] {
+            q.Set(k, redacted)
+            changed = true
+        }
+    }
+    if !changed {
+        return u.RequestURI()
+    }
+    masked := *u
+    masked.RawQuery = q.Encode()
+    return masked.RequestURI()
+}
+
+// redactBody masks sensitive fields in a JSON body. Bodies that aren't JSON are
+// replaced entirely, since we can't tell what they contain.
+func redactBody(data []byte) string {
+    if len(bytes.TrimSpace(data)) == 0 {
+        return ""
+    }
+    var v interface{}
+    if err := json.Unmarshal(data, &v); err != nil {
+        return "[non-JSON body omitted]"
+    }
+    out, _ := json.Marshal(redactValue(v))
+    return string(out)
+}
+
+// redactValue walks a decoded JSON value and masks sensitive fields.
+func redactValue(v interface{}) interface{} {
+    switch v := v.(type) {
+    case map[string]interface{}:
+        for k, child := range v {
+            if sensitiveFields[strings.ToLower(k)] {
+                v[k] = redacted
+            } else {
+                v[k] = redactValue(child)
+            }
+        }
+    case []interface{}:
+        for i, child := range v {
+            v[i] = redactValue(child)
+        }
+    }
+    return v
+}
+
+// statusRecorder captures the status code and size of a response for the access log.
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+    bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+    if rec.status == 0 {
+        rec.status = status
+    }
+    rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+    if rec.status == 0 {
+        rec.status = http.StatusOK
+    }
+    n, err := rec.ResponseWriter.Write(b)
+    rec.bytes += n
+    return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter { return rec.ResponseWriter }
+
+// withAccessLog is a middleware that logs one line per request. With LOG_DEBUG
+// it also logs headers and the request body, with credentials and sensitive
+// fields redacted.
+func withAccessLog(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        var body []byte
+        if debugLogging && r.Body != nil {
+            body, _ = io.ReadAll(io.LimitReader(r.Body, maxLoggedBody))
+            r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body)) // Hand the full body on to the handler.
+        }
+
+        rec := &statusRecorder{ResponseWriter: w}
+        next.ServeHTTP(rec, r)
+        note := ""
+        if countCancelled(r) {
+            note = " client disconnected"
+        }
+        if rec.status == 0 {
+            rec.status = http.StatusOK
+        }
+
+        recordUsage(r, rec.status)    // Anonymous, aggregated counts for the analytics sink.
+        recordKeyUsage(r, rec.status) // Per-key counts for GET /admin/usage.
+        log.Printf("%s %s %s %d %dB %s%s", clientIP(r), r.Method, redactURL(r.URL), rec.status, rec.bytes, time.Since(start).Round(time.Microsecond), note)
+        if debugLogging {
+            headers, _ := json.Marshal(redactHeaders(r.Header))
+            log.Printf("  headers=%s body=%s", headers, redactBody(body))
+        }
+    })
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "regexp"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// LookupRequest is the request body for POST /books/lookup.
+type LookupRequest struct {
+    ISBN   string `json:"isbn"`
+    Create bool   `json:"create,omitempty"` // Create the book instead of only returning it.
+    ID     string `json:"id,omitempty"`     // ID for the created book, if the ID strategy needs one.
+}
+
+// lookupEntry is one cached lookup. A nil book caches "not found".
+type lookupEntry struct {
+    book      *Book
+    fetchedAt time.Time
+}
+
+var (
+    // openLibraryURL is where ISBNs are looked up. Overridable so tests and
+    // air-gapped deployments can point at a mirror.
+    openLibraryURL = envString("OPENLIBRARY_URL", "https://openlibrary.org")
+    lookupClient   = &http.Client{Timeout: envDuration("LOOKUP_TIMEOUT", 5*time.Second)}
+    lookupCacheTTL = envDuration("LOOKUP_CACHE_TTL", 24*time.Hour) // How long a lookup, found or not, is reused.
+    lookupCacheMax = envInt("LOOKUP_CACHE_SIZE", 1000)             // Most lookups kept in the cache.
+
+    lookupCache    = make(map[string]lookupEntry) // Cached lookups by ISBN.
+    lookupCacheMux sync.Mutex                     // Mutex to safeguard lookupCache.
+)
+
+// errISBNNotFound is returned by lookupISBN when OpenLibrary has no record.
+var errISBNNotFound = errors.New("isbn not found")
+
+// yearPattern finds the year in OpenLibrary's free-form publish dates, such
+// as "June 8, 1949".
+var yearPattern = regexp.MustCompile(`\b\d{4}\b`)
+
+// openLibraryBook is the part of an OpenLibrary "data" record we use.
+type openLibraryBook struct {
+    Title   string `json:"title"`
+    Authors []struct {
+        Name string `json:"name"`
+    } `json:"authors"`
+    PublishDate string `json:"publish_date"`
+}
+
+// lookupISBN returns a book pre-filled from OpenLibrary, from the cache when
+// it was looked up recently.
+func lookupISBN(isbn string) (Book, error) {
+    now := clock.Now()
+    lookupCacheMux.Lock()
+    entry, ok := lookupCache[isbn]
+    lookupCacheMux.Unlock()
+    if !ok || now.Sub(entry.fetchedAt) > lookupCacheTTL {
+        book, err := fetchOpenLibrary(isbn)
+        if err != nil && !errors.Is(err, errISBNNotFound) {
+            return Book{}, err // Outages aren't cached; the next lookup tries again.
+        }
+        entry = lookupEntry{book: book, fetchedAt: now}
+        cacheLookup(isbn, entry)
+    }
+    if entry.book == nil {
+        return Book{}, errISBNNotFound
+    }
+    return *entry.book, nil
+}
+
+// cacheLookup stores a lookup, making room by dropping expired entries and,
+// if that isn't enough, an arbitrary one.
+func cacheLookup(isbn string, entry lookupEntry) {
+    lookupCacheMux.Lock()
+    defer lookupCacheMux.Unlock()
+    if _, ok := lookupCache[isbn]; !ok && len(lookupCache) >= lookupCacheMax {
+        for k, e := range lookupCache {
+            if entry.fetchedAt.Sub(e.fetchedAt) > lookupCacheTTL {
+                delete(lookupCache, k)
+            }
+        }
+        for k := range lookupCache {
+            if len(lookupCache) < lookupCacheMax {
+                break
+            }
+            delete(lookupCache, k)
+        }
+    }
+    lookupCache[isbn] = entry
+}
+
+// fetchOpenLibrary asks the OpenLibrary Books API about an ISBN. It returns a
+// nil book and errISBNNotFound when there is no record.
+func fetchOpenLibrary(isbn string) (*Book, error) {
+    q := url.Values{"bibkeys": {"ISBN:" + isbn}, "format": {"json"}, "jscmd": {"data"}}
+    resp, err := lookupClient.Get(openLibraryURL + "/api/books?" + q.Encode())
+    if err != nil {
+        return nil, fmt.Errorf("openlibrary: %v", err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("openlibrary answered %s", resp.Status)
+    }
+    var records map[string]openLibraryBook
+    if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+        return nil, fmt.Errorf("openlibrary: %v", err)
+    }
+    rec, ok := records["ISBN:"+isbn]
+    if !ok {
+        return nil, errISBNNotFound
+    }
+    book := &Book{Title: rec.Title, ISBN: isbn}
+    if len(rec.Authors) > 0 {
+        book.Author = rec.Authors[0].Name
+    }
+    if y := yearPattern.FindString(rec.PublishDate); y != "" {
+        book.PublicationYear, _ = strconv.Atoi(y)
+    }
+    return book, nil
+}
+
+// handleBooksLookup handles POST /books/lookup: it looks an ISBN up on
+// OpenLibrary and returns the book pre-filled with title, author and year,
+// or with "create" adds it to the catalog as POST /books would.
+func handleBooksLookup(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "POST" {
+        methodNotAllowed(w, r)
+        return
+    }
+    var req LookupRequest
+    if err := decodeJSON(r, &req); err != nil {
+        writeDecodeError(w, err)
+        return
+    }
+    isbn := normalizeISBN(req.ISBN)
+    if !validISBN13(isbn) {
+        writeError(w, "validation_failed", "isbn must be a valid ISBN-13")
+        return
+    }
+    book, err := lookupISBN(isbn)
+    if errors.Is(err, errISBNNotFound) {
+        writeError(w, "isbn_not_found", "no record of ISBN "+isbn+" was found")
+        return
+    } else if err != nil {
+        writeError(w, "lookup_failed", err.Error())
+        return
+    }
+    if !req.Create {
+        json.NewEncoder(w).Encode(book)
+        return
+    }
+    book.ID = req.ID
+    createBook(w, r, book)
+}
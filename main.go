@@ -1,13 +1,18 @@
 package main
 
 import (
+    "bytes"
     "context"
     "encoding/json"
+    "flag"
     "fmt"
     "log"
     "net/http"
     "os"
     "os/signal"
+    "sort"
+    "strconv"
+    "strings"
     "sync"
     "syscall"
     "time"           // Import sync to use synchronization primitives like RWMutex.
@@ -15,28 +20,153 @@ import (
 
 // Book struct defines the model for storing book data.
 type Book struct {
-    ID    string `json:"id"`    // ID as string, used as a unique identifier for books.
-    Title string `json:"title"` // Title of the book.
+    ID              string                 `json:"id"`                     // ID as string, used as a unique identifier for books.
+    Title           string                 `json:"title"`                  // Title of the book, in its original language.
+    Description     string                 `json:"description,omitempty"`  // Description in the original language.
+    Language        string                 `json:"language,omitempty"`     // Original language as a BCP 47 tag, e.g. "en" or "pt-BR".
+    Titles          map[string]string      `json:"titles,omitempty"`       // Translated titles by language tag.
+    Descriptions    map[string]string      `json:"descriptions,omitempty"` // Translated descriptions by language tag.
+    Author          string                 `json:"author,omitempty"`
+    AuthorID        string                 `json:"author_id,omitempty"`        // Author record the book is linked to; fills in Author if that is empty.
+    ISBN            string                 `json:"isbn,omitempty"`             // ISBN-13, stored as 13 digits without hyphens.
+    PublicationYear int                    `json:"publication_year,omitempty"` // Year of first publication.
+    Genres          []string               `json:"genres,omitempty"`           // IDs of genres from /genres.
+    Tags            []string               `json:"tags,omitempty"`             // Free-form labels such as "classic", stored lower-case.
+    PublisherID     string                 `json:"publisher_id,omitempty"`     // Publisher record of this edition.
+    Edition         int                    `json:"edition,omitempty"`          // Edition number, 1 for the first.
+    Format          string                 `json:"format,omitempty"`           // hardcover, paperback or ebook.
+    PriceCents      int                    `json:"price_cents,omitempty"`      // List price in the library's currency, in cents.
+    Visibility      string                 `json:"visibility,omitempty"`       // draft, published or archived; only published books are listed publicly.
+    Custom          map[string]interface{} `json:"custom,omitempty"`           // Deployment-specific fields declared in CUSTOM_FIELDS_FILE.
+    Metadata        map[string]string      `json:"metadata,omitempty"`         // Integrators' own key/value pairs, such as shelf locations or SKUs.
+    CreatedAt       time.Time              `json:"created_at"`                 // Set by the server when the book is first written.
+    UpdatedAt       time.Time              `json:"updated_at"`                 // Set by the server on every write.
+    DeletedAt       *time.Time             `json:"deleted_at,omitempty"`       // Set on books in the trash, as listed with ?include_deleted=true.
+    Version         int                    `json:"version"`                    // Set by the server on every write; send it back on PUT.
 }
 
 var (
-    books = make(map[string]Book) // Map to store books with their ID as the key.
-    mux   sync.RWMutex            // RWMutex to safeguard the books map for concurrent access.
+    mux sync.RWMutex // RWMutex serializing access to the book store.
+
+    adminAPIKey = envString("ADMIN_API_KEY", "admin-key") // Key for admin-only actions such as approving deaccessions.
 )
 
 func main() {
-	// Initialize default books
-    initializeBooks()
+    flag.Parse()
+    if *migrateCmd != "" {
+        if err := runMigrateCommand(*migrateCmd); err != nil {
+            log.Fatalf("migrate: %v", err)
+        }
+        return
+    }
+    if err := loadSeedFile(); err != nil {
+        log.Fatalf("seed: %v", err)
+    }
+
+    // Register warm-up work up front so /readyz fails until it has finished
+    seeding := startWarmup("seed books")
+    loadRecordingSeq() // Keep numbering recordings after the ones already on disk.
+    s, err := openStore()
+    if err != nil {
+        log.Fatalf("opening store: %v", err)
+    }
+    store = s
+    if ids, err = openIDStrategy(); err != nil {
+        log.Fatalf("%v", err)
+    }
+    if blobs, err = openBlobs(); err != nil {
+        log.Fatalf("opening blob store: %v", err)
+    }
+    if err = loadCounters(); err != nil {
+        log.Fatalf("loading counters: %v", err)
+    }
+    if backups, err = openBackups(); err != nil {
+        log.Fatalf("opening backup target: %v", err)
+    }
+    if err = openArchive(); err != nil {
+        log.Fatalf("opening archive: %v", err)
+    }
+    // Stores and targets backed by a server get a /readyz check. Backups only
+    // matter to the scheduled job, so a failing target isn't critical.
+    registerPinger("store", store, true)
+    registerPinger("blobs", blobs, true)
+    registerPinger("backups", backups, false)
+    registerPinger("archive", archive, false) // Only archived books need it.
+    go runThumbnailer() // Cover thumbnails are made in the background.
+    loadSnapshot() // Books from the last run, if the memory store is snapshotted.
+    replayWAL()    // Then the writes made after that snapshot.
 
     // Create a new HTTP server
+    apiHandler = withMethodOverride(withAccessLog(withReadOnly(withRecording(withRequestTimeout(withSchemaVersion(withAllowedMethods(withDeprecations(http.DefaultServeMux)))))))) // Use the default ServeMux, flagging deprecated routes, holding routes to their methods, answering in the pinned schema version, honoring client deadlines, refusing writes in read-only mode, recording writes and logging every request
     server := &http.Server{
         Addr:    ":8080",
-        Handler: nil, // Use the default ServeMux
+        Handler: apiHandler,
     }
 
     // Set up HTTP routes
+    http.HandleFunc("/healthz", handleHealthz) // Probes are unauthenticated so load balancers can call them.
+    http.HandleFunc("/readyz", handleReadyz)
     http.HandleFunc("/books", authenticate(handleBooks))
+    http.HandleFunc("/books/changes", authenticate(handleBookChanges))
+    http.HandleFunc("/books/export", authenticate(handleBooksExport))
+    http.HandleFunc("/books/import", authenticate(handleBooksImport))
+    http.HandleFunc("/books/lookup", authenticate(handleBooksLookup))
+    http.HandleFunc("/sync", authenticate(handleSync))
+    http.HandleFunc("/opds", withOPDSAuth(handleOPDS)) // E-reader apps sign in with basic auth.
+    http.HandleFunc("/opds/books", withOPDSAuth(handleOPDSBooks))
+    http.HandleFunc("/opds/search", withOPDSAuth(handleOPDSSearch))
+    http.HandleFunc("/opds/v2", withOPDSAuth(handleOPDS2))
+    http.HandleFunc("/opds/v2/books", withOPDSAuth(handleOPDS2Books))
+    http.HandleFunc("/opds/download/", withOPDSAuth(handleOPDSDownload))
+    http.HandleFunc("/downloads/", handleDownload) // Signed links stand in for the API key.
+    if publicAPI {
+        http.HandleFunc("/public/books", withPublicTier(handlePublicBooks)) // No key, but rate-limited per client IP.
+    }
     http.HandleFunc("/book/", authenticate(handleBook))
+    http.HandleFunc("/copy/", authenticate(handleCopy))
+    http.HandleFunc("/copies/relocate", authenticate(handleRelocateCopies))
+    http.HandleFunc("/locations", authenticate(handleLocations))
+    http.HandleFunc("/location/", authenticate(handleLocation))
+    http.HandleFunc("/authors", authenticate(handleAuthors))
+    http.HandleFunc("/author/", authenticate(handleAuthor))
+    http.HandleFunc("/genres", authenticate(handleGenres))
+    http.HandleFunc("/genre/", authenticate(handleGenre))
+    http.HandleFunc("/tags", authenticate(handleTags))
+    http.HandleFunc("/publishers", authenticate(handlePublishers))
+    http.HandleFunc("/publisher/", authenticate(handlePublisher))
+    http.HandleFunc("/purchase-orders", authenticate(handlePurchaseOrders))
+    http.HandleFunc("/purchase-order/", authenticate(handlePurchaseOrder))
+    http.HandleFunc("/stats", authenticate(handleStats))
+    http.HandleFunc("/weeding", authenticate(handleWeeding))
+    http.HandleFunc("/weeding/", authenticate(handleWeedingRecord))
+    http.HandleFunc("/ill-requests", authenticate(handleILLRequests))
+    http.HandleFunc("/ill-request/", authenticate(handleILLRequest))
+    http.HandleFunc("/searches", authenticate(handleSearches))
+    http.HandleFunc("/search/", authenticate(handleSearch))
+    http.HandleFunc("/metrics", authenticate(handleMetrics))
+    http.HandleFunc("/batch", authenticate(handleBatch))
+    http.HandleFunc("/capabilities", authenticate(handleCapabilities))
+    http.HandleFunc("/admin/read-only", authenticate(handleReadOnly))
+    http.HandleFunc("/admin/integrity", authenticate(handleIntegrity))
+    http.HandleFunc("/admin/recordings", authenticate(handleRecordings))
+    http.HandleFunc("/admin/replay", authenticate(handleReplay))
+    http.HandleFunc("/admin/migration", authenticate(handleMigration))
+    http.HandleFunc("/admin/diff", authenticate(handleDiff))
+    http.HandleFunc("/admin/backup", authenticate(handleBackup))
+    http.HandleFunc("/admin/backups", authenticate(handleBackups))
+    http.HandleFunc("/admin/restore", authenticate(handleRestore))
+    if archive != nil {
+        http.HandleFunc("/admin/archive", authenticate(handleArchive))
+    }
+    http.HandleFunc("/admin/templates", authenticate(handleTemplates))
+    http.HandleFunc("/admin/template/", authenticate(handleTemplate))
+    http.HandleFunc("/jobs", authenticate(handleJobs))
+    http.HandleFunc("/job/", authenticate(handleTransferJob))
+    http.HandleFunc("/quota", authenticate(handleQuota))
+    http.HandleFunc("/admin/usage", authenticate(handleUsage))
+    http.HandleFunc("/schemas", handleSchemas) // Request body schemas are public documentation too.
+    http.HandleFunc("/errors", handleErrors) // The error catalog is public documentation.
+    http.HandleFunc("/", handleNotFound)     // Anything unmatched gets a not_found error envelope.
 
     // Start the HTTP server in a separate goroutine so that it doesn't block.
     go func() {
@@ -46,6 +176,32 @@ func main() {
         }
     }()
 
+	// Initialize default books while /readyz reports progress
+    initializeBooks(seeding)
+
+    if sandboxMode {
+        scheduleOrExit("sandbox-reset", "@every "+sandboxResetInterval.String(), resetSandbox) // Periodically wipe the sandbox back to the seed data.
+    }
+    if snapshotFile != "" {
+        scheduleOrExit("snapshot", "@every "+snapshotInterval.String(), writeSnapshot) // Write the catalog to disk.
+    }
+    scheduleOrExit("search-alerts", "@every "+searchAlertInterval.String(), checkSearchAlerts) // Notify saved searches about new matching books.
+    if exportRetention > 0 {
+        scheduleOrExit("export-cleanup", "@every 10m", expireExports) // Delete exported files past their retention window.
+    }
+    if backupSchedule != "" {
+        scheduleOrExit("backup", backupSchedule, scheduledBackup) // Upload compressed backups and prune old ones.
+    }
+    if archive != nil {
+        scheduleOrExit("archive", archiveSchedule, scheduledArchive) // Move books untouched for ARCHIVE_AFTER_YEARS to the archive.
+    }
+    scheduleOrExit("trash-purge", "@every 1h", purgeTrash) // Remove deleted books past DELETED_RETENTION for good.
+    scheduleOrExit("expiring-keys-reaper", "@every "+expiringReapInterval.String(), reapExpiringKeys) // Drop expired rate-limit buckets, quota counts and the like.
+    scheduleOrExit("counters-flush", "@every "+counterFlushInterval.String(), flushCounters) // Fold view, download and search counts into their totals.
+    if analyticsSink != "" {
+        scheduleOrExit("analytics-flush", "@every "+analyticsFlushInterval.String(), flushAnalytics) // Ship usage counts to the analytics sink.
+    }
+
     // Listen for interrupt signal to gracefully shut down the server
     quit := make(chan os.Signal, 1)
     // Trigger graceful shutdown on interrupt signals
@@ -61,88 +217,488 @@ func main() {
     if err := server.Shutdown(ctx); err != nil {
         log.Fatalf("Server forced to shutdown: %v", err)
     }
+    if err := flushAnalytics(); err != nil { // Don't lose the last window's counts.
+        log.Print(err)
+    }
+    flushCounters() // Fold in the last views and downloads before the final snapshot.
+    if snapshotFile != "" {
+        if err := writeSnapshot(); err != nil { // Keep writes made since the last scheduled snapshot.
+            log.Printf("writing snapshot: %v", err)
+        }
+    }
 }
 
-func initializeBooks() {
-    books["1"] = Book{ID: "1", Title: "1984"}
-    books["2"] = Book{ID: "2", Title: "Brave New World"}
-    books["3"] = Book{ID: "3", Title: "To Kill a Mockingbird"}
-    books["4"] = Book{ID: "4", Title: "The Great Gatsby"}
-    books["5"] = Book{ID: "5", Title: "Moby Dick"}
+// scheduleOrExit schedules a built-in background job. Their schedules come from
+// configuration, so a bad one stops startup instead of silently never running.
+func scheduleOrExit(name, spec string, run func() error) {
+    if err := scheduleJob(name, spec, 0, run); err != nil {
+        log.Fatalf("scheduling %s: %v", name, err)
+    }
+}
+
+// defaultBooks returns the built-in catalog the server starts with when no
+// seed file is given.
+func defaultBooks() []Book {
+    return []Book{
+        {ID: "1", Title: "1984"},
+        {ID: "2", Title: "Brave New World"},
+        {ID: "3", Title: "To Kill a Mockingbird"},
+        {ID: "4", Title: "The Great Gatsby"},
+        {ID: "5", Title: "Moby Dick"},
+    }
+}
+
+func initializeBooks(step *WarmupStep) {
+    seed := seedBooks()
+    mux.Lock() // The server is already listening, so lock like any other writer.
+    if existing := listBooks(); len(existing) > 0 {
+        now := clock.Now().UTC()
+        for _, book := range existing {
+            restoreHistory(book, now) // A persistent store already has a catalog; carry on from its versions.
+        }
+        mux.Unlock()
+        if *seedFile != "" {
+            log.Printf("store already holds %d books; not seeding from %s", len(existing), *seedFile)
+        }
+        step.finish()
+        return
+    }
+    seeded := 0
+    for i, book := range seed {
+        stampBook(&seed[i], Book{}, false)
+        book = seed[i]
+        if err := store.Create(book); err != nil {
+            log.Printf("seeding book %s: %v", book.ID, err)
+            continue
+        }
+        seeded++
+        recordChange(ChangeCreate, book.ID, &seed[i]) // Seed books are part of the history too.
+        step.progress(i+1, len(seed)) // Report progress on /readyz.
+    }
+    mux.Unlock()
+    log.Printf("seeded %d of %d books", seeded, len(seed))
+    step.finish()
 }
 
 // authenticate is a middleware function that verifies the presence of an API key.
 func authenticate(next http.HandlerFunc) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
         apiKey := r.Header.Get("X-API-Key") // Retrieve the API key from the header.
-        if apiKey != "secret-key" {         // Check if the provided API key matches the expected value.
-            http.Error(w, "Unauthorized", http.StatusUnauthorized) // Send an unauthorized status if the key does not match.
+        if !validAPIKey(apiKey) { // Check if the provided API key matches an expected value.
+            writeError(w, "unauthorized", "missing or invalid X-API-Key") // Send an unauthorized status if the key does not match.
+            return
+        }
+        limit, remaining, ok := countRequest(apiKey)
+        if limit > 0 {
+            setRateLimitHeaders(w, limit, remaining) // Tell clients how much quota is left on every response.
+        }
+        if !ok {
+            w.Header().Set("Retry-After", strconv.Itoa(secondsUntilTomorrow()))
+            writeError(w, "request_quota_exceeded", "daily request quota used up; it resets at midnight UTC")
             return
         }
+        if !policyAllows(r) {
+            writeError(w, "forbidden", "the authorization policy does not allow this request")
+            return
+        }
+        if sandboxMode {
+            w.Header().Set("X-Sandbox", "true") // Make it obvious to integrators which environment answered.
+        }
         next(w, r) // Call the next handler if the API key is valid.
     }
 }
 
+// validAPIKey reports whether a key may use the API. A sandbox instance only
+// accepts the sandbox key, and a regular instance never does, so sandbox
+// credentials can't touch real data and real keys aren't used for testing.
+func validAPIKey(key string) bool {
+    if sandboxMode {
+        return key == sandboxAPIKey
+    }
+    return key == "secret-key" || key == adminAPIKey
+}
+
+// isAdmin reports whether the request was made with the admin API key. On a
+// sandbox instance the sandbox key can do everything, including admin actions.
+func isAdmin(r *http.Request) bool {
+    if sandboxMode {
+        return r.Header.Get("X-API-Key") == sandboxAPIKey
+    }
+    return r.Header.Get("X-API-Key") == adminAPIKey
+}
+
 // handleBooks handles requests for the /books route.
 func handleBooks(w http.ResponseWriter, r *http.Request) {
     switch r.Method {
     case "GET": // Handle GET requests to retrieve all books.
-        mux.RLock() // Read-lock the mutex before accessing the shared map.
-        bks := make([]Book, 0, len(books)) // Create a slice of books to send back.
-        for _, book := range books {
+        var asOf *time.Time
+        if v := r.URL.Query().Get("as_of"); v != "" {
+            t, err := time.Parse(time.RFC3339, v)
+            if err != nil {
+                writeError(w, "invalid_query", "as_of must be an RFC 3339 timestamp such as 2024-01-01T00:00:00Z")
+                return
+            }
+            asOf = &t // Read the catalog as it was at that instant.
+        }
+        include, err := parseInclude(r) // Computed fields such as available_copies.
+        if err != nil {
+            writeError(w, "invalid_query", err.Error())
+            return
+        }
+        visible, err := parseVisibility(r) // Published books unless staff ask for more.
+        if err != nil {
+            writeError(w, "invalid_query", err.Error())
+            return
+        }
+        if visible.staffOnly() && !isAdmin(r) {
+            writeError(w, "admin_required", "only the admin key may list draft or archived books")
+            return
+        }
+        includeDeleted := false // Books in the trash, for admins looking for something to restore.
+        if v := r.URL.Query().Get("include_deleted"); v != "" {
+            if includeDeleted, err = strconv.ParseBool(v); err != nil {
+                writeError(w, "invalid_query", "include_deleted must be true or false")
+                return
+            }
+        }
+        if includeDeleted && !isAdmin(r) {
+            writeError(w, "admin_required", "only the admin key may list deleted books")
+            return
+        }
+        language := r.URL.Query().Get("language") // Only books originally in this language.
+        var atLocation map[string]bool
+        if location := r.URL.Query().Get("location"); location != "" {
+            atLocation = bookIDsAtLocation(location) // Only books with a copy at this location or branch.
+        }
+        var inGenres map[string]bool
+        if genre := r.URL.Query().Get("genre"); genre != "" {
+            inGenres = genreAndSubgenres(genre) // Only books filed under this genre or one below it.
+        }
+        tags := parseTagFilter(r)            // Only books carrying every ?tag=.
+        metadata := parseMetadataFilters(r)  // Only books whose ?metadata.{key}= values match.
+        custom, err := parseCustomFilters(r) // Only books whose ?custom.{name}= fields match.
+        if err != nil {
+            writeError(w, "invalid_query", err.Error())
+            return
+        }
+        var source []Book
+        if asOf != nil {
+            source = booksAsOf(*asOf) // Rebuilt from the version history instead of the store.
+            sort.Slice(source, func(i, j int) bool { return lessID(source[i].ID, source[j].ID) }) // Stable order so pages don't overlap.
+        } else {
+            mux.RLock() // Read-lock the mutex before reading the store.
+            source, err = listWithContext(r.Context(), store)
+            if includeDeleted && err == nil {
+                source = append(source, trashedBooks()...)
+                sort.Slice(source, func(i, j int) bool { return lessID(source[i].ID, source[j].ID) })
+            }
+            mux.RUnlock() // Unlock the mutex after reading.
+            if err != nil {
+                writeStoreError(w, r, err)
+                return
+            }
+        }
+        bks := make([]Book, 0, len(source)) // Create a slice of books to send back.
+        for _, book := range source {
+            if !visible[bookVisibility(book)] {
+                continue // Skip drafts and archived books unless ?visibility= asks for them.
+            }
+            if atLocation != nil && !atLocation[book.ID] {
+                continue // Skip books filtered out by ?location=.
+            }
+            if language != "" && !strings.EqualFold(book.Language, language) {
+                continue // Skip books filtered out by ?language=.
+            }
+            if inGenres != nil && !hasGenre(book, inGenres) {
+                continue // Skip books filtered out by ?genre=.
+            }
+            if !hasTags(book, tags) {
+                continue // Skip books filtered out by ?tag=.
+            }
+            if !matchesCustom(book, custom) {
+                continue // Skip books filtered out by ?custom.{name}=.
+            }
+            if !matchesMetadata(book, metadata) {
+                continue // Skip books filtered out by ?metadata.{key}=.
+            }
             bks = append(bks, book) // Append each book to the slice.
         }
-        mux.RUnlock() // Unlock the mutex after reading.
-        json.NewEncoder(w).Encode(bks) // Send the books as JSON.
+        start, end, err := paginate(w, r, len(bks)) // Apply ?page= and ?per_page= if given.
+        if err != nil {
+            writePageError(w, err)
+            return
+        }
+        w.Header().Add("Vary", "Accept-Language")
+        var buf bytes.Buffer
+        json.NewEncoder(&buf).Encode(renderBooks(bks[start:end], include, acceptedLanguages(r)))
+        etag := contentETag(buf.Bytes()) // Changes with anything on the page, including books leaving it.
+        w.Header().Set("ETag", etag)
+        var modified time.Time
+        if len(include) == 0 { // Computed fields change without the catalog changing.
+            modified = lastChangeAt()
+        }
+        if asOf != nil && asOf.Before(modified) {
+            modified = *asOf // The catalog as of a past instant doesn't change after it.
+        }
+        if !modified.IsZero() {
+            w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+        }
+        if notModified(w, r, etag, modified) {
+            return // Polling clients that already have this page get no body.
+        }
+        w.Write(buf.Bytes()) // Send the books as JSON.
 
     case "POST": // Handle POST requests to add new books.
         var book Book
-        if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-            http.Error(w, err.Error(), http.StatusBadRequest) // Send an error if the book cannot be decoded.
+        if err := decodeJSON(r, &book); err != nil {
+            writeDecodeError(w, err) // Send an error if the book cannot be decoded.
             return
         }
-        mux.Lock()              // Lock the mutex before modifying the map.
-        books[book.ID] = book  // Add the book to the map.
-        mux.Unlock()            // Unlock the mutex after modifying.
-        w.WriteHeader(http.StatusCreated) // Respond with a status indicating creation.
+        createBook(w, r, book)
 
     default:
-        w.WriteHeader(http.StatusMethodNotAllowed) // Send an error if the method is not supported.
+        methodNotAllowed(w, r) // Send an error if the method is not supported.
+    }
+}
+
+// createBook adds a book sent to POST /books or POST /author/{id}/books, or
+// overwrites the book with the same ID.
+func createBook(w http.ResponseWriter, r *http.Request, book Book) {
+    if err := checkBook(&book); err != nil {
+        writeError(w, "validation_failed", err.Error())
+        return
+    }
+    mux.Lock() // Lock the mutex before modifying the store.
+    op := ChangeCreate
+    prev, exists, err := store.Get(book.ID)
+    if err != nil {
+        mux.Unlock()
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    if exists {
+        op = ChangeUpdate // POST overwrites an existing book with the same ID.
+    } else if err := assignID(&book); err != nil { // New books get an ID from the configured strategy.
+        mux.Unlock()
+        writeError(w, "validation_failed", err.Error())
+        return
+    }
+    if err := checkWriteQuota(r.Header.Get("X-API-Key"), []Book{book}); err != nil {
+        mux.Unlock()
+        writeError(w, "quota_exceeded", err.Error())
+        return
+    }
+    if isDryRun(r) {
+        mux.Unlock()
+        writeDryRun(w, op, &book, previousBook(prev, exists)) // Report the change without applying it.
+        return
     }
+    stampBook(&book, prev, exists)
+    if err := saveBook(op, book); err != nil { // Add the book to the store.
+        mux.Unlock()
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    if op == ChangeCreate {
+        claimBook(r.Header.Get("X-API-Key"), book.ID) // Count it toward the creator's quota.
+    }
+    recordChange(op, book.ID, &book)             // Log the change for /books/changes.
+    mux.Unlock()                                 // Unlock the mutex after modifying.
+    w.Header().Set("Location", "/book/"+book.ID) // The ID may have been generated by the server.
+    w.WriteHeader(http.StatusCreated)            // Respond with a status indicating creation.
+    json.NewEncoder(w).Encode(book)
 }
 
 // handleBook handles requests for the /book/{id} route.
 func handleBook(w http.ResponseWriter, r *http.Request) {
     id := r.URL.Path[len("/book/"):] // Extract the book ID from the URL path.
+    bookID, sub, isSub := strings.Cut(id, "/")
+    if err := rehydrate(bookID); err != nil { // Archived books are brought back on first use.
+        writeError(w, "internal_error", "reading book "+bookID+" from the archive: "+err.Error())
+        return
+    }
+    if isSub {
+        handleBookSubresource(w, r, bookID, sub) // Hand /book/{id}/{sub} routes to the subresource handlers.
+        return
+    }
     switch r.Method {
     case "GET": // Handle GET requests to retrieve a single book by ID.
-        mux.RLock()            // Read-lock the mutex before accessing the map.
-        book, ok := books[id]  // Retrieve the book from the map.
-        mux.RUnlock()          // Unlock the mutex after accessing.
-        if !ok {
-            http.NotFound(w, r) // If the book is not found, send a 404 response.
+        include, err := parseInclude(r)
+        if err != nil {
+            writeError(w, "invalid_query", err.Error())
+            return
+        }
+        mux.RLock()                                              // Read-lock the mutex before reading the store.
+        book, ok, err := getWithContext(r.Context(), store, id) // Retrieve the book from the store.
+        mux.RUnlock()                                            // Unlock the mutex after accessing.
+        if err != nil {
+            writeStoreError(w, r, err)
             return
         }
-        json.NewEncoder(w).Encode(book) // Send the book as JSON.
+        if !ok || !canSeeBook(r, book) { // Unpublished books look missing to everyone but staff.
+            writeError(w, "book_not_found", "book "+id+" not found") // If the book is not found, send a 404 response.
+            return
+        }
+        if hasReviews(id) { // Reviewed books always show their rating.
+            for _, name := range []string{"average_rating", "review_count"} {
+                if !contains(include, name) {
+                    include = append(include, name)
+                }
+            }
+        }
+        langs := acceptedLanguages(r)
+        w.Header().Add("Vary", "Accept-Language")
+        if len(langs) > 0 {
+            w.Header().Set("Content-Language", localize(book, langs).Language)
+        }
+        var buf bytes.Buffer
+        json.NewEncoder(&buf).Encode(renderBook(book, include, langs))
+        etag := renderedBookETag(book, buf.Bytes())
+        w.Header().Set("ETag", etag) // Let clients make later writes conditional on this version.
+        var modified time.Time
+        if len(include) == 0 { // Computed fields and ratings change without the book changing.
+            modified = book.UpdatedAt
+        }
+        if !modified.IsZero() {
+            w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+        }
+        if notModified(w, r, etag, modified) {
+            return // The client's copy is current; a poll isn't a view.
+        }
+        incrementCounter(CounterViews, id)
+        w.Write(buf.Bytes()) // Send the book as JSON.
 
     case "PUT": // Handle PUT requests to update an existing book.
         var book Book
-        if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-            http.Error(w, err.Error(), http.StatusBadRequest) // Send an error if the book cannot be decoded.
+        if err := decodeJSON(r, &book); err != nil {
+            writeDecodeError(w, err) // Send an error if the book cannot be decoded.
+            return
+        }
+        if err := checkBook(&book); err != nil {
+            writeError(w, "validation_failed", err.Error())
+            return
+        }
+        mux.Lock()             // Lock the mutex before modifying the store.
+        op := ChangeCreate
+        prev, exists, err := store.Get(id)
+        if err != nil {
+            mux.Unlock()
+            writeError(w, "internal_error", err.Error())
+            return
+        }
+        if exists {
+            op = ChangeUpdate
+        } else if err := ids.Check(id); err != nil { // The client picked the ID of a new book.
+            mux.Unlock()
+            writeError(w, "validation_failed", err.Error())
+            return
+        }
+        if !checkIfMatch(w, r, prev, exists) {
+            mux.Unlock()
+            return // Only replace the version the client last saw.
+        }
+        if book.Version != prev.Version { // prev.Version is 0 when creating.
+            mux.Unlock()
+            writeVersionConflict(w, prev.Version) // The client edited a version that is no longer current.
             return
         }
-        mux.Lock()             // Lock the mutex before modifying the map.
-        books[id] = book      // Update the book in the map.
+        book.ID = id
+        if err := checkWriteQuota(r.Header.Get("X-API-Key"), []Book{book}); err != nil {
+            mux.Unlock()
+            writeError(w, "quota_exceeded", err.Error())
+            return
+        }
+        if isDryRun(r) {
+            mux.Unlock()
+            writeDryRun(w, op, &book, previousBook(prev, exists)) // Report the change without applying it.
+            return
+        }
+        stampBook(&book, prev, exists) // Bumps the version and updated_at.
+        if err := saveBook(op, book); err != nil { // Update the book in the store.
+            mux.Unlock()
+            writeError(w, "internal_error", err.Error())
+            return
+        }
+        if op == ChangeCreate {
+            claimBook(r.Header.Get("X-API-Key"), id) // Count it toward the creator's quota.
+        }
+        recordChange(op, id, &book) // Log the change for /books/changes.
         mux.Unlock()           // Unlock the mutex after modifying.
+        w.Header().Set("ETag", bookETag(book)) // ETag of the new version.
         json.NewEncoder(w).Encode(book) // Send the updated book as JSON.
 
     case "DELETE": // Handle DELETE requests to remove a book by ID.
-        mux.Lock()            // Lock the mutex before modifying the map.
-        delete(books, id)     // Remove the book from the map.
+        mux.Lock()            // Lock the mutex before modifying the store.
+        book, exists, err := store.Get(id)
+        if err != nil {
+            mux.Unlock()
+            writeError(w, "internal_error", err.Error())
+            return
+        }
+        if !checkIfMatch(w, r, book, exists) {
+            mux.Unlock()
+            return // Only delete the version the client last saw.
+        }
+        if isDryRun(r) {
+            mux.Unlock()
+            op := ChangeDelete
+            if !exists {
+                op = "none" // Deleting a missing book is a no-op.
+            }
+            writeDryRun(w, op, nil, previousBook(book, exists))
+            return
+        }
+        if exists {
+            if err := trashBook(book); err != nil { // Move the book to the trash, where it can be restored.
+                mux.Unlock()
+                writeError(w, "internal_error", err.Error())
+                return
+            }
+            recordChange(ChangeDelete, id, nil) // Log the change for /books/changes.
+        }
         mux.Unlock()          // Unlock the mutex after modifying.
         w.WriteHeader(http.StatusNoContent) // Send a status to indicate successful deletion.
 
+    case "PATCH": // Handle PATCH requests to change some fields of a book.
+        handleBookPatch(w, r, id)
+
     default:
-        w.WriteHeader(http.StatusMethodNotAllowed) // Send an error if the method is not supported.
+        methodNotAllowed(w, r) // Send an error if the method is not supported.
     }
-}
\ No newline at end of file
+}
+
+// handleBookSubresource dispatches requests for the /book/{id}/{sub} routes.
+func handleBookSubresource(w http.ResponseWriter, r *http.Request, id, sub string) {
+    first, rest, _ := strings.Cut(sub, "/")
+    switch {
+    case sub == "copies":
+        handleBookCopies(w, r, id)
+    case sub == "graph":
+        handleBookGraph(w, r, id)
+    case sub == "activity":
+        handleBookActivity(w, r, id)
+    case sub == "reviews":
+        handleBookReviews(w, r, id)
+    case sub == "cover":
+        handleBookCover(w, r, id)
+    case sub == "restore":
+        handleBookRestore(w, r, id)
+    case first == "files":
+        handleBookFiles(w, r, id, rest)
+    case first == "versions":
+        handleBookVersions(w, r, id, rest)
+    default:
+        writeError(w, "not_found", "no route for "+r.URL.Path) // Unknown subresource.
+    }
+}
+
+// lessID orders IDs numerically when both are numbers and lexically otherwise,
+// so listings come back as 1, 2, 10 rather than 1, 10, 2.
+func lessID(a, b string) bool {
+    na, errA := strconv.Atoi(a)
+    nb, errB := strconv.Atoi(b)
+    if errA == nil && errB == nil {
+        return na < nb
+    }
+    return a < b
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+// useTestSeams points the server at an empty memory store and a fixed clock
+// for the rest of the test.
+func useTestSeams(t *testing.T, now time.Time) *fixedClock {
+    t.Helper()
+    prevStore, prevClock := store, clock
+    t.Cleanup(func() { store, clock = prevStore, prevClock })
+    c := &fixedClock{now: now}
+    store, clock = newMemoryStore(), c
+    return c
+}
+
+// advance moves a fixed clock forward without waiting, unlike Sleep.
+func advance(c *fixedClock, d time.Duration) {
+    c.mu.Lock()
+    c.now = c.now.Add(d)
+    c.mu.Unlock()
+}
+
+// serveBook runs one request through handleBook.
+func serveBook(method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+    r := httptest.NewRequest(method, path, strings.NewReader(body))
+    for k, v := range header {
+        r.Header.Set(k, v)
+    }
+    w := httptest.NewRecorder()
+    handleBook(w, r)
+    return w
+}
+
+func TestBookConditionalRequests(t *testing.T) {
+    created := time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC)
+    c := useTestSeams(t, created)
+
+    w := serveBook("PUT", "/book/cond-1", `{"title":"Moby Dick","author":"Herman Melville","language":"en","titles":{"fr":"Moby Dick ou le Cachalot"}}`, nil)
+    if w.Code != http.StatusOK {
+        t.Fatalf("PUT = %d %s", w.Code, w.Body)
+    }
+
+    w = serveBook("GET", "/book/cond-1", "", nil)
+    if w.Code != http.StatusOK {
+        t.Fatalf("GET = %d %s", w.Code, w.Body)
+    }
+    etag := w.Header().Get("ETag")
+    if got, want := w.Header().Get("Last-Modified"), created.Format(http.TimeFormat); got != want {
+        t.Errorf("Last-Modified = %q, want the fixed clock's %q", got, want)
+    }
+
+    conditional := []struct {
+        name   string
+        header map[string]string
+        want   int
+    }{
+        {"same etag", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+        {"other etag", map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+        {"not modified since", map[string]string{"If-Modified-Since": created.Format(http.TimeFormat)}, http.StatusNotModified},
+        {"modified since", map[string]string{"If-Modified-Since": created.Add(-time.Minute).Format(http.TimeFormat)}, http.StatusOK},
+        {"translated copy", map[string]string{"If-None-Match": etag, "Accept-Language": "fr"}, http.StatusOK},
+    }
+    for _, tt := range conditional {
+        if w := serveBook("GET", "/book/cond-1", "", tt.header); w.Code != tt.want {
+            t.Errorf("GET with %s = %d, want %d", tt.name, w.Code, tt.want)
+        }
+    }
+
+    advance(c, time.Minute)
+    if w := serveBook("PUT", "/book/cond-1", `{"title":"Moby-Dick","author":"Herman Melville","version":1}`, map[string]string{"If-Match": etag}); w.Code != http.StatusOK {
+        t.Fatalf("PUT with the current etag = %d %s", w.Code, w.Body)
+    }
+    w = serveBook("GET", "/book/cond-1", "", map[string]string{"If-None-Match": etag})
+    if w.Code != http.StatusOK {
+        t.Errorf("GET with the old etag = %d, want %d", w.Code, http.StatusOK)
+    }
+    if got, want := w.Header().Get("Last-Modified"), c.Now().Format(http.TimeFormat); got != want {
+        t.Errorf("Last-Modified after the update = %q, want %q", got, want)
+    }
+    if w := serveBook("PUT", "/book/cond-1", `{"title":"x","version":2}`, map[string]string{"If-Match": etag}); w.Code != http.StatusPreconditionFailed {
+        t.Errorf("PUT with the old etag = %d, want %d", w.Code, http.StatusPreconditionFailed)
+    }
+}
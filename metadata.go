@@ -0,0 +1,64 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+)
+
+// Limits on a book's metadata, so integrators can't turn it into a blob store.
+const (
+    maxMetadataKeys     = 50
+    maxMetadataKeyLen   = 64  // In characters.
+    maxMetadataValueLen = 512 // In characters.
+)
+
+// checkMetadata enforces the size limits on a book's metadata.
+func checkMetadata(book *Book) error {
+    if len(book.Metadata) == 0 {
+        book.Metadata = nil
+        return nil
+    }
+    if len(book.Metadata) > maxMetadataKeys {
+        return errors.New("metadata may have at most " + strconv.Itoa(maxMetadataKeys) + " keys")
+    }
+    for key, value := range book.Metadata {
+        switch {
+        case strings.TrimSpace(key) == "":
+            return errors.New("metadata keys must not be empty")
+        case utf8.RuneCountInString(key) > maxMetadataKeyLen:
+            return errors.New("metadata key " + key + " is longer than " + strconv.Itoa(maxMetadataKeyLen) + " characters")
+        case utf8.RuneCountInString(value) > maxMetadataValueLen:
+            return errors.New("metadata." + key + " is longer than " + strconv.Itoa(maxMetadataValueLen) + " characters")
+        }
+    }
+    return nil
+}
+
+// parseMetadataFilters reads ?metadata.{key}= filters for GET /books.
+func parseMetadataFilters(r *http.Request) map[string]string {
+    var filters map[string]string
+    for param, values := range r.URL.Query() {
+        if key, ok := strings.CutPrefix(param, "metadata."); ok {
+            if filters == nil {
+                filters = make(map[string]string)
+            }
+            filters[key] = values[0]
+        }
+    }
+    return filters
+}
+
+// matchesMetadata reports whether a book has every filtered metadata key set
+// to exactly the given value. Metadata is integrators' data, such as SKUs, so
+// unlike custom fields it is compared as is.
+func matchesMetadata(book Book, filters map[string]string) bool {
+    for key, want := range filters {
+        if got, ok := book.Metadata[key]; !ok || got != want {
+            return false
+        }
+    }
+    return true
+}
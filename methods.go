@@ -0,0 +1,183 @@
+package main
+
+import (
+    "net/http"
+    "path"
+    "strings"
+)
+
+// overridableMethods are the methods a POST may be turned into with X-HTTP-Method-Override.
+var overridableMethods = map[string]bool{"PUT": true, "PATCH": true, "DELETE": true}
+
+// RouteMethods lists the methods a route supports. Path is a path.Match
+// pattern, as in routeSchemas.
+type RouteMethods struct {
+    Path    string
+    Methods []string
+}
+
+// routeMethods is the one list of the methods each route supports, next to
+// the routes registered in main. withAllowedMethods refuses any other method
+// before a handler runs and every Allow header is built from it, so a
+// handler's switch can't offer a method this list doesn't. The first
+// matching pattern wins, so more specific ones come first. Paths that match
+// nothing are left to their handlers, which answer not_found.
+var routeMethods = []RouteMethods{
+    {"/healthz", []string{"GET"}},
+    {"/readyz", []string{"GET"}},
+    {"/books", []string{"GET", "POST"}},
+    {"/books/changes", []string{"GET"}},
+    {"/books/export", []string{"GET"}},
+    {"/books/import", []string{"POST"}},
+    {"/books/lookup", []string{"POST"}},
+    {"/sync", []string{"GET", "POST"}},
+    {"/opds", []string{"GET"}},
+    {"/opds/books", []string{"GET"}},
+    {"/opds/search", []string{"GET"}},
+    {"/opds/v2", []string{"GET"}},
+    {"/opds/v2/books", []string{"GET"}},
+    {"/opds/download/*", []string{"GET"}},
+    {"/downloads/*", []string{"GET"}},
+    {"/public/books", []string{"GET"}},
+    {"/book/*", []string{"GET", "PUT", "PATCH", "DELETE"}},
+    {"/book/*/copies", []string{"GET", "POST"}},
+    {"/book/*/graph", []string{"GET"}},
+    {"/book/*/activity", []string{"GET"}},
+    {"/book/*/reviews", []string{"GET", "POST"}},
+    {"/book/*/cover", []string{"GET", "PUT", "DELETE"}},
+    {"/book/*/restore", []string{"POST"}},
+    {"/book/*/files", []string{"GET", "POST"}},
+    {"/book/*/files/*", []string{"GET", "DELETE"}},
+    {"/book/*/files/*/link", []string{"POST"}},
+    {"/book/*/versions", []string{"GET"}},
+    {"/book/*/versions/*", []string{"GET"}},
+    {"/book/*/versions/*/revert", []string{"POST"}},
+    {"/copy/*", []string{"GET", "PUT"}},
+    {"/copies/relocate", []string{"POST"}},
+    {"/locations", []string{"GET", "POST"}},
+    {"/location/*", []string{"GET", "PUT", "DELETE"}},
+    {"/authors", []string{"GET", "POST"}},
+    {"/author/*", []string{"GET", "PUT", "DELETE"}},
+    {"/author/*/books", []string{"GET", "POST"}},
+    {"/genres", []string{"GET", "POST"}},
+    {"/genre/*", []string{"GET", "PUT", "DELETE"}},
+    {"/tags", []string{"GET"}},
+    {"/publishers", []string{"GET", "POST"}},
+    {"/publisher/*", []string{"GET", "PUT", "DELETE"}},
+    {"/publisher/*/books", []string{"GET"}},
+    {"/purchase-orders", []string{"GET", "POST"}},
+    {"/purchase-order/*", []string{"GET", "PUT"}},
+    {"/stats", []string{"GET"}},
+    {"/weeding", []string{"GET", "POST"}},
+    {"/weeding/report", []string{"GET"}},
+    {"/weeding/*", []string{"GET"}},
+    {"/weeding/*/*", []string{"POST"}},
+    {"/ill-requests", []string{"GET", "POST"}},
+    {"/ill-request/*", []string{"GET", "PUT"}},
+    {"/ill-request/*/catalog", []string{"POST"}},
+    {"/searches", []string{"GET", "POST"}},
+    {"/search/*", []string{"GET", "DELETE"}},
+    {"/search/*/results", []string{"GET"}},
+    {"/metrics", []string{"GET"}},
+    {"/batch", []string{"POST"}},
+    {"/capabilities", []string{"GET"}},
+    {"/admin/read-only", []string{"GET", "PUT"}},
+    {"/admin/integrity", []string{"GET", "POST"}},
+    {"/admin/recordings", []string{"GET"}},
+    {"/admin/replay", []string{"POST"}},
+    {"/admin/migration", []string{"GET", "POST"}},
+    {"/admin/diff", []string{"GET"}},
+    {"/admin/backup", []string{"POST"}},
+    {"/admin/backups", []string{"GET", "POST"}},
+    {"/admin/restore", []string{"POST"}},
+    {"/admin/archive", []string{"GET", "POST"}},
+    {"/admin/templates", []string{"GET", "POST"}},
+    {"/admin/template/*", []string{"GET", "PUT", "DELETE"}},
+    {"/admin/template/*/preview", []string{"POST"}},
+    {"/admin/usage", []string{"GET"}},
+    {"/jobs", []string{"GET"}},
+    {"/job/*", []string{"GET"}},
+    {"/job/*/download", []string{"GET"}},
+    {"/quota", []string{"GET"}},
+    {"/schemas", []string{"GET"}},
+    {"/errors", []string{"GET"}},
+}
+
+// allowedMethods returns the methods the route at a path supports, with HEAD
+// wherever GET is, or nil for a path no route matches.
+func allowedMethods(urlPath string) []string {
+    for _, rm := range routeMethods {
+        if ok, _ := path.Match(rm.Path, urlPath); !ok {
+            continue
+        }
+        var methods []string
+        for _, m := range rm.Methods {
+            methods = append(methods, m)
+            if m == "GET" {
+                methods = append(methods, "HEAD")
+            }
+        }
+        return methods
+    }
+    return nil
+}
+
+// methodNotAllowed sends a 405 with an Allow header listing the methods the
+// route does support, from routeMethods.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+    allowed := strings.Join(allowedMethods(r.URL.Path), ", ")
+    w.Header().Set("Allow", allowed)
+    writeError(w, "method_not_allowed", "supported methods: "+allowed) // Send an error if the method is not supported.
+}
+
+// headWriter drops the body of a HEAD request served as a GET, keeping the
+// headers and status.
+type headWriter struct {
+    http.ResponseWriter
+}
+
+func (hw headWriter) Write(p []byte) (int, error) {
+    return len(p), nil
+}
+
+// withAllowedMethods is the middleware that holds routes to routeMethods: a
+// method the route doesn't list gets 405 before any handler runs, and HEAD
+// is served as GET without the body, so handlers only ever see the methods
+// they list.
+func withAllowedMethods(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        allowed := allowedMethods(r.URL.Path)
+        if allowed == nil {
+            next.ServeHTTP(w, r) // Unknown path; its handler answers not_found.
+            return
+        }
+        if !contains(allowed, r.Method) {
+            methodNotAllowed(w, r)
+            return
+        }
+        if r.Method == "HEAD" {
+            get := *r // A copy, so the access log still says HEAD.
+            get.Method = "GET"
+            next.ServeHTTP(headWriter{w}, &get)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// withMethodOverride is a middleware that honors X-HTTP-Method-Override on POST
+// requests, for clients behind proxies that only let GET and POST through.
+// Only POST can be overridden, so a GET (or a link) can never turn into a write.
+func withMethodOverride(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if override := r.Header.Get("X-HTTP-Method-Override"); override != "" {
+            method := strings.ToUpper(strings.TrimSpace(override))
+            if r.Method != "POST" || !overridableMethods[method] {
+                writeError(w, "invalid_method_override", "X-HTTP-Method-Override is only supported on POST, with PUT, PATCH or DELETE")
+                return
+            }
+            r.Method = method
+        }
+        next.ServeHTTP(w, r)
+    })
+}
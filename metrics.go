@@ -0,0 +1,97 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+    "sync"
+)
+
+// counterVec is a family of monotonically increasing counters distinguished by
+// the value of a single label, exposed in the Prometheus text format on /metrics.
+type counterVec struct {
+    name   string
+    help   string
+    label  string
+    mu     sync.Mutex
+    values map[string]uint64
+}
+
+// gaugeFunc is a family of gauges distinguished by the value of a single
+// label, read from their owner each time /metrics is scraped.
+type gaugeFunc struct {
+    name  string
+    help  string
+    label string
+    read  func() map[string]float64 // Current value by label value.
+}
+
+var (
+    metrics    []*counterVec // Every registered counter family, in registration order.
+    gauges     []*gaugeFunc  // Every registered gauge family, in registration order.
+    metricsMux sync.Mutex    // Mutex to safeguard the metrics and gauges slices.
+)
+
+// newCounterVec creates a counter family and registers it for /metrics.
+func newCounterVec(name, help, label string) *counterVec {
+    c := &counterVec{name: name, help: help, label: label, values: make(map[string]uint64)}
+    metricsMux.Lock()
+    metrics = append(metrics, c)
+    metricsMux.Unlock()
+    return c
+}
+
+// registerGauge registers a gauge family for /metrics.
+func registerGauge(name, help, label string, read func() map[string]float64) {
+    metricsMux.Lock()
+    gauges = append(gauges, &gaugeFunc{name: name, help: help, label: label, read: read})
+    metricsMux.Unlock()
+}
+
+// Inc adds one to the counter for the given label value.
+func (c *counterVec) Inc(value string) {
+    c.Add(value, 1)
+}
+
+// Add adds n to the counter for the given label value.
+func (c *counterVec) Add(value string, n uint64) {
+    c.mu.Lock()
+    c.values[value] += n
+    c.mu.Unlock()
+}
+
+// handleMetrics handles requests for the /metrics route.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    metricsMux.Lock()
+    defer metricsMux.Unlock()
+    for _, c := range metrics {
+        fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+        c.mu.Lock()
+        values := make([]string, 0, len(c.values))
+        for v := range c.values {
+            values = append(values, v)
+        }
+        sort.Strings(values)
+        for _, v := range values {
+            fmt.Fprintf(w, "%s{%s=%q} %d\n", c.name, c.label, v, c.values[v])
+        }
+        c.mu.Unlock()
+    }
+    for _, g := range gauges {
+        fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+        current := g.read()
+        values := make([]string, 0, len(current))
+        for v := range current {
+            values = append(values, v)
+        }
+        sort.Strings(values)
+        for _, v := range values {
+            fmt.Fprintf(w, "%s{%s=%q} %g\n", g.name, g.label, v, current[v])
+        }
+    }
+}
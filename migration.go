@@ -0,0 +1,281 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "net/http"
+    "reflect"
+    "sync"
+    "time"
+)
+
+var (
+    // A shadow store receives a copy of every write while the server keeps
+    // reading from the primary, so data can move to a new backend without
+    // downtime. Once the consistency report is clean, make the shadow the
+    // primary and drop the shadow settings.
+    shadowDriver       = envString("STORE_SHADOW", "")
+    shadowDSN          = envString("STORE_SHADOW_DSN", "")
+    shadowCompareReads = envBool("STORE_SHADOW_COMPARE_READS", false) // Also read each book from the shadow and compare.
+)
+
+// maxMismatches caps how many recent mismatches the migration report keeps.
+const maxMismatches = 100
+
+// Mismatch is a difference between the primary and shadow stores seen while
+// serving a request.
+type Mismatch struct {
+    Op     string    `json:"op"` // get, create, update or delete.
+    BookID string    `json:"book_id"`
+    Detail string    `json:"detail"`
+    At     time.Time `json:"at"`
+}
+
+// MigrationStats counts what the dual-write store has done since startup.
+type MigrationStats struct {
+    Writes            int        `json:"writes"`
+    ShadowWriteErrors int        `json:"shadow_write_errors"`
+    ComparedReads     int        `json:"compared_reads"`
+    ReadMismatches    int        `json:"read_mismatches"`
+    Recent            []Mismatch `json:"recent"` // Newest last.
+}
+
+// Consistency compares the full contents of the two stores.
+type Consistency struct {
+    PrimaryBooks int      `json:"primary_books"`
+    ShadowBooks  int      `json:"shadow_books"`
+    Missing      []string `json:"missing"`   // In the primary but not the shadow.
+    Extra        []string `json:"extra"`     // In the shadow but not the primary.
+    Different    []string `json:"different"` // In both, with different contents.
+    Consistent   bool     `json:"consistent"`
+}
+
+// MigrationReport is the response for /admin/migration.
+type MigrationReport struct {
+    Enabled      bool            `json:"enabled"`
+    Primary      string          `json:"primary"`
+    Shadow       string          `json:"shadow,omitempty"`
+    CompareReads bool            `json:"compare_reads"`
+    Backfilled   int             `json:"backfilled,omitempty"` // Books copied by this request.
+    Stats        *MigrationStats `json:"stats,omitempty"`
+    Consistency  *Consistency    `json:"consistency,omitempty"`
+}
+
+// dualStore serves reads from the primary and writes to both stores. The
+// primary decides whether a write succeeded; shadow failures are counted and
+// logged rather than failing the request.
+type dualStore struct {
+    primary, shadow BookStore
+
+    mu    sync.Mutex // Guards stats.
+    stats MigrationStats
+}
+
+func newDualStore(primary, shadow BookStore) *dualStore {
+    return &dualStore{primary: primary, shadow: shadow, stats: MigrationStats{Recent: []Mismatch{}}}
+}
+
+// mismatch records a difference between the stores.
+func (s *dualStore) mismatch(op, id, detail string) {
+    log.Printf("shadow store: %s %s: %s", op, id, detail)
+    s.stats.Recent = append(s.stats.Recent, Mismatch{Op: op, BookID: id, Detail: detail, At: clock.Now().UTC()})
+    if len(s.stats.Recent) > maxMismatches {
+        s.stats.Recent = s.stats.Recent[len(s.stats.Recent)-maxMismatches:]
+    }
+}
+
+// shadowWrite records the outcome of a write to the shadow store.
+func (s *dualStore) shadowWrite(op, id string, err error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.stats.Writes++
+    if err != nil {
+        s.stats.ShadowWriteErrors++
+        s.mismatch(op, id, err.Error())
+    }
+}
+
+func (s *dualStore) Get(id string) (Book, bool, error) {
+    return s.GetContext(context.Background(), id)
+}
+
+// GetContext is Get, abandoned when ctx is done.
+func (s *dualStore) GetContext(ctx context.Context, id string) (Book, bool, error) {
+    book, ok, err := getWithContext(ctx, s.primary, id)
+    if err != nil || !shadowCompareReads {
+        return book, ok, err
+    }
+    shadowBook, shadowOK, shadowErr := getWithContext(ctx, s.shadow, id)
+    if ctx.Err() != nil {
+        return book, ok, nil // The shadow read was cut short, which says nothing about the shadow.
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.stats.ComparedReads++
+    switch {
+    case shadowErr != nil:
+        s.stats.ReadMismatches++
+        s.mismatch("get", id, shadowErr.Error())
+    case ok != shadowOK || !reflect.DeepEqual(book, shadowBook):
+        s.stats.ReadMismatches++
+        s.mismatch("get", id, "shadow returned a different book")
+    }
+    return book, ok, nil
+}
+
+func (s *dualStore) List() ([]Book, error) {
+    return s.primary.List()
+}
+
+// ListContext is List, abandoned when ctx is done.
+func (s *dualStore) ListContext(ctx context.Context) ([]Book, error) {
+    return listWithContext(ctx, s.primary)
+}
+
+// Create and Update upsert into the shadow, which may not have been
+// backfilled yet.
+func (s *dualStore) Create(book Book) error {
+    if err := s.primary.Create(book); err != nil {
+        return err
+    }
+    s.shadowWrite("create", book.ID, upsert(s.shadow, book))
+    return nil
+}
+
+func (s *dualStore) Update(book Book) error {
+    if err := s.primary.Update(book); err != nil {
+        return err
+    }
+    s.shadowWrite("update", book.ID, upsert(s.shadow, book))
+    return nil
+}
+
+func (s *dualStore) Delete(id string) error {
+    if err := s.primary.Delete(id); err != nil {
+        return err
+    }
+    s.shadowWrite("delete", id, s.shadow.Delete(id))
+    return nil
+}
+
+// upsert creates or updates a book, whichever the store needs.
+func upsert(st BookStore, book Book) error {
+    _, exists, err := st.Get(book.ID)
+    if err != nil {
+        return err
+    }
+    if exists {
+        return st.Update(book)
+    }
+    return st.Create(book)
+}
+
+// compare checks every book in both stores. Callers hold mux.
+func (s *dualStore) compare() (*Consistency, error) {
+    primary, err := s.primary.List()
+    if err != nil {
+        return nil, err
+    }
+    shadow, err := s.shadow.List()
+    if err != nil {
+        return nil, err
+    }
+    c := &Consistency{PrimaryBooks: len(primary), ShadowBooks: len(shadow), Missing: []string{}, Extra: []string{}, Different: []string{}}
+    inShadow := make(map[string]Book, len(shadow))
+    for _, book := range shadow {
+        inShadow[book.ID] = book
+    }
+    for _, book := range primary {
+        other, ok := inShadow[book.ID]
+        switch {
+        case !ok:
+            c.Missing = append(c.Missing, book.ID)
+        case !reflect.DeepEqual(book, other):
+            c.Different = append(c.Different, book.ID)
+        }
+        delete(inShadow, book.ID)
+    }
+    for _, book := range shadow { // Keep the store's order.
+        if _, ok := inShadow[book.ID]; ok {
+            c.Extra = append(c.Extra, book.ID)
+        }
+    }
+    c.Consistent = len(c.Missing)+len(c.Extra)+len(c.Different) == 0
+    return c, nil
+}
+
+// backfill copies every primary book into the shadow and removes books the
+// primary doesn't have. Callers hold mux.
+func (s *dualStore) backfill() (int, error) {
+    c, err := s.compare()
+    if err != nil {
+        return 0, err
+    }
+    n := 0
+    for _, id := range append(c.Missing, c.Different...) {
+        book, ok, err := s.primary.Get(id)
+        if err != nil {
+            return n, err
+        }
+        if !ok {
+            continue
+        }
+        if err := upsert(s.shadow, book); err != nil {
+            return n, err
+        }
+        n++
+    }
+    for _, id := range c.Extra {
+        if err := s.shadow.Delete(id); err != nil {
+            return n, err
+        }
+    }
+    return n, nil
+}
+
+// handleMigration handles requests for the /admin/migration route. GET
+// reports on the dual-write migration, comparing the full contents of both
+// stores; POST first backfills the shadow from the primary. Both need the
+// admin key.
+func handleMigration(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" && r.Method != "POST" {
+        methodNotAllowed(w, r)
+        return
+    }
+    if !isAdmin(r) {
+        writeError(w, "admin_required", "the migration report requires the admin key")
+        return
+    }
+    report := MigrationReport{Primary: storeDriver, CompareReads: shadowCompareReads}
+    dual, ok := store.(*dualStore)
+    if !ok {
+        if r.Method == "POST" {
+            writeError(w, "validation_failed", "no shadow store is configured; set STORE_SHADOW")
+            return
+        }
+        json.NewEncoder(w).Encode(report)
+        return
+    }
+    report.Enabled, report.Shadow = true, shadowDriver
+
+    mux.Lock() // Hold off writes so the stores are compared at one instant.
+    var err error
+    if r.Method == "POST" {
+        report.Backfilled, err = dual.backfill()
+    }
+    if err == nil {
+        report.Consistency, err = dual.compare()
+    }
+    mux.Unlock()
+    if err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    dual.mu.Lock()
+    stats := dual.stats
+    stats.Recent = append([]Mismatch{}, dual.stats.Recent...)
+    dual.mu.Unlock()
+    report.Stats = &stats
+    json.NewEncoder(w).Encode(report)
+}
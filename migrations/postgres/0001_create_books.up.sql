@@ -0,0 +1,5 @@
+-- Books are stored as JSON so new fields don't need a migration.
+CREATE TABLE IF NOT EXISTS books (
+    id   text PRIMARY KEY,
+    data jsonb NOT NULL
+);
@@ -0,0 +1,475 @@
+package main
+
+import (
+    "encoding/json"
+    "encoding/xml"
+    "errors"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// OPDS media types.
+const (
+    opdsNavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+    opdsAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+    opdsSearchType      = "application/opensearchdescription+xml"
+    opds2Type           = "application/opds+json"
+)
+
+// OPDS 1.2 feeds are Atom documents.
+type atomFeed struct {
+    XMLName      xml.Name    `xml:"feed"`
+    Xmlns        string      `xml:"xmlns,attr"`
+    XmlnsDC      string      `xml:"xmlns:dc,attr"`
+    XmlnsOS      string      `xml:"xmlns:opensearch,attr"`
+    ID           string      `xml:"id"`
+    Title        string      `xml:"title"`
+    Updated      string      `xml:"updated"`
+    Author       atomAuthor  `xml:"author"`
+    Links        []atomLink  `xml:"link"`
+    TotalResults int         `xml:"opensearch:totalResults,omitempty"`
+    ItemsPerPage int         `xml:"opensearch:itemsPerPage,omitempty"`
+    Entries      []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+    Name string `xml:"name"`
+}
+
+type atomLink struct {
+    Rel   string `xml:"rel,attr"`
+    Href  string `xml:"href,attr"`
+    Type  string `xml:"type,attr"`
+    Title string `xml:"title,attr,omitempty"`
+}
+
+type atomEntry struct {
+    ID       string      `xml:"id"`
+    Title    string      `xml:"title"`
+    Author   *atomAuthor `xml:"author,omitempty"`
+    Updated  string      `xml:"updated"`
+    Language string      `xml:"dc:language,omitempty"`
+    Issued   string      `xml:"dc:issued,omitempty"` // Publication year.
+    Summary  string      `xml:"summary,omitempty"`
+    Content  string      `xml:"content,omitempty"` // Describes a navigation entry.
+    Links    []atomLink  `xml:"link"`
+}
+
+// OPDS 2.0 feeds are JSON.
+type opds2Feed struct {
+    Metadata     opds2FeedMetadata  `json:"metadata"`
+    Links        []opds2Link        `json:"links"`
+    Navigation   []opds2Link        `json:"navigation,omitempty"`
+    Publications []opds2Publication `json:"publications,omitempty"`
+}
+
+type opds2FeedMetadata struct {
+    Title         string `json:"title"`
+    NumberOfItems int    `json:"numberOfItems,omitempty"`
+    ItemsPerPage  int    `json:"itemsPerPage,omitempty"`
+    CurrentPage   int    `json:"currentPage,omitempty"`
+}
+
+type opds2Link struct {
+    Rel       string `json:"rel,omitempty"`
+    Href      string `json:"href"`
+    Type      string `json:"type"`
+    Title     string `json:"title,omitempty"`
+    Templated bool   `json:"templated,omitempty"`
+}
+
+type opds2Publication struct {
+    Metadata opds2Metadata `json:"metadata"`
+    Links    []opds2Link   `json:"links"`
+}
+
+type opds2Metadata struct {
+    Type        string `json:"@type"`
+    Identifier  string `json:"identifier"`
+    Title       string `json:"title"`
+    Author      string `json:"author,omitempty"`
+    Language    string `json:"language,omitempty"`
+    Description string `json:"description,omitempty"`
+    Published   string `json:"published,omitempty"` // Publication year.
+    Modified    string `json:"modified,omitempty"`
+}
+
+// errInvalidPage rejects a ?page= that isn't a positive integer.
+var errInvalidPage = errors.New("page must be a positive integer")
+
+// opdsPage is one page of books for an acquisition feed.
+type opdsPage struct {
+    Books           []Book
+    Page, LastPage  int
+    Total, PerPage  int
+    Query, Language string
+}
+
+// withOPDSAuth lets e-reader apps, which can't set X-API-Key, sign in with
+// HTTP basic auth using the API key as the password. Failures ask for
+// credentials so readers show a login prompt.
+func withOPDSAuth(next http.HandlerFunc) http.HandlerFunc {
+    auth := authenticate(next)
+    return func(w http.ResponseWriter, r *http.Request) {
+        if _, key, ok := r.BasicAuth(); ok && r.Header.Get("X-API-Key") == "" {
+            r.Header.Set("X-API-Key", key)
+        }
+        if !validAPIKey(r.Header.Get("X-API-Key")) {
+            w.Header().Set("WWW-Authenticate", `Basic realm="library"`)
+        }
+        auth(w, r)
+    }
+}
+
+// matchesQuery reports whether a search term appears in a book's title,
+// description (in any language) or author.
+func matchesQuery(book Book, q string) bool {
+    q = strings.ToLower(q)
+    texts := []string{book.Title, book.Description, book.Author}
+    for _, m := range []map[string]string{book.Titles, book.Descriptions} {
+        for _, t := range m {
+            texts = append(texts, t)
+        }
+    }
+    for _, t := range texts {
+        if strings.Contains(strings.ToLower(t), q) {
+            return true
+        }
+    }
+    return false
+}
+
+// loadOPDSPage reads ?q=, ?language= and ?page= and returns that page of
+// matching books. Feeds are always paged, as readers follow next links.
+func loadOPDSPage(r *http.Request) (opdsPage, error) {
+    p := opdsPage{Page: 1, PerPage: defaultPerPage, Query: r.URL.Query().Get("q"), Language: r.URL.Query().Get("language")}
+    if v := r.URL.Query().Get("page"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 {
+            return p, errInvalidPage
+        }
+        p.Page = n
+    }
+    mux.RLock()
+    all, err := listWithContext(r.Context(), store)
+    mux.RUnlock()
+    if err != nil {
+        return p, err
+    }
+    for _, book := range publishedOnly(all) { // E-reader feeds only ever show the public catalog.
+        if p.Language != "" && !strings.EqualFold(book.Language, p.Language) {
+            continue
+        }
+        if p.Query != "" && !matchesQuery(book, p.Query) {
+            continue
+        }
+        p.Books = append(p.Books, book)
+    }
+    if p.Query != "" && p.Page == 1 {
+        incrementCounter(CounterSearches, "") // Later pages are the same search.
+    }
+    p.Total = len(p.Books)
+    p.LastPage = max(1, (p.Total+p.PerPage-1)/p.PerPage)
+    start := min((p.Page-1)*p.PerPage, p.Total)
+    p.Books = p.Books[start:min(start+p.PerPage, p.Total)]
+    return p, nil
+}
+
+// pageHref links to another page of the same feed, keeping the filters.
+func (p opdsPage) pageHref(base string, page int) string {
+    q := url.Values{}
+    if p.Query != "" {
+        q.Set("q", p.Query)
+    }
+    if p.Language != "" {
+        q.Set("language", p.Language)
+    }
+    if page > 1 {
+        q.Set("page", strconv.Itoa(page))
+    }
+    if len(q) == 0 {
+        return base
+    }
+    return base + "?" + q.Encode()
+}
+
+// pageLinks returns the self, first, previous, next and last links of a page
+// as (rel, href) pairs.
+func (p opdsPage) pageLinks(base string) [][2]string {
+    links := [][2]string{{"self", p.pageHref(base, p.Page)}, {"first", p.pageHref(base, 1)}}
+    if p.Page > 1 {
+        links = append(links, [2]string{"previous", p.pageHref(base, min(p.Page-1, p.LastPage))})
+    }
+    if p.Page < p.LastPage {
+        links = append(links, [2]string{"next", p.pageHref(base, p.Page+1)})
+    }
+    return append(links, [2]string{"last", p.pageHref(base, p.LastPage)})
+}
+
+// catalogLanguages lists the original languages of the books in the catalog.
+func catalogLanguages() ([]string, error) {
+    mux.RLock()
+    all, err := store.List()
+    mux.RUnlock()
+    if err != nil {
+        return nil, err
+    }
+    seen := make(map[string]bool)
+    for _, book := range publishedOnly(all) {
+        if book.Language != "" {
+            seen[strings.ToLower(book.Language)] = true
+        }
+    }
+    return sortedKeys(seen), nil
+}
+
+// feedUpdated is the updated time of a feed: the newest of its books.
+func feedUpdated(bks []Book) time.Time {
+    var t time.Time
+    for _, book := range bks {
+        if m := lastModified(book.ID); m.After(t) {
+            t = m
+        }
+    }
+    if t.IsZero() {
+        t = clock.Now().UTC()
+    }
+    return t
+}
+
+// opdsAcquisition is the link relation for downloading a book's file.
+const opdsAcquisition = "http://opds-spec.org/acquisition"
+
+// atomAcquisitionLinks links to each of a book's e-book files.
+func atomAcquisitionLinks(bookID string) []atomLink {
+    var links []atomLink
+    for _, f := range bookFiles(bookID) {
+        links = append(links, atomLink{Rel: opdsAcquisition, Href: "/opds/download/" + f.ID, Type: f.MediaType})
+    }
+    return links
+}
+
+// opds2AcquisitionLinks is atomAcquisitionLinks for OPDS 2.0.
+func opds2AcquisitionLinks(bookID string) []opds2Link {
+    var links []opds2Link
+    for _, f := range bookFiles(bookID) {
+        links = append(links, opds2Link{Rel: opdsAcquisition, Href: "/opds/download/" + f.ID, Type: f.MediaType})
+    }
+    return links
+}
+
+// publicationYear formats a book's publication year for a feed, or "" if unknown.
+func publicationYear(book Book) string {
+    if book.PublicationYear == 0 {
+        return ""
+    }
+    return strconv.Itoa(book.PublicationYear)
+}
+
+// bookURN is the permanent identifier of a book in feeds.
+func bookURN(id string) string {
+    return "urn:library:book:" + id
+}
+
+// writeAtom sends an OPDS 1.2 feed.
+func writeAtom(w http.ResponseWriter, mediaType string, feed atomFeed) {
+    feed.Xmlns = "http://www.w3.org/2005/Atom"
+    feed.XmlnsDC = "http://purl.org/dc/terms/"
+    feed.XmlnsOS = "http://a9.com/-/spec/opensearch/1.1/"
+    feed.Author = atomAuthor{Name: "Library"}
+    w.Header().Set("Content-Type", mediaType)
+    w.Write([]byte(xml.Header))
+    enc := xml.NewEncoder(w)
+    enc.Indent("", "  ")
+    enc.Encode(feed)
+}
+
+// handleOPDS handles GET /opds, the OPDS 1.2 navigation feed: all books, then
+// books by original language.
+func handleOPDS(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    langs, err := catalogLanguages()
+    if err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    now := clock.Now().UTC().Format(time.RFC3339)
+    feed := atomFeed{
+        ID:      "urn:library:opds",
+        Title:   "Library catalog",
+        Updated: now,
+        Links: []atomLink{
+            {Rel: "self", Href: "/opds", Type: opdsNavigationType},
+            {Rel: "start", Href: "/opds", Type: opdsNavigationType},
+            {Rel: "search", Href: "/opds/search", Type: opdsSearchType},
+        },
+        Entries: []atomEntry{{
+            ID: "urn:library:opds:books", Title: "All books", Updated: now, Content: "Every book in the catalog",
+            Links: []atomLink{{Rel: "subsection", Href: "/opds/books", Type: opdsAcquisitionType}},
+        }},
+    }
+    for _, lang := range langs {
+        feed.Entries = append(feed.Entries, atomEntry{
+            ID: "urn:library:opds:books:" + lang, Title: "Books in " + lang, Updated: now, Content: "Books originally in " + lang,
+            Links: []atomLink{{Rel: "subsection", Href: "/opds/books?language=" + url.QueryEscape(lang), Type: opdsAcquisitionType}},
+        })
+    }
+    writeAtom(w, opdsNavigationType, feed)
+}
+
+// handleOPDSBooks handles GET /opds/books, the OPDS 1.2 acquisition feed,
+// paged with ?page= and filtered with ?q= and ?language=.
+func handleOPDSBooks(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    p, err := loadOPDSPage(r)
+    if err == errInvalidPage {
+        writeError(w, "invalid_query", err.Error())
+        return
+    } else if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    feed := atomFeed{
+        ID:           "urn:library:opds:books",
+        Title:        "All books",
+        Updated:      feedUpdated(p.Books).Format(time.RFC3339),
+        TotalResults: p.Total,
+        ItemsPerPage: p.PerPage,
+        Links: []atomLink{
+            {Rel: "start", Href: "/opds", Type: opdsNavigationType},
+            {Rel: "search", Href: "/opds/search", Type: opdsSearchType},
+        },
+        Entries: []atomEntry{},
+    }
+    if p.Query != "" {
+        feed.Title = "Search results for " + p.Query
+    }
+    for _, l := range p.pageLinks("/opds/books") {
+        feed.Links = append(feed.Links, atomLink{Rel: l[0], Href: l[1], Type: opdsAcquisitionType})
+    }
+    langs := acceptedLanguages(r)
+    for _, book := range p.Books {
+        l := localize(book, langs) // Readers send Accept-Language; show titles in it where we can.
+        entry := atomEntry{
+            ID:       bookURN(book.ID),
+            Title:    l.Title,
+            Updated:  feedUpdated([]Book{book}).Format(time.RFC3339),
+            Language: book.Language,
+            Issued:   publicationYear(book),
+            Summary:  l.Description,
+            Links:    append([]atomLink{{Rel: "alternate", Href: "/book/" + book.ID, Type: "application/json"}}, atomAcquisitionLinks(book.ID)...),
+        }
+        if book.Author != "" {
+            entry.Author = &atomAuthor{Name: book.Author}
+        }
+        feed.Entries = append(feed.Entries, entry)
+    }
+    writeAtom(w, opdsAcquisitionType, feed)
+}
+
+// handleOPDSSearch handles GET /opds/search, the OpenSearch description that
+// tells readers how to search the acquisition feed.
+func handleOPDSSearch(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    w.Header().Set("Content-Type", opdsSearchType)
+    w.Write([]byte(xml.Header + `<OpenSearchDescription xmlns="http://a9.com/-/spec/opensearch/1.1/">
+  <ShortName>Library</ShortName>
+  <Description>Search the library catalog</Description>
+  <Url type="` + xmlEscape(opdsAcquisitionType) + `" template="/opds/books?q={searchTerms}"/>
+</OpenSearchDescription>
+`))
+}
+
+// xmlEscape escapes text for use in an XML attribute.
+func xmlEscape(s string) string {
+    var b strings.Builder
+    xml.EscapeText(&b, []byte(s))
+    return b.String()
+}
+
+// handleOPDS2 handles GET /opds/v2, the OPDS 2.0 navigation feed.
+func handleOPDS2(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    langs, err := catalogLanguages()
+    if err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    feed := opds2Feed{
+        Metadata: opds2FeedMetadata{Title: "Library catalog"},
+        Links: []opds2Link{
+            {Rel: "self", Href: "/opds/v2", Type: opds2Type},
+            {Rel: "search", Href: "/opds/v2/books{?query}", Type: opds2Type, Templated: true},
+        },
+        Navigation: []opds2Link{{Rel: "subsection", Href: "/opds/v2/books", Type: opds2Type, Title: "All books"}},
+    }
+    for _, lang := range langs {
+        feed.Navigation = append(feed.Navigation, opds2Link{Rel: "subsection", Href: "/opds/v2/books?language=" + url.QueryEscape(lang), Type: opds2Type, Title: "Books in " + lang})
+    }
+    w.Header().Set("Content-Type", opds2Type)
+    json.NewEncoder(w).Encode(feed)
+}
+
+// handleOPDS2Books handles GET /opds/v2/books, the OPDS 2.0 publications
+// feed, with the same paging and filters as the 1.2 feed. OPDS 2.0 calls the
+// search parameter query rather than q.
+func handleOPDS2Books(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    if q := r.URL.Query(); q.Get("query") != "" {
+        q.Set("q", q.Get("query"))
+        r.URL.RawQuery = q.Encode()
+    }
+    p, err := loadOPDSPage(r)
+    if err == errInvalidPage {
+        writeError(w, "invalid_query", err.Error())
+        return
+    } else if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    feed := opds2Feed{
+        Metadata:     opds2FeedMetadata{Title: "All books", NumberOfItems: p.Total, ItemsPerPage: p.PerPage, CurrentPage: p.Page},
+        Links:        []opds2Link{{Rel: "start", Href: "/opds/v2", Type: opds2Type}},
+        Publications: []opds2Publication{},
+    }
+    for _, l := range p.pageLinks("/opds/v2/books") {
+        feed.Links = append(feed.Links, opds2Link{Rel: l[0], Href: l[1], Type: opds2Type})
+    }
+    langs := acceptedLanguages(r)
+    for _, book := range p.Books {
+        l := localize(book, langs)
+        feed.Publications = append(feed.Publications, opds2Publication{
+            Metadata: opds2Metadata{
+                Type:        "http://schema.org/Book",
+                Identifier:  bookURN(book.ID),
+                Title:       l.Title,
+                Author:      book.Author,
+                Language:    book.Language,
+                Description: l.Description,
+                Published:   publicationYear(book),
+                Modified:    feedUpdated([]Book{book}).Format(time.RFC3339),
+            },
+            Links: append([]opds2Link{{Rel: "self", Href: "/book/" + book.ID, Type: "application/json"}}, opds2AcquisitionLinks(book.ID)...),
+        })
+    }
+    w.Header().Set("Content-Type", opds2Type)
+    json.NewEncoder(w).Encode(feed)
+}
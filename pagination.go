@@ -0,0 +1,138 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+const (
+    defaultPerPage = 20  // Page size when ?page= is given without ?per_page=.
+    maxPerPage     = 100 // Largest page a client may ask for.
+)
+
+// How an unpaginated list longer than maxUnpaginated is answered, as set in
+// OVERSIZED_LISTS.
+const (
+    OversizedReject   = "reject"   // 400 result_too_large, pointing at pagination and the export.
+    OversizedPaginate = "paginate" // The first page of maxPerPage items, as if ?page=1 had been sent.
+)
+
+var (
+    // maxUnpaginated is the most items a list returns without ?page= or
+    // ?per_page=, so one careless request can't serialize the whole catalog.
+    // 0 removes the cap.
+    maxUnpaginated = envInt("MAX_UNPAGINATED_RESULTS", 1000)
+    oversizedLists = envString("OVERSIZED_LISTS", OversizedReject)
+)
+
+// exportRoutes maps list routes to the route that streams the same records,
+// for the hint given when a list is too long.
+var exportRoutes = map[string]string{"/books": "/books/export"}
+
+// tooLargeError is returned by paginate when an unpaginated list is over the cap.
+type tooLargeError struct {
+    Total     int    `json:"total"`
+    Limit     int    `json:"limit"`
+    FirstPage string `json:"first_page"`       // The same request, paginated.
+    Export    string `json:"export,omitempty"` // Where to stream every record instead.
+}
+
+func (e tooLargeError) Error() string {
+    msg := fmt.Sprintf("%d results are more than the %d an unpaginated list may return; pass page and per_page", e.Total, e.Limit)
+    if e.Export != "" {
+        msg += ", or stream them all from " + e.Export
+    }
+    return msg
+}
+
+// writePageError reports an error from paginate.
+func writePageError(w http.ResponseWriter, err error) {
+    if e, ok := err.(tooLargeError); ok {
+        writeErrorDetails(w, "result_too_large", err.Error(), e)
+        return
+    }
+    writeError(w, "invalid_query", err.Error())
+}
+
+// paginate applies ?page= and ?per_page= to a list of total items and returns
+// the bounds of the requested page. Lists are only paginated when the client
+// asks for it, or when an unpaginated list would be longer than
+// maxUnpaginated and OVERSIZED_LISTS is paginate; then the response gets an
+// X-Total-Count header and an RFC 5988 Link header with first, prev, next
+// and last relations so generic HTTP clients can walk the pages without
+// knowing our query parameters.
+func paginate(w http.ResponseWriter, r *http.Request, total int) (start, end int, err error) {
+    q := r.URL.Query()
+    page, perPage := 1, defaultPerPage
+    if q.Get("page") == "" && q.Get("per_page") == "" {
+        if maxUnpaginated <= 0 || total <= maxUnpaginated {
+            return 0, total, nil // Unpaginated request: return everything.
+        }
+        if oversizedLists != OversizedPaginate {
+            return 0, 0, tooLargeError{Total: total, Limit: maxUnpaginated, FirstPage: pageLinkURL(r, 1, maxPerPage), Export: exportRoutes[r.URL.Path]}
+        }
+        perPage = maxPerPage // Fall back to the first page, as large as pages get.
+    }
+    if v := q.Get("page"); v != "" {
+        if page, err = strconv.Atoi(v); err != nil || page < 1 {
+            return 0, 0, errors.New("page must be a positive integer")
+        }
+    }
+    if v := q.Get("per_page"); v != "" {
+        if perPage, err = strconv.Atoi(v); err != nil || perPage < 1 || perPage > maxPerPage {
+            return 0, 0, fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+        }
+    }
+
+    lastPage := (total + perPage - 1) / perPage
+    if lastPage == 0 {
+        lastPage = 1 // An empty list still has one (empty) page.
+    }
+    links := []string{pageLink(r, 1, perPage, "first")}
+    if page > 1 {
+        links = append(links, pageLink(r, min(page-1, lastPage), perPage, "prev"))
+    }
+    if page < lastPage {
+        links = append(links, pageLink(r, page+1, perPage, "next"))
+    }
+    links = append(links, pageLink(r, lastPage, perPage, "last"))
+    if export, ok := exportRoutes[r.URL.Path]; ok {
+        links = append(links, fmt.Sprintf("<%s>; rel=%q", export, "alternate")) // Everything in one streamed response.
+    }
+    w.Header().Set("Link", strings.Join(links, ", "))
+    w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+    start = min((page-1)*perPage, total)
+    end = min(start+perPage, total)
+    return start, end, nil
+}
+
+// paginateByDefault is paginate for lists that are always paginated: without
+// ?page= or ?per_page= the client gets the first page.
+func paginateByDefault(w http.ResponseWriter, r *http.Request, total int) (start, end int, err error) {
+    q := r.URL.Query()
+    if q.Get("page") == "" && q.Get("per_page") == "" {
+        q.Set("page", "1")
+        r = r.Clone(r.Context())
+        r.URL.RawQuery = q.Encode()
+    }
+    return paginate(w, r, total)
+}
+
+// pageLink formats one Link header entry pointing at the given page of the current request.
+func pageLink(r *http.Request, page, perPage int, rel string) string {
+    return fmt.Sprintf("<%s>; rel=%q", pageLinkURL(r, page, perPage), rel)
+}
+
+// pageLinkURL is the current request's URL with the given page.
+func pageLinkURL(r *http.Request, page, perPage int) string {
+    u := *r.URL
+    q := u.Query()
+    q.Set("page", strconv.Itoa(page))
+    q.Set("per_page", strconv.Itoa(perPage))
+    u.RawQuery = q.Encode()
+    return u.RequestURI()
+}
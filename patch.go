@@ -0,0 +1,390 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "mime"
+    "net/http"
+    "reflect"
+    "strconv"
+    "strings"
+)
+
+// Media types PATCH accepts.
+const (
+    jsonPatchType  = "application/json-patch+json"  // RFC 6902 JSON Patch: a list of operations.
+    mergePatchType = "application/merge-patch+json" // RFC 7386 JSON Merge Patch: the fields to change.
+)
+
+// PatchOp is one operation of an RFC 6902 JSON Patch.
+type PatchOp struct {
+    Op    string          `json:"op"`             // add, remove, replace, move, copy or test.
+    Path  string          `json:"path"`           // RFC 6901 JSON Pointer to the target.
+    From  string          `json:"from,omitempty"` // Source pointer for move and copy.
+    Value json.RawMessage `json:"value,omitempty"`
+}
+
+// errPatchTest is wrapped by the error of a test operation that did not
+// hold, so it can be told apart from a malformed patch.
+var errPatchTest = errors.New("test failed")
+
+// splitPointer splits an RFC 6901 JSON Pointer into its unescaped tokens.
+func splitPointer(ptr string) ([]string, error) {
+    if ptr == "" {
+        return nil, nil // The whole document.
+    }
+    if !strings.HasPrefix(ptr, "/") {
+        return nil, fmt.Errorf("path %q must start with /", ptr)
+    }
+    tokens := strings.Split(ptr[1:], "/")
+    for i, t := range tokens {
+        tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+    }
+    return tokens, nil
+}
+
+// arrayIndex parses a token as an index into an array of length n. "-",
+// the position after the last element, is allowed when end is true.
+func arrayIndex(token string, n int, end bool) (int, error) {
+    if token == "-" && end {
+        return n, nil
+    }
+    i, err := strconv.Atoi(token)
+    if err != nil || i < 0 || i > n || (i == n && !end) || (len(token) > 1 && token[0] == '0') {
+        return 0, fmt.Errorf("%q is not an index into an array of %d elements", token, n)
+    }
+    return i, nil
+}
+
+// pointerGet returns the value a pointer refers to.
+func pointerGet(doc interface{}, ptr string) (interface{}, error) {
+    tokens, err := splitPointer(ptr)
+    if err != nil {
+        return nil, err
+    }
+    cur := doc
+    for _, t := range tokens {
+        switch node := cur.(type) {
+        case map[string]interface{}:
+            v, ok := node[t]
+            if !ok {
+                return nil, fmt.Errorf("path %q does not exist", ptr)
+            }
+            cur = v
+        case []interface{}:
+            i, err := arrayIndex(t, len(node), false)
+            if err != nil {
+                return nil, err
+            }
+            cur = node[i]
+        default:
+            return nil, fmt.Errorf("path %q does not exist", ptr)
+        }
+    }
+    return cur, nil
+}
+
+// pointerUpdate applies fn to the container holding the last token of a
+// pointer, replacing the container with what fn returns. Arrays can't grow
+// or shrink in place, so the new slice is stored in their parent.
+func pointerUpdate(doc interface{}, ptr string, fn func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+    tokens, err := splitPointer(ptr)
+    if err != nil {
+        return nil, err
+    }
+    if len(tokens) == 0 {
+        return nil, errors.New("the whole book can't be removed")
+    }
+    var walk func(node interface{}, tokens []string) (interface{}, error)
+    walk = func(node interface{}, tokens []string) (interface{}, error) {
+        if len(tokens) == 1 {
+            return fn(node, tokens[0])
+        }
+        switch n := node.(type) {
+        case map[string]interface{}:
+            child, ok := n[tokens[0]]
+            if !ok {
+                return nil, fmt.Errorf("path %q does not exist", ptr)
+            }
+            updated, err := walk(child, tokens[1:])
+            if err != nil {
+                return nil, err
+            }
+            n[tokens[0]] = updated
+            return n, nil
+        case []interface{}:
+            i, err := arrayIndex(tokens[0], len(n), false)
+            if err != nil {
+                return nil, err
+            }
+            updated, err := walk(n[i], tokens[1:])
+            if err != nil {
+                return nil, err
+            }
+            n[i] = updated
+            return n, nil
+        }
+        return nil, fmt.Errorf("path %q does not exist", ptr)
+    }
+    return walk(doc, tokens)
+}
+
+// pointerAdd adds a value at a pointer: it sets an object member, or inserts
+// into an array.
+func pointerAdd(doc interface{}, ptr string, value interface{}) (interface{}, error) {
+    if ptr == "" {
+        return value, nil
+    }
+    return pointerUpdate(doc, ptr, func(parent interface{}, token string) (interface{}, error) {
+        switch p := parent.(type) {
+        case map[string]interface{}:
+            p[token] = value
+            return p, nil
+        case []interface{}:
+            i, err := arrayIndex(token, len(p), true)
+            if err != nil {
+                return nil, err
+            }
+            p = append(p, nil)
+            copy(p[i+1:], p[i:])
+            p[i] = value
+            return p, nil
+        }
+        return nil, fmt.Errorf("path %q does not exist", ptr)
+    })
+}
+
+// pointerRemove removes the value at a pointer.
+func pointerRemove(doc interface{}, ptr string) (interface{}, error) {
+    return pointerUpdate(doc, ptr, func(parent interface{}, token string) (interface{}, error) {
+        switch p := parent.(type) {
+        case map[string]interface{}:
+            if _, ok := p[token]; !ok {
+                return nil, fmt.Errorf("path %q does not exist", ptr)
+            }
+            delete(p, token)
+            return p, nil
+        case []interface{}:
+            i, err := arrayIndex(token, len(p), false)
+            if err != nil {
+                return nil, err
+            }
+            return append(p[:i], p[i+1:]...), nil
+        }
+        return nil, fmt.Errorf("path %q does not exist", ptr)
+    })
+}
+
+// applyJSONPatch applies the operations in order to a decoded JSON document,
+// stopping at the first that fails. The document may be changed in place
+// either way, so callers pass a copy they can throw away.
+func applyJSONPatch(doc interface{}, ops []PatchOp) (interface{}, error) {
+    for i, op := range ops {
+        var value interface{}
+        if op.Op == "add" || op.Op == "replace" || op.Op == "test" {
+            if op.Value == nil {
+                return nil, fmt.Errorf("operation %d: %s needs a value", i, op.Op)
+            }
+            if err := json.Unmarshal(op.Value, &value); err != nil {
+                return nil, fmt.Errorf("operation %d: %v", i, err)
+            }
+        }
+        var err error
+        switch op.Op {
+        case "add":
+            doc, err = pointerAdd(doc, op.Path, value)
+        case "remove":
+            doc, err = pointerRemove(doc, op.Path)
+        case "replace":
+            switch _, err = pointerGet(doc, op.Path); {
+            case err != nil:
+            case op.Path == "":
+                doc = value
+            default:
+                if doc, err = pointerRemove(doc, op.Path); err == nil {
+                    doc, err = pointerAdd(doc, op.Path, value)
+                }
+            }
+        case "move":
+            if strings.HasPrefix(op.Path, op.From+"/") {
+                return nil, fmt.Errorf("operation %d: can't move %q into itself", i, op.From)
+            }
+            if value, err = pointerGet(doc, op.From); err == nil {
+                if doc, err = pointerRemove(doc, op.From); err == nil {
+                    doc, err = pointerAdd(doc, op.Path, value)
+                }
+            }
+        case "copy":
+            if value, err = pointerGet(doc, op.From); err == nil {
+                data, _ := json.Marshal(value) // A deep copy, so later operations on one don't change the other.
+                json.Unmarshal(data, &value)
+                doc, err = pointerAdd(doc, op.Path, value)
+            }
+        case "test":
+            var current interface{}
+            if current, err = pointerGet(doc, op.Path); err == nil && !reflect.DeepEqual(current, value) {
+                err = fmt.Errorf("%w: %s is not %s", errPatchTest, op.Path, op.Value)
+            }
+        default:
+            return nil, fmt.Errorf("operation %d: unknown op %q", i, op.Op)
+        }
+        if err != nil {
+            return nil, fmt.Errorf("operation %d: %w", i, err)
+        }
+    }
+    return doc, nil
+}
+
+// applyMergePatch applies an RFC 7386 merge patch to a decoded JSON document:
+// members of a patch object replace the target's, recursively for objects,
+// and explicit nulls remove them. Anything but an object replaces the target
+// outright, arrays included.
+func applyMergePatch(target, patch interface{}) interface{} {
+    p, ok := patch.(map[string]interface{})
+    if !ok {
+        return patch
+    }
+    t, ok := target.(map[string]interface{})
+    if !ok {
+        t = make(map[string]interface{})
+    }
+    for name, v := range p {
+        if v == nil {
+            delete(t, name) // Clears the field: the book gets its zero value.
+        } else {
+            t[name] = applyMergePatch(t[name], v)
+        }
+    }
+    return t
+}
+
+// jsonPatchVersion returns the version a JSON Patch was made against: the
+// value of an operation that tests or replaces /version.
+func jsonPatchVersion(ops []PatchOp) (int, bool) {
+    for _, op := range ops {
+        var version int
+        if op.Path == "/version" && (op.Op == "test" || op.Op == "replace") && json.Unmarshal(op.Value, &version) == nil {
+            return version, true
+        }
+    }
+    return 0, false
+}
+
+// mergePatchVersion returns the version a merge patch was made against: its
+// version member.
+func mergePatchVersion(patch interface{}) (int, bool) {
+    p, _ := patch.(map[string]interface{})
+    version, ok := p["version"].(float64)
+    if !ok || version != float64(int(version)) {
+        return 0, false
+    }
+    return int(version), true
+}
+
+// handleBookPatch handles PATCH /book/{id}: the body changes only the fields
+// it names. The book is read, patched, checked and written under the write
+// lock, so concurrent writes can't interleave with it and a failed patch
+// changes nothing. Like PUT, a patch must carry the version it was made
+// against. Other server-managed fields and the ID can't be patched.
+func handleBookPatch(w http.ResponseWriter, r *http.Request, id string) {
+    mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+    var apply func(doc interface{}) (interface{}, error)
+    var version int
+    var stated bool
+    switch mediaType {
+    case jsonPatchType:
+        var ops []PatchOp
+        if err := decodeJSON(r, &ops); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        version, stated = jsonPatchVersion(ops)
+        apply = func(doc interface{}) (interface{}, error) { return applyJSONPatch(doc, ops) }
+    case mergePatchType:
+        var patch interface{}
+        if err := decodeJSON(r, &patch); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        version, stated = mergePatchVersion(patch)
+        apply = func(doc interface{}) (interface{}, error) { return applyMergePatch(doc, patch), nil }
+    default:
+        writeError(w, "unsupported_media_type", "Content-Type must be "+jsonPatchType+" or "+mergePatchType)
+        return
+    }
+
+    mux.Lock()
+    defer mux.Unlock()
+    prev, exists, err := store.Get(id)
+    if err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    if !exists {
+        writeError(w, "book_not_found", "book "+id+" not found")
+        return
+    }
+    if !checkIfMatch(w, r, prev, exists) {
+        return // Only patch the version the client last saw.
+    }
+    if !stated || version != prev.Version {
+        writeVersionConflict(w, prev.Version) // The client patched a version that is no longer current, or didn't say which.
+        return
+    }
+    data, _ := json.Marshal(prev)
+    var before map[string]interface{}
+    var doc interface{}
+    json.Unmarshal(data, &before)
+    json.Unmarshal(data, &doc)
+    doc, err = apply(doc)
+    if errors.Is(err, errPatchTest) {
+        writeError(w, "patch_test_failed", err.Error())
+        return
+    } else if err != nil {
+        writeError(w, "invalid_patch", err.Error())
+        return
+    }
+    patched, ok := doc.(map[string]interface{})
+    if !ok {
+        writeError(w, "invalid_patch", "the patched book is not an object")
+        return
+    }
+    patched["version"] = before["version"] // Already checked; the write sets the next one.
+    for _, name := range append([]string{"id"}, serverManagedFields...) {
+        if !reflect.DeepEqual(patched[name], before[name]) {
+            writeError(w, "invalid_patch", name+" is set by the server and can't be patched")
+            return
+        }
+    }
+    data, _ = json.Marshal(patched)
+    if unknown := unknownFields(data, reflect.TypeOf(Book{})); len(unknown) > 0 { // A patch names its fields, so one the book lacks is a mistake.
+        writeError(w, "invalid_patch", unknownFieldsError(unknown).Error())
+        return
+    }
+    var book Book
+    if err := json.Unmarshal(data, &book); err != nil {
+        writeError(w, "invalid_patch", err.Error())
+        return
+    }
+    if err := checkBook(&book); err != nil {
+        writeError(w, "validation_failed", err.Error())
+        return
+    }
+    if err := checkWriteQuota(r.Header.Get("X-API-Key"), []Book{book}); err != nil {
+        writeError(w, "quota_exceeded", err.Error())
+        return
+    }
+    if isDryRun(r) {
+        writeDryRun(w, ChangeUpdate, &book, previousBook(prev, exists)) // Report the change without applying it.
+        return
+    }
+    stampBook(&book, prev, exists)
+    if err := store.Update(book); err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    recordChange(ChangeUpdate, id, &book)
+    w.Header().Set("ETag", bookETag(book))
+    json.NewEncoder(w).Encode(book)
+}
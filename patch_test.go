@@ -0,0 +1,174 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestApplyJSONPatch(t *testing.T) {
+    tests := []struct {
+        name     string
+        doc      string
+        ops      string
+        want     string // Patched document; empty when the patch must fail.
+        testFail bool   // Whether the failure must be a test op that didn't hold.
+    }{
+        {"add member", `{"a":1}`, `[{"op":"add","path":"/b","value":2}]`, `{"a":1,"b":2}`, false},
+        {"add replaces member", `{"a":1}`, `[{"op":"add","path":"/a","value":3}]`, `{"a":3}`, false},
+        {"add to array end", `{"a":[1,2]}`, `[{"op":"add","path":"/a/-","value":3}]`, `{"a":[1,2,3]}`, false},
+        {"insert into array", `{"a":[1,3]}`, `[{"op":"add","path":"/a/1","value":2}]`, `{"a":[1,2,3]}`, false},
+        {"add past array end", `{"a":[1]}`, `[{"op":"add","path":"/a/2","value":2}]`, "", false},
+        {"dash only for add", `{"a":[1]}`, `[{"op":"remove","path":"/a/-"}]`, "", false},
+        {"leading zero index", `{"a":[1,2]}`, `[{"op":"remove","path":"/a/01"}]`, "", false},
+        {"add needs parent", `{}`, `[{"op":"add","path":"/a/b","value":1}]`, "", false},
+        {"remove member", `{"a":1,"b":2}`, `[{"op":"remove","path":"/a"}]`, `{"b":2}`, false},
+        {"remove from array", `{"a":[1,2,3]}`, `[{"op":"remove","path":"/a/1"}]`, `{"a":[1,3]}`, false},
+        {"remove missing", `{}`, `[{"op":"remove","path":"/a"}]`, "", false},
+        {"remove whole document", `{}`, `[{"op":"remove","path":""}]`, "", false},
+        {"replace member", `{"a":1}`, `[{"op":"replace","path":"/a","value":"x"}]`, `{"a":"x"}`, false},
+        {"replace missing", `{}`, `[{"op":"replace","path":"/a","value":1}]`, "", false},
+        {"replace needs value", `{"a":1}`, `[{"op":"replace","path":"/a"}]`, "", false},
+        {"replace whole document", `{"a":1}`, `[{"op":"replace","path":"","value":{"b":2}}]`, `{"b":2}`, false},
+        {"move member", `{"a":1}`, `[{"op":"move","from":"/a","path":"/b"}]`, `{"b":1}`, false},
+        {"move within array", `{"a":[1,2,3]}`, `[{"op":"move","from":"/a/0","path":"/a/-"}]`, `{"a":[2,3,1]}`, false},
+        {"move into itself", `{"a":{"b":1}}`, `[{"op":"move","from":"/a","path":"/a/b"}]`, "", false},
+        {"move to sibling prefix", `{"a":1}`, `[{"op":"move","from":"/a","path":"/ab"}]`, `{"ab":1}`, false},
+        {"copy is deep", `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2}}`, false},
+        {"escaped pointer", `{"a/b":1,"m~n":2}`, `[{"op":"remove","path":"/a~1b"},{"op":"remove","path":"/m~0n"}]`, `{}`, false},
+        {"test holds", `{"a":[1,{"b":true}]}`, `[{"op":"test","path":"/a","value":[1,{"b":true}]}]`, `{"a":[1,{"b":true}]}`, false},
+        {"test fails", `{"a":1}`, `[{"op":"test","path":"/a","value":2}]`, "", true},
+        {"test number types", `{"a":1}`, `[{"op":"test","path":"/a","value":1.0}]`, `{"a":1}`, false},
+        {"failed test stops patch", `{"a":1}`, `[{"op":"test","path":"/a","value":2},{"op":"remove","path":"/a"}]`, "", true},
+        {"unknown op", `{}`, `[{"op":"frob","path":"/a"}]`, "", false},
+        {"path without slash", `{"a":1}`, `[{"op":"remove","path":"a"}]`, "", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var ops []PatchOp
+            if err := json.Unmarshal([]byte(tt.ops), &ops); err != nil {
+                t.Fatalf("bad ops in test table: %v", err)
+            }
+            got, err := applyJSONPatch(decode(t, tt.doc), ops)
+            if tt.want == "" {
+                if err == nil {
+                    t.Fatalf("applyJSONPatch succeeded with %v, want an error", got)
+                }
+                if errors.Is(err, errPatchTest) != tt.testFail {
+                    t.Errorf("errors.Is(err, errPatchTest) = %v, want %v (err: %v)", !tt.testFail, tt.testFail, err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("applyJSONPatch: %v", err)
+            }
+            if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+                t.Errorf("applyJSONPatch = %v, want %v", got, want)
+            }
+        })
+    }
+}
+
+// TestApplyMergePatch runs the examples from RFC 7386, appendix A.
+func TestApplyMergePatch(t *testing.T) {
+    tests := []struct {
+        target, patch, want string
+    }{
+        {`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+        {`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+        {`{"a":"b"}`, `{"a":null}`, `{}`},
+        {`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+        {`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+        {`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+        {`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+        {`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+        {`["a","b"]`, `["c","d"]`, `["c","d"]`},
+        {`{"a":"b"}`, `["c"]`, `["c"]`},
+        {`{"a":"foo"}`, `null`, `null`},
+        {`{"a":"foo"}`, `"bar"`, `"bar"`},
+        {`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+        {`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+        {`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+    }
+    for _, tt := range tests {
+        got := applyMergePatch(decode(t, tt.target), decode(t, tt.patch))
+        if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+            t.Errorf("applyMergePatch(%s, %s) = %v, want %v", tt.target, tt.patch, got, want)
+        }
+    }
+}
+
+func TestPatchVersion(t *testing.T) {
+    tests := []struct {
+        name, mediaType, body string
+        want                  int
+        stated                bool
+    }{
+        {"json patch test", jsonPatchType, `[{"op":"test","path":"/version","value":3}]`, 3, true},
+        {"json patch replace", jsonPatchType, `[{"op":"replace","path":"/version","value":4}]`, 4, true},
+        {"json patch add doesn't count", jsonPatchType, `[{"op":"add","path":"/version","value":4}]`, 0, false},
+        {"json patch silent", jsonPatchType, `[{"op":"replace","path":"/title","value":"x"}]`, 0, false},
+        {"json patch not a number", jsonPatchType, `[{"op":"test","path":"/version","value":"3"}]`, 0, false},
+        {"merge patch", mergePatchType, `{"version":2,"title":"x"}`, 2, true},
+        {"merge patch silent", mergePatchType, `{"title":"x"}`, 0, false},
+        {"merge patch fraction", mergePatchType, `{"version":2.5}`, 0, false},
+        {"merge patch null", mergePatchType, `{"version":null}`, 0, false},
+        {"merge patch not an object", mergePatchType, `[1]`, 0, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var got int
+            var stated bool
+            if tt.mediaType == jsonPatchType {
+                var ops []PatchOp
+                if err := json.Unmarshal([]byte(tt.body), &ops); err != nil {
+                    t.Fatalf("bad ops in test table: %v", err)
+                }
+                got, stated = jsonPatchVersion(ops)
+            } else {
+                got, stated = mergePatchVersion(decode(t, tt.body))
+            }
+            if got != tt.want || stated != tt.stated {
+                t.Errorf("version = %d, %v; want %d, %v", got, stated, tt.want, tt.stated)
+            }
+        })
+    }
+}
+
+func TestPatchRequiresVersion(t *testing.T) {
+    c := useTestSeams(t, time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC))
+    if w := serveBook("PUT", "/book/patch-1", `{"title":"Moby Dick","author":"Herman Melville"}`, nil); w.Code != http.StatusOK {
+        t.Fatalf("PUT = %d %s", w.Code, w.Body)
+    }
+    c.Sleep(time.Millisecond)
+
+    tests := []struct {
+        name, mediaType, body string
+        want                  int
+    }{
+        {"merge without a version", mergePatchType, `{"title":"Moby-Dick"}`, http.StatusConflict},
+        {"merge with a stale version", mergePatchType, `{"version":2,"title":"Moby-Dick"}`, http.StatusConflict},
+        {"json patch without a version", jsonPatchType, `[{"op":"replace","path":"/title","value":"Moby-Dick"}]`, http.StatusConflict},
+        {"json patch with a stale test", jsonPatchType, `[{"op":"test","path":"/version","value":0},{"op":"replace","path":"/title","value":"x"}]`, http.StatusConflict},
+        {"merge with the current version", mergePatchType, `{"version":1,"title":"Moby-Dick"}`, http.StatusOK},
+        {"json patch replacing the version", jsonPatchType, `[{"op":"replace","path":"/version","value":2},{"op":"replace","path":"/title","value":"Moby Dick"}]`, http.StatusOK},
+    }
+    for _, tt := range tests {
+        w := serveBook("PATCH", "/book/patch-1", tt.body, map[string]string{"Content-Type": tt.mediaType})
+        if w.Code != tt.want {
+            t.Fatalf("%s = %d, want %d (%s)", tt.name, w.Code, tt.want, w.Body)
+        }
+    }
+
+    var book Book
+    if err := json.Unmarshal(serveBook("GET", "/book/patch-1", "", nil).Body.Bytes(), &book); err != nil {
+        t.Fatal(err)
+    }
+    if book.Version != 3 || book.Title != "Moby Dick" || !book.UpdatedAt.Equal(c.Now()) {
+        t.Errorf("patched book = version %d, %q, updated %s; want version 3, \"Moby Dick\", updated %s",
+            book.Version, book.Title, book.UpdatedAt, c.Now())
+    }
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "os"
+    "path"
+    "strings"
+)
+
+// PolicyRule is one rule of an authorization policy. A rule matches a request
+// when every condition it sets matches; conditions left empty match anything.
+type PolicyRule struct {
+    Effect  string            `json:"effect"`            // allow or deny.
+    Keys    []string          `json:"keys,omitempty"`    // API keys the rule applies to.
+    Methods []string          `json:"methods,omitempty"` // HTTP methods, e.g. ["PUT", "DELETE"].
+    Paths   []string          `json:"paths,omitempty"`   // path.Match patterns, e.g. "/book/*".
+    Book    map[string]string `json:"book,omitempty"`    // Fields the book addressed by /book/{id} must have, e.g. {"title": "1984"}.
+}
+
+// Policy is the contents of POLICY_FILE: rules are tried in order and the
+// first match decides; requests no rule matches get Default.
+type Policy struct {
+    Default string       `json:"default"` // allow or deny; allow when empty.
+    Rules   []PolicyRule `json:"rules"`
+}
+
+// policy is loaded once at startup. Without POLICY_FILE every valid key may do
+// everything its key type allows, as before.
+var policy = loadPolicy(envString("POLICY_FILE", ""))
+
+// loadPolicy reads and checks a policy file. Unlike other settings a bad policy
+// stops the server: silently falling back to "allow everything" would open up
+// whatever the policy was meant to close.
+func loadPolicy(file string) *Policy {
+    if file == "" {
+        return nil
+    }
+    data, err := os.ReadFile(file)
+    if err != nil {
+        log.Fatalf("reading POLICY_FILE: %v", err)
+    }
+    var p Policy
+    if err := json.Unmarshal(data, &p); err != nil {
+        log.Fatalf("parsing POLICY_FILE: %v", err)
+    }
+    if p.Default == "" {
+        p.Default = "allow"
+    }
+    if p.Default != "allow" && p.Default != "deny" {
+        log.Fatalf("POLICY_FILE: default must be allow or deny")
+    }
+    for i, rule := range p.Rules {
+        if rule.Effect != "allow" && rule.Effect != "deny" {
+            log.Fatalf("POLICY_FILE: rule %d: effect must be allow or deny", i)
+        }
+        for _, pattern := range rule.Paths {
+            if _, err := path.Match(pattern, "/"); err != nil {
+                log.Fatalf("POLICY_FILE: rule %d: bad path pattern %q", i, pattern)
+            }
+        }
+    }
+    return &p
+}
+
+// policyAllows reports whether the policy lets the request through.
+func policyAllows(r *http.Request) bool {
+    if policy == nil {
+        return true
+    }
+    for _, rule := range policy.Rules {
+        if rule.matches(r) {
+            return rule.Effect == "allow"
+        }
+    }
+    return policy.Default == "allow"
+}
+
+// matches reports whether every condition of the rule holds for the request.
+func (rule PolicyRule) matches(r *http.Request) bool {
+    if len(rule.Keys) > 0 && !contains(rule.Keys, r.Header.Get("X-API-Key")) {
+        return false
+    }
+    if len(rule.Methods) > 0 && !contains(rule.Methods, r.Method) {
+        return false
+    }
+    if len(rule.Paths) > 0 {
+        matched := false
+        for _, pattern := range rule.Paths {
+            if ok, _ := path.Match(pattern, r.URL.Path); ok {
+                matched = true
+                break
+            }
+        }
+        if !matched {
+            return false
+        }
+    }
+    if len(rule.Book) > 0 {
+        id, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/book/"), "/")
+        if !strings.HasPrefix(r.URL.Path, "/book/") {
+            return false // Book conditions only apply to requests for a single book.
+        }
+        mux.RLock()
+        book, ok := getBook(id)
+        mux.RUnlock()
+        if !ok {
+            return false
+        }
+        fields := bookFields(book)
+        for name, want := range rule.Book {
+            var got string
+            if json.Unmarshal(fields[name], &got) != nil || got != want {
+                return false
+            }
+        }
+    }
+    return true
+}
+
+// contains reports whether list holds s, ignoring case so "get" matches GET.
+func contains(list []string, s string) bool {
+    for _, v := range list {
+        if strings.EqualFold(v, s) {
+            return true
+        }
+    }
+    return false
+}
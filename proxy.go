@@ -0,0 +1,112 @@
+package main
+
+import (
+    "log"
+    "net"
+    "net/http"
+    "net/netip"
+    "strings"
+)
+
+// trustedProxies are the networks whose forwarding headers are believed, e.g.
+// "10.0.0.0/8,192.168.1.5/32". Headers from anyone else are ignored, since any
+// client can send them.
+var trustedProxies = parsePrefixes(envString("TRUSTED_PROXIES", ""))
+
+// parsePrefixes parses a comma-separated list of CIDRs or bare addresses.
+// Invalid entries are logged and skipped.
+func parsePrefixes(list string) []netip.Prefix {
+    var out []netip.Prefix
+    for _, s := range strings.Split(list, ",") {
+        s = strings.TrimSpace(s)
+        if s == "" {
+            continue
+        }
+        if !strings.Contains(s, "/") {
+            if addr, err := netip.ParseAddr(s); err == nil {
+                out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+                continue
+            }
+        }
+        p, err := netip.ParsePrefix(s)
+        if err != nil {
+            log.Printf("ignoring trusted proxy %q: %v", s, err)
+            continue
+        }
+        out = append(out, p.Masked())
+    }
+    return out
+}
+
+// isTrustedProxy reports whether addr belongs to a trusted proxy.
+func isTrustedProxy(addr netip.Addr) bool {
+    addr = addr.Unmap()
+    for _, p := range trustedProxies {
+        if p.Contains(addr) {
+            return true
+        }
+    }
+    return false
+}
+
+// clientIP returns the address of the real client. The connection's peer is
+// used unless it is a trusted proxy, in which case the forwarding chain
+// (Forwarded, then X-Forwarded-For, then X-Real-IP) is walked from the nearest
+// hop outwards, skipping trusted proxies, to the first address that isn't one.
+func clientIP(r *http.Request) string {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        host = r.RemoteAddr
+    }
+    peer, err := netip.ParseAddr(host)
+    if err != nil || !isTrustedProxy(peer) {
+        return host
+    }
+
+    hops := forwardedFor(r)
+    for i := len(hops) - 1; i >= 0; i-- {
+        addr, err := netip.ParseAddr(hops[i])
+        if err != nil {
+            return host // A garbled hop: don't trust anything further out.
+        }
+        if !isTrustedProxy(addr) || i == 0 {
+            return addr.Unmap().String()
+        }
+    }
+    return host
+}
+
+// forwardedFor returns the client chain from the forwarding headers, client
+// first, with ports and brackets stripped.
+func forwardedFor(r *http.Request) []string {
+    var hops []string
+    if values := r.Header.Values("Forwarded"); len(values) > 0 {
+        for _, element := range strings.Split(strings.Join(values, ","), ",") {
+            for _, pair := range strings.Split(element, ";") {
+                k, v, _ := strings.Cut(strings.TrimSpace(pair), "=")
+                if strings.EqualFold(k, "for") {
+                    hops = append(hops, stripPort(strings.Trim(v, `"`)))
+                }
+            }
+        }
+        return hops
+    }
+    if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+        for _, v := range strings.Split(strings.Join(values, ","), ",") {
+            hops = append(hops, stripPort(strings.TrimSpace(v)))
+        }
+        return hops
+    }
+    if v := r.Header.Get("X-Real-IP"); v != "" {
+        hops = append(hops, stripPort(strings.TrimSpace(v)))
+    }
+    return hops
+}
+
+// stripPort removes an optional port and IPv6 brackets from a forwarded address.
+func stripPort(s string) string {
+    if host, _, err := net.SplitHostPort(s); err == nil {
+        return host
+    }
+    return strings.Trim(s, "[]")
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// PublicBook is the catalog view of a book on the public tier: what a
+// library website shows, without internal fields such as the version.
+type PublicBook struct {
+    ID              string   `json:"id"`
+    Title           string   `json:"title"`
+    Author          string   `json:"author,omitempty"`
+    Description     string   `json:"description,omitempty"`
+    Language        string   `json:"language,omitempty"`
+    ISBN            string   `json:"isbn,omitempty"`
+    PublicationYear int      `json:"publication_year,omitempty"`
+    Genres          []string `json:"genres,omitempty"`
+    Edition         int      `json:"edition,omitempty"`
+    Format          string   `json:"format,omitempty"`
+}
+
+var (
+    // publicAPI turns on the unauthenticated /public/ routes.
+    publicAPI = envBool("PUBLIC_API", false)
+
+    // publicRateLimit is how many requests one client IP may make to the
+    // public tier per minute.
+    publicRateLimit = envInt("PUBLIC_RATE_LIMIT", 30)
+
+    publicHits = newExpiringStore("public_rate_limit") // Requests per client IP in the current minute.
+)
+
+// publicView strips a book down to its public catalog fields.
+func publicView(book Book) PublicBook {
+    return PublicBook{
+        ID:              book.ID,
+        Title:           book.Title,
+        Author:          book.Author,
+        Description:     book.Description,
+        Language:        book.Language,
+        ISBN:            book.ISBN,
+        PublicationYear: book.PublicationYear,
+        Genres:          book.Genres,
+        Edition:         book.Edition,
+        Format:          book.Format,
+    }
+}
+
+// countPublicRequest counts a request against the client IP's per-minute
+// limit and reports whether it is still within it, how many requests are
+// left and when the minute ends. Counts expire at the end of their minute.
+// A limit of 0 or less refuses every request, unlike Take where it means
+// unlimited.
+func countPublicRequest(ip string) (remaining int, reset time.Time, ok bool) {
+    reset = clock.Now().UTC().Truncate(time.Minute).Add(time.Minute)
+    if publicRateLimit <= 0 {
+        return 0, reset, false
+    }
+    count, ok := publicHits.Take(ip, publicRateLimit, reset)
+    return max(publicRateLimit-count, 0), reset, ok
+}
+
+// withPublicTier is the middleware for the public tier in place of
+// authenticate: no API key, GET only, a per-IP rate limit and a CORS header
+// so any website can call it from the browser.
+func withPublicTier(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Access-Control-Allow-Origin", "*") // Nothing on the public tier is private.
+        if r.Method != "GET" {
+            methodNotAllowed(w, r)
+            return
+        }
+        remaining, reset, ok := countPublicRequest(clientIP(r))
+        seconds := int(reset.Sub(clock.Now()).Seconds()) + 1
+        w.Header().Set("RateLimit-Policy", strconv.Itoa(publicRateLimit)+";w=60")
+        w.Header().Set("RateLimit", "limit="+strconv.Itoa(publicRateLimit)+", remaining="+strconv.Itoa(remaining)+", reset="+strconv.Itoa(seconds))
+        if !ok {
+            w.Header().Set("Retry-After", strconv.Itoa(seconds))
+            writeError(w, "rate_limited", "too many requests from this address; slow down")
+            return
+        }
+        next(w, r)
+    }
+}
+
+// handlePublicBooks handles GET /public/books, listing published books a page
+// at a time. ?q= searches titles, descriptions and authors, and with
+// ?fuzzy=true also finds titles it misspells, most relevant first; ?genre=
+// and ?language= filter as on GET /books.
+func handlePublicBooks(w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    fuzzy := false
+    if v := q.Get("fuzzy"); v != "" {
+        var err error
+        if fuzzy, err = strconv.ParseBool(v); err != nil {
+            writeError(w, "invalid_query", "fuzzy must be true or false")
+            return
+        }
+    }
+    var inGenres map[string]bool
+    if genre := q.Get("genre"); genre != "" {
+        inGenres = genreAndSubgenres(genre)
+    }
+    if q.Get("q") != "" && q.Get("page") == "" {
+        incrementCounter(CounterSearches, "")
+    }
+    mux.RLock()
+    source, err := listWithContext(r.Context(), store)
+    mux.RUnlock()
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    list := make([]PublicBook, 0, len(source))
+    scores := make(map[string]float64) // Relevance of each listed book to ?q=.
+    for _, book := range publishedOnly(source) {
+        score, ok := 1.0, true
+        if q.Get("q") != "" {
+            score, ok = searchScore(book, q.Get("q"), fuzzy)
+        }
+        if ok &&
+            (q.Get("language") == "" || strings.EqualFold(book.Language, q.Get("language"))) &&
+            (inGenres == nil || hasGenre(book, inGenres)) {
+            list = append(list, publicView(book))
+            scores[book.ID] = score
+        }
+    }
+    sort.Slice(list, func(i, j int) bool {
+        if si, sj := scores[list[i].ID], scores[list[j].ID]; si != sj {
+            return si > sj // Only fuzzy searches score below 1.
+        }
+        return lessID(list[i].ID, list[j].ID)
+    })
+    start, end, err := paginateByDefault(w, r, len(list))
+    if err != nil {
+        writePageError(w, err)
+        return
+    }
+    json.NewEncoder(w).Encode(list[start:end])
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// Book formats, as set in a book's format field.
+const (
+    FormatHardcover = "hardcover"
+    FormatPaperback = "paperback"
+    FormatEbook     = "ebook"
+)
+
+// Publisher struct defines a publishing house whose editions the library holds.
+type Publisher struct {
+    ID      string `json:"id"`
+    Name    string `json:"name"`
+    Country string `json:"country,omitempty"` // ISO 3166-1 alpha-2 code, e.g. "GB".
+    Website string `json:"website,omitempty"`
+}
+
+var (
+    publishers    = make(map[string]Publisher) // Map to store publishers with their ID as the key.
+    publisherSeq  int                          // Last publisher ID handed out.
+    publishersMux sync.RWMutex                 // RWMutex to safeguard publishers and publisherSeq. Taken after genresMux.
+)
+
+// checkPublisher reports the first problem with a publisher record, if any.
+func checkPublisher(p Publisher) (string, bool) {
+    if strings.TrimSpace(p.Name) == "" {
+        return "name is required", false
+    }
+    if p.Website != "" {
+        if u, err := url.Parse(p.Website); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+            return "website must be an http or https URL", false
+        }
+    }
+    return "", true
+}
+
+// checkEdition checks a book's edition metadata and that its publisher exists.
+func checkEdition(book *Book) error {
+    switch book.Format {
+    case "", FormatHardcover, FormatPaperback, FormatEbook:
+    default:
+        return errors.New("format must be hardcover, paperback or ebook")
+    }
+    if book.Edition < 0 {
+        return errors.New("edition must be a positive number")
+    }
+    if book.PublisherID == "" {
+        return nil
+    }
+    publishersMux.RLock()
+    _, ok := publishers[book.PublisherID]
+    publishersMux.RUnlock()
+    if !ok {
+        return errors.New("unknown publisher_id " + book.PublisherID)
+    }
+    return nil
+}
+
+// booksByPublisher returns the books published by a publisher. Callers hold mux.
+func booksByPublisher(id string) ([]Book, error) {
+    source, err := store.List()
+    if err != nil {
+        return nil, err
+    }
+    bks := make([]Book, 0)
+    for _, book := range source {
+        if book.PublisherID == id {
+            bks = append(bks, book)
+        }
+    }
+    return bks, nil
+}
+
+// handlePublishers handles requests for the /publishers route.
+func handlePublishers(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case "GET": // Retrieve all publishers, optionally those whose name contains ?q=.
+        q := strings.ToLower(r.URL.Query().Get("q"))
+        publishersMux.RLock()
+        list := make([]Publisher, 0, len(publishers))
+        for _, p := range publishers {
+            if q == "" || strings.Contains(strings.ToLower(p.Name), q) {
+                list = append(list, p)
+            }
+        }
+        publishersMux.RUnlock()
+        sort.Slice(list, func(i, j int) bool { return lessID(list[i].ID, list[j].ID) })
+        start, end, err := paginate(w, r, len(list))
+        if err != nil {
+            writePageError(w, err)
+            return
+        }
+        json.NewEncoder(w).Encode(list[start:end])
+
+    case "POST": // Add a new publisher.
+        var p Publisher
+        if err := decodeJSON(r, &p); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        if msg, ok := checkPublisher(p); !ok {
+            writeError(w, "validation_failed", msg)
+            return
+        }
+        publishersMux.Lock()
+        publisherSeq++
+        p.ID = strconv.Itoa(publisherSeq)
+        publishers[p.ID] = p
+        publishersMux.Unlock()
+        w.Header().Set("Location", "/publisher/"+p.ID)
+        w.WriteHeader(http.StatusCreated)
+        json.NewEncoder(w).Encode(p)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// handlePublisher handles requests for the /publisher/{id} and /publisher/{id}/books routes.
+func handlePublisher(w http.ResponseWriter, r *http.Request) {
+    id, sub, _ := strings.Cut(r.URL.Path[len("/publisher/"):], "/")
+    if sub != "" && sub != "books" {
+        writeError(w, "not_found", "no route for "+r.URL.Path)
+        return
+    }
+    publishersMux.RLock()
+    p, ok := publishers[id]
+    publishersMux.RUnlock()
+    if !ok {
+        writeError(w, "publisher_not_found", "publisher "+id+" not found")
+        return
+    }
+    if sub == "books" {
+        handlePublisherBooks(w, r, id)
+        return
+    }
+
+    switch r.Method {
+    case "GET": // Retrieve a single publisher.
+        json.NewEncoder(w).Encode(p)
+
+    case "PUT": // Update a publisher.
+        var p Publisher
+        if err := decodeJSON(r, &p); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        p.ID = id // The ID in the path is authoritative.
+        if msg, ok := checkPublisher(p); !ok {
+            writeError(w, "validation_failed", msg)
+            return
+        }
+        publishersMux.Lock()
+        defer publishersMux.Unlock()
+        if _, ok := publishers[id]; !ok {
+            writeError(w, "publisher_not_found", "publisher "+id+" not found")
+            return
+        }
+        publishers[id] = p
+        json.NewEncoder(w).Encode(p)
+
+    case "DELETE": // Remove a publisher that no book links to.
+        mux.RLock() // Held until the publisher is gone, so no book can link to it meanwhile.
+        defer mux.RUnlock()
+        bks, err := booksByPublisher(id)
+        if err != nil {
+            writeError(w, "internal_error", err.Error())
+            return
+        }
+        if len(bks) > 0 {
+            writeError(w, "publisher_in_use", strconv.Itoa(len(bks))+" book(s) still link to publisher "+id+"; relink or delete them first")
+            return
+        }
+        publishersMux.Lock()
+        delete(publishers, id)
+        publishersMux.Unlock()
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// handlePublisherBooks handles GET /publisher/{id}/books, listing the
+// publisher's books, optionally only those in one ?format=.
+func handlePublisherBooks(w http.ResponseWriter, r *http.Request, id string) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    format := r.URL.Query().Get("format")
+    mux.RLock()
+    source, err := booksByPublisher(id)
+    mux.RUnlock()
+    if err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    bks := make([]Book, 0, len(source))
+    for _, book := range source {
+        if (format == "" || book.Format == format) && canSeeBook(r, book) {
+            bks = append(bks, book)
+        }
+    }
+    sort.Slice(bks, func(i, j int) bool { return lessID(bks[i].ID, bks[j].ID) })
+    start, end, err := paginate(w, r, len(bks))
+    if err != nil {
+        writePageError(w, err)
+        return
+    }
+    w.Header().Add("Vary", "Accept-Language")
+    json.NewEncoder(w).Encode(renderBooks(bks[start:end], nil, acceptedLanguages(r)))
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "time"
+)
+
+// Quota limits what one API key may use. Zero means unlimited.
+type Quota struct {
+    MaxRecords        int `json:"max_records"`          // Books the key may own (have created).
+    MaxStorageBytes   int `json:"max_storage_bytes"`    // Total JSON size of the books the key owns.
+    MaxRequestsPerDay int `json:"max_requests_per_day"` // Requests per UTC day.
+}
+
+// QuotaConfig is the contents of QUOTAS_FILE.
+type QuotaConfig struct {
+    Default Quota             `json:"default"` // Applies to keys not listed in Keys.
+    Keys    map[string]Quota  `json:"keys"`
+    Tenants map[string]string `json:"tenants"` // Tenant each key is billed to, for GET /admin/usage.
+}
+
+// QuotaUsage is the response for GET /quota.
+type QuotaUsage struct {
+    Quota         Quota  `json:"quota"`
+    Records       int    `json:"records"`
+    StorageBytes  int    `json:"storage_bytes"`
+    RequestsToday int    `json:"requests_today"`
+    Day           string `json:"day"` // UTC day the request count is for (YYYY-MM-DD).
+}
+
+var (
+    // quotas is loaded once at startup; without QUOTAS_FILE nothing is limited.
+    quotas = loadQuotas(envString("QUOTAS_FILE", ""))
+
+    bookOwners = make(map[string]string) // API key that created each book, by book ID. Guarded by mux.
+
+    requestCounts = newExpiringStore("request_quota") // Requests per key today (UTC), expiring at midnight.
+)
+
+// loadQuotas reads QUOTAS_FILE. Like the policy file, a bad quota file stops
+// the server rather than leaving every key unlimited.
+func loadQuotas(file string) QuotaConfig {
+    if file == "" {
+        return QuotaConfig{}
+    }
+    data, err := os.ReadFile(file)
+    if err != nil {
+        log.Fatalf("reading QUOTAS_FILE: %v", err)
+    }
+    var cfg QuotaConfig
+    if err := json.Unmarshal(data, &cfg); err != nil {
+        log.Fatalf("parsing QUOTAS_FILE: %v", err)
+    }
+    return cfg
+}
+
+// quotaFor returns the quota that applies to a key.
+func quotaFor(key string) Quota {
+    if q, ok := quotas.Keys[key]; ok {
+        return q
+    }
+    return quotas.Default
+}
+
+// countRequest counts a request against the key's daily quota and reports
+// whether it is still within it, along with the limit (0 when unlimited) and
+// how many requests are left today.
+func countRequest(key string) (limit, remaining int, ok bool) {
+    limit = quotaFor(key).MaxRequestsPerDay
+    count, ok := requestCounts.Take(key, limit, tomorrow())
+    return limit, limit - count, ok
+}
+
+// setRateLimitHeaders describes the key's daily request quota using the IETF
+// RateLimit header fields draft, so clients can slow down before they hit 429.
+func setRateLimitHeaders(w http.ResponseWriter, limit, remaining int) {
+    reset := secondsUntilTomorrow()
+    w.Header().Set("RateLimit-Policy", strconv.Itoa(limit)+";w=86400")
+    w.Header().Set("RateLimit", "limit="+strconv.Itoa(limit)+", remaining="+strconv.Itoa(remaining)+", reset="+strconv.Itoa(reset))
+}
+
+// tomorrow is the next UTC midnight, when daily quotas start afresh.
+func tomorrow() time.Time {
+    now := clock.Now().UTC()
+    return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+// secondsUntilTomorrow is the Retry-After for a spent daily quota.
+func secondsUntilTomorrow() int {
+    return int(tomorrow().Sub(clock.Now()).Seconds()) + 1
+}
+
+// ownedUsage returns how many books a key owns and their total size. Callers
+// hold mux.
+func ownedUsage(key string) (records, bytes int) {
+    for id, owner := range bookOwners {
+        if owner == key {
+            records++
+            book, _ := getBook(id)
+            bytes += bookSize(book)
+        }
+    }
+    return records, bytes
+}
+
+// bookSize is the size of a book as counted against storage quotas.
+func bookSize(book Book) int {
+    data, _ := json.Marshal(book)
+    return len(data)
+}
+
+// checkWriteQuota reports whether writing the given books would take the key
+// past its record or storage quota. New books count toward the key; books it
+// owns count with their new size; edits to other books don't count. Callers
+// hold mux.
+func checkWriteQuota(key string, writes []Book) error {
+    q := quotaFor(key)
+    if q.MaxRecords == 0 && q.MaxStorageBytes == 0 {
+        return nil
+    }
+    records, bytes := ownedUsage(key)
+    for _, book := range writes {
+        switch prev, exists := getBook(book.ID); {
+        case !exists:
+            records++
+            bytes += bookSize(book)
+        case bookOwners[book.ID] == key:
+            bytes += bookSize(book) - bookSize(prev)
+        }
+    }
+    if q.MaxRecords > 0 && records > q.MaxRecords {
+        return errors.New("record quota of " + strconv.Itoa(q.MaxRecords) + " books exceeded")
+    }
+    if q.MaxStorageBytes > 0 && bytes > q.MaxStorageBytes {
+        return errors.New("storage quota of " + strconv.Itoa(q.MaxStorageBytes) + " bytes exceeded")
+    }
+    return nil
+}
+
+// claimBook records the key as the owner of a book it just created. Callers
+// hold mux.
+func claimBook(key, id string) {
+    bookOwners[id] = key
+}
+
+// releaseBook forgets the owner of a deleted book. Callers hold mux.
+func releaseBook(id string) {
+    delete(bookOwners, id)
+}
+
+// handleQuota handles requests for the /quota route, reporting the calling
+// key's quota and current usage.
+func handleQuota(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    json.NewEncoder(w).Encode(quotaUsage(r.Header.Get("X-API-Key")))
+}
+
+// quotaUsage reports a key's quota and how much of it is used.
+func quotaUsage(key string) QuotaUsage {
+    usage := QuotaUsage{Quota: quotaFor(key)}
+    mux.RLock()
+    usage.Records, usage.StorageBytes = ownedUsage(key)
+    mux.RUnlock()
+    usage.RequestsToday, usage.Day = requestCounts.Count(key), clock.Now().UTC().Format(dateLayout)
+    return usage
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// ReadOnlyState is the body of GET and PUT /admin/read-only.
+type ReadOnlyState struct {
+    ReadOnly          bool `json:"read_only"`
+    RetryAfterSeconds int  `json:"retry_after_seconds"` // Sent as Retry-After on rejected writes.
+}
+
+var (
+    // readOnly rejects every write with 503 while reads keep working, for
+    // restores, migrations and replicas that must never accept writes. It
+    // starts from READ_ONLY and can be flipped at runtime by an admin.
+    readOnly           = envBool("READ_ONLY", false)
+    readOnlyRetryAfter = envDuration("READ_ONLY_RETRY_AFTER", time.Minute)
+    readOnlyMux        sync.RWMutex // RWMutex to safeguard readOnly and readOnlyRetryAfter.
+)
+
+// readOnlyExempt are the routes that still accept POSTs in read-only mode: the
+// toggle itself, /batch, whose sub-requests are checked one by one, and
+// /admin/backup and /admin/backups, which only read.
+var readOnlyExempt = map[string]bool{"/admin/read-only": true, "/batch": true, "/admin/backup": true, "/admin/backups": true}
+
+// readOnlyState returns the current read-only setting.
+func readOnlyState() ReadOnlyState {
+    readOnlyMux.RLock()
+    defer readOnlyMux.RUnlock()
+    return ReadOnlyState{ReadOnly: readOnly, RetryAfterSeconds: int(readOnlyRetryAfter / time.Second)}
+}
+
+// withReadOnly is a middleware that rejects mutating requests with 503 and a
+// Retry-After header while the server is in read-only mode.
+func withReadOnly(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case "GET", "HEAD", "OPTIONS":
+        default:
+            if state := readOnlyState(); state.ReadOnly && !readOnlyExempt[r.URL.Path] {
+                w.Header().Set("Retry-After", strconv.Itoa(state.RetryAfterSeconds))
+                writeError(w, "read_only", "the server is in read-only mode; try again later")
+                return
+            }
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// handleReadOnly handles requests for the /admin/read-only route. Anyone can
+// read the setting; changing it requires the admin key.
+func handleReadOnly(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case "GET":
+        json.NewEncoder(w).Encode(readOnlyState())
+
+    case "PUT":
+        if !isAdmin(r) {
+            writeError(w, "admin_required", "changing read-only mode requires the admin key")
+            return
+        }
+        state := readOnlyState() // Fields left out of the body keep their current value.
+        if err := decodeJSON(r, &state); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        if state.RetryAfterSeconds < 0 {
+            writeError(w, "validation_failed", "retry_after_seconds must not be negative")
+            return
+        }
+        readOnlyMux.Lock()
+        readOnly, readOnlyRetryAfter = state.ReadOnly, time.Duration(state.RetryAfterSeconds)*time.Second
+        readOnlyMux.Unlock()
+        json.NewEncoder(w).Encode(state)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
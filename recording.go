@@ -0,0 +1,247 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/json"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// maxRecordedBody caps how much of a request body is kept in a recording.
+const maxRecordedBody = 1 << 20
+
+// Recording is one mutating request as kept in RECORDING_FILE, after redaction.
+type Recording struct {
+    Seq        int64             `json:"seq"`
+    At         time.Time         `json:"at"`
+    Method     string            `json:"method"`
+    Path       string            `json:"path"` // Request URI with sensitive query parameters masked.
+    Headers    map[string]string `json:"headers"`
+    Body       string            `json:"body,omitempty"`
+    Status     int               `json:"status"`
+    ChangeFrom int64             `json:"change_from"` // Change log entries this request produced are those after ChangeFrom...
+    ChangeTo   int64             `json:"change_to"`   // ...up to and including ChangeTo.
+}
+
+// ReplayResult reports the outcome of one replayed recording.
+type ReplayResult struct {
+    Seq            int64 `json:"seq"`
+    Status         int   `json:"status"`          // Status the replay got.
+    RecordedStatus int   `json:"recorded_status"` // Status the original request got.
+}
+
+// replayKey marks requests issued by a replay so they aren't recorded again.
+type replayKey struct{}
+
+var (
+    // recordingFile is where mutating requests are appended, one JSON object per
+    // line, for incident forensics. Recording is off when it is empty.
+    recordingFile = envString("RECORDING_FILE", "")
+    recordingSeq  int64      // Seq of the last recording written.
+    recordingMux  sync.Mutex // Mutex to safeguard recordingSeq and appends to recordingFile.
+)
+
+// unrecordedPaths are POST routes left out of recordings: /batch, whose
+// sub-requests are recorded one by one, /admin/replay, which would otherwise
+// replay itself, /admin/backup and /admin/backups, which change nothing,
+// and /admin/restore, whose body is far too large to record.
+var unrecordedPaths = map[string]bool{"/batch": true, "/admin/replay": true, "/admin/backup": true, "/admin/backups": true, "/admin/restore": true}
+
+// withRecording is a middleware that appends every mutating request, redacted
+// like the debug log, to RECORDING_FILE together with the range of change log
+// entries it produced.
+func withRecording(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if recordingFile == "" || r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" ||
+            unrecordedPaths[r.URL.Path] || r.Context().Value(replayKey{}) != nil {
+            next.ServeHTTP(w, r)
+            return
+        }
+        var body []byte
+        if r.Body != nil {
+            body, _ = io.ReadAll(io.LimitReader(r.Body, maxRecordedBody))
+            r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+        }
+        from := currentChangeSeq()
+        rec := &statusRecorder{ResponseWriter: w}
+        next.ServeHTTP(rec, r)
+        if rec.status == 0 {
+            rec.status = http.StatusOK
+        }
+        appendRecording(Recording{
+            At:         clock.Now().UTC(),
+            Method:     r.Method,
+            Path:       redactURL(r.URL),
+            Headers:    redactHeaders(r.Header),
+            Body:       redactBody(body),
+            Status:     rec.status,
+            ChangeFrom: from,
+            ChangeTo:   currentChangeSeq(), // May include a concurrent request's changes; ranges are a guide, not proof.
+        })
+    })
+}
+
+// appendRecording assigns the next seq and appends the recording to the file.
+func appendRecording(rec Recording) {
+    recordingMux.Lock()
+    defer recordingMux.Unlock()
+    f, err := os.OpenFile(recordingFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+    if err != nil {
+        log.Printf("recording: %v", err)
+        return
+    }
+    defer f.Close()
+    recordingSeq++
+    rec.Seq = recordingSeq
+    data, _ := json.Marshal(rec)
+    if _, err := f.Write(append(data, '\n')); err != nil {
+        log.Printf("recording: %v", err)
+    }
+}
+
+// readRecordings returns the recordings with a seq between from and to
+// (inclusive; 0 means unbounded).
+func readRecordings(from, to int64) ([]Recording, error) {
+    recordingMux.Lock()
+    defer recordingMux.Unlock()
+    f, err := os.Open(recordingFile)
+    if os.IsNotExist(err) {
+        return []Recording{}, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    out := make([]Recording, 0)
+    sc := bufio.NewScanner(f)
+    sc.Buffer(nil, 4*maxRecordedBody) // Lines hold a whole body plus headers.
+    for sc.Scan() {
+        var rec Recording
+        if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+            return nil, err
+        }
+        if rec.Seq >= from && (to == 0 || rec.Seq <= to) {
+            out = append(out, rec)
+        }
+    }
+    return out, sc.Err()
+}
+
+// loadRecordingSeq continues numbering after the recordings already on disk,
+// so seqs stay unique across restarts.
+func loadRecordingSeq() {
+    if recordingFile == "" {
+        return
+    }
+    recs, err := readRecordings(0, 0)
+    if err != nil {
+        log.Fatalf("reading RECORDING_FILE: %v", err)
+    }
+    if len(recs) > 0 {
+        recordingSeq = recs[len(recs)-1].Seq
+    }
+}
+
+// recordingRange parses ?from= and ?to= seqs.
+func recordingRange(r *http.Request) (from, to int64, ok bool) {
+    q := r.URL.Query()
+    for _, p := range []struct {
+        name string
+        dst  *int64
+    }{{"from", &from}, {"to", &to}} {
+        if v := q.Get(p.name); v != "" {
+            n, err := strconv.ParseInt(v, 10, 64)
+            if err != nil || n < 0 {
+                return 0, 0, false
+            }
+            *p.dst = n
+        }
+    }
+    return from, to, true
+}
+
+// handleRecordings handles GET /admin/recordings?from=&to=, listing recorded
+// requests. Admin only.
+func handleRecordings(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    if !isAdmin(r) {
+        writeError(w, "admin_required", "recordings require the admin key")
+        return
+    }
+    from, to, ok := recordingRange(r)
+    if !ok {
+        writeError(w, "invalid_query", "from and to must be recording seqs")
+        return
+    }
+    recs, err := readRecordings(from, to)
+    if err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    json.NewEncoder(w).Encode(recs)
+}
+
+// handleReplay handles POST /admin/replay?from=&to=, re-running recorded
+// requests in order through the full middleware chain to reconstruct how the
+// data changed. It only runs on a sandbox instance, which starts from the same
+// seed data as production, so an investigation can never rewrite real data.
+// Redacted values are replayed as redacted.
+func handleReplay(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "POST" {
+        methodNotAllowed(w, r)
+        return
+    }
+    if !isAdmin(r) {
+        writeError(w, "admin_required", "replay requires the admin key")
+        return
+    }
+    if !sandboxMode {
+        writeError(w, "sandbox_required", "replay only runs on a sandbox instance")
+        return
+    }
+    from, to, ok := recordingRange(r)
+    if !ok {
+        writeError(w, "invalid_query", "from and to must be recording seqs")
+        return
+    }
+    recs, err := readRecordings(from, to)
+    if err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+
+    results := make([]ReplayResult, 0, len(recs))
+    ctx := context.WithValue(r.Context(), replayKey{}, true)
+    for _, rec := range recs {
+        req, err := http.NewRequestWithContext(ctx, rec.Method, rec.Path, strings.NewReader(rec.Body))
+        if err != nil {
+            results = append(results, ReplayResult{Seq: rec.Seq, Status: http.StatusBadRequest, RecordedStatus: rec.Status})
+            continue
+        }
+        req.RemoteAddr = r.RemoteAddr
+        for k, v := range rec.Headers {
+            if !sensitiveHeaders[http.CanonicalHeaderKey(k)] {
+                req.Header.Set(k, v)
+            }
+        }
+        req.Header.Set("X-API-Key", r.Header.Get("X-API-Key")) // Original keys were redacted; act as the caller.
+        out := &batchRecorder{header: make(http.Header)} // Same in-process dispatch as /batch.
+        apiHandler.ServeHTTP(out, req)
+        if out.status == 0 {
+            out.status = http.StatusOK
+        }
+        results = append(results, ReplayResult{Seq: rec.Seq, Status: out.status, RecordedStatus: rec.Status})
+    }
+    json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Review struct defines a reader's rating and review of a book.
+type Review struct {
+    ID        string    `json:"id"`
+    BookID    string    `json:"book_id"`
+    Rating    int       `json:"rating"` // 1 to 5 stars.
+    Body      string    `json:"body,omitempty"`
+    Reviewer  string    `json:"reviewer"` // "admin", or a fingerprint of the API key.
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// ReviewPage is the response for GET /book/{id}/reviews.
+type ReviewPage struct {
+    BookID        string   `json:"book_id"`
+    Count         int      `json:"count"`          // All reviews of the book, not just this page.
+    AverageRating float64  `json:"average_rating"` // Mean rating over all reviews, 0 if there are none.
+    Reviews       []Review `json:"reviews"`        // Newest first.
+}
+
+// ratingTotals is the running count and sum of one book's ratings.
+type ratingTotals struct {
+    count, sum int
+}
+
+var (
+    reviews    = make(map[string]Review)       // Map to store reviews with their ID as the key.
+    reviewSeq  int                             // Last review ID handed out.
+    ratings    = make(map[string]ratingTotals) // Totals per book ID, kept in step with reviews so reads needn't scan them.
+    reviewsMux sync.RWMutex                    // RWMutex to safeguard reviews, reviewSeq and ratings. Taken after publishersMux.
+)
+
+func init() {
+    registerComputedField("review_count", func(ids []string) map[string]interface{} {
+        out := make(map[string]interface{}, len(ids))
+        reviewsMux.RLock()
+        defer reviewsMux.RUnlock()
+        for _, id := range ids {
+            out[id] = ratings[id].count
+        }
+        return out
+    })
+    registerComputedField("average_rating", func(ids []string) map[string]interface{} {
+        out := make(map[string]interface{}, len(ids))
+        reviewsMux.RLock()
+        defer reviewsMux.RUnlock()
+        for _, id := range ids {
+            out[id] = ratings[id].average() // nil for books nobody has reviewed.
+        }
+        return out
+    })
+}
+
+// average is the mean rating, or nil when there are no ratings.
+func (t ratingTotals) average() interface{} {
+    if t.count == 0 {
+        return nil
+    }
+    return float64(t.sum) / float64(t.count)
+}
+
+// hasReviews reports whether anyone has reviewed a book.
+func hasReviews(bookID string) bool {
+    reviewsMux.RLock()
+    defer reviewsMux.RUnlock()
+    return ratings[bookID].count > 0
+}
+
+// rebuildRatings recomputes every book's totals from the reviews, after
+// reviews were replaced or repaired wholesale. Callers hold reviewsMux.
+func rebuildRatings() {
+    ratings = make(map[string]ratingTotals)
+    for _, rv := range reviews {
+        t := ratings[rv.BookID]
+        t.count++
+        t.sum += rv.Rating
+        ratings[rv.BookID] = t
+    }
+}
+
+// dropReviews deletes every review of a book, when the book itself is deleted.
+func dropReviews(bookID string) {
+    reviewsMux.Lock()
+    defer reviewsMux.Unlock()
+    for id, rv := range reviews {
+        if rv.BookID == bookID {
+            delete(reviews, id)
+        }
+    }
+    delete(ratings, bookID)
+}
+
+// handleBookReviews handles requests for the /book/{id}/reviews route.
+func handleBookReviews(w http.ResponseWriter, r *http.Request, bookID string) {
+    mux.RLock()
+    book, ok := getBook(bookID)
+    mux.RUnlock()
+    if !ok || !canSeeBook(r, book) {
+        writeError(w, "book_not_found", "book "+bookID+" not found")
+        return
+    }
+
+    switch r.Method {
+    case "GET": // List the book's reviews, newest first, a page at a time.
+        reviewsMux.RLock()
+        totals := ratings[bookID] // Kept in step with reviews, so the page is the only thing scanned for.
+        page := ReviewPage{BookID: bookID, Count: totals.count, Reviews: []Review{}}
+        if avg, ok := totals.average().(float64); ok {
+            page.AverageRating = avg
+        }
+        all := make([]Review, 0, totals.count)
+        for _, rv := range reviews {
+            if rv.BookID == bookID {
+                all = append(all, rv)
+            }
+        }
+        reviewsMux.RUnlock()
+        sort.Slice(all, func(i, j int) bool { return lessID(all[j].ID, all[i].ID) })
+        start, end, err := paginateByDefault(w, r, len(all))
+        if err != nil {
+            writePageError(w, err)
+            return
+        }
+        page.Reviews = append(page.Reviews, all[start:end]...)
+        json.NewEncoder(w).Encode(page)
+
+    case "POST": // Add a review.
+        var rv Review
+        if err := decodeJSON(r, &rv); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        if rv.Rating < 1 || rv.Rating > 5 {
+            writeError(w, "validation_failed", "rating must be between 1 and 5")
+            return
+        }
+        rv.Body = strings.TrimSpace(rv.Body)
+        rv.BookID, rv.Reviewer, rv.CreatedAt = bookID, keyLabel(r.Header.Get("X-API-Key")), clock.Now().UTC()
+        mux.RLock() // The book must still be there when the review is stored.
+        if _, ok := getBook(bookID); !ok {
+            mux.RUnlock()
+            writeError(w, "book_not_found", "book "+bookID+" not found")
+            return
+        }
+        reviewsMux.Lock()
+        reviewSeq++
+        rv.ID = strconv.Itoa(reviewSeq)
+        reviews[rv.ID] = rv
+        t := ratings[bookID]
+        t.count++
+        t.sum += rv.Rating
+        ratings[bookID] = t
+        reviewsMux.Unlock()
+        mux.RUnlock()
+        w.WriteHeader(http.StatusCreated)
+        json.NewEncoder(w).Encode(rv)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
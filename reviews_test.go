@@ -0,0 +1,36 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+    "time"
+)
+
+func TestBookReviewsTotals(t *testing.T) {
+    useTestSeams(t, time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC))
+    reader := map[string]string{"X-API-Key": "secret-key"}
+    if w := serveBook("PUT", "/book/rev-1", `{"title":"Dune"}`, nil); w.Code != http.StatusOK {
+        t.Fatalf("PUT = %d %s", w.Code, w.Body)
+    }
+    for _, body := range []string{`{"rating":4}`, `{"rating":5}`} {
+        if w := serveBook("POST", "/book/rev-1/reviews", body, reader); w.Code != http.StatusCreated {
+            t.Fatalf("POST review = %d %s", w.Code, w.Body)
+        }
+    }
+
+    w := serveBook("GET", "/book/rev-1/reviews?per_page=1", "", reader)
+    var page ReviewPage
+    if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+        t.Fatalf("GET reviews = %d %s", w.Code, w.Body)
+    }
+    if page.Count != 2 || page.AverageRating != 4.5 || len(page.Reviews) != 1 || page.Reviews[0].Rating != 5 {
+        t.Errorf("page = %+v, want count 2, average 4.5 and the newest review", page)
+    }
+    reviewsMux.RLock()
+    totals := ratings["rev-1"]
+    reviewsMux.RUnlock()
+    if totals.count != page.Count {
+        t.Errorf("ratings count = %d, page count = %d", totals.count, page.Count)
+    }
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+    "log"
+    "time"
+)
+
+var (
+    // sandboxMode turns this instance into a sandbox for integrators: only the
+    // sandbox key is accepted and all data is reset to the seed catalog on a
+    // schedule, so destructive flows can be tried against a live server.
+    sandboxMode          = envBool("SANDBOX_MODE", false)
+    sandboxAPIKey        = envString("SANDBOX_API_KEY", "sandbox-key")
+    sandboxResetInterval = envDuration("SANDBOX_RESET_INTERVAL", time.Hour)
+)
+
+// resetSandbox is the scheduled job that resets the sandbox data every
+// sandboxResetInterval.
+func resetSandbox() error {
+    resetToSeed()
+    log.Printf("sandbox data reset to seed fixtures; next reset in %s", sandboxResetInterval)
+    return nil
+}
+
+// resetToSeed discards every record, including archived books, counts, kept
+// exports, saved searches and notification templates, and restores the seed
+// catalog.
+func resetToSeed() {
+    defer lockAll()()
+
+    illRequests, illSeq = make(map[string]ILLRequest), 0
+    weeding, weedingSeq = make(map[string]WeedingRecord), 0
+    purchaseOrders, purchaseOrderSeq = make(map[string]PurchaseOrder), 0
+    copies, copySeq = make(map[string]Copy), 0
+    locations = make(map[string]Location)
+    authors, authorSeq = make(map[string]Author), 0
+    genres = make(map[string]Genre)
+    publishers, publisherSeq = make(map[string]Publisher), 0
+    reviews, reviewSeq, ratings = make(map[string]Review), 0, make(map[string]ratingTotals)
+    templates = make(map[string]NotificationTemplate)
+    searchesMux.Lock()
+    savedSearches, searchSeq = make(map[string]SavedSearch), 0 // Their alerts would keep calling the previous user's webhooks.
+    searchesMux.Unlock()
+    resetChangeLog() // Old cursors are meaningless after a reset.
+    trash = make(map[string]DeletedBook)
+    resetArchive()
+    resetCounters()
+    resetTransferJobs() // Their exports hold the old data.
+    historyMux.Lock()
+    bookHistory = make(map[string][]BookVersion) // History restarts with the seed data.
+    historyMux.Unlock()
+    for _, book := range listBooks() {
+        if err := store.Delete(book.ID); err != nil {
+            log.Printf("sandbox reset: deleting book %s: %v", book.ID, err)
+        }
+    }
+    bookOwners = make(map[string]string)
+    for id := range ebookFiles {
+        blobs.Delete(id)
+    }
+    ebookFiles, ebookSeq = make(map[string]EbookFile), 0
+    for id := range covers {
+        deleteCoverBlobs(id)
+    }
+    covers = make(map[string]Cover)
+    now := clock.Now().UTC()
+    for _, book := range seedBooks() {
+        book.Version = 1 // History starts over.
+        book.CreatedAt, book.UpdatedAt = now, now
+        if err := store.Create(book); err != nil {
+            log.Printf("sandbox reset: seeding book %s: %v", book.ID, err)
+            continue
+        }
+        recordVersion(book.ID, &book, now, "")
+    }
+}
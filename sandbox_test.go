@@ -0,0 +1,49 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+    "time"
+)
+
+func TestResetToSeed(t *testing.T) {
+    useTestSeams(t, time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC))
+    if w := serveBook("PUT", "/book/sandbox-1", `{"title":"Scratch"}`, nil); w.Code != http.StatusOK {
+        t.Fatalf("PUT = %d %s", w.Code, w.Body)
+    }
+    searchesMux.Lock()
+    savedSearches["1"] = SavedSearch{ID: "1", Name: "new sf", Alert: &SearchAlert{Webhook: "http://example.com/hook"}}
+    searchesMux.Unlock()
+    templatesMux.Lock()
+    templates["ill-arrived"] = NotificationTemplate{Name: "ill-arrived", Subject: "s", Body: "b"}
+    templatesMux.Unlock()
+    incrementCounter(CounterViews, "sandbox-1")
+
+    done := make(chan struct{})
+    go func() {
+        resetToSeed()
+        close(done)
+    }()
+    select {
+    case <-done:
+    case <-time.After(5 * time.Second):
+        t.Fatal("resetToSeed deadlocked")
+    }
+
+    if _, exists, _ := store.Get("sandbox-1"); exists {
+        t.Error("reset kept a book that isn't in the seed catalog")
+    }
+    if bks := listBooks(); len(bks) != len(seedBooks()) {
+        t.Errorf("reset left %d books, want the %d seed books", len(bks), len(seedBooks()))
+    }
+    if len(savedSearches) != 0 || searchSeq != 0 {
+        t.Errorf("reset kept %d saved searches", len(savedSearches))
+    }
+    if len(templates) != 0 {
+        t.Errorf("reset kept %d templates", len(templates))
+    }
+    flushCounters()
+    if n := counterTotals[counterKey{CounterViews, "sandbox-1"}]; n != 0 {
+        t.Errorf("reset kept %d views", n)
+    }
+}
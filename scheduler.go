@@ -0,0 +1,282 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Schedule computes when a job should next run.
+type Schedule interface {
+    Next(after time.Time) time.Time
+}
+
+// everySchedule runs a job at a fixed interval ("@every 1h").
+type everySchedule time.Duration
+
+func (s everySchedule) Next(after time.Time) time.Time { return after.Add(time.Duration(s)) }
+
+// cronSchedule is a standard five-field cron expression: minute, hour, day of
+// month, month and day of week, each a set of allowed values.
+type cronSchedule struct {
+    minute, hour, dom, month, dow map[int]bool
+    domAny, dowAny                bool // Whether the day fields were "*", which changes how they combine.
+}
+
+// cronDescriptors are the shorthand schedules accepted besides five fields and @every.
+var cronDescriptors = map[string]string{
+    "@yearly":  "0 0 1 1 *",
+    "@monthly": "0 0 1 * *",
+    "@weekly":  "0 0 * * 0",
+    "@daily":   "0 0 * * *",
+    "@hourly":  "0 * * * *",
+}
+
+// parseSchedule parses "@every <duration>", a descriptor such as "@daily", or a
+// five-field cron expression such as "*/15 2-4 * * 1-5".
+func parseSchedule(spec string) (Schedule, error) {
+    spec = strings.TrimSpace(spec)
+    if rest, ok := strings.CutPrefix(spec, "@every "); ok {
+        d, err := time.ParseDuration(strings.TrimSpace(rest))
+        if err != nil || d <= 0 {
+            return nil, fmt.Errorf("@every needs a positive duration, got %q", rest)
+        }
+        return everySchedule(d), nil
+    }
+    if expr, ok := cronDescriptors[spec]; ok {
+        spec = expr
+    }
+    fields := strings.Fields(spec)
+    if len(fields) != 5 {
+        return nil, fmt.Errorf("cron expression %q must have five fields", spec)
+    }
+    var s cronSchedule
+    var err error
+    for i, f := range []struct {
+        set      *map[int]bool
+        min, max int
+    }{{&s.minute, 0, 59}, {&s.hour, 0, 23}, {&s.dom, 1, 31}, {&s.month, 1, 12}, {&s.dow, 0, 7}} {
+        if *f.set, err = parseCronField(fields[i], f.min, f.max); err != nil {
+            return nil, fmt.Errorf("cron field %d (%q): %v", i+1, fields[i], err)
+        }
+    }
+    if s.dow[7] {
+        s.dow[0] = true // Both 0 and 7 mean Sunday.
+    }
+    s.domAny, s.dowAny = fields[2] == "*", fields[4] == "*"
+    return s, nil
+}
+
+// parseCronField parses one comma-separated cron field of values, ranges
+// ("1-5"), wildcards and steps ("*/15", "10-50/10").
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+    set := make(map[int]bool)
+    for _, part := range strings.Split(field, ",") {
+        rng, stepStr, hasStep := strings.Cut(part, "/")
+        step := 1
+        if hasStep {
+            n, err := strconv.Atoi(stepStr)
+            if err != nil || n <= 0 {
+                return nil, fmt.Errorf("bad step %q", stepStr)
+            }
+            step = n
+        }
+        lo, hi := min, max
+        if rng != "*" {
+            a, b, isRange := strings.Cut(rng, "-")
+            var err error
+            if lo, err = strconv.Atoi(a); err != nil {
+                return nil, fmt.Errorf("bad value %q", a)
+            }
+            hi = lo
+            if isRange {
+                if hi, err = strconv.Atoi(b); err != nil {
+                    return nil, fmt.Errorf("bad value %q", b)
+                }
+            } else if hasStep {
+                hi = max // "5/15" means every 15 starting at 5.
+            }
+        }
+        if lo < min || hi > max || lo > hi {
+            return nil, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+        }
+        for v := lo; v <= hi; v += step {
+            set[v] = true
+        }
+    }
+    return set, nil
+}
+
+// Next returns the first whole minute after the given time that matches.
+func (s cronSchedule) Next(after time.Time) time.Time {
+    t := after.Truncate(time.Minute).Add(time.Minute)
+    limit := t.AddDate(5, 0, 0) // Expressions like "0 0 30 2 *" never match.
+    for t.Before(limit) {
+        switch {
+        case !s.month[int(t.Month())]:
+            t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+        case !s.dayMatches(t):
+            t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+        case !s.hour[t.Hour()]:
+            t = t.Truncate(time.Hour).Add(time.Hour)
+        case !s.minute[t.Minute()]:
+            t = t.Add(time.Minute)
+        default:
+            return t
+        }
+    }
+    return time.Time{}
+}
+
+// dayMatches applies cron's rule that when both day fields are restricted a
+// day matching either one is enough.
+func (s cronSchedule) dayMatches(t time.Time) bool {
+    dom, dow := s.dom[t.Day()], s.dow[int(t.Weekday())]
+    if s.domAny || s.dowAny {
+        return dom && dow
+    }
+    return dom || dow
+}
+
+// Job is a scheduled background task.
+type Job struct {
+    name     string
+    spec     string
+    schedule Schedule
+    jitter   time.Duration // Random delay of up to this much added to each run, so instances don't all fire at once.
+    run      func() error
+
+    mu     sync.Mutex // Mutex to safeguard the fields below.
+    status JobStatus
+}
+
+// JobStatus is the state of one job as reported by GET /jobs.
+type JobStatus struct {
+    Name           string     `json:"name"`
+    Schedule       string     `json:"schedule"`
+    Running        bool       `json:"running"`
+    Runs           int        `json:"runs"`
+    Skipped        int        `json:"skipped"` // Runs skipped because the previous one was still going.
+    LastStarted    *time.Time `json:"last_started,omitempty"`
+    LastFinished   *time.Time `json:"last_finished,omitempty"`
+    LastDurationMS int64      `json:"last_duration_ms"`
+    LastError      string     `json:"last_error,omitempty"`
+    NextRun        time.Time  `json:"next_run"`
+}
+
+var (
+    jobs    = make(map[string]*Job) // Scheduled jobs by name.
+    jobsMux sync.RWMutex            // RWMutex to safeguard jobs.
+)
+
+// scheduleJob registers a job and starts running it on its schedule. Features
+// that need periodic work register a job here instead of running their own
+// timer goroutine, so every background task shows up on GET /jobs.
+func scheduleJob(name, spec string, jitter time.Duration, run func() error) error {
+    schedule, err := parseSchedule(spec)
+    if err != nil {
+        return err
+    }
+    job := &Job{name: name, spec: spec, schedule: schedule, jitter: jitter, run: run}
+    job.status = JobStatus{Name: name, Schedule: spec}
+    jobsMux.Lock()
+    defer jobsMux.Unlock()
+    if _, ok := jobs[name]; ok {
+        return errors.New("job " + name + " is already scheduled")
+    }
+    jobs[name] = job
+    go job.loop()
+    return nil
+}
+
+// loop waits for each scheduled time and starts a run, unless the previous
+// run is still going, in which case this one is skipped.
+func (j *Job) loop() {
+    for {
+        next := j.schedule.Next(clock.Now())
+        if next.IsZero() {
+            log.Printf("job %s: schedule %q never fires again", j.name, j.spec)
+            return
+        }
+        if j.jitter > 0 {
+            next = next.Add(time.Duration(rng.Int63n(int64(j.jitter))))
+        }
+        j.mu.Lock()
+        j.status.NextRun = next
+        j.mu.Unlock()
+        clock.Sleep(next.Sub(clock.Now()))
+
+        j.mu.Lock()
+        if j.status.Running {
+            j.status.Skipped++
+            j.mu.Unlock()
+            log.Printf("job %s: skipping run, previous run still in progress", j.name)
+            continue
+        }
+        j.status.Running = true
+        j.mu.Unlock()
+        go j.execute()
+    }
+}
+
+// execute runs the job once and records the outcome. A panicking job is
+// recorded as failed rather than taking the server down.
+func (j *Job) execute() {
+    start := clock.Now().UTC()
+    j.mu.Lock()
+    j.status.LastStarted = &start
+    j.mu.Unlock()
+
+    var err error
+    func() {
+        defer func() {
+            if p := recover(); p != nil {
+                err = fmt.Errorf("panic: %v", p)
+            }
+        }()
+        err = j.run()
+    }()
+
+    end := clock.Now().UTC()
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    j.status.Running = false
+    j.status.Runs++
+    j.status.LastFinished = &end
+    j.status.LastDurationMS = end.Sub(start).Milliseconds()
+    j.status.LastError = ""
+    if err != nil {
+        j.status.LastError = err.Error()
+        log.Printf("job %s failed: %v", j.name, err)
+    }
+}
+
+// handleJobs handles requests for the /jobs route, listing every scheduled job
+// with its last run and next run, or with ?type= finished imports or exports.
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    if typ := r.URL.Query().Get("type"); typ != "" {
+        listTransfers(w, r, typ) // Finished imports or exports rather than scheduled jobs.
+        return
+    }
+    jobsMux.RLock()
+    out := make([]JobStatus, 0, len(jobs))
+    for _, j := range jobs {
+        j.mu.Lock()
+        out = append(out, j.status)
+        j.mu.Unlock()
+    }
+    jobsMux.RUnlock()
+    sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+    json.NewEncoder(w).Encode(out)
+}
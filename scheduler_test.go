@@ -0,0 +1,83 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestParseScheduleErrors(t *testing.T) {
+    for _, spec := range []string{
+        "",
+        "* * * *",         // Four fields.
+        "* * * * * *",     // Six fields.
+        "60 * * * *",      // Minute out of range.
+        "* 24 * * *",      // Hour out of range.
+        "* * 0 * *",       // Days of the month start at 1.
+        "* * * 13 *",      // Month out of range.
+        "* * * * 8",       // Day of week out of range.
+        "5-1 * * * *",     // Backwards range.
+        "*/0 * * * *",     // Zero step.
+        "*/x * * * *",     // Bad step.
+        "a * * * *",       // Not a number.
+        "@every",          // No duration.
+        "@every -1m",      // Negative duration.
+        "@every 0s",       // Zero duration.
+        "@fortnightly",    // Unknown descriptor.
+    } {
+        if _, err := parseSchedule(spec); err == nil {
+            t.Errorf("parseSchedule(%q) succeeded, want an error", spec)
+        }
+    }
+}
+
+func TestScheduleNext(t *testing.T) {
+    at := func(s string) time.Time {
+        t.Helper()
+        v, err := time.Parse("2006-01-02 15:04", s)
+        if err != nil {
+            t.Fatalf("bad time %q in test table: %v", s, err)
+        }
+        return v
+    }
+    tests := []struct {
+        spec, after, want string
+    }{
+        {"* * * * *", "2026-10-15 09:30", "2026-10-15 09:31"},
+        {"*/15 * * * *", "2026-10-15 09:30", "2026-10-15 09:45"},
+        {"*/15 * * * *", "2026-10-15 09:50", "2026-10-15 10:00"},
+        {"5/20 * * * *", "2026-10-15 09:30", "2026-10-15 09:45"},
+        {"10-50/20 * * * *", "2026-10-15 09:51", "2026-10-15 10:10"},
+        {"0,30 9 * * *", "2026-10-15 09:00", "2026-10-15 09:30"},
+        {"0 2-4 * * *", "2026-10-15 04:00", "2026-10-16 02:00"},
+        {"@hourly", "2026-10-15 09:30", "2026-10-15 10:00"},
+        {"@daily", "2026-10-15 09:30", "2026-10-16 00:00"},
+        {"@weekly", "2026-10-15 09:30", "2026-10-18 00:00"}, // 2026-10-15 is a Thursday.
+        {"@monthly", "2026-10-15 09:30", "2026-11-01 00:00"},
+        {"@yearly", "2026-10-15 09:30", "2027-01-01 00:00"},
+        {"0 0 * * 7", "2026-10-15 09:30", "2026-10-18 00:00"},   // 7 is Sunday too.
+        {"0 0 * * 1-5", "2026-10-16 09:30", "2026-10-19 00:00"}, // Friday to Monday.
+        {"0 0 13 * 5", "2026-10-15 09:30", "2026-10-16 00:00"},  // Either day field is enough: Friday the 16th.
+        {"0 0 13 * *", "2026-10-15 09:30", "2026-11-13 00:00"},
+        {"0 0 29 2 *", "2026-03-01 00:00", "2028-02-29 00:00"},   // Leap day.
+        {"0 12 31 * *", "2026-04-30 12:00", "2026-05-31 12:00"}, // Skips months without a 31st.
+    }
+    for _, tt := range tests {
+        s, err := parseSchedule(tt.spec)
+        if err != nil {
+            t.Errorf("parseSchedule(%q): %v", tt.spec, err)
+            continue
+        }
+        if got := s.Next(at(tt.after)); !got.Equal(at(tt.want)) {
+            t.Errorf("%q after %s = %s, want %s", tt.spec, tt.after, got.Format("2006-01-02 15:04"), tt.want)
+        }
+    }
+
+    s, _ := parseSchedule("0 0 30 2 *")
+    if got := s.Next(at("2026-10-15 09:30")); !got.IsZero() {
+        t.Errorf("a schedule that never matches gave %s, want the zero time", got)
+    }
+    s, _ = parseSchedule("@every 90m")
+    if got := s.Next(at("2026-10-15 09:30")); !got.Equal(at("2026-10-15 11:00")) {
+        t.Errorf("@every 90m after 09:30 = %s, want 11:00", got)
+    }
+}
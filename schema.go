@@ -0,0 +1,321 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "math"
+    "net/http"
+    "path"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Schema is the subset of JSON Schema used to describe request bodies. The
+// same values validate requests and are published at GET /schemas, so the
+// documented shape and the enforced one can't drift apart.
+type Schema struct {
+    Type       string             `json:"type,omitempty"` // object, array, string, integer, number or boolean.
+    Properties map[string]*Schema `json:"properties,omitempty"`
+    Required   []string           `json:"required,omitempty"`
+    Items      *Schema            `json:"items,omitempty"`
+    Additional *Schema            `json:"additionalProperties,omitempty"` // Schema for properties not listed, as in a map.
+    Enum       []string           `json:"enum,omitempty"`
+    MinLength  int                `json:"minLength,omitempty"`
+    Minimum    *float64           `json:"minimum,omitempty"`
+    Format     string             `json:"format,omitempty"` // Only "date" (YYYY-MM-DD) is checked.
+}
+
+// RouteSchema ties a request body schema to a method and path pattern.
+type RouteSchema struct {
+    Method string  `json:"method"`
+    Path   string  `json:"path"` // path.Match pattern, e.g. /book/*.
+    Schema *Schema `json:"schema"`
+}
+
+// schemaError lists every way a body failed its schema.
+type schemaError []string
+
+func (e schemaError) Error() string {
+    return "request body does not match the schema: " + strings.Join(e, "; ")
+}
+
+// Shorthands for building schemas.
+func str() *Schema                   { return &Schema{Type: "string"} }
+func nonEmpty() *Schema              { return &Schema{Type: "string", MinLength: 1} }
+func date() *Schema                  { return &Schema{Type: "string", Format: "date"} }
+func timestamp() *Schema             { return &Schema{Type: "string", Format: "date-time"} }
+func oneOf(values ...string) *Schema { return &Schema{Type: "string", Enum: values} }
+func arrayOf(items *Schema) *Schema  { return &Schema{Type: "array", Items: items} }
+func mapOf(values *Schema) *Schema   { return &Schema{Type: "object", Additional: values} }
+func boolean() *Schema               { return &Schema{Type: "boolean"} }
+func atLeast(min float64) *Schema    { return &Schema{Type: "integer", Minimum: &min} }
+func object(required []string, props map[string]*Schema) *Schema {
+    return &Schema{Type: "object", Properties: props, Required: required}
+}
+
+// sortedKeys returns the keys of a set in order, for enums built from lookup maps.
+func sortedKeys(set map[string]bool) []string {
+    keys := make([]string, 0, len(set))
+    for k := range set {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// routeSchemas lists the request body schemas by route. Handlers still run
+// their own checks for things a schema can't express, such as references
+// to other records.
+var routeSchemas []RouteSchema
+
+func init() {
+    book := func(required ...string) *Schema {
+        return object(required, map[string]*Schema{
+            "id":               nonEmpty(),
+            "title":            str(),
+            "description":      str(),
+            "language":         str(),
+            "titles":           mapOf(str()),
+            "descriptions":     mapOf(str()),
+            "author":           str(),
+            "author_id":        str(),
+            "isbn":             str(),
+            "publication_year": atLeast(1),
+            "genres":           arrayOf(nonEmpty()),
+            "tags":             arrayOf(nonEmpty()),
+            "publisher_id":     str(),
+            "edition":          atLeast(1),
+            "format":           oneOf(FormatHardcover, FormatPaperback, FormatEbook),
+            "visibility":       oneOf(VisibilityDraft, VisibilityPublished, VisibilityArchived),
+            "price_cents":      atLeast(0),
+            "custom":           customFieldsSchema(),
+            "metadata":         mapOf(str()),
+            "created_at":       timestamp(), // Accepted so a fetched book can be sent back; the server sets both.
+            "updated_at":       timestamp(),
+            "version":          atLeast(0),
+        })
+    }
+    newBook := book() // Strategies other than user generate a missing ID.
+    if idStrategy == IDUser {
+        newBook = book("id")
+    }
+    copyUpdate := object(nil, map[string]*Schema{
+        "condition":   oneOf(ConditionNew, ConditionGood, ConditionFair, ConditionPoor),
+        "status":      oneOf(CopyAvailable, CopyInRepair, CopyWithdrawn),
+        "note":        str(),
+        "location_id": str(),
+    })
+    location := func(required ...string) *Schema {
+        return object(required, map[string]*Schema{"id": nonEmpty(), "branch": nonEmpty(), "room": str(), "shelf": str()})
+    }
+    author := object([]string{"name"}, map[string]*Schema{
+        "name":       nonEmpty(),
+        "sort_name":  str(),
+        "birth_year": atLeast(1),
+        "death_year": atLeast(1),
+        "bio":        str(),
+    })
+    genre := func(required ...string) *Schema {
+        return object(required, map[string]*Schema{"id": nonEmpty(), "name": nonEmpty(), "parent": str()})
+    }
+    publisher := object([]string{"name"}, map[string]*Schema{
+        "name":    nonEmpty(),
+        "country": str(),
+        "website": str(),
+    })
+    purchaseOrder := object([]string{"vendor", "budget_line", "ordered_date"}, map[string]*Schema{
+        "vendor":        nonEmpty(),
+        "cost_cents":    atLeast(0),
+        "budget_line":   nonEmpty(),
+        "ordered_date":  date(),
+        "received_date": date(),
+        "copy_ids":      arrayOf(str()),
+    })
+    routeSchemas = []RouteSchema{
+        {"POST", "/books", newBook},
+        {"POST", "/books/lookup", object([]string{"isbn"}, map[string]*Schema{
+            "isbn":   nonEmpty(),
+            "create": boolean(),
+            "id":     nonEmpty(),
+        })},
+        {"PUT", "/book/*", book()},
+        {"POST", "/book/*/copies", copyUpdate},
+        {"POST", "/book/*/reviews", object([]string{"rating"}, map[string]*Schema{
+            "rating": atLeast(1),
+            "body":   str(),
+        })},
+        {"PUT", "/copy/*", copyUpdate},
+        {"POST", "/copies/relocate", object([]string{"to_location_id"}, map[string]*Schema{
+            "copy_ids":         arrayOf(nonEmpty()),
+            "from_location_id": str(),
+            "to_location_id":   nonEmpty(),
+        })},
+        {"POST", "/locations", location("id", "branch")},
+        {"PUT", "/location/*", location("branch")},
+        {"POST", "/authors", author},
+        {"PUT", "/author/*", author},
+        {"POST", "/author/*/books", newBook},
+        {"POST", "/genres", genre("id", "name")},
+        {"PUT", "/genre/*", genre("name")},
+        {"POST", "/publishers", publisher},
+        {"PUT", "/publisher/*", publisher},
+        {"POST", "/purchase-orders", purchaseOrder},
+        {"PUT", "/purchase-order/*", purchaseOrder},
+        {"POST", "/weeding", object([]string{"copy_id", "reason_code"}, map[string]*Schema{
+            "copy_id":     nonEmpty(),
+            "reason_code": oneOf(sortedKeys(weedingReasons)...),
+            "note":        str(),
+        })},
+        {"POST", "/ill-requests", object([]string{"title", "requested_by"}, map[string]*Schema{
+            "title":        nonEmpty(),
+            "author":       str(),
+            "isbn":         str(),
+            "requested_by": nonEmpty(),
+            "note":         str(),
+        })},
+        {"PUT", "/ill-request/*", object(nil, map[string]*Schema{
+            "status":          oneOf(ILLBorrowed, ILLReturned, ILLCancelled),
+            "lending_library": str(),
+            "note":            str(),
+        })},
+        {"POST", "/sync", object([]string{"changes"}, map[string]*Schema{
+            "policy": oneOf(SyncLastWriteWins, SyncMerge, SyncManual),
+            "changes": arrayOf(object([]string{"op", "id"}, map[string]*Schema{
+                "op":           oneOf(SyncUpsert, SyncDelete),
+                "id":           nonEmpty(),
+                "book":         book(),
+                "base_version": atLeast(0),
+            })),
+        })},
+        {"POST", "/searches", object([]string{"name", "query"}, map[string]*Schema{
+            "name": nonEmpty(),
+            "query": object(nil, map[string]*Schema{
+                "q":        str(),
+                "language": str(),
+                "location": str(),
+            }),
+            "alert": object(nil, map[string]*Schema{
+                "webhook": str(),
+                "email":   str(),
+            }),
+        })},
+        {"POST", "/admin/templates", object([]string{"name", "subject", "body"}, map[string]*Schema{
+            "name":    nonEmpty(),
+            "subject": nonEmpty(),
+            "body":    nonEmpty(),
+        })},
+        {"PUT", "/admin/template/*", object([]string{"subject", "body"}, map[string]*Schema{
+            "subject": nonEmpty(),
+            "body":    nonEmpty(),
+        })},
+        {"POST", "/admin/template/*/preview", object(nil, map[string]*Schema{
+            "data":    object(nil, nil),
+            "subject": str(),
+            "body":    str(),
+        })},
+        {"PUT", "/admin/read-only", object(nil, map[string]*Schema{
+            "read_only":           boolean(),
+            "retry_after_seconds": atLeast(0),
+        })},
+    }
+}
+
+// schemaFor returns the body schema for a request, or nil if the route has none.
+func schemaFor(r *http.Request) *Schema {
+    for _, rs := range routeSchemas {
+        if ok, _ := path.Match(rs.Path, r.URL.Path); ok && rs.Method == r.Method {
+            return rs.Schema
+        }
+    }
+    return nil
+}
+
+// validate checks a decoded JSON value against the schema, returning one
+// message per problem, each prefixed with where in the body it was found.
+func (s *Schema) validate(v interface{}, at string) []string {
+    where := at
+    if where == "" {
+        where = "body"
+    }
+    var errs []string
+    switch s.Type {
+    case "object":
+        obj, ok := v.(map[string]interface{})
+        if !ok {
+            return []string{where + " must be an object"}
+        }
+        for _, name := range s.Required {
+            if _, ok := obj[name]; !ok {
+                errs = append(errs, joinPath(at, name)+" is required")
+            }
+        }
+        names := make([]string, 0, len(obj))
+        for name := range obj {
+            names = append(names, name)
+        }
+        sort.Strings(names) // Stable error order.
+        for _, name := range names {
+            prop, ok := s.Properties[name]
+            if !ok {
+                prop = s.Additional
+            }
+            if prop != nil && obj[name] != nil {
+                errs = append(errs, prop.validate(obj[name], joinPath(at, name))...)
+            }
+        }
+    case "array":
+        arr, ok := v.([]interface{})
+        if !ok {
+            return []string{where + " must be an array"}
+        }
+        for i, item := range arr {
+            errs = append(errs, s.Items.validate(item, fmt.Sprintf("%s[%d]", at, i))...)
+        }
+    case "string":
+        text, ok := v.(string)
+        if !ok {
+            return []string{where + " must be a string"}
+        }
+        if len(text) < s.MinLength {
+            errs = append(errs, where+" must not be empty")
+        }
+        if len(s.Enum) > 0 && !contains(s.Enum, text) {
+            errs = append(errs, where+" must be one of "+strings.Join(s.Enum, ", "))
+        }
+        if _, err := time.Parse(dateLayout, text); s.Format == "date" && text != "" && err != nil {
+            errs = append(errs, where+" must be a date (YYYY-MM-DD)")
+        }
+    case "integer", "number":
+        n, ok := v.(float64)
+        if !ok || (s.Type == "integer" && n != math.Trunc(n)) {
+            return []string{where + " must be " + map[string]string{"integer": "an integer", "number": "a number"}[s.Type]}
+        }
+        if s.Minimum != nil && n < *s.Minimum {
+            errs = append(errs, fmt.Sprintf("%s must be at least %g", where, *s.Minimum))
+        }
+    case "boolean":
+        if _, ok := v.(bool); !ok {
+            return []string{where + " must be true or false"}
+        }
+    }
+    return errs
+}
+
+// joinPath appends a field name to a location in the body.
+func joinPath(at, name string) string {
+    if at == "" {
+        return name
+    }
+    return at + "." + name
+}
+
+// handleSchemas handles requests for the /schemas route, publishing the
+// request body schema of every route that has one.
+func handleSchemas(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    json.NewEncoder(w).Encode(routeSchemas)
+}
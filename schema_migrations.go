@@ -0,0 +1,191 @@
+package main
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "io/fs"
+    "log"
+    "path"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// migrateCmd runs schema migrations for the configured store and exits
+// instead of starting the server: --migrate=up applies every pending
+// migration, --migrate=down rolls back the latest one and --migrate=status
+// lists them.
+var migrateCmd = flag.String("migrate", "", "apply (up) or roll back (down) schema migrations for STORE, or list them (status), then exit")
+
+// Migration struct defines one versioned schema change, read from a pair of
+// files named 0001_name.up.sql and 0001_name.down.sql.
+type Migration struct {
+    Version int
+    Name    string
+    Up      string
+    Down    string // Empty if the migration can't be rolled back.
+}
+
+// Migrator is implemented by SQL stores to apply migrations and keep track
+// of them in a schema_migrations table. An open Migrator holds a lock so
+// that instances starting together don't migrate twice.
+type Migrator interface {
+    Applied() (map[int]time.Time, error) // Applied versions and when they ran.
+    Apply(m Migration) error             // Run Up and record the version, in one transaction.
+    Revert(m Migration) error            // Run Down and forget the version, in one transaction.
+    Close()
+}
+
+// migrationSource is a driver's embedded migrations and how to open its Migrator.
+type migrationSource struct {
+    files fs.FS
+    open  func(dsn string) (Migrator, error)
+}
+
+// migrationSources holds the stores that have schema migrations, by driver name.
+var migrationSources = make(map[string]migrationSource)
+
+// registerMigrations makes a store driver's migrations available to --migrate.
+// files holds the .sql files at its root.
+func registerMigrations(driver string, files fs.FS, open func(dsn string) (Migrator, error)) {
+    migrationSources[driver] = migrationSource{files: files, open: open}
+}
+
+// loadMigrations reads the migrations in files, oldest first.
+func loadMigrations(files fs.FS) ([]Migration, error) {
+    names, err := fs.Glob(files, "*.sql")
+    if err != nil {
+        return nil, err
+    }
+    byVersion := make(map[int]*Migration)
+    for _, file := range names {
+        base, direction, ok := strings.Cut(strings.TrimSuffix(path.Base(file), ".sql"), ".")
+        num, name, _ := strings.Cut(base, "_")
+        version, err := strconv.Atoi(num)
+        if !ok || err != nil || version <= 0 || (direction != "up" && direction != "down") {
+            return nil, errors.New("migration file " + file + " is not named like 0001_name.up.sql")
+        }
+        data, err := fs.ReadFile(files, file)
+        if err != nil {
+            return nil, err
+        }
+        m := byVersion[version]
+        if m == nil {
+            m = &Migration{Version: version, Name: name}
+            byVersion[version] = m
+        } else if m.Name != name {
+            return nil, fmt.Errorf("migration %d is named both %q and %q", version, m.Name, name)
+        }
+        if direction == "up" {
+            m.Up = string(data)
+        } else {
+            m.Down = string(data)
+        }
+    }
+    ms := make([]Migration, 0, len(byVersion))
+    for _, m := range byVersion {
+        if m.Up == "" {
+            return nil, fmt.Errorf("migration %d has no up file", m.Version)
+        }
+        ms = append(ms, *m)
+    }
+    sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+    return ms, nil
+}
+
+// migrateUp applies every migration that hasn't been applied yet, in order.
+func migrateUp(mg Migrator, ms []Migration) error {
+    applied, err := mg.Applied()
+    if err != nil {
+        return err
+    }
+    for _, m := range ms {
+        if _, ok := applied[m.Version]; ok {
+            continue
+        }
+        if err := mg.Apply(m); err != nil {
+            return fmt.Errorf("migration %d (%s): %v", m.Version, m.Name, err)
+        }
+        log.Printf("applied migration %d (%s)", m.Version, m.Name)
+    }
+    return nil
+}
+
+// migrateDown rolls back the latest applied migration.
+func migrateDown(mg Migrator, ms []Migration) error {
+    applied, err := mg.Applied()
+    if err != nil {
+        return err
+    }
+    for i := len(ms) - 1; i >= 0; i-- {
+        m := ms[i]
+        if _, ok := applied[m.Version]; !ok {
+            continue
+        }
+        if m.Down == "" {
+            return fmt.Errorf("migration %d (%s) has no down file and can't be rolled back", m.Version, m.Name)
+        }
+        if err := mg.Revert(m); err != nil {
+            return fmt.Errorf("migration %d (%s): %v", m.Version, m.Name, err)
+        }
+        log.Printf("rolled back migration %d (%s)", m.Version, m.Name)
+        return nil
+    }
+    log.Print("no migrations to roll back")
+    return nil
+}
+
+// pendingMigrations counts the migrations that haven't been applied.
+func pendingMigrations(mg Migrator, ms []Migration) (int, error) {
+    applied, err := mg.Applied()
+    if err != nil {
+        return 0, err
+    }
+    pending := 0
+    for _, m := range ms {
+        if _, ok := applied[m.Version]; !ok {
+            pending++
+        }
+    }
+    return pending, nil
+}
+
+// runMigrateCommand carries out --migrate against STORE and STORE_DSN.
+func runMigrateCommand(cmd string) error {
+    src, ok := migrationSources[storeDriver]
+    if !ok {
+        return errors.New("store " + storeDriver + " has no schema migrations")
+    }
+    ms, err := loadMigrations(src.files)
+    if err != nil {
+        return err
+    }
+    mg, err := src.open(storeDSN)
+    if err != nil {
+        return err
+    }
+    defer mg.Close()
+    switch cmd {
+    case "up":
+        return migrateUp(mg, ms)
+    case "down":
+        return migrateDown(mg, ms)
+    case "status":
+        applied, err := mg.Applied()
+        if err != nil {
+            return err
+        }
+        for _, m := range ms {
+            state := "pending"
+            if at, ok := applied[m.Version]; ok {
+                state = "applied " + at.UTC().Format(time.RFC3339)
+            }
+            fmt.Printf("%04d %-30s %s\n", m.Version, m.Name, state)
+        }
+        return nil
+    default:
+        return errors.New("--migrate must be up, down or status, not " + cmd)
+    }
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+    "encoding/json"
+    "reflect"
+    "testing"
+)
+
+// decode unmarshals a JSON literal in a test table, failing the test if it
+// doesn't parse.
+func decode(t *testing.T, s string) interface{} {
+    t.Helper()
+    var v interface{}
+    if err := json.Unmarshal([]byte(s), &v); err != nil {
+        t.Fatalf("bad JSON %q in test table: %v", s, err)
+    }
+    return v
+}
+
+func TestSchemaValidate(t *testing.T) {
+    s := object([]string{"name", "count"}, map[string]*Schema{
+        "name":  nonEmpty(),
+        "count": atLeast(1),
+        "ratio": {Type: "number"},
+        "kind":  oneOf("a", "b"),
+        "when":  date(),
+        "flag":  boolean(),
+        "tags":  arrayOf(nonEmpty()),
+        "meta":  mapOf(str()),
+        "inner": object([]string{"id"}, map[string]*Schema{"id": nonEmpty()}),
+    })
+    tests := []struct {
+        name string
+        body string
+        want []string
+    }{
+        {"valid", `{"name":"x","count":2}`, nil},
+        {"everything valid", `{"name":"x","count":1,"ratio":0.5,"kind":"b","when":"2026-10-15","flag":true,"tags":["t"],"meta":{"k":"v"},"inner":{"id":"1"}}`, nil},
+        {"unknown fields pass", `{"name":"x","count":1,"other":[1]}`, nil},
+        {"null is skipped", `{"name":"x","count":1,"kind":null}`, nil},
+        {"empty date is allowed", `{"name":"x","count":1,"when":""}`, nil},
+        {"not an object", `[]`, []string{"body must be an object"}},
+        {"missing required", `{}`, []string{"name is required", "count is required"}},
+        {"empty string", `{"name":"","count":1}`, []string{"name must not be empty"}},
+        {"wrong type", `{"name":1,"count":"1"}`, []string{"count must be an integer", "name must be a string"}},
+        {"not an integer", `{"name":"x","count":1.5}`, []string{"count must be an integer"}},
+        {"below minimum", `{"name":"x","count":0}`, []string{"count must be at least 1"}},
+        {"not a number", `{"name":"x","count":1,"ratio":"1"}`, []string{"ratio must be a number"}},
+        {"not in enum", `{"name":"x","count":1,"kind":"c"}`, []string{"kind must be one of a, b"}},
+        {"bad date", `{"name":"x","count":1,"when":"15/10/2026"}`, []string{"when must be a date (YYYY-MM-DD)"}},
+        {"not a boolean", `{"name":"x","count":1,"flag":"yes"}`, []string{"flag must be true or false"}},
+        {"bad array item", `{"name":"x","count":1,"tags":["ok",""]}`, []string{"tags[1] must not be empty"}},
+        {"not an array", `{"name":"x","count":1,"tags":"t"}`, []string{"tags must be an array"}},
+        {"bad map value", `{"name":"x","count":1,"meta":{"k":1}}`, []string{"meta.k must be a string"}},
+        {"nested required", `{"name":"x","count":1,"inner":{}}`, []string{"inner.id is required"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := s.validate(decode(t, tt.body), ""); !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("validate = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
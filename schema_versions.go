@@ -0,0 +1,201 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// SchemaChange is a breaking change to the JSON shape of some routes, such as
+// a renamed or removed field. Clients that pin an earlier schema version
+// with X-API-Schema get responses turned back into the shape they know, and
+// may keep sending request bodies in it.
+type SchemaChange struct {
+    Version     string                           // Day the change took effect, YYYY-MM-DD.
+    Description string                           // What changed, for GET /capabilities.
+    Routes      []string                         // Route patterns it applies to, as passed to http.HandleFunc.
+    Down        func(obj map[string]interface{}) // Turns a response object into its shape before the change.
+    Up          func(obj map[string]interface{}) // Turns a request object in the old shape into the new one. Optional.
+}
+
+// SchemaVersion describes one schema version in GET /capabilities.
+type SchemaVersion struct {
+    Version string `json:"version"`
+    Changes string `json:"changes,omitempty"`
+}
+
+// schemaBaseline is the first schema version: the shape of the API when
+// clients could first pin one.
+const schemaBaseline = "2026-10-15"
+
+// schemaChanges are the breaking changes since schemaBaseline, oldest first,
+// e.g.
+//
+//	{Version: "2027-03-01", Description: "author is now author_name", Routes: []string{"/books", "/book/"},
+//	    Down: func(b map[string]interface{}) { b["author"] = b["author_name"]; delete(b, "author_name") },
+//	    Up:   func(b map[string]interface{}) { b["author_name"] = b["author"]; delete(b, "author") }},
+//
+// Down is applied to the response body if it is an object, or to each
+// object in it if it is an array.
+var schemaChanges = []SchemaChange{}
+
+// currentSchemaVersion is the version the server's own models are in.
+func currentSchemaVersion() string {
+    if len(schemaChanges) == 0 {
+        return schemaBaseline
+    }
+    return schemaChanges[len(schemaChanges)-1].Version
+}
+
+// schemaVersions lists every version, oldest first.
+func schemaVersions() []SchemaVersion {
+    versions := []SchemaVersion{{Version: schemaBaseline}}
+    for _, c := range schemaChanges {
+        versions = append(versions, SchemaVersion{Version: c.Version, Changes: c.Description})
+    }
+    return versions
+}
+
+// resolveSchemaVersion maps a pinned date to the version in force on that
+// day. Days before the baseline get the baseline, so an integration written
+// before versioning existed can pin the day it was written.
+func resolveSchemaVersion(pinned string) (string, error) {
+    if _, err := time.Parse(dateLayout, pinned); err != nil {
+        return "", errors.New("X-API-Schema must be a date such as " + currentSchemaVersion())
+    }
+    version := schemaBaseline
+    for _, c := range schemaChanges {
+        if c.Version <= pinned { // YYYY-MM-DD compares as text.
+            version = c.Version
+        }
+    }
+    return version, nil
+}
+
+// pendingSchemaChanges returns the changes made after a pinned version that
+// apply to a route, oldest first.
+func pendingSchemaChanges(pinned, route string) []SchemaChange {
+    var pending []SchemaChange
+    for _, c := range schemaChanges {
+        if c.Version > pinned && contains(c.Routes, route) {
+            pending = append(pending, c)
+        }
+    }
+    return pending
+}
+
+// eachObject calls fn on a JSON value if it is an object, or on each object
+// in it if it is an array.
+func eachObject(v interface{}, fn func(map[string]interface{})) {
+    switch v := v.(type) {
+    case map[string]interface{}:
+        fn(v)
+    case []interface{}:
+        for _, item := range v {
+            if obj, ok := item.(map[string]interface{}); ok {
+                fn(obj)
+            }
+        }
+    }
+}
+
+// schemaETag marks a handler's ETag as that of the body turned back to a
+// pinned version, which is a different representation from the one the
+// handler rendered.
+func schemaETag(etag, version string) string {
+    if !strings.HasSuffix(etag, `"`) {
+        return etag
+    }
+    return strings.TrimSuffix(etag, `"`) + "@" + version + `"`
+}
+
+// untagSchemaETags strips the mark schemaETag adds from the ETags in an
+// If-Match or If-None-Match header, so handlers compare them with their own.
+func untagSchemaETags(header, version string) string {
+    return strings.ReplaceAll(header, "@"+version+`"`, `"`)
+}
+
+// withSchemaVersion is the middleware behind X-API-Schema. Every response
+// says which version it is in. When the pinned version predates changes to
+// the route, the request body is brought up to date and the response is
+// buffered and turned back, newest change first; otherwise requests pass
+// straight through.
+func withSchemaVersion(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Add("Vary", "X-API-Schema")
+        pinned := currentSchemaVersion()
+        if v := r.Header.Get("X-API-Schema"); v != "" {
+            var err error
+            if pinned, err = resolveSchemaVersion(v); err != nil {
+                writeError(w, "invalid_header", err.Error())
+                return
+            }
+        }
+        w.Header().Set("X-API-Schema", pinned)
+        _, route := http.DefaultServeMux.Handler(r)
+        pending := pendingSchemaChanges(pinned, route)
+        if len(pending) == 0 {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        if r.Body != nil && r.ContentLength != 0 {
+            data, err := io.ReadAll(r.Body)
+            if err != nil {
+                writeError(w, "invalid_json", err.Error())
+                return
+            }
+            var body interface{}
+            if json.Unmarshal(data, &body) == nil {
+                for _, c := range pending {
+                    if c.Up != nil {
+                        eachObject(body, c.Up)
+                    }
+                }
+                data, _ = json.Marshal(body)
+            }
+            r.Body, r.ContentLength = io.NopCloser(bytes.NewReader(data)), int64(len(data)) // Bodies that aren't JSON are left for the handler to refuse.
+        }
+        for _, name := range []string{"If-Match", "If-None-Match"} {
+            if v := r.Header.Get(name); v != "" {
+                r.Header.Set(name, untagSchemaETags(v, pinned))
+            }
+        }
+
+        rec := &batchRecorder{header: w.Header().Clone()} // Keeps the headers set so far, such as Vary.
+        next.ServeHTTP(rec, r)
+        if rec.status == 0 {
+            rec.status = http.StatusOK
+        }
+        data := rec.body.Bytes()
+        var body interface{}
+        if rec.status < 300 && json.Unmarshal(data, &body) == nil { // Error envelopes keep one shape across versions.
+            for i := len(pending) - 1; i >= 0; i-- {
+                eachObject(body, pending[i].Down)
+            }
+            data, _ = json.Marshal(body)
+            data = append(data, '\n')
+        }
+        for k, v := range rec.header {
+            w.Header()[k] = v
+        }
+        if etag := w.Header().Get("ETag"); etag != "" {
+            w.Header().Set("ETag", schemaETag(etag, pinned)) // Also on a 304, so it matches what the client holds.
+        }
+        w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+        w.WriteHeader(rec.status)
+        w.Write(data)
+    })
+}
+
+func init() {
+    if !sort.SliceIsSorted(schemaChanges, func(i, j int) bool { return schemaChanges[i].Version < schemaChanges[j].Version }) {
+        panic("schemaChanges must be listed oldest first")
+    }
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "net/smtp"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// searchAlertTemplate is the notification template used for alert emails, if
+// an admin has defined one; otherwise defaultSearchAlert is used.
+const searchAlertTemplate = "search-alert"
+
+// defaultSearchAlert is the wording of alert emails without a search-alert template.
+var defaultSearchAlert = NotificationTemplate{
+    Name:    searchAlertTemplate,
+    Subject: `{{len .Books}} new book(s) matching "{{.Search.Name}}"`,
+    Body:    "{{range .Books}}- {{.Title}} (/book/{{.ID}})\n{{end}}",
+}
+
+var (
+    // searchAlertInterval is how often saved searches with alerts are checked
+    // against the books added since the last check.
+    searchAlertInterval = envDuration("SEARCH_ALERT_INTERVAL", 5*time.Minute)
+
+    smtpAddr     = envString("SMTP_ADDR", "") // host:port of the mail server for email alerts; unset disables them.
+    smtpFrom     = envString("SMTP_FROM", "library@localhost")
+    smtpUsername = envString("SMTP_USERNAME", "") // PLAIN auth, if the server needs it.
+    smtpPassword = envString("SMTP_PASSWORD", "")
+)
+
+// SearchQuery struct defines the filters of a saved search. They mean the
+// same as the GET /books and /opds/books query parameters.
+type SearchQuery struct {
+    Q        string `json:"q,omitempty"`        // Text in the title or description, in any language.
+    Language string `json:"language,omitempty"` // Original language.
+    Location string `json:"location,omitempty"` // Location ID or branch holding a copy.
+}
+
+// SearchAlert struct defines where to send news of matching books. At least
+// one of Webhook and Email is set.
+type SearchAlert struct {
+    Webhook string `json:"webhook,omitempty"` // URL that receives a JSON POST.
+    Email   string `json:"email,omitempty"`   // Address that receives a message.
+}
+
+// SavedSearch struct defines a named search kept for the API key that made it.
+type SavedSearch struct {
+    ID          string       `json:"id"`
+    Name        string       `json:"name"`
+    Query       SearchQuery  `json:"query"`
+    Alert       *SearchAlert `json:"alert,omitempty"`         // Notify about newly added books that match.
+    LastAlertAt *time.Time   `json:"last_alert_at,omitempty"` // When the last alert went out.
+    LastError   string       `json:"last_error,omitempty"`    // Why the last alert could not be delivered.
+    CreatedAt   time.Time    `json:"created_at"`
+    owner       string       // API key that created the search.
+    cursor      int64        // Change log seq up to which books have been checked.
+}
+
+// SearchAlertPayload is the body POSTed to an alert webhook.
+type SearchAlertPayload struct {
+    Search SavedSearch `json:"search"`
+    Books  []Book      `json:"books"` // Newly added books that match, oldest first.
+}
+
+var (
+    savedSearches = make(map[string]SavedSearch) // Map to store saved searches with their ID as the key.
+    searchSeq     int                            // Last saved search ID handed out.
+    searchesMux   sync.RWMutex                   // RWMutex to safeguard savedSearches and searchSeq.
+)
+
+func init() {
+    registerHealthCheck(HealthCheck{Name: "search-webhooks", Check: pingSearchWebhooks})
+    if smtpAddr != "" {
+        registerHealthCheck(HealthCheck{Name: "smtp", Check: func(ctx context.Context) error { return dialTCP(ctx, smtpAddr) }})
+    }
+}
+
+// dialTCP checks that something accepts connections at addr.
+func dialTCP(ctx context.Context, addr string) error {
+    var d net.Dialer
+    conn, err := d.DialContext(ctx, "tcp", addr)
+    if err != nil {
+        return err
+    }
+    return conn.Close()
+}
+
+// pingSearchWebhooks checks that every host receiving search alert webhooks
+// accepts connections. Nothing is sent to them.
+func pingSearchWebhooks(ctx context.Context) error {
+    hosts := make(map[string]bool)
+    searchesMux.RLock()
+    for _, s := range savedSearches {
+        if s.Alert == nil || s.Alert.Webhook == "" {
+            continue
+        }
+        if u, err := url.Parse(s.Alert.Webhook); err == nil {
+            port := u.Port()
+            if port == "" {
+                port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+            }
+            hosts[net.JoinHostPort(u.Hostname(), port)] = true
+        }
+    }
+    searchesMux.RUnlock()
+    var failed []string
+    for host := range hosts {
+        if err := dialTCP(ctx, host); err != nil {
+            failed = append(failed, host)
+        }
+    }
+    if len(failed) > 0 {
+        sort.Strings(failed)
+        return fmt.Errorf("%d of %d webhook hosts unreachable: %s", len(failed), len(hosts), strings.Join(failed, ", "))
+    }
+    return nil
+}
+
+// matches reports whether a book passes the query's filters. atLocation is
+// the result of bookIDsAtLocation for the query's location, if it has one.
+func (q SearchQuery) matches(book Book, atLocation map[string]bool) bool {
+    if q.Q != "" && !matchesQuery(book, q.Q) {
+        return false
+    }
+    if q.Language != "" && !strings.EqualFold(book.Language, q.Language) {
+        return false
+    }
+    return q.Location == "" || atLocation[book.ID]
+}
+
+// checkSearch reports the first problem with a saved search, if any.
+func checkSearch(s SavedSearch) (string, bool) {
+    if s.Name == "" {
+        return "name is required", false
+    }
+    if s.Query == (SearchQuery{}) {
+        return "query needs at least one of q, language and location", false
+    }
+    if s.Alert == nil {
+        return "", true
+    }
+    if s.Alert.Webhook == "" && s.Alert.Email == "" {
+        return "alert needs a webhook or an email", false
+    }
+    if s.Alert.Webhook != "" {
+        if u, err := url.Parse(s.Alert.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+            return "alert.webhook must be an http or https URL", false
+        }
+    }
+    if s.Alert.Email != "" {
+        if smtpAddr == "" {
+            return "email alerts are not configured on this server", false
+        }
+        if !strings.Contains(s.Alert.Email, "@") || strings.ContainsAny(s.Alert.Email, "\r\n") {
+            return "alert.email must be an email address", false
+        }
+    }
+    return "", true
+}
+
+// canSee reports whether the request's API key may read or delete a search.
+// Admins see every search.
+func canSee(r *http.Request, s SavedSearch) bool {
+    return isAdmin(r) || s.owner == r.Header.Get("X-API-Key")
+}
+
+// handleSearches handles requests for the /searches route.
+func handleSearches(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case "GET": // List the caller's saved searches.
+        searchesMux.RLock()
+        list := make([]SavedSearch, 0, len(savedSearches))
+        for _, s := range savedSearches {
+            if canSee(r, s) {
+                list = append(list, s)
+            }
+        }
+        searchesMux.RUnlock()
+        sort.Slice(list, func(i, j int) bool { return lessID(list[i].ID, list[j].ID) })
+        start, end, err := paginate(w, r, len(list))
+        if err != nil {
+            writePageError(w, err)
+            return
+        }
+        json.NewEncoder(w).Encode(list[start:end])
+
+    case "POST": // Save a search, optionally with an alert.
+        var s SavedSearch
+        if err := decodeJSON(r, &s); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        if msg, ok := checkSearch(s); !ok {
+            writeError(w, "validation_failed", msg)
+            return
+        }
+        s.LastAlertAt, s.LastError = nil, ""
+        s.CreatedAt = clock.Now().UTC()
+        s.owner = r.Header.Get("X-API-Key")
+        s.cursor = currentChangeSeq() // Alerts cover books added from now on.
+        searchesMux.Lock()
+        searchSeq++
+        s.ID = strconv.Itoa(searchSeq)
+        savedSearches[s.ID] = s
+        searchesMux.Unlock()
+        w.WriteHeader(http.StatusCreated)
+        json.NewEncoder(w).Encode(s)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// handleSearch handles requests for the /search/{id} and /search/{id}/results routes.
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+    id, sub, _ := strings.Cut(r.URL.Path[len("/search/"):], "/")
+    if sub != "" && sub != "results" {
+        writeError(w, "not_found", "no route for "+r.URL.Path)
+        return
+    }
+    searchesMux.RLock()
+    s, ok := savedSearches[id]
+    searchesMux.RUnlock()
+    if !ok || !canSee(r, s) { // Other keys' searches look missing rather than forbidden.
+        writeError(w, "search_not_found", "saved search "+id+" not found")
+        return
+    }
+    if sub == "results" {
+        handleSearchResults(w, r, s)
+        return
+    }
+
+    switch r.Method {
+    case "GET": // Retrieve a single saved search.
+        json.NewEncoder(w).Encode(s)
+
+    case "DELETE": // Delete a saved search and its alert.
+        searchesMux.Lock()
+        delete(savedSearches, id)
+        searchesMux.Unlock()
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// handleSearchResults handles GET /search/{id}/results, running the saved
+// search against the current catalog.
+func handleSearchResults(w http.ResponseWriter, r *http.Request, s SavedSearch) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    var atLocation map[string]bool
+    if s.Query.Location != "" {
+        atLocation = bookIDsAtLocation(s.Query.Location)
+    }
+    mux.RLock()
+    source, err := store.List()
+    mux.RUnlock()
+    if err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    if r.URL.Query().Get("page") == "" {
+        incrementCounter(CounterSearches, "")
+    }
+    bks := make([]Book, 0)
+    for _, book := range publishedOnly(source) {
+        if s.Query.matches(book, atLocation) {
+            bks = append(bks, book)
+        }
+    }
+    start, end, err := paginate(w, r, len(bks))
+    if err != nil {
+        writePageError(w, err)
+        return
+    }
+    w.Header().Add("Vary", "Accept-Language")
+    json.NewEncoder(w).Encode(renderBooks(bks[start:end], nil, acceptedLanguages(r)))
+}
+
+// checkSearchAlerts is the scheduled job that looks through the books added
+// since each alerting search was last checked and notifies about matches.
+// A failed delivery is recorded on the search and not retried; the next run
+// only looks at newer books.
+func checkSearchAlerts() error {
+    searchesMux.RLock()
+    var alerting []SavedSearch
+    for _, s := range savedSearches {
+        if s.Alert != nil {
+            alerting = append(alerting, s)
+        }
+    }
+    searchesMux.RUnlock()
+
+    failed := 0
+    for _, s := range alerting {
+        chs, cursor, _, ok := changesSince(s.cursor)
+        if !ok {
+            log.Printf("saved search %s: changes since the last check are no longer retained; skipping them", s.ID)
+        }
+        var atLocation map[string]bool
+        if s.Query.Location != "" {
+            atLocation = bookIDsAtLocation(s.Query.Location)
+        }
+        var found []Book
+        for _, c := range chs {
+            if c.Op == ChangeCreate && c.Book != nil && isPublished(*c.Book) && s.Query.matches(*c.Book, atLocation) {
+                found = append(found, *c.Book)
+            }
+        }
+        var sendErr error
+        if len(found) > 0 {
+            sendErr = sendSearchAlert(s, found)
+        }
+
+        searchesMux.Lock()
+        if cur, ok := savedSearches[s.ID]; ok { // It may have been deleted meanwhile.
+            cur.cursor = cursor
+            if len(found) > 0 {
+                now := clock.Now().UTC()
+                cur.LastAlertAt, cur.LastError = &now, ""
+                if sendErr != nil {
+                    cur.LastError = sendErr.Error()
+                }
+            }
+            savedSearches[s.ID] = cur
+        }
+        searchesMux.Unlock()
+        if sendErr != nil {
+            failed++
+            log.Printf("saved search %s: %v", s.ID, sendErr)
+        }
+    }
+    if failed > 0 {
+        return fmt.Errorf("%d of %d search alerts failed", failed, len(alerting))
+    }
+    return nil
+}
+
+// sendSearchAlert delivers one alert to the search's webhook and email.
+func sendSearchAlert(s SavedSearch, found []Book) error {
+    if s.Alert.Webhook != "" {
+        data, _ := json.Marshal(SearchAlertPayload{Search: s, Books: found})
+        client := http.Client{Timeout: 10 * time.Second}
+        resp, err := client.Post(s.Alert.Webhook, "application/json", bytes.NewReader(data))
+        if err != nil {
+            return fmt.Errorf("webhook: %v", err)
+        }
+        resp.Body.Close()
+        if resp.StatusCode >= 300 {
+            return fmt.Errorf("webhook answered %s", resp.Status)
+        }
+    }
+    if s.Alert.Email != "" {
+        templatesMux.RLock()
+        t, ok := templates[searchAlertTemplate]
+        templatesMux.RUnlock()
+        if !ok {
+            t = defaultSearchAlert
+        }
+        msg, err := renderTemplate(t, SearchAlertPayload{Search: s, Books: found})
+        if err != nil {
+            return fmt.Errorf("rendering %s template: %v", searchAlertTemplate, err)
+        }
+        if err := sendEmail(s.Alert.Email, msg); err != nil {
+            return fmt.Errorf("email: %v", err)
+        }
+    }
+    return nil
+}
+
+// sendEmail sends a rendered notification through the SMTP_ADDR server.
+func sendEmail(to string, msg TemplatePreview) error {
+    var auth smtp.Auth
+    if smtpUsername != "" {
+        host, _, _ := strings.Cut(smtpAddr, ":")
+        auth = smtp.PlainAuth("", smtpUsername, smtpPassword, host)
+    }
+    subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(msg.Subject) // Keep the subject on its header line.
+    body := "From: " + smtpFrom + "\r\nTo: " + to + "\r\nSubject: " + subject +
+        "\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n" + msg.Body
+    return smtp.SendMail(smtpAddr, auth, smtpFrom, []string{to}, []byte(body))
+}
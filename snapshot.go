@@ -0,0 +1,101 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+var (
+    // snapshotFile gives the in-memory store basic durability: the catalog is
+    // written there every snapshotInterval and on shutdown, and loaded back at
+    // startup. Snapshots are off when it is empty.
+    snapshotFile     = envString("SNAPSHOT_FILE", "")
+    snapshotInterval = envDuration("SNAPSHOT_INTERVAL", time.Minute)
+)
+
+// Snapshot is the contents of SNAPSHOT_FILE.
+type Snapshot struct {
+    TakenAt time.Time `json:"taken_at"`
+    Books   []Book    `json:"books"`
+}
+
+// readSnapshot reads SNAPSHOT_FILE. A missing file is an empty snapshot.
+func readSnapshot() (Snapshot, error) {
+    var snap Snapshot
+    data, err := os.ReadFile(snapshotFile)
+    if errors.Is(err, os.ErrNotExist) {
+        return snap, nil
+    } else if err != nil {
+        return snap, err
+    }
+    err = json.Unmarshal(data, &snap)
+    return snap, err
+}
+
+// loadSnapshot fills the store from SNAPSHOT_FILE at startup, before the
+// catalog is seeded. Only the memory store needs it; the others persist on
+// their own.
+func loadSnapshot() {
+    if snapshotFile == "" {
+        return
+    }
+    if storeDriver != "memory" {
+        log.Fatalf("SNAPSHOT_FILE only applies to STORE=memory, not %s", storeDriver)
+    }
+    snap, err := readSnapshot()
+    if err != nil {
+        log.Fatalf("reading SNAPSHOT_FILE: %v", err)
+    }
+    mux.Lock()
+    defer mux.Unlock()
+    for _, book := range snap.Books {
+        if err := store.Create(book); err != nil {
+            log.Fatalf("loading snapshot: book %s: %v", book.ID, err)
+        }
+    }
+    if len(snap.Books) > 0 {
+        log.Printf("loaded %d books from snapshot taken at %s", len(snap.Books), snap.TakenAt.Format(time.RFC3339))
+    }
+}
+
+// writeSnapshot writes the catalog to SNAPSHOT_FILE. It writes a temporary
+// file and renames it into place, so a crash mid-write leaves the previous
+// snapshot intact. Writers wait until it is done, so the write-ahead log can
+// be truncated at exactly the point the snapshot covers.
+func writeSnapshot() error {
+    mux.Lock()
+    defer mux.Unlock()
+    bks, err := store.List()
+    if err != nil {
+        return err
+    }
+    data, err := json.Marshal(Snapshot{TakenAt: clock.Now().UTC(), Books: bks})
+    if err != nil {
+        return err
+    }
+    f, err := os.CreateTemp(filepath.Dir(snapshotFile), ".snapshot-*")
+    if err != nil {
+        return err
+    }
+    if _, err = f.Write(data); err == nil {
+        err = f.Sync()
+    }
+    if cerr := f.Close(); err == nil {
+        err = cerr
+    }
+    if err == nil {
+        err = os.Rename(f.Name(), snapshotFile)
+    }
+    if err != nil {
+        os.Remove(f.Name())
+        return err
+    }
+    if wal != nil {
+        return wal.truncate() // Everything logged so far is in the snapshot.
+    }
+    return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "strconv"
+)
+
+// Stats is the response for GET /stats.
+type Stats struct {
+    Books           int              `json:"books"`            // Number of catalog records.
+    Copies          int              `json:"copies"`           // Number of physical copies.
+    AvailableCopies int              `json:"available_copies"` // Copies that can currently be lent out.
+    Acquisitions    []BudgetSpend    `json:"acquisitions"`     // Spend per budget line and fiscal year.
+    Counters        map[string]int64 `json:"counters"`         // Total views, downloads and searches, as of the last flush.
+}
+
+// handleStats handles requests for the /stats route. ?fiscal_year=2024 limits
+// the acquisitions summary to one fiscal year.
+func handleStats(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    year := 0
+    if v := r.URL.Query().Get("fiscal_year"); v != "" {
+        y, err := strconv.Atoi(v)
+        if err != nil {
+            writeError(w, "invalid_query", "fiscal_year must be a year, e.g. 2024")
+            return
+        }
+        year = y
+    }
+
+    var stats Stats
+    mux.RLock()
+    stats.Books = len(listBooks())
+    mux.RUnlock()
+    copiesMux.RLock()
+    stats.Copies = len(copies)
+    for _, c := range copies {
+        if c.isAvailable() {
+            stats.AvailableCopies++
+        }
+    }
+    copiesMux.RUnlock()
+
+    stats.Counters = counterSums()
+    stats.Acquisitions = make([]BudgetSpend, 0)
+    for _, s := range budgetSpend() {
+        if year == 0 || s.FiscalYear == year {
+            stats.Acquisitions = append(stats.Acquisitions, s)
+        }
+    }
+    json.NewEncoder(w).Encode(stats)
+}
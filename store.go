@@ -0,0 +1,193 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "log"
+    "sort"
+)
+
+// Errors returned by BookStore implementations.
+var (
+    errBookNotFound = errors.New("book not found")
+    errBookExists   = errors.New("book already exists")
+)
+
+// BookStore is where books are kept. Handlers go through it rather than a
+// map so a database-backed store can be swapped in without touching them.
+// Callers hold mux for every call, which keeps a write and its change log
+// entry together; implementations don't need their own locking for that.
+type BookStore interface {
+    Get(id string) (Book, bool, error) // The book, and whether it exists.
+    List() ([]Book, error)             // Every book, ordered by ID.
+    Create(book Book) error            // errBookExists if the ID is taken.
+    Update(book Book) error            // errBookNotFound if there is no such book.
+    Delete(id string) error            // Deleting a missing book is not an error.
+}
+
+// contextReader is implemented by stores whose reads go over the network, so
+// a request's reads can be abandoned when its client goes away. Writes have
+// no such variant: once started under mux they run to the end, so a
+// disconnect can't leave one half-applied or out of step with the change log.
+type contextReader interface {
+    GetContext(ctx context.Context, id string) (Book, bool, error)
+    ListContext(ctx context.Context) ([]Book, error)
+}
+
+// getWithContext is s.Get, given up when ctx is done. Callers hold mux.
+func getWithContext(ctx context.Context, s BookStore, id string) (Book, bool, error) {
+    if err := ctx.Err(); err != nil {
+        return Book{}, false, err
+    }
+    if cr, ok := s.(contextReader); ok {
+        return cr.GetContext(ctx, id)
+    }
+    return s.Get(id)
+}
+
+// listWithContext is s.List, given up when ctx is done. Callers hold mux.
+func listWithContext(ctx context.Context, s BookStore) ([]Book, error) {
+    if err := ctx.Err(); err != nil {
+        return nil, err
+    }
+    if cr, ok := s.(contextReader); ok {
+        return cr.ListContext(ctx)
+    }
+    return s.List()
+}
+
+var (
+    // store is the configured book store, replaced by openStore at startup.
+    store BookStore = newMemoryStore()
+
+    storeDriver = envString("STORE", "memory") // Name of the registered driver to keep books in.
+    storeDSN    = envString("STORE_DSN", "")   // Driver-specific connection string.
+)
+
+// storeDrivers holds the available storage backends by name. Database drivers
+// register themselves from files built in with a build tag.
+var storeDrivers = make(map[string]func(dsn string) (BookStore, error))
+
+func init() {
+    registerStoreDriver("memory", func(string) (BookStore, error) { return newMemoryStore(), nil })
+}
+
+// registerStoreDriver makes a storage backend available under a name.
+func registerStoreDriver(name string, open func(dsn string) (BookStore, error)) {
+    storeDrivers[name] = open
+}
+
+// openDriver opens a store with the named driver.
+func openDriver(name, dsn string) (BookStore, error) {
+    open, ok := storeDrivers[name]
+    if !ok {
+        return nil, errors.New("unknown store driver " + name)
+    }
+    return open(dsn)
+}
+
+// openStore opens the configured store, wrapped for dual writes when a shadow
+// store is configured.
+func openStore() (BookStore, error) {
+    primary, err := openDriver(storeDriver, storeDSN)
+    if err != nil {
+        return nil, err
+    }
+    if walFile != "" {
+        if storeDriver != "memory" {
+            return nil, errors.New("WAL_FILE only applies to STORE=memory, not " + storeDriver)
+        }
+        wal = &walStore{BookStore: primary}
+        primary = wal
+    }
+    if shadowDriver == "" {
+        return primary, nil
+    }
+    shadow, err := openDriver(shadowDriver, shadowDSN)
+    if err != nil {
+        return nil, errors.New("shadow store: " + err.Error())
+    }
+    return newDualStore(primary, shadow), nil
+}
+
+// saveBook creates or updates a book depending on the change operation. A
+// new book replaces any deleted one with its ID still in the trash. Callers
+// hold mux.
+func saveBook(op string, book Book) error {
+    if op == ChangeCreate {
+        if err := store.Create(book); err != nil {
+            return err
+        }
+        supersedeTrashed(book.ID)
+        return nil
+    }
+    return store.Update(book)
+}
+
+// memoryStore keeps books in a map. Everything is lost on restart.
+type memoryStore struct {
+    books map[string]Book // Map to store books with their ID as the key.
+}
+
+// newMemoryStore returns an empty in-memory store.
+func newMemoryStore() *memoryStore {
+    return &memoryStore{books: make(map[string]Book)}
+}
+
+func (s *memoryStore) Get(id string) (Book, bool, error) {
+    book, ok := s.books[id]
+    return book, ok, nil
+}
+
+func (s *memoryStore) List() ([]Book, error) {
+    bks := make([]Book, 0, len(s.books))
+    for _, book := range s.books {
+        bks = append(bks, book)
+    }
+    sort.Slice(bks, func(i, j int) bool { return lessID(bks[i].ID, bks[j].ID) })
+    return bks, nil
+}
+
+func (s *memoryStore) Create(book Book) error {
+    if _, ok := s.books[book.ID]; ok {
+        return errBookExists
+    }
+    s.books[book.ID] = book
+    return nil
+}
+
+func (s *memoryStore) Update(book Book) error {
+    if _, ok := s.books[book.ID]; !ok {
+        return errBookNotFound
+    }
+    s.books[book.ID] = book
+    return nil
+}
+
+func (s *memoryStore) Delete(id string) error {
+    delete(s.books, id)
+    return nil
+}
+
+// getBook looks a book up for code that only needs to know whether it exists,
+// such as policy and quota checks. A store failure is logged and treated as a
+// missing book. Callers hold mux.
+func getBook(id string) (Book, bool) {
+    book, ok, err := store.Get(id)
+    if err != nil {
+        log.Printf("reading book %s: %v", id, err)
+        return Book{}, false
+    }
+    return book, ok
+}
+
+// listBooks returns every book, logging and returning nothing on a store
+// failure. Callers hold mux.
+func listBooks() []Book {
+    bks, err := store.List()
+    if err != nil {
+        log.Printf("listing books: %v", err)
+        return nil
+    }
+    return bks
+}
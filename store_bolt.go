@@ -0,0 +1,112 @@
+//go:build bolt
+
+package main
+
+import (
+    "encoding/json"
+    "sort"
+    "time"
+
+    bolt "go.etcd.io/bbolt"
+)
+
+// boltBucket is the bucket books are kept in, keyed by ID.
+var boltBucket = []byte("books")
+
+func init() {
+    registerStoreDriver("bolt", openBolt)
+}
+
+// boltStore keeps books in an embedded bbolt file, for single-binary
+// deployments without a database server.
+type boltStore struct {
+    db *bolt.DB
+}
+
+// openBolt opens (creating if needed) the bbolt file at path, "books.db" if
+// empty.
+func openBolt(path string) (BookStore, error) {
+    if path == "" {
+        path = "books.db"
+    }
+    db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second}) // Fail rather than hang if another process has the file.
+    if err != nil {
+        return nil, err
+    }
+    err = db.Update(func(tx *bolt.Tx) error {
+        _, err := tx.CreateBucketIfNotExists(boltBucket)
+        return err
+    })
+    if err != nil {
+        db.Close()
+        return nil, err
+    }
+    return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Get(id string) (Book, bool, error) {
+    var book Book
+    var ok bool
+    err := s.db.View(func(tx *bolt.Tx) error {
+        data := tx.Bucket(boltBucket).Get([]byte(id))
+        if data == nil {
+            return nil
+        }
+        ok = true
+        return json.Unmarshal(data, &book)
+    })
+    return book, ok, err
+}
+
+func (s *boltStore) List() ([]Book, error) {
+    bks := []Book{}
+    err := s.db.View(func(tx *bolt.Tx) error {
+        return tx.Bucket(boltBucket).ForEach(func(_, data []byte) error {
+            var book Book
+            if err := json.Unmarshal(data, &book); err != nil {
+                return err
+            }
+            bks = append(bks, book)
+            return nil
+        })
+    })
+    if err != nil {
+        return nil, err
+    }
+    sort.Slice(bks, func(i, j int) bool { return lessID(bks[i].ID, bks[j].ID) }) // Keys are in byte order, not ID order.
+    return bks, nil
+}
+
+func (s *boltStore) Create(book Book) error {
+    return s.put(book, true)
+}
+
+func (s *boltStore) Update(book Book) error {
+    return s.put(book, false)
+}
+
+// put writes a book, failing if it already exists when creating or doesn't
+// exist when updating.
+func (s *boltStore) put(book Book, create bool) error {
+    data, err := json.Marshal(book)
+    if err != nil {
+        return err
+    }
+    return s.db.Update(func(tx *bolt.Tx) error {
+        b := tx.Bucket(boltBucket)
+        exists := b.Get([]byte(book.ID)) != nil
+        if create && exists {
+            return errBookExists
+        }
+        if !create && !exists {
+            return errBookNotFound
+        }
+        return b.Put([]byte(book.ID), data)
+    })
+}
+
+func (s *boltStore) Delete(id string) error {
+    return s.db.Update(func(tx *bolt.Tx) error {
+        return tx.Bucket(boltBucket).Delete([]byte(id))
+    })
+}
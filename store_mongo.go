@@ -0,0 +1,174 @@
+//go:build mongo
+
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "sort"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+    mongoDatabase = envString("MONGO_DATABASE", "library")
+    mongoTimeout  = envDuration("MONGO_TIMEOUT", 5*time.Second) // Limit on each operation.
+)
+
+func init() {
+    registerStoreDriver("mongo", openMongo)
+}
+
+// mongoStore keeps books in the "books" collection, one document per book
+// with the same fields as the API and the book ID as _id.
+type mongoStore struct {
+    books *mongo.Collection
+}
+
+// openMongo connects to the cluster at dsn, a mongodb:// URI, and creates
+// the title index if needed. _id is always indexed.
+func openMongo(dsn string) (BookStore, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+    defer cancel()
+    client, err := mongo.Connect(ctx, options.Client().ApplyURI(dsn))
+    if err != nil {
+        return nil, err
+    }
+    if err := client.Ping(ctx, nil); err != nil {
+        client.Disconnect(context.Background())
+        return nil, err
+    }
+    books := client.Database(mongoDatabase).Collection("books")
+    _, err = books.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "title", Value: 1}}})
+    if err != nil {
+        client.Disconnect(context.Background())
+        return nil, err
+    }
+    return &mongoStore{books: books}, nil
+}
+
+// toDocument converts a book to a document through its JSON form, so field
+// names match the API without bson tags on Book.
+func toDocument(book Book) (bson.M, error) {
+    data, err := json.Marshal(book)
+    if err != nil {
+        return nil, err
+    }
+    var doc bson.M
+    if err := bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+        return nil, err
+    }
+    delete(doc, "id")
+    doc["_id"] = book.ID
+    return doc, nil
+}
+
+// fromDocument is the reverse of toDocument.
+func fromDocument(doc bson.M) (Book, error) {
+    doc["id"] = doc["_id"]
+    delete(doc, "_id")
+    data, err := bson.MarshalExtJSON(doc, false, false)
+    if err != nil {
+        return Book{}, err
+    }
+    var book Book
+    err = json.Unmarshal(data, &book)
+    return book, err
+}
+
+func (s *mongoStore) Ping(ctx context.Context) error {
+    return s.books.Database().Client().Ping(ctx, nil)
+}
+
+func (s *mongoStore) Get(id string) (Book, bool, error) {
+    return s.GetContext(context.Background(), id)
+}
+
+// GetContext is Get, abandoned when ctx is done.
+func (s *mongoStore) GetContext(ctx context.Context, id string) (Book, bool, error) {
+    ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+    defer cancel()
+    var doc bson.M
+    err := s.books.FindOne(ctx, bson.M{"_id": id}).Decode(&doc)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return Book{}, false, nil
+    } else if err != nil {
+        return Book{}, false, err
+    }
+    book, err := fromDocument(doc)
+    return book, err == nil, err
+}
+
+func (s *mongoStore) List() ([]Book, error) {
+    return s.ListContext(context.Background())
+}
+
+// ListContext is List, abandoned when ctx is done.
+func (s *mongoStore) ListContext(ctx context.Context) ([]Book, error) {
+    ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+    defer cancel()
+    cur, err := s.books.Find(ctx, bson.M{})
+    if err != nil {
+        return nil, err
+    }
+    defer cur.Close(ctx)
+    bks := []Book{}
+    for cur.Next(ctx) {
+        var doc bson.M
+        if err := cur.Decode(&doc); err != nil {
+            return nil, err
+        }
+        book, err := fromDocument(doc)
+        if err != nil {
+            return nil, err
+        }
+        bks = append(bks, book)
+    }
+    if err := cur.Err(); err != nil {
+        return nil, err
+    }
+    sort.Slice(bks, func(i, j int) bool { return lessID(bks[i].ID, bks[j].ID) })
+    return bks, nil
+}
+
+func (s *mongoStore) Create(book Book) error {
+    doc, err := toDocument(book)
+    if err != nil {
+        return err
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+    defer cancel()
+    _, err = s.books.InsertOne(ctx, doc)
+    if mongo.IsDuplicateKeyError(err) {
+        return errBookExists
+    }
+    return err
+}
+
+func (s *mongoStore) Update(book Book) error {
+    doc, err := toDocument(book)
+    if err != nil {
+        return err
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+    defer cancel()
+    res, err := s.books.ReplaceOne(ctx, bson.M{"_id": book.ID}, doc)
+    if err != nil {
+        return err
+    }
+    if res.MatchedCount == 0 {
+        return errBookNotFound
+    }
+    return nil
+}
+
+func (s *mongoStore) Delete(id string) error {
+    ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+    defer cancel()
+    _, err := s.books.DeleteOne(ctx, bson.M{"_id": id})
+    return err
+}
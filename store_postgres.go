@@ -0,0 +1,277 @@
+//go:build postgres
+
+package main
+
+import (
+    "context"
+    "embed"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "sort"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+    postgresMaxConns = envInt("POSTGRES_MAX_CONNS", 10)               // Upper bound on pooled connections.
+    postgresTimeout  = envDuration("POSTGRES_TIMEOUT", 5*time.Second) // Limit on each statement.
+
+    // postgresMigrateOnStart applies pending schema migrations when the store
+    // opens. Turn it off to run them separately with --migrate=up; startup
+    // then refuses to use an out-of-date schema.
+    postgresMigrateOnStart = envBool("MIGRATE_ON_START", true)
+    postgresMigrateTimeout = envDuration("MIGRATE_TIMEOUT", 5*time.Minute) // Limit on a whole migration run, including waiting for the lock.
+)
+
+// postgresMigrationLock is the advisory lock key held while migrating.
+const postgresMigrationLock = 7_301_204
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrationFiles embed.FS
+
+// postgresStatements are prepared on every pooled connection, by name.
+var postgresStatements = map[string]string{
+    "get_book":    `SELECT data FROM books WHERE id = $1`,
+    "list_books":  `SELECT data FROM books`,
+    "create_book": `INSERT INTO books (id, data) VALUES ($1, $2) ON CONFLICT (id) DO NOTHING`,
+    "update_book": `UPDATE books SET data = $2 WHERE id = $1`,
+    "delete_book": `DELETE FROM books WHERE id = $1`,
+}
+
+func init() {
+    registerStoreDriver("postgres", openPostgres)
+    files, _ := fs.Sub(postgresMigrationFiles, "migrations/postgres")
+    registerMigrations("postgres", files, openPostgresMigrator)
+}
+
+// postgresStore keeps books in a PostgreSQL table.
+type postgresStore struct {
+    pool *pgxpool.Pool
+}
+
+// openPostgres connects to the database named by dsn, a connection string or
+// URL as accepted by pgx, after bringing its schema up to date.
+func openPostgres(dsn string) (BookStore, error) {
+    if err := migratePostgres(dsn); err != nil { // Before the pool, whose connections prepare statements against the tables.
+        return nil, err
+    }
+    cfg, err := pgxpool.ParseConfig(dsn)
+    if err != nil {
+        return nil, err
+    }
+    cfg.MaxConns = int32(postgresMaxConns)
+    cfg.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+        for name, sql := range postgresStatements {
+            if _, err := conn.Prepare(ctx, name, sql); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+    defer cancel()
+    pool, err := pgxpool.NewWithConfig(ctx, cfg)
+    if err != nil {
+        return nil, err
+    }
+    return &postgresStore{pool: pool}, nil
+}
+
+// migratePostgres applies pending migrations, or with MIGRATE_ON_START off
+// checks that there are none.
+func migratePostgres(dsn string) error {
+    files, _ := fs.Sub(postgresMigrationFiles, "migrations/postgres")
+    ms, err := loadMigrations(files)
+    if err != nil {
+        return err
+    }
+    mg, err := openPostgresMigrator(dsn)
+    if err != nil {
+        return err
+    }
+    defer mg.Close()
+    if postgresMigrateOnStart {
+        return migrateUp(mg, ms)
+    }
+    pending, err := pendingMigrations(mg, ms)
+    if err != nil {
+        return err
+    }
+    if pending > 0 {
+        return fmt.Errorf("%d schema migrations pending; run with --migrate=up", pending)
+    }
+    return nil
+}
+
+// postgresMigrator applies migrations over a single connection holding an
+// advisory lock, so only one instance migrates at a time.
+type postgresMigrator struct {
+    conn   *pgx.Conn
+    ctx    context.Context
+    cancel context.CancelFunc
+}
+
+// openPostgresMigrator connects, waits for the migration lock and creates
+// the schema_migrations table if needed.
+func openPostgresMigrator(dsn string) (Migrator, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), postgresMigrateTimeout)
+    conn, err := pgx.Connect(ctx, dsn)
+    if err != nil {
+        cancel()
+        return nil, err
+    }
+    mg := &postgresMigrator{conn: conn, ctx: ctx, cancel: cancel}
+    if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, postgresMigrationLock); err != nil {
+        mg.Close()
+        return nil, err
+    }
+    if _, err := conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    integer PRIMARY KEY,
+    name       text NOT NULL,
+    applied_at timestamptz NOT NULL DEFAULT now()
+)`); err != nil {
+        mg.Close()
+        return nil, err
+    }
+    return mg, nil
+}
+
+func (mg *postgresMigrator) Applied() (map[int]time.Time, error) {
+    rows, err := mg.conn.Query(mg.ctx, `SELECT version, applied_at FROM schema_migrations`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    applied := make(map[int]time.Time)
+    for rows.Next() {
+        var version int
+        var at time.Time
+        if err := rows.Scan(&version, &at); err != nil {
+            return nil, err
+        }
+        applied[version] = at
+    }
+    return applied, rows.Err()
+}
+
+func (mg *postgresMigrator) Apply(m Migration) error {
+    return pgx.BeginFunc(mg.ctx, mg.conn, func(tx pgx.Tx) error {
+        if _, err := tx.Exec(mg.ctx, m.Up); err != nil {
+            return err
+        }
+        _, err := tx.Exec(mg.ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+        return err
+    })
+}
+
+func (mg *postgresMigrator) Revert(m Migration) error {
+    return pgx.BeginFunc(mg.ctx, mg.conn, func(tx pgx.Tx) error {
+        if _, err := tx.Exec(mg.ctx, m.Down); err != nil {
+            return err
+        }
+        _, err := tx.Exec(mg.ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+        return err
+    })
+}
+
+// Close ends the connection, which also releases the advisory lock.
+func (mg *postgresMigrator) Close() {
+    mg.conn.Close(context.Background())
+    mg.cancel()
+}
+
+func (s *postgresStore) Ping(ctx context.Context) error {
+    return s.pool.Ping(ctx)
+}
+
+func (s *postgresStore) Get(id string) (Book, bool, error) {
+    return s.GetContext(context.Background(), id)
+}
+
+// GetContext is Get, abandoned when ctx is done.
+func (s *postgresStore) GetContext(ctx context.Context, id string) (Book, bool, error) {
+    ctx, cancel := context.WithTimeout(ctx, postgresTimeout)
+    defer cancel()
+    var data []byte
+    err := s.pool.QueryRow(ctx, "get_book", id).Scan(&data)
+    if errors.Is(err, pgx.ErrNoRows) {
+        return Book{}, false, nil
+    } else if err != nil {
+        return Book{}, false, err
+    }
+    var book Book
+    if err := json.Unmarshal(data, &book); err != nil {
+        return Book{}, false, err
+    }
+    return book, true, nil
+}
+
+func (s *postgresStore) List() ([]Book, error) {
+    return s.ListContext(context.Background())
+}
+
+// ListContext is List, abandoned when ctx is done.
+func (s *postgresStore) ListContext(ctx context.Context) ([]Book, error) {
+    ctx, cancel := context.WithTimeout(ctx, postgresTimeout)
+    defer cancel()
+    rows, err := s.pool.Query(ctx, "list_books")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    bks := []Book{}
+    for rows.Next() {
+        var data []byte
+        if err := rows.Scan(&data); err != nil {
+            return nil, err
+        }
+        var book Book
+        if err := json.Unmarshal(data, &book); err != nil {
+            return nil, err
+        }
+        bks = append(bks, book)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    sort.Slice(bks, func(i, j int) bool { return lessID(bks[i].ID, bks[j].ID) }) // IDs sort numerically, which ORDER BY can't do for text.
+    return bks, nil
+}
+
+func (s *postgresStore) Create(book Book) error {
+    return s.write("create_book", book, errBookExists)
+}
+
+func (s *postgresStore) Update(book Book) error {
+    return s.write("update_book", book, errBookNotFound)
+}
+
+// write runs an insert or update, returning noRows if no row was written.
+func (s *postgresStore) write(stmt string, book Book, noRows error) error {
+    data, err := json.Marshal(book)
+    if err != nil {
+        return err
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+    defer cancel()
+    tag, err := s.pool.Exec(ctx, stmt, book.ID, data)
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        return noRows
+    }
+    return nil
+}
+
+func (s *postgresStore) Delete(id string) error {
+    ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+    defer cancel()
+    _, err := s.pool.Exec(ctx, "delete_book", id)
+    return err
+}
@@ -0,0 +1,171 @@
+//go:build redis
+
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "sort"
+    "time"
+
+    "github.com/redis/go-redis/v9"
+)
+
+var (
+    redisTimeout = envDuration("REDIS_TIMEOUT", 5*time.Second) // Limit on each command or pipeline.
+    redisBookTTL = envDuration("REDIS_BOOK_TTL", 0)            // Books expire this long after their last write; 0 keeps them.
+)
+
+// Redis keys. Each book is a JSON string under its own key; the set lists
+// the IDs so List doesn't have to scan the keyspace.
+const (
+    redisBookPrefix = "library:book:"
+    redisBookIDs    = "library:books"
+)
+
+func init() {
+    registerStoreDriver("redis", openRedis)
+}
+
+// redisStore keeps books in Redis, so several stateless instances can share
+// one catalog.
+type redisStore struct {
+    client *redis.Client
+}
+
+// openRedis connects to the server at dsn, a redis:// URL, defaulting to a
+// local server.
+func openRedis(dsn string) (BookStore, error) {
+    if dsn == "" {
+        dsn = "redis://localhost:6379/0"
+    }
+    opts, err := redis.ParseURL(dsn)
+    if err != nil {
+        return nil, err
+    }
+    client := redis.NewClient(opts)
+    ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+    defer cancel()
+    if err := client.Ping(ctx).Err(); err != nil {
+        client.Close()
+        return nil, err
+    }
+    return &redisStore{client: client}, nil
+}
+
+func (s *redisStore) Ping(ctx context.Context) error {
+    return s.client.Ping(ctx).Err()
+}
+
+func (s *redisStore) Get(id string) (Book, bool, error) {
+    return s.GetContext(context.Background(), id)
+}
+
+// GetContext is Get, abandoned when ctx is done.
+func (s *redisStore) GetContext(ctx context.Context, id string) (Book, bool, error) {
+    ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+    defer cancel()
+    data, err := s.client.Get(ctx, redisBookPrefix+id).Bytes()
+    if err == redis.Nil {
+        return Book{}, false, nil
+    } else if err != nil {
+        return Book{}, false, err
+    }
+    var book Book
+    if err := json.Unmarshal(data, &book); err != nil {
+        return Book{}, false, err
+    }
+    return book, true, nil
+}
+
+// List fetches every book in one pipelined round trip. IDs whose book has
+// expired are dropped from the set on the way.
+func (s *redisStore) List() ([]Book, error) {
+    return s.ListContext(context.Background())
+}
+
+// ListContext is List, abandoned when ctx is done.
+func (s *redisStore) ListContext(ctx context.Context) ([]Book, error) {
+    ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+    defer cancel()
+    ids, err := s.client.SMembers(ctx, redisBookIDs).Result()
+    if err != nil {
+        return nil, err
+    }
+    cmds := make([]*redis.StringCmd, len(ids))
+    _, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+        for i, id := range ids {
+            cmds[i] = pipe.Get(ctx, redisBookPrefix+id)
+        }
+        return nil
+    })
+    if err != nil && err != redis.Nil {
+        return nil, err
+    }
+    bks := make([]Book, 0, len(ids))
+    var expired []interface{}
+    for i, cmd := range cmds {
+        data, err := cmd.Bytes()
+        if err == redis.Nil {
+            expired = append(expired, ids[i])
+            continue
+        } else if err != nil {
+            return nil, err
+        }
+        var book Book
+        if err := json.Unmarshal(data, &book); err != nil {
+            return nil, err
+        }
+        bks = append(bks, book)
+    }
+    if len(expired) > 0 {
+        s.client.SRem(ctx, redisBookIDs, expired...) // Best effort; the next List tries again.
+    }
+    sort.Slice(bks, func(i, j int) bool { return lessID(bks[i].ID, bks[j].ID) })
+    return bks, nil
+}
+
+func (s *redisStore) Create(book Book) error {
+    data, err := json.Marshal(book)
+    if err != nil {
+        return err
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+    defer cancel()
+    ok, err := s.client.SetNX(ctx, redisBookPrefix+book.ID, data, redisBookTTL).Result()
+    if err != nil {
+        return err
+    }
+    if !ok {
+        return errBookExists
+    }
+    return s.client.SAdd(ctx, redisBookIDs, book.ID).Err()
+}
+
+func (s *redisStore) Update(book Book) error {
+    data, err := json.Marshal(book)
+    if err != nil {
+        return err
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+    defer cancel()
+    ok, err := s.client.SetXX(ctx, redisBookPrefix+book.ID, data, redisBookTTL).Result() // Resets the TTL too.
+    if err != nil {
+        return err
+    }
+    if !ok {
+        return errBookNotFound
+    }
+    return nil
+}
+
+func (s *redisStore) Delete(id string) error {
+    ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+    defer cancel()
+    _, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+        pipe.Del(ctx, redisBookPrefix+id)
+        pipe.SRem(ctx, redisBookIDs, id)
+        return nil
+    })
+    return err
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "reflect"
+    "sort"
+    "strconv"
+    "time"
+)
+
+// Conflict policies for POST /sync, used when a pushed change was based on a
+// version of the book that is no longer current.
+const (
+    SyncLastWriteWins = "last_write_wins" // The pushed change replaces whatever the server has.
+    SyncMerge         = "merge"           // Fields changed on only one side are combined; overlapping edits are conflicts.
+    SyncManual        = "manual"          // Every conflict is returned for the client to resolve.
+)
+
+// syncConflictPolicy is the policy used when a push does not ask for one.
+var syncConflictPolicy = envString("SYNC_CONFLICT_POLICY", SyncManual)
+
+// Push operations and results for POST /sync.
+const (
+    SyncUpsert = "upsert"
+    SyncDelete = "delete"
+
+    SyncApplied  = "applied"  // The change was based on the current version and was applied as is.
+    SyncMerged   = "merged"   // The change conflicted and was resolved by the policy.
+    SyncConflict = "conflict" // The change was not applied; the server's version is returned.
+    SyncRejected = "rejected" // The change would exceed the key's quota, or creates a book with an ID the ID strategy refuses, and was not applied.
+)
+
+// Tombstone marks a book that was deleted since the client's cursor.
+type Tombstone struct {
+    ID        string    `json:"id"`
+    DeletedAt time.Time `json:"deleted_at"`
+}
+
+// SyncResponse is the response for GET /sync.
+type SyncResponse struct {
+    Full       bool           `json:"full"`       // True when Upserts is the whole catalog rather than a delta.
+    Upserts    []Book         `json:"upserts"`    // Books created or updated since the cursor, in their latest state.
+    Tombstones []Tombstone    `json:"tombstones"` // Books deleted since the cursor.
+    Versions   map[string]int `json:"versions"`   // Current version of every book in Upserts and Tombstones; send back as base_version when pushing.
+    Cursor     string         `json:"cursor"`     // Pass back as ?since= on the next sync.
+}
+
+// SyncPush is the request body for POST /sync.
+type SyncPush struct {
+    Policy  string       `json:"policy,omitempty"` // Conflict policy; defaults to SYNC_CONFLICT_POLICY.
+    Changes []SyncChange `json:"changes"`
+}
+
+// SyncChange is one change made by an offline client.
+type SyncChange struct {
+    Op          string `json:"op"`             // upsert or delete.
+    ID          string `json:"id"`             // ID of the book.
+    Book        *Book  `json:"book,omitempty"` // The book as the client has it; required for upserts.
+    BaseVersion int    `json:"base_version"`   // Version the client's change was based on; 0 for a new book.
+}
+
+// SyncResult reports what happened to one pushed change.
+type SyncResult struct {
+    ID      string `json:"id"`
+    Status  string `json:"status"`         // applied, merged, conflict or rejected.
+    Book    *Book  `json:"book,omitempty"` // The server's book after the push; omitted if it does not exist.
+    Version int    `json:"version"`        // The server's version after the push.
+}
+
+// handleSync handles requests for the /sync route.
+func handleSync(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case "GET":
+        handleSyncPull(w, r)
+    case "POST":
+        handleSyncPush(w, r)
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// handleSyncPull handles GET /sync?since=<cursor>. Without a cursor it returns
+// the whole catalog; with one it returns only what changed since, collapsed to
+// the latest state of each book, so offline clients can catch up cheaply.
+func handleSyncPull(w http.ResponseWriter, r *http.Request) {
+    resp := SyncResponse{Upserts: []Book{}, Tombstones: []Tombstone{}, Versions: map[string]int{}}
+    v := r.URL.Query().Get("since")
+    if v == "" {
+        mux.RLock() // Holding mux keeps the snapshot and cursor consistent, as writers record changes under it.
+        for _, book := range listBooks() {
+            if !canSeeBook(r, book) {
+                continue
+            }
+            resp.Upserts = append(resp.Upserts, book)
+            resp.Versions[book.ID] = currentVersion(book.ID)
+        }
+        resp.Full, resp.Cursor = true, formatCursor(currentChangeSeq())
+        mux.RUnlock()
+    } else {
+        since, err := parseCursor(v)
+        if errors.Is(err, errCursorExpired) {
+            writeError(w, "cursor_expired", "cursor is from before a restart; sync again without since to get the full catalog")
+            return
+        } else if err != nil {
+            writeError(w, "invalid_query", err.Error())
+            return
+        }
+        chs, cursor, _, ok := changesSince(since)
+        if !ok {
+            writeError(w, "cursor_expired", "cursor is too old; sync again without since to get the full catalog")
+            return
+        }
+        latest := make(map[string]Change) // Only the last change to each book matters.
+        for _, c := range chs {
+            latest[c.BookID] = visibleChange(r, c)
+        }
+        for _, c := range latest {
+            resp.Versions[c.BookID] = currentVersion(c.BookID)
+            if c.Op == ChangeDelete {
+                resp.Tombstones = append(resp.Tombstones, Tombstone{ID: c.BookID, DeletedAt: c.At})
+            } else {
+                resp.Upserts = append(resp.Upserts, *c.Book)
+            }
+        }
+        resp.Cursor = formatCursor(cursor)
+    }
+    sort.Slice(resp.Upserts, func(i, j int) bool { return lessID(resp.Upserts[i].ID, resp.Upserts[j].ID) })
+    sort.Slice(resp.Tombstones, func(i, j int) bool { return lessID(resp.Tombstones[i].ID, resp.Tombstones[j].ID) })
+    json.NewEncoder(w).Encode(resp)
+}
+
+// handleSyncPush handles POST /sync, applying changes made by an offline client.
+// A change based on the current version is applied; otherwise the conflict
+// policy decides. Each change gets its own result, so one conflict does not
+// hold up the rest of the push.
+func handleSyncPush(w http.ResponseWriter, r *http.Request) {
+    var push SyncPush
+    if err := decodeJSON(r, &push); err != nil {
+        writeDecodeError(w, err)
+        return
+    }
+    if push.Policy == "" {
+        push.Policy = syncConflictPolicy
+    }
+    if push.Policy != SyncLastWriteWins && push.Policy != SyncMerge && push.Policy != SyncManual {
+        writeError(w, "validation_failed", "policy must be last_write_wins, merge or manual")
+        return
+    }
+    for i, c := range push.Changes {
+        if c.ID == "" || (c.Op != SyncUpsert && c.Op != SyncDelete) || (c.Op == SyncUpsert && c.Book == nil) {
+            writeError(w, "validation_failed", "change "+strconv.Itoa(i)+" needs an id, an op of upsert or delete, and a book for upserts")
+            return
+        }
+        if c.Book != nil {
+            if err := checkBook(c.Book); err != nil {
+                writeError(w, "validation_failed", "change "+strconv.Itoa(i)+": "+err.Error())
+                return
+            }
+        }
+    }
+
+    mux.Lock()
+    defer mux.Unlock()
+    results := make([]SyncResult, 0, len(push.Changes))
+    for _, c := range push.Changes {
+        status := SyncApplied
+        var want *Book // What the book should become; nil to delete it.
+        if c.Op == SyncUpsert {
+            b := *c.Book
+            b.ID = c.ID // The change's ID is authoritative.
+            want = &b
+        }
+        if c.BaseVersion != currentVersion(c.ID) {
+            status = SyncMerged
+            switch push.Policy {
+            case SyncManual:
+                status = SyncConflict
+            case SyncMerge:
+                merged, ok := mergeBook(bookAtVersion(c.ID, c.BaseVersion), currentBook(c.ID), want)
+                if !ok {
+                    status = SyncConflict
+                }
+                want = merged
+            }
+        }
+        if want != nil && status != SyncConflict && checkWriteQuota(r.Header.Get("X-API-Key"), []Book{*want}) != nil {
+            status = SyncRejected
+        }
+        if want != nil && status != SyncConflict && currentBook(c.ID) == nil && ids.Check(c.ID) != nil {
+            status = SyncRejected // Offline clients pick IDs for new books, so they must follow the strategy.
+        }
+        if status != SyncConflict && status != SyncRejected {
+            if err := applySyncChange(r.Header.Get("X-API-Key"), c.ID, want); err != nil {
+                writeError(w, "internal_error", err.Error()) // Earlier changes in the push have been applied.
+                return
+            }
+        }
+        book := currentBook(c.ID)
+        if book != nil && !canSeeBook(r, *book) {
+            book = nil // Not even a conflict shows a draft to a key that can't read it.
+        }
+        results = append(results, SyncResult{ID: c.ID, Status: status, Book: book, Version: currentVersion(c.ID)})
+    }
+    json.NewEncoder(w).Encode(results)
+}
+
+// currentBook returns the live book with the given ID, or nil. Callers hold mux.
+func currentBook(id string) *Book {
+    if book, ok := getBook(id); ok {
+        return &book
+    }
+    return nil
+}
+
+// applySyncChange writes (or, for nil, deletes) a book on behalf of a key and
+// records the change. Callers hold mux.
+func applySyncChange(key, id string, book *Book) error {
+    prev, exists, err := store.Get(id)
+    if err != nil {
+        return err
+    }
+    switch {
+    case book == nil && exists:
+        if err := trashBook(prev); err != nil {
+            return err
+        }
+        recordChangeVia("sync", ChangeDelete, id, nil)
+    case book != nil && exists && sameContent(&prev, book):
+        // Nothing to do; avoid a version that changes nothing.
+    case book != nil:
+        op := ChangeCreate
+        if exists {
+            op = ChangeUpdate
+        }
+        stampBook(book, prev, exists)
+        if err := saveBook(op, *book); err != nil {
+            return err
+        }
+        if op == ChangeCreate {
+            claimBook(key, id)
+        }
+        recordChangeVia("sync", op, id, book)
+    }
+    return nil
+}
+
+// mergeBook does a three-way merge of a client's change with the server's
+// version, both descended from base. Fields changed on only one side are
+// taken from that side, clearing a field counting as a change; a field
+// changed differently on both sides, or a delete racing an edit, cannot be
+// merged.
+func mergeBook(base, server, client *Book) (*Book, bool) {
+    if sameContent(server, client) {
+        return server, true // Both sides made the same change.
+    }
+    if base == nil || server == nil || client == nil {
+        return nil, false // Created, deleted or recreated on one side: nothing to merge field by field.
+    }
+    baseFields, serverFields, clientFields := bookFields(*base), bookFields(*server), bookFields(*client)
+    for _, name := range serverManagedFields { // The server sets these; they aren't fields to merge.
+        delete(baseFields, name)
+        delete(clientFields, name)
+    }
+    merged := make(map[string]json.RawMessage, len(serverFields))
+    for k, v := range serverFields {
+        merged[k] = v
+    }
+    names := make(map[string]bool, len(baseFields)+len(clientFields))
+    for k := range baseFields {
+        names[k] = true // Empty fields are left out of the JSON, so a field the client cleared is only in base.
+    }
+    for k := range clientFields {
+        names[k] = true
+    }
+    for k := range names {
+        cv := clientFields[k] // nil if cleared.
+        if string(cv) == string(baseFields[k]) {
+            continue // Unchanged on the client; keep the server's value.
+        }
+        if sv := serverFields[k]; string(sv) != string(baseFields[k]) && string(sv) != string(cv) {
+            return nil, false // Both sides changed this field differently.
+        }
+        if cv == nil {
+            delete(merged, k)
+        } else {
+            merged[k] = cv
+        }
+    }
+    data, _ := json.Marshal(merged)
+    var out Book
+    json.Unmarshal(data, &out)
+    return &out, true
+}
+
+// sameContent reports whether two books (either may be nil) are equal apart
+// from their version and timestamps.
+func sameContent(a, b *Book) bool {
+    if a == nil || b == nil {
+        return a == b
+    }
+    x, y := *a, *b
+    x.Version, y.Version = 0, 0
+    x.CreatedAt, y.CreatedAt = time.Time{}, time.Time{}
+    x.UpdatedAt, y.UpdatedAt = time.Time{}, time.Time{}
+    return reflect.DeepEqual(x, y)
+}
+
+// bookFields splits a book into its JSON fields so merges work field by field
+// without listing Book's fields by hand.
+func bookFields(b Book) map[string]json.RawMessage {
+    data, _ := json.Marshal(b)
+    fields := make(map[string]json.RawMessage)
+    json.Unmarshal(data, &fields)
+    return fields
+}
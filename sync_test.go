@@ -0,0 +1,126 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+// bookFromJSON decodes a book literal in a test table; "" is no book.
+func bookFromJSON(t *testing.T, s string) *Book {
+    t.Helper()
+    if s == "" {
+        return nil
+    }
+    var b Book
+    if err := json.Unmarshal([]byte(s), &b); err != nil {
+        t.Fatalf("bad book %q in test table: %v", s, err)
+    }
+    return &b
+}
+
+func TestMergeBook(t *testing.T) {
+    base := `{"id":"1","title":"Dune","author":"Frank Herbert","description":"Spice","tags":["sf"]}`
+    tests := []struct {
+        name           string
+        base           string
+        server, client string
+        want           string // Merged book; empty when the merge must fail.
+    }{
+        {"different fields", base,
+            `{"id":"1","title":"Dune","author":"F. Herbert","description":"Spice","tags":["sf"]}`,
+            `{"id":"1","title":"Dune (1965)","author":"Frank Herbert","description":"Spice","tags":["sf"]}`,
+            `{"id":"1","title":"Dune (1965)","author":"F. Herbert","description":"Spice","tags":["sf"]}`},
+        {"same field differently", base,
+            `{"id":"1","title":"Dune I","author":"Frank Herbert","description":"Spice","tags":["sf"]}`,
+            `{"id":"1","title":"Dune 1","author":"Frank Herbert","description":"Spice","tags":["sf"]}`,
+            ""},
+        {"same change on both sides", base,
+            `{"id":"1","title":"Dune I","author":"Frank Herbert","description":"Spice","tags":["sf"]}`,
+            `{"id":"1","title":"Dune I","author":"Frank Herbert","description":"Spice","tags":["sf"]}`,
+            `{"id":"1","title":"Dune I","author":"Frank Herbert","description":"Spice","tags":["sf"]}`},
+        {"client clears a field", base,
+            `{"id":"1","title":"Dune","author":"F. Herbert","description":"Spice","tags":["sf"]}`,
+            `{"id":"1","title":"Dune","author":"Frank Herbert","tags":["sf"]}`,
+            `{"id":"1","title":"Dune","author":"F. Herbert","tags":["sf"]}`},
+        {"client clears a list", base,
+            `{"id":"1","title":"Dune","author":"Frank Herbert","description":"Spice","tags":["sf"]}`,
+            `{"id":"1","title":"Dune","author":"Frank Herbert","description":"Spice"}`,
+            `{"id":"1","title":"Dune","author":"Frank Herbert","description":"Spice"}`},
+        {"client clears what the server edited", base,
+            `{"id":"1","title":"Dune","author":"Frank Herbert","description":"Melange","tags":["sf"]}`,
+            `{"id":"1","title":"Dune","author":"Frank Herbert","tags":["sf"]}`,
+            ""},
+        {"both clear the same field", base,
+            `{"id":"1","title":"Dune","author":"Frank Herbert","tags":["sf"]}`,
+            `{"id":"1","title":"Dune","author":"Frank Herbert","tags":["sf"],"language":"en"}`,
+            `{"id":"1","title":"Dune","author":"Frank Herbert","tags":["sf"],"language":"en"}`},
+        {"server clears, client keeps", base,
+            `{"id":"1","title":"Dune","author":"Frank Herbert","tags":["sf"]}`,
+            `{"id":"1","title":"Dune","author":"Frank Herbert","description":"Spice","tags":["sf","classic"]}`,
+            `{"id":"1","title":"Dune","author":"Frank Herbert","tags":["sf","classic"]}`},
+        {"client fills a new field", base,
+            `{"id":"1","title":"Dune","author":"F. Herbert","description":"Spice","tags":["sf"]}`,
+            `{"id":"1","title":"Dune","author":"Frank Herbert","description":"Spice","tags":["sf"],"isbn":"9780441013593"}`,
+            `{"id":"1","title":"Dune","author":"F. Herbert","description":"Spice","tags":["sf"],"isbn":"9780441013593"}`},
+        {"deleted on the server", base, "", `{"id":"1","title":"Dune 1"}`, ""},
+        {"created on both sides", "", `{"id":"1","title":"Dune"}`, `{"id":"1","title":"Dune 1"}`, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, ok := mergeBook(bookFromJSON(t, tt.base), bookFromJSON(t, tt.server), bookFromJSON(t, tt.client))
+            if tt.want == "" {
+                if ok {
+                    t.Fatalf("mergeBook = %+v, want a conflict", got)
+                }
+                return
+            }
+            if !ok {
+                t.Fatal("mergeBook reported a conflict")
+            }
+            if want := bookFromJSON(t, tt.want); !sameContent(got, want) {
+                t.Errorf("mergeBook = %+v, want %+v", *got, *want)
+            }
+        })
+    }
+}
+
+func TestSyncPushMerge(t *testing.T) {
+    useTestSeams(t, time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC))
+    if w := serveBook("PUT", "/book/sync-1", `{"title":"Dune","author":"Frank Herbert","description":"Spice"}`, nil); w.Code != http.StatusOK {
+        t.Fatalf("PUT = %d %s", w.Code, w.Body)
+    }
+    if w := serveBook("PUT", "/book/sync-1", `{"title":"Dune","author":"F. Herbert","description":"Spice","version":1}`, nil); w.Code != http.StatusOK {
+        t.Fatalf("PUT = %d %s", w.Code, w.Body)
+    }
+
+    push := func(policy, book string) SyncResult {
+        t.Helper()
+        body := `{"policy":"` + policy + `","changes":[{"op":"upsert","id":"sync-1","base_version":1,"book":` + book + `}]}`
+        w := httptest.NewRecorder()
+        handleSync(w, httptest.NewRequest("POST", "/sync", strings.NewReader(body)))
+        var results []SyncResult
+        if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil || len(results) != 1 {
+            t.Fatalf("POST /sync = %d %s", w.Code, w.Body)
+        }
+        return results[0]
+    }
+
+    if res := push("manual", `{"title":"Dune","author":"Frank Herbert"}`); res.Status != SyncConflict || res.Book.Description != "Spice" {
+        t.Errorf("manual push = %s with description %q, want a conflict leaving \"Spice\"", res.Status, res.Book.Description)
+    }
+    res := push("merge", `{"title":"Dune","author":"Frank Herbert"}`)
+    if res.Status != SyncMerged || res.Version != 3 {
+        t.Fatalf("merge push = %s at version %d, want merged at version 3", res.Status, res.Version)
+    }
+    if res.Book.Author != "F. Herbert" || res.Book.Description != "" {
+        t.Errorf("merged book has author %q and description %q; want the server's author and the client's cleared description",
+            res.Book.Author, res.Book.Description)
+    }
+    if res := push("merge", `{"title":"Dune","author":"Frank H."}`); res.Status != SyncConflict {
+        t.Errorf("push editing the author the server changed = %s, want a conflict", res.Status)
+    }
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+)
+
+// maxTagLength is the longest tag, in characters, a book can carry.
+const maxTagLength = 64
+
+// TagCount is one entry of GET /tags.
+type TagCount struct {
+    Tag   string `json:"tag"`
+    Count int    `json:"count"` // Books carrying the tag.
+}
+
+// normalizeTag puts a tag in the form it is stored and matched in: trimmed
+// and lower-case, so "Classic" and "classic " are the same tag.
+func normalizeTag(tag string) string {
+    return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// checkTags normalizes a book's tags, dropping duplicates, and rejects empty
+// or overlong ones.
+func checkTags(book *Book) error {
+    if len(book.Tags) == 0 {
+        book.Tags = nil
+        return nil
+    }
+    seen := make(map[string]bool)
+    kept := book.Tags[:0]
+    for _, tag := range book.Tags {
+        tag = normalizeTag(tag)
+        if tag == "" {
+            return errors.New("tags must not be empty")
+        }
+        if utf8.RuneCountInString(tag) > maxTagLength {
+            return errors.New("tags may be at most " + strconv.Itoa(maxTagLength) + " characters")
+        }
+        if !seen[tag] {
+            seen[tag] = true
+            kept = append(kept, tag)
+        }
+    }
+    book.Tags = kept
+    return nil
+}
+
+// hasTags reports whether a book carries every one of the given tags, which
+// are already normalized.
+func hasTags(book Book, tags []string) bool {
+    for _, tag := range tags {
+        if !contains(book.Tags, tag) {
+            return false
+        }
+    }
+    return true
+}
+
+// parseTagFilter reads the ?tag= filters of GET /books; repeating it asks
+// for books with all of the tags.
+func parseTagFilter(r *http.Request) []string {
+    var tags []string
+    for _, tag := range r.URL.Query()["tag"] {
+        tags = append(tags, normalizeTag(tag))
+    }
+    return tags
+}
+
+// handleTags handles GET /tags: every tag on the books the caller can see,
+// with how many books carry it, most used first.
+func handleTags(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    mux.RLock()
+    bks, err := store.List()
+    mux.RUnlock()
+    if err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    counts := make(map[string]int)
+    for _, book := range bks {
+        if canSeeBook(r, book) {
+            for _, tag := range book.Tags {
+                counts[tag]++
+            }
+        }
+    }
+    list := make([]TagCount, 0, len(counts))
+    for tag, n := range counts {
+        list = append(list, TagCount{Tag: tag, Count: n})
+    }
+    sort.Slice(list, func(i, j int) bool {
+        if list[i].Count != list[j].Count {
+            return list[i].Count > list[j].Count
+        }
+        return list[i].Tag < list[j].Tag
+    })
+    start, end, err := paginate(w, r, len(list))
+    if err != nil {
+        writePageError(w, err)
+        return
+    }
+    json.NewEncoder(w).Encode(list[start:end])
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "text/template"
+    "time"
+)
+
+// NotificationTemplate struct defines the wording of a notification, such as
+// a reminder email. Subject and Body are Go text/template sources, so the
+// wording can change at runtime without a redeploy.
+type NotificationTemplate struct {
+    Name      string    `json:"name"`    // Unique identifier, e.g. "ill-arrived".
+    Subject   string    `json:"subject"` // Template for the subject line.
+    Body      string    `json:"body"`    // Template for the message body.
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TemplatePreview is the response for POST /admin/template/{name}/preview.
+type TemplatePreview struct {
+    Subject string `json:"subject"`
+    Body    string `json:"body"`
+}
+
+var (
+    templates    = make(map[string]NotificationTemplate) // Map to store notification templates with their name as the key.
+    templatesMux sync.RWMutex                            // RWMutex to safeguard the templates map.
+)
+
+// parseTemplate compiles a notification template. Missing keys in the data
+// are errors, so a preview shows a typo instead of rendering "<no value>".
+func parseTemplate(name, text string) (*template.Template, error) {
+    return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+// checkTemplate reports the first problem with a template: a missing name,
+// subject or body, or one that doesn't parse.
+func checkTemplate(t NotificationTemplate) (string, bool) {
+    if t.Name == "" || t.Subject == "" || t.Body == "" {
+        return "name, subject and body are required", false
+    }
+    if strings.Contains(t.Name, "/") {
+        return "name must not contain /", false
+    }
+    if _, err := parseTemplate("subject", t.Subject); err != nil {
+        return err.Error(), false
+    }
+    if _, err := parseTemplate("body", t.Body); err != nil {
+        return err.Error(), false
+    }
+    return "", true
+}
+
+// renderTemplate fills in a template's subject and body with the given data.
+func renderTemplate(t NotificationTemplate, data interface{}) (TemplatePreview, error) {
+    var out TemplatePreview
+    for _, part := range []struct {
+        name, text string
+        dst        *string
+    }{{"subject", t.Subject, &out.Subject}, {"body", t.Body, &out.Body}} {
+        tmpl, err := parseTemplate(part.name, part.text)
+        if err != nil {
+            return out, err
+        }
+        var buf bytes.Buffer
+        if err := tmpl.Execute(&buf, data); err != nil {
+            return out, err
+        }
+        *part.dst = buf.String()
+    }
+    return out, nil
+}
+
+// handleTemplates handles requests for the /admin/templates route. Needs the
+// admin key.
+func handleTemplates(w http.ResponseWriter, r *http.Request) {
+    if !isAdmin(r) {
+        writeError(w, "admin_required", "notification templates require the admin key")
+        return
+    }
+    switch r.Method {
+    case "GET": // Retrieve all templates.
+        templatesMux.RLock()
+        list := make([]NotificationTemplate, 0, len(templates))
+        for _, t := range templates {
+            list = append(list, t)
+        }
+        templatesMux.RUnlock()
+        sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+        json.NewEncoder(w).Encode(list)
+
+    case "POST": // Add a new template.
+        var t NotificationTemplate
+        if err := decodeJSON(r, &t); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        if msg, ok := checkTemplate(t); !ok {
+            writeError(w, "validation_failed", msg)
+            return
+        }
+        t.UpdatedAt = clock.Now().UTC()
+        templatesMux.Lock()
+        defer templatesMux.Unlock()
+        if _, exists := templates[t.Name]; exists {
+            writeError(w, "duplicate", "template "+t.Name+" already exists; use PUT to change it")
+            return
+        }
+        templates[t.Name] = t
+        w.WriteHeader(http.StatusCreated)
+        json.NewEncoder(w).Encode(t)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// handleTemplate handles requests for the /admin/template/{name} and
+// /admin/template/{name}/preview routes. Needs the admin key.
+func handleTemplate(w http.ResponseWriter, r *http.Request) {
+    if !isAdmin(r) {
+        writeError(w, "admin_required", "notification templates require the admin key")
+        return
+    }
+    name, sub, _ := strings.Cut(r.URL.Path[len("/admin/template/"):], "/") // Extract the template name from the URL path.
+    if sub == "preview" {
+        handleTemplatePreview(w, r, name)
+        return
+    } else if sub != "" {
+        writeError(w, "not_found", "no route for "+r.URL.Path)
+        return
+    }
+
+    switch r.Method {
+    case "GET": // Retrieve a single template.
+        templatesMux.RLock()
+        t, ok := templates[name]
+        templatesMux.RUnlock()
+        if !ok {
+            writeError(w, "template_not_found", "template "+name+" not found")
+            return
+        }
+        json.NewEncoder(w).Encode(t)
+
+    case "PUT": // Change the wording of an existing template.
+        var t NotificationTemplate
+        if err := decodeJSON(r, &t); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        t.Name = name // The name in the path is authoritative.
+        if msg, ok := checkTemplate(t); !ok {
+            writeError(w, "validation_failed", msg)
+            return
+        }
+        t.UpdatedAt = clock.Now().UTC()
+        templatesMux.Lock()
+        defer templatesMux.Unlock()
+        if _, ok := templates[name]; !ok {
+            writeError(w, "template_not_found", "template "+name+" not found")
+            return
+        }
+        templates[name] = t
+        json.NewEncoder(w).Encode(t)
+
+    case "DELETE": // Remove a template.
+        templatesMux.Lock()
+        delete(templates, name)
+        templatesMux.Unlock()
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// handleTemplatePreview handles POST /admin/template/{name}/preview, rendering
+// a template with sample data from the request body. If the body also has a
+// subject or body, that draft wording is previewed instead of the saved one,
+// so changes can be checked before they are saved.
+func handleTemplatePreview(w http.ResponseWriter, r *http.Request, name string) {
+    if r.Method != "POST" {
+        methodNotAllowed(w, r)
+        return
+    }
+    var req struct {
+        Data    map[string]interface{} `json:"data"`    // Values for the template, e.g. {"Title": "1984"}.
+        Subject string                 `json:"subject"` // Draft subject to preview instead of the saved one.
+        Body    string                 `json:"body"`    // Draft body to preview instead of the saved one.
+    }
+    if err := decodeJSON(r, &req); err != nil {
+        writeDecodeError(w, err)
+        return
+    }
+    templatesMux.RLock()
+    t, ok := templates[name]
+    templatesMux.RUnlock()
+    if !ok && (req.Subject == "" || req.Body == "") {
+        writeError(w, "template_not_found", "template "+name+" not found; send subject and body to preview a new one")
+        return
+    }
+    if req.Subject != "" {
+        t.Subject = req.Subject
+    }
+    if req.Body != "" {
+        t.Body = req.Body
+    }
+    preview, err := renderTemplate(t, req.Data)
+    if err != nil {
+        writeError(w, "validation_failed", err.Error())
+        return
+    }
+    json.NewEncoder(w).Encode(preview)
+}
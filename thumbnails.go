@@ -0,0 +1,145 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "image"
+    "image/color"
+    "image/jpeg"
+    "image/png"
+    "log"
+)
+
+// thumbnailSizes are the cover sizes clients can ask for with ?size=, each
+// the length in pixels of the longer edge. "original" is the upload itself.
+var thumbnailSizes = map[string]int{
+    "small":  120,
+    "medium": 400,
+}
+
+// Thumbnail describes one scaled-down copy of a cover, kept in the blob store
+// under thumbnailKey.
+type Thumbnail struct {
+    MediaType string `json:"media_type"` // image/jpeg, or image/png for PNG covers so transparency survives.
+    Size      int64  `json:"size"`       // In bytes.
+    SHA256    string `json:"sha256"`
+}
+
+// thumbnailQueue feeds uploaded covers to the thumbnail worker.
+var thumbnailQueue = make(chan Cover, 64)
+
+// thumbnailKey is the blob store key of one thumbnail of a book's cover.
+func thumbnailKey(bookID, size string) string {
+    return coverKey(bookID) + "-" + size
+}
+
+// deleteCoverBlobs deletes a cover image and its thumbnails from the blob store.
+func deleteCoverBlobs(bookID string) {
+    keys := []string{coverKey(bookID)}
+    for size := range thumbnailSizes {
+        keys = append(keys, thumbnailKey(bookID, size))
+    }
+    for _, key := range keys {
+        if err := blobs.Delete(key); err != nil {
+            log.Printf("deleting %s: %v", key, err) // The record is gone; the bytes are just orphaned.
+        }
+    }
+}
+
+// queueThumbnails asks the worker to make thumbnails of a new cover. If the
+// worker is swamped the cover just goes without; ?size= then serves the
+// original.
+func queueThumbnails(c Cover) {
+    select {
+    case thumbnailQueue <- c:
+    default:
+        log.Printf("thumbnails: queue full, skipping cover of book %s", c.BookID)
+    }
+}
+
+// runThumbnailer makes thumbnails for queued covers, one at a time, so
+// uploads return as soon as the original is stored.
+func runThumbnailer() {
+    for c := range thumbnailQueue {
+        thumbs, err := makeThumbnails(c)
+        if err != nil {
+            log.Printf("thumbnails: cover of book %s: %v", c.BookID, err)
+            continue
+        }
+        coversMux.Lock()
+        cur, ok := covers[c.BookID]
+        if ok && cur.SHA256 == c.SHA256 { // Still the cover these were made from.
+            cur.Thumbnails = thumbs
+            covers[c.BookID] = cur
+        }
+        coversMux.Unlock()
+    }
+}
+
+// makeThumbnails scales a cover down to every thumbnail size and stores the
+// results. Covers already smaller than a size aren't scaled up.
+func makeThumbnails(c Cover) (map[string]Thumbnail, error) {
+    rc, err := blobs.Open(coverKey(c.BookID))
+    if err != nil {
+        return nil, err
+    }
+    src, _, err := image.Decode(rc) // WebP has no decoder in the standard library, so those covers get no thumbnails.
+    rc.Close()
+    if err != nil {
+        return nil, err
+    }
+    thumbs := make(map[string]Thumbnail)
+    for name, edge := range thumbnailSizes {
+        var buf bytes.Buffer
+        t := Thumbnail{MediaType: "image/jpeg"}
+        scaled := scaleDown(src, edge)
+        if c.MediaType == "image/png" {
+            t.MediaType = "image/png"
+            err = png.Encode(&buf, scaled)
+        } else {
+            err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 85})
+        }
+        if err != nil {
+            return nil, err
+        }
+        sum := sha256.Sum256(buf.Bytes())
+        t.SHA256 = hex.EncodeToString(sum[:])
+        if t.Size, err = blobs.Put(thumbnailKey(c.BookID, name), &buf); err != nil {
+            return nil, err
+        }
+        thumbs[name] = t
+    }
+    return thumbs, nil
+}
+
+// scaleDown shrinks an image so its longer edge is at most edge pixels,
+// averaging the source pixels behind each result pixel.
+func scaleDown(src image.Image, edge int) image.Image {
+    b := src.Bounds()
+    w, h := b.Dx(), b.Dy()
+    if w <= edge && h <= edge {
+        return src
+    }
+    dw, dh := edge, h*edge/w
+    if h > w {
+        dw, dh = w*edge/h, edge
+    }
+    dw, dh = max(dw, 1), max(dh, 1)
+    dst := image.NewNRGBA64(image.Rect(0, 0, dw, dh))
+    for y := 0; y < dh; y++ {
+        y0, y1 := b.Min.Y+y*h/dh, b.Min.Y+(y+1)*h/dh
+        for x := 0; x < dw; x++ {
+            x0, x1 := b.Min.X+x*w/dw, b.Min.X+(x+1)*w/dw
+            var r, g, bl, a, n uint64
+            for sy := y0; sy < max(y1, y0+1); sy++ {
+                for sx := x0; sx < max(x1, x0+1); sx++ {
+                    c := color.NRGBA64Model.Convert(src.At(sx, sy)).(color.NRGBA64)
+                    r, g, bl, a, n = r+uint64(c.R), g+uint64(c.G), bl+uint64(c.B), a+uint64(c.A), n+1
+                }
+            }
+            dst.SetNRGBA64(x, y, color.NRGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+        }
+    }
+    return dst
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// maxRequestTimeout caps the deadline a client may ask for with X-Request-Timeout.
+var maxRequestTimeout = envDuration("MAX_REQUEST_TIMEOUT", 60*time.Second)
+
+// parseRequestTimeout parses an X-Request-Timeout value, either a Go duration
+// ("2.5s", "500ms") or a whole number of seconds.
+func parseRequestTimeout(v string) (time.Duration, bool) {
+    if secs, err := strconv.Atoi(v); err == nil {
+        return time.Duration(secs) * time.Second, secs > 0
+    }
+    d, err := time.ParseDuration(v)
+    return d, err == nil && d > 0
+}
+
+// timeoutWriter buffers a handler's response so that, if the deadline passes
+// first, a 504 can be sent instead and the late response discarded.
+type timeoutWriter struct {
+    mu       sync.Mutex
+    header   http.Header
+    buf      bytes.Buffer
+    status   int
+    timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.header }
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+    tw.mu.Lock()
+    defer tw.mu.Unlock()
+    if tw.timedOut {
+        return 0, http.ErrHandlerTimeout
+    }
+    if tw.status == 0 {
+        tw.status = http.StatusOK
+    }
+    return tw.buf.Write(b)
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+    tw.mu.Lock()
+    defer tw.mu.Unlock()
+    if !tw.timedOut && tw.status == 0 {
+        tw.status = status
+    }
+}
+
+// withRequestTimeout is a middleware that honors X-Request-Timeout. The handler
+// runs with a context deadline of the requested duration (capped by
+// MAX_REQUEST_TIMEOUT); if it has not finished by then the client gets a 504
+// straight away rather than waiting on a slow operation.
+func withRequestTimeout(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        v := r.Header.Get("X-Request-Timeout")
+        if v == "" {
+            next.ServeHTTP(w, r)
+            return
+        }
+        timeout, ok := parseRequestTimeout(v)
+        if !ok {
+            writeError(w, "invalid_header", "X-Request-Timeout must be a positive duration such as 5s or a number of seconds")
+            return
+        }
+        timeout = min(timeout, maxRequestTimeout)
+
+        ctx, cancel := context.WithTimeout(r.Context(), timeout)
+        defer cancel()
+        r = r.WithContext(ctx)
+
+        tw := &timeoutWriter{header: make(http.Header)}
+        done := make(chan struct{})
+        panicked := make(chan interface{}, 1)
+        go func() {
+            defer func() {
+                if p := recover(); p != nil {
+                    panicked <- p
+                }
+            }()
+            next.ServeHTTP(tw, r)
+            close(done)
+        }()
+
+        select {
+        case p := <-panicked:
+            panic(p) // Re-raise on the serving goroutine so net/http handles it as usual.
+        case <-done:
+            tw.mu.Lock()
+            defer tw.mu.Unlock()
+            for k, vv := range tw.header {
+                w.Header()[k] = vv
+            }
+            if tw.status == 0 {
+                tw.status = http.StatusOK
+            }
+            w.WriteHeader(tw.status)
+            w.Write(tw.buf.Bytes())
+        case <-ctx.Done():
+            tw.mu.Lock()
+            defer tw.mu.Unlock()
+            tw.timedOut = true // Discard anything the handler writes from now on.
+            if r.Context().Err() == context.DeadlineExceeded {
+                writeError(w, "request_timeout", "request did not complete within X-Request-Timeout")
+            }
+        }
+    })
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "io"
+    "log"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Transfer job types, as given to GET /jobs?type=.
+const (
+    TransferImport = "import"
+    TransferExport = "export"
+)
+
+var (
+    // exportRetention is how long the file produced by an export is kept for
+    // re-download from GET /job/{id}/download. 0 keeps no files.
+    exportRetention = envDuration("EXPORT_RETENTION", 24*time.Hour)
+    transferHistory = envInt("TRANSFER_HISTORY_SIZE", 1000) // Finished import/export jobs to remember.
+)
+
+// TransferJob struct defines one finished import or export.
+type TransferJob struct {
+    ID          string     `json:"id"`
+    Type        string     `json:"type"` // import or export.
+    Format      string     `json:"format"`
+    RequestedBy string     `json:"requested_by"` // "admin", or a fingerprint of the API key.
+    StartedAt   time.Time  `json:"started_at"`
+    FinishedAt  time.Time  `json:"finished_at"`
+    Rows        int        `json:"rows"`                 // Books read from the file, or written to it.
+    Created     int        `json:"created,omitempty"`    // Imports only.
+    Updated     int        `json:"updated,omitempty"`    // Imports only.
+    Skipped     int        `json:"skipped,omitempty"`    // Imports only: conflicting records left out.
+    DryRun      bool       `json:"dry_run,omitempty"`    // Imports only: counted with ?dry_run=true, nothing written.
+    Error       string     `json:"error,omitempty"`      // Why the job failed; empty if it succeeded.
+    Size        int64      `json:"size,omitempty"`       // Exports only: size of the file in bytes.
+    Download    string     `json:"download,omitempty"`   // Exports only: where to fetch the file again while it is kept.
+    ExpiresAt   *time.Time `json:"expires_at,omitempty"` // When the kept file is deleted.
+    owner       string     // API key that ran the job.
+    mediaType   string     // Content-Type of the exported file.
+}
+
+var (
+    transferJobs = make(map[string]TransferJob) // Map to store finished jobs with their ID as the key.
+    transferSeq  int                            // Last job ID handed out.
+    transfersMux sync.RWMutex                   // RWMutex to safeguard transferJobs and transferSeq.
+)
+
+// keyLabel identifies an API key in job records without revealing it.
+func keyLabel(key string) string {
+    if key == adminAPIKey {
+        return "admin"
+    }
+    sum := sha256.Sum256([]byte(key))
+    return "key:" + hex.EncodeToString(sum[:4])
+}
+
+// newTransferJob starts the record of an import or export made by the request.
+func newTransferJob(r *http.Request, typ, format string) TransferJob {
+    key := r.Header.Get("X-API-Key")
+    transfersMux.Lock()
+    transferSeq++
+    id := strconv.Itoa(transferSeq)
+    transfersMux.Unlock()
+    return TransferJob{ID: id, Type: typ, Format: format, RequestedBy: keyLabel(key), StartedAt: clock.Now().UTC(), owner: key}
+}
+
+// finishTransfer stores a job record, dropping the oldest records once there
+// are more than transferHistory.
+func finishTransfer(job TransferJob) {
+    job.FinishedAt = clock.Now().UTC()
+    transfersMux.Lock()
+    defer transfersMux.Unlock()
+    transferJobs[job.ID] = job
+    for id := transferSeq - transferHistory; len(transferJobs) > transferHistory && id > 0; id-- {
+        if old, ok := transferJobs[strconv.Itoa(id)]; ok {
+            dropExport(old)
+            delete(transferJobs, old.ID)
+        }
+    }
+}
+
+// writeTransferError records a failed job and reports the failure.
+func writeTransferError(w http.ResponseWriter, job TransferJob, code, message string) {
+    job.Error = message
+    finishTransfer(job)
+    writeError(w, code, message)
+}
+
+// exportKey is the blob key an export's file is kept under.
+func exportKey(jobID string) string {
+    return "export-" + jobID
+}
+
+// keepExport stores an export's file for re-download and notes where to get it.
+func keepExport(job *TransferJob, data []byte) {
+    if exportRetention <= 0 {
+        return
+    }
+    if _, err := blobs.Put(exportKey(job.ID), bytes.NewReader(data)); err != nil {
+        log.Printf("keeping export %s: %v", job.ID, err) // The export itself worked; it just can't be fetched again.
+        return
+    }
+    expires := job.StartedAt.Add(exportRetention)
+    job.Download, job.ExpiresAt = "/job/"+job.ID+"/download", &expires
+}
+
+// dropExport deletes a job's kept file, if it has one. Callers hold transfersMux.
+func dropExport(job TransferJob) {
+    if job.ExpiresAt == nil {
+        return
+    }
+    if err := blobs.Delete(exportKey(job.ID)); err != nil {
+        log.Printf("deleting export %s: %v", job.ID, err)
+    }
+}
+
+// resetTransferJobs forgets every job and deletes the exports kept for
+// them, for a sandbox reset. IDs carry on from the last one, so a job still
+// running across the reset can't collide with a new one.
+func resetTransferJobs() {
+    transfersMux.Lock()
+    defer transfersMux.Unlock()
+    for _, job := range transferJobs {
+        dropExport(job)
+    }
+    transferJobs = make(map[string]TransferJob)
+}
+
+// expireExports is the scheduled job that deletes exported files once their
+// retention window has passed. The job records stay.
+func expireExports() error {
+    now := clock.Now()
+    transfersMux.Lock()
+    defer transfersMux.Unlock()
+    for id, job := range transferJobs {
+        if job.ExpiresAt != nil && now.After(*job.ExpiresAt) {
+            dropExport(job)
+            job.Download, job.ExpiresAt = "", nil
+            transferJobs[id] = job
+        }
+    }
+    return nil
+}
+
+// canSeeJob reports whether the request's API key may read a job. Admins see every job.
+func canSeeJob(r *http.Request, job TransferJob) bool {
+    return isAdmin(r) || job.owner == r.Header.Get("X-API-Key")
+}
+
+// listTransfers answers GET /jobs?type=import|export with the caller's
+// finished jobs of that type, newest first.
+func listTransfers(w http.ResponseWriter, r *http.Request, typ string) {
+    if typ != TransferImport && typ != TransferExport {
+        writeError(w, "invalid_query", "type must be import or export")
+        return
+    }
+    transfersMux.RLock()
+    list := make([]TransferJob, 0)
+    for _, job := range transferJobs {
+        if job.Type == typ && canSeeJob(r, job) {
+            list = append(list, job)
+        }
+    }
+    transfersMux.RUnlock()
+    sort.Slice(list, func(i, j int) bool { return lessID(list[j].ID, list[i].ID) })
+    start, end, err := paginate(w, r, len(list))
+    if err != nil {
+        writePageError(w, err)
+        return
+    }
+    json.NewEncoder(w).Encode(list[start:end])
+}
+
+// handleTransferJob handles requests for the /job/{id} and /job/{id}/download routes.
+func handleTransferJob(w http.ResponseWriter, r *http.Request) {
+    id, sub, _ := strings.Cut(r.URL.Path[len("/job/"):], "/")
+    if sub != "" && sub != "download" {
+        writeError(w, "not_found", "no route for "+r.URL.Path)
+        return
+    }
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    transfersMux.RLock()
+    job, ok := transferJobs[id]
+    transfersMux.RUnlock()
+    if !ok || !canSeeJob(r, job) { // Other keys' jobs look missing rather than forbidden.
+        writeError(w, "job_not_found", "job "+id+" not found")
+        return
+    }
+    if sub == "" {
+        json.NewEncoder(w).Encode(job)
+        return
+    }
+
+    if job.ExpiresAt == nil || clock.Now().After(*job.ExpiresAt) {
+        writeError(w, "export_expired", "job "+id+" has no export file to download")
+        return
+    }
+    rc, err := blobs.Open(exportKey(job.ID))
+    if err != nil {
+        writeError(w, "internal_error", "reading export "+job.ID+": "+err.Error())
+        return
+    }
+    defer rc.Close()
+    w.Header().Set("Content-Type", job.mediaType)
+    w.Header().Set("Content-Length", strconv.FormatInt(job.Size, 10))
+    w.Header().Set("Content-Disposition", `attachment; filename="books.`+job.Format+`"`)
+    io.Copy(w, rc)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "time"
+)
+
+// DeletedBook is a book in the trash: deleted, but restorable until purged.
+type DeletedBook struct {
+    Book  Book   // With DeletedAt set.
+    Owner string // API key that created the book, so it counts toward its quota again once restored.
+}
+
+var (
+    // deletedRetention is how long deleted books can be restored before the
+    // trash-purge job removes them for good.
+    deletedRetention = envDuration("DELETED_RETENTION", 30*24*time.Hour)
+
+    trash = make(map[string]DeletedBook) // Deleted books by ID. Guarded by mux.
+)
+
+// trashBook deletes a book by moving it from the store to the trash. Its
+// reviews, cover and counts are kept until it is purged. Callers hold mux.
+func trashBook(book Book) error {
+    if err := store.Delete(book.ID); err != nil {
+        return err
+    }
+    now := clock.Now().UTC()
+    book.DeletedAt = &now
+    trash[book.ID] = DeletedBook{Book: book, Owner: bookOwners[book.ID]}
+    releaseBook(book.ID)
+    return nil
+}
+
+// trashedBooks returns the books in the trash, for ?include_deleted=.
+// Callers hold mux.
+func trashedBooks() []Book {
+    bks := make([]Book, 0, len(trash))
+    for _, d := range trash {
+        bks = append(bks, d.Book)
+    }
+    return bks
+}
+
+// purgeTrash is the scheduled job that removes books deleted more than
+// deletedRetention ago, along with their reviews, cover and view count.
+func purgeTrash() error {
+    cutoff := clock.Now().UTC().Add(-deletedRetention)
+    mux.Lock()
+    defer mux.Unlock()
+    for id, d := range trash {
+        if d.Book.DeletedAt.After(cutoff) {
+            continue
+        }
+        purgeBook(id)
+        touchCatalog() // Gone from ?include_deleted= listings.
+    }
+    return nil
+}
+
+// purgeBook removes a book from the trash for good, with its reviews, cover
+// and view count. Callers hold mux.
+func purgeBook(id string) {
+    delete(trash, id)
+    dropReviews(id)
+    dropCover(id)
+    dropCounters(id)
+}
+
+// supersedeTrashed purges the book in the trash with the given ID, if any,
+// when a new book is created with that ID: the new book must start without
+// the old one's reviews, cover and views, and the old one could no longer be
+// restored over it anyway. Callers hold mux.
+func supersedeTrashed(id string) {
+    if _, ok := trash[id]; ok {
+        purgeBook(id)
+    }
+}
+
+// handleBookRestore handles POST /book/{id}/restore, bringing a deleted book
+// back from the trash as a new version. Needs the admin key.
+func handleBookRestore(w http.ResponseWriter, r *http.Request, id string) {
+    if r.Method != "POST" {
+        methodNotAllowed(w, r)
+        return
+    }
+    if !isAdmin(r) {
+        writeError(w, "admin_required", "restoring deleted books requires the admin key")
+        return
+    }
+    mux.Lock()
+    defer mux.Unlock()
+    d, ok := trash[id]
+    if !ok {
+        writeError(w, "book_not_found", "book "+id+" is not in the trash")
+        return
+    }
+    if _, exists := getBook(id); exists {
+        writeError(w, "duplicate", "a new book "+id+" has been created since; delete it before restoring this one")
+        return
+    }
+    book := d.Book
+    stampBook(&book, d.Book, true) // Clears DeletedAt.
+    if err := store.Create(book); err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    delete(trash, id)
+    if d.Owner != "" {
+        claimBook(d.Owner, id)
+    }
+    recordChange(ChangeCreate, id, &book)
+    w.Header().Set("ETag", bookETag(book))
+    json.NewEncoder(w).Encode(book)
+}
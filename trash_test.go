@@ -0,0 +1,86 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestTrashAndRestore(t *testing.T) {
+    useTestSeams(t, time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC))
+    staff := map[string]string{"X-API-Key": adminAPIKey}
+    reader := map[string]string{"X-API-Key": "secret-key"}
+    reviewCount := func() int {
+        t.Helper()
+        var page ReviewPage
+        w := serveBook("GET", "/book/trash-1/reviews", "", reader)
+        if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+            t.Fatalf("GET reviews = %d %s", w.Code, w.Body)
+        }
+        return page.Count
+    }
+    views := func() int64 {
+        flushCounters()
+        counterTotalsMux.RLock()
+        defer counterTotalsMux.RUnlock()
+        return counterTotals[counterKey{CounterViews, "trash-1"}]
+    }
+
+    if w := serveBook("PUT", "/book/trash-1", `{"title":"Dune"}`, nil); w.Code != http.StatusOK {
+        t.Fatalf("PUT = %d %s", w.Code, w.Body)
+    }
+    if w := serveBook("POST", "/book/trash-1/reviews", `{"rating":5,"body":"Spice"}`, reader); w.Code != http.StatusCreated {
+        t.Fatalf("POST review = %d %s", w.Code, w.Body)
+    }
+    serveBook("GET", "/book/trash-1", "", reader)
+    if got := views(); got != 1 {
+        t.Fatalf("views = %d, want 1", got)
+    }
+
+    if w := serveBook("DELETE", "/book/trash-1", "", nil); w.Code != http.StatusNoContent && w.Code != http.StatusOK {
+        t.Fatalf("DELETE = %d %s", w.Code, w.Body)
+    }
+    if w := serveBook("GET", "/book/trash-1", "", staff); w.Code != http.StatusNotFound {
+        t.Errorf("GET of a deleted book = %d, want %d", w.Code, http.StatusNotFound)
+    }
+    if w := serveBook("POST", "/book/trash-1/restore", "", reader); w.Code != http.StatusForbidden {
+        t.Errorf("restore without the admin key = %d, want %d", w.Code, http.StatusForbidden)
+    }
+    w := serveBook("POST", "/book/trash-1/restore", "", staff)
+    if w.Code != http.StatusOK {
+        t.Fatalf("restore = %d %s", w.Code, w.Body)
+    }
+    var book Book
+    if err := json.Unmarshal(w.Body.Bytes(), &book); err != nil {
+        t.Fatal(err)
+    }
+    if book.Title != "Dune" || book.DeletedAt != nil || book.Version != 3 {
+        t.Errorf("restored book = %q, deleted_at %v, version %d; want \"Dune\", not deleted, version 3", book.Title, book.DeletedAt, book.Version)
+    }
+    if got := reviewCount(); got != 1 {
+        t.Errorf("restored book has %d reviews, want its 1", got)
+    }
+    if w := serveBook("POST", "/book/trash-1/restore", "", staff); w.Code != http.StatusNotFound {
+        t.Errorf("second restore = %d, want %d", w.Code, http.StatusNotFound)
+    }
+
+    // A new book taking a deleted book's ID starts afresh.
+    serveBook("DELETE", "/book/trash-1", "", nil)
+    if w := serveBook("PUT", "/book/trash-1", `{"title":"Another book"}`, nil); w.Code != http.StatusOK {
+        t.Fatalf("PUT over a deleted ID = %d %s", w.Code, w.Body)
+    }
+    if got := reviewCount(); got != 0 {
+        t.Errorf("new book inherited %d reviews", got)
+    }
+    if got := views(); got != 0 {
+        t.Errorf("new book inherited %d views", got)
+    }
+    if w := serveBook("GET", "/book/trash-1", "", reader); strings.Contains(w.Body.String(), "review_count") {
+        t.Errorf("new book shows the old rating: %s", w.Body)
+    }
+    if w := serveBook("POST", "/book/trash-1/restore", "", staff); w.Code != http.StatusNotFound {
+        t.Errorf("restoring the replaced book = %d, want %d", w.Code, http.StatusNotFound)
+    }
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Counter names for per-key request usage. Their IDs are usageID(key, hour).
+const (
+    CounterRequests     = "requests"
+    CounterErrors       = "errors"        // Responses with a 4xx or 5xx status.
+    CounterServerErrors = "server_errors" // Responses with a 5xx status.
+)
+
+// usageWindows are the windows GET /admin/usage can summarize.
+var usageWindows = map[string]time.Duration{
+    "1h":  time.Hour,
+    "24h": 24 * time.Hour,
+    "7d":  7 * 24 * time.Hour,
+    "30d": 30 * 24 * time.Hour,
+}
+
+// usageRetention is how long hourly usage is kept: the longest window.
+const usageRetention = 30 * 24 * time.Hour
+
+// UsageCounts are the request counts of a key or tenant over a window.
+type UsageCounts struct {
+    Requests     int64   `json:"requests"`
+    Errors       int64   `json:"errors"`        // 4xx and 5xx responses.
+    ServerErrors int64   `json:"server_errors"` // 5xx responses.
+    ErrorRate    float64 `json:"error_rate"`    // Errors as a fraction of requests.
+}
+
+// KeyUsage is one API key's entry in GET /admin/usage.
+type KeyUsage struct {
+    Key    string     `json:"key"` // The key's label, never the key itself.
+    Tenant string     `json:"tenant"`
+    Quota  QuotaUsage `json:"quota"`
+    UsageCounts
+}
+
+// TenantUsage is one tenant's entry in GET /admin/usage: its keys together.
+type TenantUsage struct {
+    Tenant string `json:"tenant"`
+    Keys   int    `json:"keys"`
+    UsageCounts
+}
+
+// UsageReport is the response for GET /admin/usage.
+type UsageReport struct {
+    Window  string        `json:"window"`
+    From    time.Time     `json:"from"`
+    To      time.Time     `json:"to"`
+    Keys    []KeyUsage    `json:"keys"`
+    Tenants []TenantUsage `json:"tenants"`
+}
+
+// usageKey identifies one key's counts in one hour.
+type usageKey struct {
+    key  string
+    hour int64 // Unix time of the start of the hour.
+}
+
+var (
+    usageTotals = make(map[usageKey]*UsageCounts) // Flushed hourly counts per key. ErrorRate is left unset.
+    usageMux    sync.RWMutex                      // RWMutex to safeguard usageTotals.
+)
+
+// usageID is the counter ID of a key's usage in the hour containing t.
+func usageID(key string, t time.Time) string {
+    return strconv.FormatInt(t.Truncate(time.Hour).Unix(), 10) + " " + key
+}
+
+// recordKeyUsage counts a finished request toward its API key's usage.
+// Requests without a valid key aren't counted, so made-up keys can't fill
+// the table.
+func recordKeyUsage(r *http.Request, status int) {
+    key := r.Header.Get("X-API-Key")
+    if !validAPIKey(key) {
+        return
+    }
+    id := usageID(key, clock.Now().UTC())
+    incrementCounter(CounterRequests, id)
+    if status >= 400 {
+        incrementCounter(CounterErrors, id)
+    }
+    if status >= 500 {
+        incrementCounter(CounterServerErrors, id)
+    }
+}
+
+// flushUsage folds flushed usage counts into usageTotals and forgets hours
+// past usageRetention.
+func flushUsage(drained map[counterKey]int64) {
+    cutoff := clock.Now().UTC().Add(-usageRetention).Unix()
+    usageMux.Lock()
+    defer usageMux.Unlock()
+    for k, n := range drained {
+        hour, key, _ := strings.Cut(k.id, " ")
+        h, _ := strconv.ParseInt(hour, 10, 64)
+        uk := usageKey{key, h}
+        c := usageTotals[uk]
+        if c == nil {
+            c = &UsageCounts{}
+            usageTotals[uk] = c
+        }
+        switch k.name {
+        case CounterRequests:
+            c.Requests += n
+        case CounterErrors:
+            c.Errors += n
+        case CounterServerErrors:
+            c.ServerErrors += n
+        }
+    }
+    for uk := range usageTotals {
+        if uk.hour < cutoff {
+            delete(usageTotals, uk)
+        }
+    }
+}
+
+// add adds other's counts to c.
+func (c *UsageCounts) add(other UsageCounts) {
+    c.Requests += other.Requests
+    c.Errors += other.Errors
+    c.ServerErrors += other.ServerErrors
+}
+
+// rate fills in ErrorRate.
+func (c *UsageCounts) rate() {
+    if c.Requests > 0 {
+        c.ErrorRate = float64(c.Errors) / float64(c.Requests)
+    }
+}
+
+// tenantOf returns the tenant a key is billed to; keys without one are their
+// own tenant.
+func tenantOf(key string) string {
+    if t, ok := quotas.Tenants[key]; ok {
+        return t
+    }
+    return keyLabel(key)
+}
+
+// handleUsage handles GET /admin/usage: requests, error rates and quota use
+// per API key and per tenant over ?window= (1h, 24h, 7d or 30d; 24h when
+// unset). Counts are by the hour and lag by up to COUNTERS_FLUSH_INTERVAL.
+// Needs the admin key.
+func handleUsage(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    if !isAdmin(r) {
+        writeError(w, "admin_required", "usage reports require the admin key")
+        return
+    }
+    window := r.URL.Query().Get("window")
+    if window == "" {
+        window = "24h"
+    }
+    length, ok := usageWindows[window]
+    if !ok {
+        writeError(w, "invalid_query", "window must be 1h, 24h, 7d or 30d")
+        return
+    }
+    now := clock.Now().UTC()
+    from := now.Truncate(time.Hour).Add(-length + time.Hour) // Whole hours, including the current one.
+
+    byKey := make(map[string]UsageCounts)
+    usageMux.RLock()
+    for uk, c := range usageTotals {
+        if uk.hour >= from.Unix() {
+            sum := byKey[uk.key]
+            sum.add(*c)
+            byKey[uk.key] = sum
+        }
+    }
+    usageMux.RUnlock()
+
+    report := UsageReport{Window: window, From: from, To: now, Keys: []KeyUsage{}, Tenants: []TenantUsage{}}
+    tenants := make(map[string]*TenantUsage)
+    for key, counts := range byKey {
+        tenant := tenantOf(key)
+        t := tenants[tenant]
+        if t == nil {
+            t = &TenantUsage{Tenant: tenant}
+            tenants[tenant] = t
+        }
+        t.Keys++
+        t.add(counts)
+        counts.rate()
+        report.Keys = append(report.Keys, KeyUsage{Key: keyLabel(key), Tenant: tenant, Quota: quotaUsage(key), UsageCounts: counts})
+    }
+    for _, t := range tenants {
+        t.rate()
+        report.Tenants = append(report.Tenants, *t)
+    }
+    sort.Slice(report.Keys, func(i, j int) bool { // Busiest first.
+        if report.Keys[i].Requests != report.Keys[j].Requests {
+            return report.Keys[i].Requests > report.Keys[j].Requests
+        }
+        return report.Keys[i].Key < report.Keys[j].Key
+    })
+    sort.Slice(report.Tenants, func(i, j int) bool {
+        if report.Tenants[i].Requests != report.Tenants[j].Requests {
+            return report.Tenants[i].Requests > report.Tenants[j].Requests
+        }
+        return report.Tenants[i].Tenant < report.Tenants[j].Tenant
+    })
+    json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+    "errors"
+    "strconv"
+    "strings"
+)
+
+// normalizeISBN strips the hyphens and spaces ISBNs are usually printed with.
+func normalizeISBN(isbn string) string {
+    return strings.NewReplacer("-", "", " ", "").Replace(isbn)
+}
+
+// validISBN13 reports whether s is 13 digits starting with 978 or 979 whose
+// last digit is the correct check digit.
+func validISBN13(s string) bool {
+    if len(s) != 13 || (!strings.HasPrefix(s, "978") && !strings.HasPrefix(s, "979")) {
+        return false
+    }
+    sum := 0
+    for i := 0; i < 13; i++ {
+        d := int(s[i] - '0')
+        if d < 0 || d > 9 {
+            return false
+        }
+        if i%2 == 1 {
+            d *= 3
+        }
+        sum += d
+    }
+    return sum%10 == 0
+}
+
+// checkBook normalizes a book's catalog fields and checks what the request
+// schema can't: the ISBN checksum, a plausible publication year, the edition
+// format and that the linked author, publisher and genres exist. A book
+// without a visibility is published. Every write path runs it, including imports and
+// sync, which skip the schema.
+func checkBook(book *Book) error {
+    if book.ISBN != "" {
+        book.ISBN = normalizeISBN(book.ISBN)
+        if !validISBN13(book.ISBN) {
+            return errors.New("isbn must be a valid ISBN-13")
+        }
+    }
+    if latest := clock.Now().Year() + 1; book.PublicationYear < 0 || book.PublicationYear > latest {
+        return errors.New("publication_year must be between 1 and " + strconv.Itoa(latest)) // Next year's titles can be pre-ordered.
+    }
+    if book.PriceCents < 0 {
+        return errors.New("price_cents must not be negative")
+    }
+    if err := checkVisibility(book); err != nil {
+        return err
+    }
+    if err := checkEdition(book); err != nil {
+        return err
+    }
+    if err := checkBookGenres(book); err != nil {
+        return err
+    }
+    if err := checkTags(book); err != nil {
+        return err
+    }
+    if err := checkMetadata(book); err != nil {
+        return err
+    }
+    if err := checkCustomFields(book); err != nil {
+        return err
+    }
+    return linkAuthor(book)
+}
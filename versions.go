@@ -0,0 +1,199 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// VersionSummary is one entry in GET /book/{id}/versions.
+type VersionSummary struct {
+    Version int        `json:"version"`
+    At      *time.Time `json:"at,omitempty"`     // Unknown for versions written before the last restart.
+    Via     string     `json:"via,omitempty"`    // import, sync or revert; empty for a plain API call.
+    Action  string     `json:"action"`           // created, updated or deleted; unknown before the last restart.
+    Fields  []string   `json:"fields,omitempty"` // Fields changed since the previous version, for updates.
+}
+
+// versionAction says what a version did: created, updated or deleted the
+// book. Callers hold historyMux.
+func versionAction(versions []BookVersion, i int) string {
+    switch {
+    case versions[i].At.IsZero():
+        return "unknown" // Placeholder for a version written by a previous run.
+    case versions[i].Book == nil:
+        return "deleted"
+    case i == 0 || versions[i-1].Book == nil:
+        return "created"
+    }
+    return "updated"
+}
+
+// canSeeHistory reports whether the caller may read a book's versions. Like
+// the book itself, they are hidden from everyone but staff unless the book is
+// in the public catalog, which also hides the history of deleted books.
+func canSeeHistory(r *http.Request, bookID string) bool {
+    if isAdmin(r) {
+        return true
+    }
+    mux.RLock()
+    book, ok := getBook(bookID)
+    mux.RUnlock()
+    return ok && isPublished(book)
+}
+
+// handleBookVersions handles the /book/{id}/versions routes:
+//
+//	GET  /book/{id}/versions              every version, oldest first
+//	GET  /book/{id}/versions/{n}          the book as of version n
+//	POST /book/{id}/versions/{n}/revert   write version n again as a new version
+func handleBookVersions(w http.ResponseWriter, r *http.Request, id, rest string) {
+    if rest == "" {
+        handleVersionList(w, r, id)
+        return
+    }
+    n, action, _ := strings.Cut(rest, "/")
+    version, err := strconv.Atoi(n)
+    if err != nil {
+        writeError(w, "not_found", "no route for "+r.URL.Path)
+        return
+    }
+    switch action {
+    case "":
+        handleVersion(w, r, id, version)
+    case "revert":
+        handleVersionRevert(w, r, id, version)
+    default:
+        writeError(w, "not_found", "no route for "+r.URL.Path)
+    }
+}
+
+// handleVersionList handles GET /book/{id}/versions.
+func handleVersionList(w http.ResponseWriter, r *http.Request, id string) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    if !canSeeHistory(r, id) {
+        writeError(w, "book_not_found", "book "+id+" not found")
+        return
+    }
+    historyMux.RLock()
+    versions := bookHistory[id]
+    list := make([]VersionSummary, len(versions))
+    for i, v := range versions {
+        s := VersionSummary{Version: v.Version, Via: v.Via, Action: versionAction(versions, i)}
+        if !v.At.IsZero() {
+            at := v.At
+            s.At = &at
+        }
+        if s.Action == "updated" && versions[i-1].Book != nil {
+            for name := range fieldDiff(*versions[i-1].Book, *v.Book) {
+                s.Fields = append(s.Fields, name)
+            }
+            sort.Strings(s.Fields)
+        }
+        list[i] = s
+    }
+    historyMux.RUnlock()
+    if len(list) == 0 {
+        writeError(w, "book_not_found", "book "+id+" not found")
+        return
+    }
+    start, end, err := paginate(w, r, len(list))
+    if err != nil {
+        writePageError(w, err)
+        return
+    }
+    json.NewEncoder(w).Encode(list[start:end])
+}
+
+// handleVersion handles GET /book/{id}/versions/{n}. A version that deleted
+// the book comes back without one.
+func handleVersion(w http.ResponseWriter, r *http.Request, id string, version int) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    if !canSeeHistory(r, id) {
+        writeError(w, "book_not_found", "book "+id+" not found")
+        return
+    }
+    historyMux.RLock()
+    versions := bookHistory[id]
+    var v BookVersion
+    ok := version >= 1 && version <= len(versions)
+    if ok {
+        v = versions[version-1]
+    }
+    historyMux.RUnlock()
+    if !ok || v.At.IsZero() {
+        writeError(w, "version_not_found", "version "+strconv.Itoa(version)+" of book "+id+" is not recorded")
+        return
+    }
+    if v.Book != nil && !canSeeBook(r, *v.Book) { // An earlier draft of a book published since.
+        writeError(w, "version_not_found", "version "+strconv.Itoa(version)+" of book "+id+" is not recorded")
+        return
+    }
+    json.NewEncoder(w).Encode(v)
+}
+
+// handleVersionRevert handles POST /book/{id}/versions/{n}/revert: the book's
+// content as of version n is written as a new version, so the revert itself
+// shows up in the history and can be undone the same way. The old content is
+// checked like any other write, as the catalog may have changed around it.
+// Deleted books must be restored from the trash first. Only versions the
+// caller could read can be reverted to.
+func handleVersionRevert(w http.ResponseWriter, r *http.Request, id string, version int) {
+    if r.Method != "POST" {
+        methodNotAllowed(w, r)
+        return
+    }
+    if !canSeeHistory(r, id) {
+        writeError(w, "book_not_found", "book "+id+" not found")
+        return
+    }
+    old := bookAtVersion(id, version)
+    if old == nil || !canSeeBook(r, *old) { // A draft version stays hidden, as on GET.
+        writeError(w, "version_not_found", "version "+strconv.Itoa(version)+" of book "+id+" is not recorded, or deleted the book")
+        return
+    }
+    book := *old
+    if err := checkBook(&book); err != nil {
+        writeError(w, "validation_failed", "version "+strconv.Itoa(version)+" is no longer valid: "+err.Error())
+        return
+    }
+    mux.Lock()
+    defer mux.Unlock()
+    prev, exists, err := store.Get(id)
+    if err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    if !exists {
+        writeError(w, "book_not_found", "book "+id+" not found; restore it before reverting it")
+        return
+    }
+    if !checkIfMatch(w, r, prev, exists) {
+        return // Only revert the version the client last saw.
+    }
+    if err := checkWriteQuota(r.Header.Get("X-API-Key"), []Book{book}); err != nil {
+        writeError(w, "quota_exceeded", err.Error())
+        return
+    }
+    if isDryRun(r) {
+        writeDryRun(w, ChangeUpdate, &book, previousBook(prev, exists)) // Report the revert without applying it.
+        return
+    }
+    stampBook(&book, prev, exists)
+    if err := store.Update(book); err != nil {
+        writeError(w, "internal_error", err.Error())
+        return
+    }
+    recordChangeVia("revert", ChangeUpdate, id, &book)
+    w.Header().Set("ETag", bookETag(book))
+    json.NewEncoder(w).Encode(book)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+    "time"
+)
+
+func TestVersionRevert(t *testing.T) {
+    useTestSeams(t, time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC))
+    staff := map[string]string{"X-API-Key": adminAPIKey}
+    reader := map[string]string{"X-API-Key": "secret-key"}
+    for i, body := range []string{
+        `{"title":"Dune (draft)","visibility":"draft"}`,
+        `{"title":"Dune","visibility":"published","version":1}`,
+        `{"title":"Dune, 2nd ed.","visibility":"published","version":2}`,
+        `{"title":"Secret","visibility":"draft","version":3}`,
+    } {
+        if w := serveBook("PUT", "/book/rev-1", body, staff); w.Code != http.StatusOK {
+            t.Fatalf("PUT %d = %d %s", i+1, w.Code, w.Body)
+        }
+    }
+
+    // The book is a draft now, so only staff see its history.
+    if w := serveBook("POST", "/book/rev-1/versions/2/revert", "", reader); w.Code != http.StatusNotFound {
+        t.Errorf("reader reverting a draft = %d, want %d", w.Code, http.StatusNotFound)
+    }
+    if w := serveBook("POST", "/book/rev-1/versions/3/revert", "", staff); w.Code != http.StatusOK {
+        t.Fatalf("staff revert = %d %s", w.Code, w.Body)
+    }
+
+    tests := []struct {
+        name    string
+        path    string
+        header  map[string]string
+        want    int
+        version int // The book's version afterwards.
+    }{
+        {"dry run", "/book/rev-1/versions/2/revert?dry_run=true", reader, http.StatusOK, 5},
+        {"to a draft version", "/book/rev-1/versions/1/revert", reader, http.StatusNotFound, 5},
+        {"to a published version", "/book/rev-1/versions/2/revert", reader, http.StatusOK, 6},
+        {"staff to a draft version", "/book/rev-1/versions/1/revert", staff, http.StatusOK, 7},
+    }
+    for _, tt := range tests {
+        w := serveBook("POST", tt.path, "", tt.header)
+        if w.Code != tt.want {
+            t.Errorf("%s = %d, want %d (%s)", tt.name, w.Code, tt.want, w.Body)
+        }
+        if tt.name == "dry run" && w.Header().Get("X-Dry-Run") != "true" {
+            t.Errorf("dry run isn't marked as one")
+        }
+        if got := currentVersion("rev-1"); got != tt.version {
+            t.Errorf("after %s the book is at version %d, want %d", tt.name, got, tt.version)
+        }
+    }
+}
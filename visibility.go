@@ -0,0 +1,101 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "strings"
+)
+
+// Book visibility states, as set in a book's visibility field. Only
+// published books are listed to ordinary API keys.
+const (
+    VisibilityDraft     = "draft"     // Being catalogued or awaiting review.
+    VisibilityPublished = "published" // Part of the public catalog. Books without a visibility are published.
+    VisibilityArchived  = "archived"  // Withdrawn from the public catalog but kept.
+)
+
+// visibilitySet is the set of visibility states a list should show.
+type visibilitySet map[string]bool
+
+// bookVisibility returns a book's visibility, treating books saved before
+// visibility existed as published.
+func bookVisibility(book Book) string {
+    if book.Visibility == "" {
+        return VisibilityPublished
+    }
+    return book.Visibility
+}
+
+// isPublished reports whether a book is part of the public catalog.
+func isPublished(book Book) bool {
+    return bookVisibility(book) == VisibilityPublished
+}
+
+// checkVisibility defaults a book's visibility to published and rejects
+// unknown states.
+func checkVisibility(book *Book) error {
+    switch book.Visibility {
+    case "":
+        book.Visibility = VisibilityPublished
+    case VisibilityDraft, VisibilityPublished, VisibilityArchived:
+    default:
+        return errors.New("visibility must be draft, published or archived")
+    }
+    return nil
+}
+
+// parseVisibility reads ?visibility=, a comma-separated list of states or
+// "all". Without it only published books are shown.
+func parseVisibility(r *http.Request) (visibilitySet, error) {
+    v := r.URL.Query().Get("visibility")
+    switch v {
+    case "":
+        return visibilitySet{VisibilityPublished: true}, nil
+    case "all":
+        return visibilitySet{VisibilityDraft: true, VisibilityPublished: true, VisibilityArchived: true}, nil
+    }
+    set := make(visibilitySet)
+    for _, state := range strings.Split(v, ",") {
+        switch state {
+        case VisibilityDraft, VisibilityPublished, VisibilityArchived:
+            set[state] = true
+        default:
+            return nil, errors.New("visibility must be all or a comma-separated list of draft, published and archived")
+        }
+    }
+    return set, nil
+}
+
+// staffOnly reports whether the set reaches beyond the public catalog, which
+// needs the admin key.
+func (s visibilitySet) staffOnly() bool {
+    return s[VisibilityDraft] || s[VisibilityArchived]
+}
+
+// canSeeBook reports whether the request may read a single book: staff see
+// every book, everyone else only published ones.
+func canSeeBook(r *http.Request, book Book) bool {
+    return isPublished(book) || isAdmin(r)
+}
+
+// visibleChange returns a change-log entry as the request may see it. For
+// everyone but staff, a change that leaves a book outside the public catalog
+// reads as its deletion, so feeds never carry a draft or archived book and
+// clients drop one that has just been unpublished.
+func visibleChange(r *http.Request, c Change) Change {
+    if c.Book == nil || canSeeBook(r, *c.Book) {
+        return c
+    }
+    return Change{Seq: c.Seq, Op: ChangeDelete, BookID: c.BookID, At: c.At}
+}
+
+// publishedOnly drops the books that aren't part of the public catalog.
+func publishedOnly(bks []Book) []Book {
+    kept := make([]Book, 0, len(bks))
+    for _, book := range bks {
+        if isPublished(book) {
+            kept = append(kept, book)
+        }
+    }
+    return kept
+}
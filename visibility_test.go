@@ -0,0 +1,84 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestUnpublishedBooksStayHidden(t *testing.T) {
+    useTestSeams(t, time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC))
+    staff := map[string]string{"X-API-Key": adminAPIKey}
+    reader := map[string]string{"X-API-Key": "secret-key"}
+    since := formatCursor(currentChangeSeq())
+
+    if w := serveBook("PUT", "/book/vis-public", `{"title":"Published","visibility":"published"}`, staff); w.Code != http.StatusOK {
+        t.Fatalf("PUT published = %d %s", w.Code, w.Body)
+    }
+    if w := serveBook("PUT", "/book/vis-draft", `{"title":"Secret draft","visibility":"draft"}`, staff); w.Code != http.StatusOK {
+        t.Fatalf("PUT draft = %d %s", w.Code, w.Body)
+    }
+
+    for _, path := range []string{"/book/vis-draft", "/book/vis-draft/graph", "/book/vis-draft/activity", "/book/vis-draft/versions"} {
+        if w := serveBook("GET", path, "", reader); w.Code != http.StatusNotFound {
+            t.Errorf("GET %s = %d, want %d", path, w.Code, http.StatusNotFound)
+        }
+        if w := serveBook("GET", path, "", staff); w.Code != http.StatusOK {
+            t.Errorf("GET %s as staff = %d, want %d", path, w.Code, http.StatusOK)
+        }
+    }
+
+    feeds := []struct {
+        name    string
+        handler http.HandlerFunc
+        path    string
+    }{
+        {"export", handleBooksExport, "/books/export?format=json"},
+        {"full sync", handleSync, "/sync"},
+        {"delta sync", handleSync, "/sync?since=" + since},
+        {"changes", handleBookChanges, "/books/changes?since=" + since},
+    }
+    for _, tt := range feeds {
+        for _, as := range []struct {
+            header map[string]string
+            shown  bool
+        }{{reader, false}, {staff, true}} {
+            r := httptest.NewRequest("GET", tt.path, nil)
+            for k, v := range as.header {
+                r.Header.Set(k, v)
+            }
+            w := httptest.NewRecorder()
+            tt.handler(w, r)
+            if w.Code != http.StatusOK {
+                t.Fatalf("%s = %d %s", tt.name, w.Code, w.Body)
+            }
+            body := w.Body.String()
+            if !strings.Contains(body, "Published") {
+                t.Errorf("%s is missing the published book: %s", tt.name, body)
+            }
+            if strings.Contains(body, "Secret draft") != as.shown {
+                t.Errorf("%s shows the draft: %v, want %v", tt.name, !as.shown, as.shown)
+            }
+        }
+    }
+
+    // A reader following the changes learns that a book left the catalog.
+    since = formatCursor(currentChangeSeq())
+    if w := serveBook("PUT", "/book/vis-public", `{"title":"Published","visibility":"archived","version":1}`, staff); w.Code != http.StatusOK {
+        t.Fatalf("PUT archived = %d %s", w.Code, w.Body)
+    }
+    r := httptest.NewRequest("GET", "/sync?since="+since, nil)
+    r.Header.Set("X-API-Key", "secret-key")
+    w := httptest.NewRecorder()
+    handleSync(w, r)
+    var resp SyncResponse
+    if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+        t.Fatal(err)
+    }
+    if len(resp.Upserts) != 0 || len(resp.Tombstones) != 1 || resp.Tombstones[0].ID != "vis-public" {
+        t.Errorf("sync after archiving = %d upserts, tombstones %v; want only a tombstone for vis-public", len(resp.Upserts), resp.Tombstones)
+    }
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "log"
+    "os"
+    "sync"
+)
+
+var (
+    // walFile makes the memory store crash-safe: every book write is appended
+    // to it before it is applied, and it is replayed on top of the last
+    // snapshot at startup. Each snapshot compacts it back to empty, so it
+    // needs SNAPSHOT_FILE too.
+    walFile = envString("WAL_FILE", "")
+    walSync = envBool("WAL_SYNC", true) // fsync after every entry; off trades the last few writes for speed.
+
+    // wal is the write-ahead log wrapper, if WAL_FILE is set.
+    wal *walStore
+)
+
+// WALEntry is one line of WAL_FILE.
+type WALEntry struct {
+    Op   string `json:"op"` // create, update or delete.
+    ID   string `json:"id"`
+    Book *Book  `json:"book,omitempty"` // The whole book after the write; nil for deletes.
+}
+
+// walStore logs every write to WAL_FILE before passing it on. Until the log
+// is opened, after replay, writes go straight through unlogged.
+type walStore struct {
+    BookStore
+
+    mu   sync.Mutex // Guards file.
+    file *os.File
+}
+
+// append writes an entry to the log, syncing it to disk if WAL_SYNC is on.
+func (s *walStore) append(e WALEntry) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.file == nil {
+        return nil
+    }
+    data, err := json.Marshal(e)
+    if err != nil {
+        return err
+    }
+    if _, err := s.file.Write(append(data, '\n')); err != nil {
+        return err
+    }
+    if walSync {
+        return s.file.Sync()
+    }
+    return nil
+}
+
+// Writes are logged first: a write that fails to log isn't applied, and a
+// logged write that fails to apply is replayed as an upsert or delete, which
+// is harmless.
+func (s *walStore) Create(book Book) error {
+    if err := s.append(WALEntry{Op: ChangeCreate, ID: book.ID, Book: &book}); err != nil {
+        return err
+    }
+    return s.BookStore.Create(book)
+}
+
+func (s *walStore) Update(book Book) error {
+    if err := s.append(WALEntry{Op: ChangeUpdate, ID: book.ID, Book: &book}); err != nil {
+        return err
+    }
+    return s.BookStore.Update(book)
+}
+
+func (s *walStore) Delete(id string) error {
+    if err := s.append(WALEntry{Op: ChangeDelete, ID: id}); err != nil {
+        return err
+    }
+    return s.BookStore.Delete(id)
+}
+
+// truncate empties the log once a snapshot holds everything in it. Callers
+// hold mux so no write lands between the snapshot and the truncation.
+func (s *walStore) truncate() error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.file == nil {
+        return nil
+    }
+    if err := s.file.Truncate(0); err != nil {
+        return err
+    }
+    _, err := s.file.Seek(0, 0)
+    return err
+}
+
+// replayWAL applies the entries in WAL_FILE to the store at startup, after
+// the snapshot has been loaded, then opens the log for new writes. A torn
+// last line, from a crash mid-append, is dropped; corruption anywhere else
+// stops startup.
+func replayWAL() {
+    if wal == nil {
+        return
+    }
+    if snapshotFile == "" {
+        log.Fatal("WAL_FILE needs SNAPSHOT_FILE to compact into")
+    }
+    f, err := os.Open(walFile)
+    if err != nil && !errors.Is(err, os.ErrNotExist) {
+        log.Fatalf("reading WAL_FILE: %v", err)
+    }
+    if f != nil {
+        var entries []WALEntry
+        var bad error
+        sc := bufio.NewScanner(f)
+        sc.Buffer(nil, 16<<20)
+        for sc.Scan() {
+            if bad != nil {
+                log.Fatalf("WAL_FILE entry %d: %v", len(entries)+1, bad) // Not the last line, so not a torn write.
+            }
+            var e WALEntry
+            if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+                bad = err
+                continue
+            }
+            entries = append(entries, e)
+        }
+        f.Close()
+        if err := sc.Err(); err != nil {
+            log.Fatalf("reading WAL_FILE: %v", err)
+        }
+        if bad != nil {
+            log.Printf("dropping torn last entry of WAL_FILE: %v", bad)
+        }
+
+        mux.Lock()
+        for _, e := range entries {
+            if e.Book != nil {
+                err = upsert(store, *e.Book)
+            } else {
+                err = store.Delete(e.ID)
+            }
+            if err != nil {
+                log.Fatalf("replaying WAL_FILE: %s %s: %v", e.Op, e.ID, err)
+            }
+        }
+        mux.Unlock()
+        if len(entries) > 0 {
+            log.Printf("replayed %d writes from WAL_FILE", len(entries))
+        }
+    }
+
+    file, err := os.OpenFile(walFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+    if err != nil {
+        log.Fatalf("opening WAL_FILE: %v", err)
+    }
+    wal.mu.Lock()
+    wal.file = file
+    wal.mu.Unlock()
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Weeding workflow states. A copy is flagged by staff, approved (or rejected)
+// by an admin, and only then deaccessioned.
+const (
+    WeedingFlagged       = "flagged"
+    WeedingApproved      = "approved"
+    WeedingRejected      = "rejected"
+    WeedingDeaccessioned = "deaccessioned"
+)
+
+// weedingReasons are the reason codes accepted when flagging a copy.
+var weedingReasons = map[string]bool{
+    "damaged":         true, // Beyond economical repair.
+    "outdated":        true, // Content is no longer accurate.
+    "superseded":      true, // Replaced by a newer edition.
+    "low_circulation": true, // Not borrowed in a long time.
+    "duplicate":       true, // Surplus copy.
+    "lost":            true, // Missing from the shelf.
+}
+
+// WeedingRecord tracks one copy through the weeding workflow.
+type WeedingRecord struct {
+    ID              string     `json:"id"`
+    CopyID          string     `json:"copy_id"`
+    BookID          string     `json:"book_id"`
+    ReasonCode      string     `json:"reason_code"`
+    Note            string     `json:"note,omitempty"`
+    State           string     `json:"state"`
+    FlaggedAt       time.Time  `json:"flagged_at"`
+    DecidedAt       *time.Time `json:"decided_at,omitempty"`       // When an admin approved or rejected the flag.
+    DeaccessionedAt *time.Time `json:"deaccessioned_at,omitempty"` // When the copy was withdrawn from the collection.
+}
+
+var (
+    weeding    = make(map[string]WeedingRecord) // Map to store weeding records with their ID as the key.
+    weedingSeq int                              // Last weeding record ID handed out.
+    weedingMux sync.RWMutex                     // RWMutex to safeguard weeding and weedingSeq.
+)
+
+// handleWeeding handles requests for the /weeding route.
+func handleWeeding(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case "GET": // List weeding records, optionally in a single state.
+        recs := weedingRecords(r.URL.Query().Get("state"))
+        start, end, err := paginate(w, r, len(recs))
+        if err != nil {
+            writePageError(w, err)
+            return
+        }
+        json.NewEncoder(w).Encode(recs[start:end])
+
+    case "POST": // Flag a copy for weeding.
+        var req struct {
+            CopyID     string `json:"copy_id"`
+            ReasonCode string `json:"reason_code"`
+            Note       string `json:"note"`
+        }
+        if err := decodeJSON(r, &req); err != nil {
+            writeDecodeError(w, err)
+            return
+        }
+        if !weedingReasons[req.ReasonCode] {
+            writeError(w, "validation_failed", "unknown reason_code")
+            return
+        }
+        copiesMux.RLock()
+        c, ok := copies[req.CopyID]
+        copiesMux.RUnlock()
+        if !ok {
+            writeError(w, "validation_failed", "unknown copy "+req.CopyID)
+            return
+        }
+        if c.Status == CopyWithdrawn {
+            writeError(w, "copy_withdrawn", "copy is already withdrawn")
+            return
+        }
+
+        weedingMux.Lock()
+        defer weedingMux.Unlock()
+        for _, rec := range weeding {
+            if rec.CopyID == c.ID && (rec.State == WeedingFlagged || rec.State == WeedingApproved) {
+                writeError(w, "duplicate", "copy is already flagged as weeding record "+rec.ID)
+                return
+            }
+        }
+        weedingSeq++
+        rec := WeedingRecord{
+            ID:         strconv.Itoa(weedingSeq),
+            CopyID:     c.ID,
+            BookID:     c.BookID,
+            ReasonCode: req.ReasonCode,
+            Note:       req.Note,
+            State:      WeedingFlagged,
+            FlaggedAt:  clock.Now().UTC(),
+        }
+        weeding[rec.ID] = rec
+        w.WriteHeader(http.StatusCreated)
+        json.NewEncoder(w).Encode(rec)
+
+    default:
+        methodNotAllowed(w, r)
+    }
+}
+
+// handleWeedingRecord handles requests for the /weeding/{id}, /weeding/{id}/{action}
+// and /weeding/report routes. Actions are approve, reject and deaccession.
+func handleWeedingRecord(w http.ResponseWriter, r *http.Request) {
+    path := r.URL.Path[len("/weeding/"):] // Extract the record ID and optional action from the URL path.
+    if path == "report" {
+        handleWeedingReport(w, r)
+        return
+    }
+    id, action, _ := strings.Cut(path, "/")
+
+    if action == "" {
+        if r.Method != "GET" {
+            methodNotAllowed(w, r)
+            return
+        }
+        weedingMux.RLock()
+        rec, ok := weeding[id]
+        weedingMux.RUnlock()
+        if !ok {
+            writeError(w, "weeding_record_not_found", "weeding record "+id+" not found")
+            return
+        }
+        json.NewEncoder(w).Encode(rec)
+        return
+    }
+
+    if r.Method != "POST" {
+        methodNotAllowed(w, r)
+        return
+    }
+    var from, to string // Required current state and the state the action moves to.
+    switch action {
+    case "approve":
+        from, to = WeedingFlagged, WeedingApproved
+    case "reject":
+        from, to = WeedingFlagged, WeedingRejected
+    case "deaccession":
+        from, to = WeedingApproved, WeedingDeaccessioned
+    default:
+        writeError(w, "not_found", "no route for "+r.URL.Path)
+        return
+    }
+    if (action == "approve" || action == "reject") && !isAdmin(r) {
+        writeError(w, "admin_required", "approving or rejecting a weeding flag requires the admin key")
+        return
+    }
+
+    weedingMux.Lock()
+    defer weedingMux.Unlock()
+    rec, ok := weeding[id]
+    if !ok {
+        writeError(w, "weeding_record_not_found", "weeding record "+id+" not found")
+        return
+    }
+    if rec.State != from {
+        writeError(w, "invalid_transition", "cannot "+action+" a record that is "+rec.State)
+        return
+    }
+    now := clock.Now().UTC()
+    rec.State = to
+    if to == WeedingDeaccessioned {
+        rec.DeaccessionedAt = &now
+        withdrawCopy(rec.CopyID, "deaccessioned: "+rec.ReasonCode, now)
+    } else {
+        rec.DecidedAt = &now
+    }
+    weeding[id] = rec
+    json.NewEncoder(w).Encode(rec)
+}
+
+// withdrawCopy marks a copy as withdrawn, recording why in its history.
+func withdrawCopy(id, note string, at time.Time) {
+    copiesMux.Lock()
+    defer copiesMux.Unlock()
+    c, ok := copies[id]
+    if !ok {
+        return
+    }
+    c.Status = CopyWithdrawn
+    c.History = append(c.History, CopyStatusChange{Condition: c.Condition, Status: c.Status, Note: note, ChangedAt: at})
+    copies[id] = c
+}
+
+// weedingRecords returns the weeding records in the given state (all when empty), ordered by ID.
+func weedingRecords(state string) []WeedingRecord {
+    weedingMux.RLock()
+    recs := make([]WeedingRecord, 0, len(weeding))
+    for _, rec := range weeding {
+        if state == "" || rec.State == state {
+            recs = append(recs, rec)
+        }
+    }
+    weedingMux.RUnlock()
+    sort.Slice(recs, func(i, j int) bool { return lessID(recs[i].ID, recs[j].ID) })
+    return recs
+}
+
+// handleWeedingReport handles GET /weeding/report, listing deaccessioned copies
+// as JSON or, with ?format=csv, as a CSV download.
+func handleWeedingReport(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        methodNotAllowed(w, r)
+        return
+    }
+    recs := weedingRecords(WeedingDeaccessioned)
+    if r.URL.Query().Get("format") != "csv" {
+        json.NewEncoder(w).Encode(recs)
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/csv")
+    w.Header().Set("Content-Disposition", `attachment; filename="deaccession-report.csv"`)
+    cw := csv.NewWriter(w)
+    cw.Write([]string{"record_id", "copy_id", "book_id", "title", "reason_code", "note", "flagged_at", "deaccessioned_at"})
+    mux.RLock()
+    defer mux.RUnlock()
+    for _, rec := range recs {
+        book, _ := getBook(rec.BookID)
+        cw.Write([]string{
+            rec.ID,
+            rec.CopyID,
+            rec.BookID,
+            book.Title, // Empty if the book record itself has since been removed.
+            rec.ReasonCode,
+            rec.Note,
+            rec.FlaggedAt.Format(time.RFC3339),
+            rec.DeaccessionedAt.Format(time.RFC3339),
+        })
+    }
+    cw.Flush()
+}